@@ -0,0 +1,43 @@
+// Command asaas-simulator serves an in-memory imitation of the Asaas API for local development.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/joho/godotenv"
+
+	"asaas/src/simulator"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	port := os.Getenv("SIMULATOR_PORT")
+	if port == "" {
+		port = "8090"
+	}
+	webhookURL := os.Getenv("SIMULATOR_WEBHOOK_URL")
+	if webhookURL == "" {
+		webhookURL = "http://localhost:8080/webhooks/asaas"
+	}
+
+	publicURL := os.Getenv("SIMULATOR_PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:" + port
+	}
+
+	sim := simulator.New(simulator.Options{
+		AccessToken:  os.Getenv("SIMULATOR_ACCESS_TOKEN"),
+		WebhookURL:   webhookURL,
+		WebhookToken: os.Getenv("ASAAS_WEBHOOK_TOKEN"),
+		PublicURL:    publicURL,
+	})
+
+	addr := ":" + port
+	log.Printf("asaas simulator listening on %s (API under /v3, webhooks to %s)", addr, webhookURL)
+	if err := http.ListenAndServe(addr, sim); err != nil {
+		log.Fatalf("simulator error: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"asaas/src/payments"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyStore keeps the keys and responses seen by idempotencyGuard.
+type idempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, record payments.IdempotencyRecord, expiredBefore time.Time) (payments.IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, key, method, path string, statusCode int, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, key, method, path string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+// idempotencyGuard replays the first response of POST requests that carry an Idempotency-Key.
+type idempotencyGuard struct {
+	repo   idempotencyStore
+	window time.Duration
+}
+
+func (g idempotencyGuard) wrap(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyKeyHeader)
+		if req.Method != http.MethodPost || key == "" || g.repo == nil {
+			next(w, req)
+			return
+		}
+		if len(key) > 255 {
+			respondError(w, http.StatusBadRequest, "Idempotency-Key muito longa")
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "não foi possível ler o corpo")
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+
+		now := time.Now().UTC()
+		record := payments.IdempotencyRecord{
+			Key:         key,
+			Method:      req.Method,
+			Path:        req.URL.Path,
+			RequestHash: hex.EncodeToString(sum[:]),
+			CreatedAt:   now,
+		}
+		stored, reserved, err := g.repo.ReserveIdempotencyKey(req.Context(), record, now.Add(-g.window))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "erro interno do servidor")
+			return
+		}
+		if !reserved {
+			switch {
+			case stored.RequestHash != record.RequestHash:
+				respondError(w, http.StatusUnprocessableEntity, "Idempotency-Key já utilizada com outro payload")
+			case stored.StatusCode == 0:
+				respondError(w, http.StatusConflict, "requisição com esta Idempotency-Key ainda em processamento")
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.StatusCode)
+				_, _ = w.Write(stored.ResponseBody)
+			}
+			return
+		}
+
+		// A request that failed without changing anything in Asaas, because it never got
+		// there or Asaas rejected it with a 4xx, can be retried with the same key. After a
+		// write Asaas accepted, or one whose outcome is unknown, the key keeps its response,
+		// or stays reserved when none could be stored.
+		ctx := context.WithoutCancel(req.Context())
+		trackedCtx, remoteWrite := payments.WithRemoteWriteTracking(req.Context())
+		req = req.WithContext(trackedCtx)
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if remoteWrite() {
+				log.Printf("keeping idempotency key %q reserved: the request may have changed data in Asaas", record.Key)
+				return
+			}
+			if err := g.repo.ReleaseIdempotencyKey(ctx, record.Key, record.Method, record.Path); err != nil {
+				log.Printf("failed to release idempotency key %q: %v", record.Key, err)
+			}
+		}()
+
+		next(recorder, req)
+
+		if recorder.status >= http.StatusBadRequest && !remoteWrite() {
+			return
+		}
+		if err := g.repo.CompleteIdempotencyKey(ctx, record.Key, record.Method, record.Path, recorder.status, recorder.body.Bytes()); err != nil {
+			log.Printf("failed to store idempotent response for key %q: %v", record.Key, err)
+			return
+		}
+		completed = true
+	})
+}
+
+// runIdempotencyCleanup periodically deletes idempotency keys older than the replay window.
+func runIdempotencyCleanup(ctx context.Context, g idempotencyGuard) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := g.repo.DeleteExpiredIdempotencyKeys(ctx, time.Now().UTC().Add(-g.window)); err != nil {
+				log.Printf("idempotency key cleanup failed: %v", err)
+			}
+		}
+	}
+}
+
+// responseRecorder captures the status and body written by a handler while forwarding them.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
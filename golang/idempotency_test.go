@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"asaas/src/payments"
+)
+
+// memoryIdempotencyStore keeps idempotency keys in memory for tests.
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]payments.IdempotencyRecord
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: make(map[string]payments.IdempotencyRecord)}
+}
+
+func (s *memoryIdempotencyStore) ReserveIdempotencyKey(ctx context.Context, record payments.IdempotencyRecord, expiredBefore time.Time) (payments.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := record.Key + " " + record.Method + " " + record.Path
+	if stored, ok := s.records[id]; ok && !stored.CreatedAt.Before(expiredBefore) {
+		return stored, false, nil
+	}
+	s.records[id] = record
+	return record, true, nil
+}
+
+func (s *memoryIdempotencyStore) CompleteIdempotencyKey(ctx context.Context, key, method, path string, statusCode int, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := key + " " + method + " " + path
+	record := s.records[id]
+	record.StatusCode = statusCode
+	record.ResponseBody = append([]byte(nil), body...)
+	record.CompletedAt = time.Now().UTC()
+	s.records[id] = record
+	return nil
+}
+
+func (s *memoryIdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, key, method, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key+" "+method+" "+path)
+	return nil
+}
+
+func (s *memoryIdempotencyStore) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func idempotentPost(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(body))
+	req.Header.Set(idempotencyKeyHeader, key)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestIdempotencyGuard(t *testing.T) {
+	tests := []struct {
+		name string
+		// asaasStatus is the status Asaas answers with; 0 when the handler does not call it.
+		asaasStatus int
+		localStatus int
+		wantStatus  int
+		wantReplay  bool
+	}{
+		{name: "created in Asaas", asaasStatus: http.StatusOK, wantStatus: http.StatusCreated, wantReplay: true},
+		{name: "rejected locally", localStatus: http.StatusUnprocessableEntity, wantStatus: http.StatusUnprocessableEntity},
+		{name: "rejected by Asaas", asaasStatus: http.StatusBadRequest, wantStatus: http.StatusUnprocessableEntity},
+		{name: "Asaas failed", asaasStatus: http.StatusInternalServerError, wantStatus: http.StatusBadGateway, wantReplay: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(tt.asaasStatus)
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer asaas.Close()
+			client := payments.NewAsaasClient(payments.Config{APIURL: asaas.URL})
+
+			calls := 0
+			guard := idempotencyGuard{repo: newMemoryIdempotencyStore(), window: time.Hour}
+			handler := guard.wrap(func(w http.ResponseWriter, req *http.Request) {
+				calls++
+				if tt.asaasStatus == 0 {
+					respondError(w, tt.localStatus, "inválido")
+					return
+				}
+				if err := client.DeletePayment(req.Context(), "pay_1"); err != nil {
+					respondError(w, statusForError(err), err.Error())
+					return
+				}
+				respondJSON(w, map[string]int{"calls": calls}, http.StatusCreated)
+			})
+
+			first := idempotentPost(handler, "key-1", `{"value":10}`)
+			if first.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, first.Code, first.Body)
+			}
+			second := idempotentPost(handler, "key-1", `{"value":10}`)
+			replayed := second.Header().Get("Idempotent-Replayed") == "true"
+			if replayed != tt.wantReplay || (tt.wantReplay && (calls != 1 || second.Code != first.Code || second.Body.String() != first.Body.String())) {
+				t.Fatalf("expected replay %v, got %v after %d calls: %d %s", tt.wantReplay, replayed, calls, second.Code, second.Body)
+			}
+			if !tt.wantReplay && calls != 2 {
+				t.Fatalf("expected the released key to run the handler again, got %d calls", calls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyGuardRejectsReuse(t *testing.T) {
+	guard := idempotencyGuard{repo: newMemoryIdempotencyStore(), window: time.Hour}
+	var handler http.Handler
+	var inFlight *httptest.ResponseRecorder
+	handler = guard.wrap(func(w http.ResponseWriter, req *http.Request) {
+		if inFlight == nil {
+			inFlight = idempotentPost(handler, "key-1", `{"value":10}`)
+		}
+		respondJSON(w, map[string]string{"id": "pay-1"}, http.StatusCreated)
+	})
+
+	if got := idempotentPost(handler, "key-1", `{"value":10}`); got.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", got.Code)
+	}
+	if inFlight.Code != http.StatusConflict {
+		t.Fatalf("expected 409 while the first request runs, got %d", inFlight.Code)
+	}
+	if got := idempotentPost(handler, "key-1", `{"value":20}`); got.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for another body, got %d", got.Code)
+	}
+	if got := idempotentPost(handler, "key-2", `{"value":20}`); got.Code != http.StatusCreated || got.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected a new key to run the handler, got %d", got.Code)
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"time"
 
 	"asaas/src/payments"
@@ -19,9 +21,17 @@ import (
 )
 
 type AppConfig struct {
-	Port        string
-	DatabaseDSN string
-	Asaas       payments.Config
+	Port                    string
+	DatabaseDSN             string
+	Asaas                   payments.Config
+	PendingRecoveryInterval time.Duration
+	PendingRecoveryAge      time.Duration
+	AuthorizationInterval   time.Duration
+	AuthorizationMaxAge     time.Duration
+	IdempotencyWindow       time.Duration
+	WebhookQueue            payments.WebhookQueueConfig
+	UnknownEventPolicy      payments.UnknownEventPolicy
+	ChargeDefaults          payments.ChargeDefaults
 }
 
 func main() {
@@ -54,8 +64,25 @@ func main() {
 
 	client := payments.NewAsaasClient(cfg.Asaas)
 	service := payments.NewService(repo, client)
+	service.SetWebhookQueueConfig(cfg.WebhookQueue)
+	service.SetUnknownEventPolicy(cfg.UnknownEventPolicy)
+	service.SetChargeDefaults(cfg.ChargeDefaults)
 
-	handler := buildHandler(service, client)
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, os.Args[1], cfg, service); err != nil {
+			log.Fatalf("command %s failed: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	go runPendingRecovery(ctx, cfg, service)
+	go runAuthorizationExpiry(ctx, cfg, service)
+	go service.RunWebhookWorkers(ctx)
+
+	guard := idempotencyGuard{repo: repo, window: cfg.IdempotencyWindow}
+	go runIdempotencyCleanup(ctx, guard)
+
+	handler := buildHandler(service, client, guard)
 
 	srv := &http.Server{ //nolint:gosec
 		Addr:         ":" + cfg.Port,
@@ -87,16 +114,154 @@ func loadConfig() (AppConfig, error) {
 		port = "8080"
 	}
 
-	return AppConfig{Port: port, DatabaseDSN: dsn, Asaas: asaasConfig}, nil
+	recoveryInterval, err := durationFromEnv("PENDING_RECOVERY_INTERVAL", 5*time.Minute)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	recoveryAge, err := durationFromEnv("PENDING_RECOVERY_AGE", 10*time.Minute)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	authorizationInterval, err := durationFromEnv("AUTHORIZATION_EXPIRY_INTERVAL", time.Hour)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	authorizationMaxAge, err := durationFromEnv("AUTHORIZATION_MAX_AGE", 72*time.Hour)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	idempotencyWindow, err := durationFromEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	webhookQueue, err := payments.LoadWebhookQueueConfigFromEnv()
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	chargeDefaults, err := payments.LoadChargeDefaultsFromEnv()
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	unknownEventPolicy := payments.UnknownEventStore
+	if value := os.Getenv("WEBHOOK_UNKNOWN_EVENT_POLICY"); value != "" {
+		if unknownEventPolicy, err = payments.ParseUnknownEventPolicy(value); err != nil {
+			return AppConfig{}, err
+		}
+	}
+
+	return AppConfig{
+		Port:                    port,
+		DatabaseDSN:             dsn,
+		Asaas:                   asaasConfig,
+		PendingRecoveryInterval: recoveryInterval,
+		PendingRecoveryAge:      recoveryAge,
+		AuthorizationInterval:   authorizationInterval,
+		AuthorizationMaxAge:     authorizationMaxAge,
+		IdempotencyWindow:       idempotencyWindow,
+		WebhookQueue:            webhookQueue,
+		UnknownEventPolicy:      unknownEventPolicy,
+		ChargeDefaults:          chargeDefaults,
+	}, nil
+}
+
+func durationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s inv\u00e1lida: %w", name, err)
+	}
+	return parsed, nil
+}
+
+// runCommand executes one-off maintenance commands instead of starting the server.
+func runCommand(ctx context.Context, name string, cfg AppConfig, service *payments.Service) error {
+	switch name {
+	case "recover-pending-operations":
+		result, err := service.RecoverPendingOperations(ctx, cfg.PendingRecoveryAge)
+		logRecoveryResult(result)
+		return err
+	case "cancel-expired-authorizations":
+		cancelled, err := service.CancelExpiredAuthorizations(ctx, cfg.AuthorizationMaxAge)
+		log.Printf("expired authorizations: %d cancelled", cancelled)
+		return err
+	case "backfill-asaas-ids":
+		result, err := service.BackfillAsaasIDs(ctx)
+		log.Printf("backfill finished: %d updated, %d not found in Asaas, %d failed", result.Updated, result.NotFound, result.Failed)
+		return err
+	default:
+		return fmt.Errorf("comando desconhecido: %s", name)
+	}
+}
+
+// runPendingRecovery periodically finishes or compensates remote creates whose local save did not complete.
+func runPendingRecovery(ctx context.Context, cfg AppConfig, service *payments.Service) {
+	if cfg.PendingRecoveryInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cfg.PendingRecoveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := service.RecoverPendingOperations(ctx, cfg.PendingRecoveryAge)
+			logRecoveryResult(result)
+			if err != nil {
+				log.Printf("pending operation recovery failed: %v", err)
+			}
+		}
+	}
+}
+
+// runAuthorizationExpiry periodically cancels card pre-authorizations that were not captured in time.
+func runAuthorizationExpiry(ctx context.Context, cfg AppConfig, service *payments.Service) {
+	if cfg.AuthorizationInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cfg.AuthorizationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cancelled, err := service.CancelExpiredAuthorizations(ctx, cfg.AuthorizationMaxAge)
+			if cancelled > 0 {
+				log.Printf("expired authorizations: %d cancelled", cancelled)
+			}
+			if err != nil {
+				log.Printf("authorization expiry failed: %v", err)
+			}
+		}
+	}
+}
+
+func logRecoveryResult(result payments.RecoveryResult) {
+	if result == (payments.RecoveryResult{}) {
+		return
+	}
+	log.Printf(
+		"pending operation recovery: %d confirmed, %d linked, %d compensated, %d abandoned, %d failed",
+		result.Confirmed, result.Linked, result.Compensated, result.Abandoned, result.Failed,
+	)
 }
 
-func buildHandler(service *payments.Service, client *payments.AsaasClient) http.Handler {
+func buildHandler(service *payments.Service, client *payments.AsaasClient, guard idempotencyGuard) http.Handler {
 	mux := http.NewServeMux()
-	registerRoutes(mux, service, client)
+	registerRoutes(mux, service, client, guard)
 	return withRecovery(withRequestLogging(mux))
 }
 
-func registerRoutes(mux *http.ServeMux, service *payments.Service, client *payments.AsaasClient) {
+func registerRoutes(mux *http.ServeMux, service *payments.Service, client *payments.AsaasClient, guard idempotencyGuard) {
 	customerHandler := func(w http.ResponseWriter, req *http.Request) {
 		switch req.Method {
 		case http.MethodPost:
@@ -114,7 +279,7 @@ func registerRoutes(mux *http.ServeMux, service *payments.Service, client *payme
 		case http.MethodGet:
 			id := req.URL.Query().Get("id")
 			if id == "" {
-				respondError(w, http.StatusBadRequest, "id \u00e9 obrigat\u00f3rio")
+				listHandler(w, req, service.ListCustomers)
 				return
 			}
 			customer, err := client.GetCustomer(req.Context(), id)
@@ -128,6 +293,51 @@ func registerRoutes(mux *http.ServeMux, service *payments.Service, client *payme
 		}
 	}
 
+	customerUpdateHandler := func(w http.ResponseWriter, req *http.Request) {
+		var payload payments.UpdateCustomerRequest
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			respondError(w, http.StatusBadRequest, "payload inv\u00e1lido")
+			return
+		}
+		_, remote, err := service.UpdateCustomer(req.Context(), req.PathValue("id"), payload)
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, remote, http.StatusOK)
+	}
+
+	customerDeleteHandler := func(w http.ResponseWriter, req *http.Request) {
+		if err := service.DeleteCustomer(req.Context(), req.PathValue("id")); err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	customerRestoreHandler := func(w http.ResponseWriter, req *http.Request) {
+		_, remote, err := service.RestoreCustomer(req.Context(), req.PathValue("id"))
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, remote, http.StatusOK)
+	}
+
+	customerCreditCardHandler := func(w http.ResponseWriter, req *http.Request) {
+		var payload payments.TokenizeCreditCardRequest
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			respondError(w, http.StatusBadRequest, "payload inv\u00e1lido")
+			return
+		}
+		customer, err := service.TokenizeCreditCard(req.Context(), req.PathValue("id"), payload)
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, customer, http.StatusOK)
+	}
+
 	paymentHandler := func(w http.ResponseWriter, req *http.Request) {
 		switch req.Method {
 		case http.MethodPost:
@@ -145,7 +355,7 @@ func registerRoutes(mux *http.ServeMux, service *payments.Service, client *payme
 		case http.MethodGet:
 			id := req.URL.Query().Get("id")
 			if id == "" {
-				respondError(w, http.StatusBadRequest, "id \u00e9 obrigat\u00f3rio")
+				listHandler(w, req, service.ListPayments)
 				return
 			}
 			payment, err := client.GetPayment(req.Context(), id)
@@ -153,12 +363,150 @@ func registerRoutes(mux *http.ServeMux, service *payments.Service, client *payme
 				respondError(w, http.StatusBadGateway, err.Error())
 				return
 			}
-			respondJSON(w, payment, http.StatusOK)
+			respondJSON(w, payment.WithoutCardToken(), http.StatusOK)
 		default:
 			respondError(w, http.StatusMethodNotAllowed, "m\u00e9todo n\u00e3o permitido")
 		}
 	}
 
+	paymentRefundHandler := func(w http.ResponseWriter, req *http.Request) {
+		var payload payments.RefundRequest
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+			respondError(w, http.StatusBadRequest, "payload inv\u00e1lido")
+			return
+		}
+		_, remote, err := service.RefundPayment(req.Context(), req.PathValue("id"), payload)
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, remote, http.StatusOK)
+	}
+
+	paymentCaptureHandler := func(w http.ResponseWriter, req *http.Request) {
+		_, remote, err := service.CapturePayment(req.Context(), req.PathValue("id"))
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, remote, http.StatusOK)
+	}
+
+	paymentPixHandler := func(w http.ResponseWriter, req *http.Request) {
+		code, err := service.PaymentPixQrCode(req.Context(), req.PathValue("id"))
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, code, http.StatusOK)
+	}
+
+	paymentBoletoHandler := func(w http.ResponseWriter, req *http.Request) {
+		boleto, err := service.PaymentBoleto(req.Context(), req.PathValue("id"))
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, boleto, http.StatusOK)
+	}
+
+	paymentBankSlipHandler := func(w http.ResponseWriter, req *http.Request) {
+		id := req.PathValue("id")
+		pdf, err := service.PaymentBankSlipPDF(req.Context(), id)
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"boleto-%s.pdf\"", id))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(pdf)
+	}
+
+	paymentSplitsHandler := func(w http.ResponseWriter, req *http.Request) {
+		splits, err := service.PaymentSplits(req.Context(), req.PathValue("id"))
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, splits, http.StatusOK)
+	}
+
+	subscriptionUpdateHandler := func(w http.ResponseWriter, req *http.Request) {
+		var payload payments.UpdateSubscriptionRequest
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			respondError(w, http.StatusBadRequest, "payload inv\u00e1lido")
+			return
+		}
+		subscription, _, err := service.UpdateSubscription(req.Context(), req.PathValue("id"), payload)
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, subscription, http.StatusOK)
+	}
+
+	subscriptionChangesHandler := func(w http.ResponseWriter, req *http.Request) {
+		changes, err := service.SubscriptionChanges(req.Context(), req.PathValue("id"))
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, changes, http.StatusOK)
+	}
+
+	subscriptionSplitsHandler := func(w http.ResponseWriter, req *http.Request) {
+		splits, err := service.SubscriptionSplits(req.Context(), req.PathValue("id"))
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, splits, http.StatusOK)
+	}
+
+	subscriptionPaymentsHandler := func(w http.ResponseWriter, req *http.Request) {
+		id := req.PathValue("id")
+		listHandler(w, req, func(ctx context.Context, filter payments.ListFilter) (payments.Page[payments.PaymentRecord], error) {
+			return service.SubscriptionPayments(ctx, id, filter)
+		})
+	}
+
+	subscriptionPaymentsSyncHandler := func(w http.ResponseWriter, req *http.Request) {
+		imported, err := service.SyncSubscriptionPayments(req.Context(), req.PathValue("id"))
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, imported, http.StatusOK)
+	}
+
+	installmentHandler := func(w http.ResponseWriter, req *http.Request) {
+		installment, err := service.Installment(req.Context(), req.PathValue("id"))
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, installment, http.StatusOK)
+	}
+
+	installmentRefundHandler := func(w http.ResponseWriter, req *http.Request) {
+		installment, err := service.RefundInstallment(req.Context(), req.PathValue("id"))
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, installment, http.StatusOK)
+	}
+
+	installmentCancelHandler := func(w http.ResponseWriter, req *http.Request) {
+		installment, err := service.CancelRemainingInstallments(req.Context(), req.PathValue("id"))
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, installment, http.StatusOK)
+	}
+
 	subscriptionHandler := func(w http.ResponseWriter, req *http.Request) {
 		switch req.Method {
 		case http.MethodPost:
@@ -173,16 +521,14 @@ func registerRoutes(mux *http.ServeMux, service *payments.Service, client *payme
 				return
 			}
 			respondJSON(w, remote, http.StatusCreated)
+		case http.MethodGet:
+			listHandler(w, req, service.ListSubscriptions)
 		default:
 			respondError(w, http.StatusMethodNotAllowed, "m\u00e9todo n\u00e3o permitido")
 		}
 	}
 
 	subscriptionCancelHandler := func(w http.ResponseWriter, req *http.Request) {
-		if req.Method != http.MethodPost {
-			respondError(w, http.StatusMethodNotAllowed, "m\u00e9todo n\u00e3o permitido")
-			return
-		}
 		id := req.URL.Query().Get("id")
 		if id == "" {
 			respondError(w, http.StatusBadRequest, "id \u00e9 obrigat\u00f3rio")
@@ -193,7 +539,7 @@ func registerRoutes(mux *http.ServeMux, service *payments.Service, client *payme
 			respondError(w, http.StatusBadGateway, err.Error())
 			return
 		}
-		respondJSON(w, subscription, http.StatusOK)
+		respondJSON(w, subscription.WithoutCardToken(), http.StatusOK)
 	}
 
 	invoiceHandler := func(w http.ResponseWriter, req *http.Request) {
@@ -213,7 +559,7 @@ func registerRoutes(mux *http.ServeMux, service *payments.Service, client *payme
 		case http.MethodGet:
 			id := req.URL.Query().Get("id")
 			if id == "" {
-				respondError(w, http.StatusBadRequest, "id \u00e9 obrigat\u00f3rio")
+				listHandler(w, req, service.ListInvoices)
 				return
 			}
 			invoice, err := client.GetInvoice(req.Context(), id)
@@ -246,25 +592,74 @@ func registerRoutes(mux *http.ServeMux, service *payments.Service, client *payme
 		}
 		defer req.Body.Close()
 
-		if err := service.HandleWebhookPayload(req.Context(), payload); err != nil {
-			respondError(w, http.StatusBadRequest, err.Error())
+		// Events are processed by the background workers so Asaas gets an answer right away.
+		if err := service.EnqueueWebhookPayload(req.Context(), payload); err != nil {
+			respondError(w, statusForWebhookError(err), err.Error())
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
 	}
 
-	mux.HandleFunc("/customers", customerHandler)
-	mux.HandleFunc("/customers/", customerHandler)
-	mux.HandleFunc("/payments", paymentHandler)
-	mux.HandleFunc("/payments/", paymentHandler)
-	mux.HandleFunc("/subscriptions", subscriptionHandler)
-	mux.HandleFunc("/subscriptions/", subscriptionHandler)
-	mux.HandleFunc("/subscriptions/cancel", subscriptionCancelHandler)
-	mux.HandleFunc("/subscriptions/cancel/", subscriptionCancelHandler)
-	mux.HandleFunc("/invoices", invoiceHandler)
-	mux.HandleFunc("/invoices/", invoiceHandler)
+	mux.Handle("/customers", guard.wrap(customerHandler))
+	mux.Handle("/customers/", guard.wrap(customerHandler))
+	mux.HandleFunc("PUT /customers/{id}", customerUpdateHandler)
+	mux.HandleFunc("DELETE /customers/{id}", customerDeleteHandler)
+	mux.Handle("POST /customers/{id}/restore", guard.wrap(customerRestoreHandler))
+	mux.Handle("POST /customers/{id}/credit-card", guard.wrap(customerCreditCardHandler))
+	mux.Handle("/payments", guard.wrap(paymentHandler))
+	mux.Handle("/payments/", guard.wrap(paymentHandler))
+	mux.Handle("POST /payments/{id}/refund", guard.wrap(paymentRefundHandler))
+	mux.Handle("POST /payments/{id}/capture", guard.wrap(paymentCaptureHandler))
+	mux.HandleFunc("GET /payments/{id}/pix", paymentPixHandler)
+	mux.HandleFunc("GET /payments/{id}/boleto", paymentBoletoHandler)
+	mux.HandleFunc("GET /payments/{id}/boleto.pdf", paymentBankSlipHandler)
+	mux.HandleFunc("GET /payments/{id}/splits", paymentSplitsHandler)
+	mux.HandleFunc("GET /installments/{id}", installmentHandler)
+	mux.Handle("POST /installments/{id}/refund", guard.wrap(installmentRefundHandler))
+	mux.Handle("POST /installments/{id}/cancel", guard.wrap(installmentCancelHandler))
+	mux.Handle("/subscriptions", guard.wrap(subscriptionHandler))
+	mux.Handle("/subscriptions/", guard.wrap(subscriptionHandler))
+	mux.HandleFunc("PATCH /subscriptions/{id}", subscriptionUpdateHandler)
+	mux.HandleFunc("GET /subscriptions/{id}/changes", subscriptionChangesHandler)
+	mux.HandleFunc("GET /subscriptions/{id}/splits", subscriptionSplitsHandler)
+	mux.HandleFunc("GET /subscriptions/{id}/payments", subscriptionPaymentsHandler)
+	mux.Handle("POST /subscriptions/{id}/payments/sync", guard.wrap(subscriptionPaymentsSyncHandler))
+	mux.HandleFunc("POST /subscriptions/cancel", subscriptionCancelHandler)
+	mux.HandleFunc("POST /subscriptions/cancel/{$}", subscriptionCancelHandler)
+	mux.Handle("/invoices", guard.wrap(invoiceHandler))
+	mux.Handle("/invoices/", guard.wrap(invoiceHandler))
+	webhookReplayHandler := func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			respondError(w, http.StatusMethodNotAllowed, "m\u00e9todo n\u00e3o permitido")
+			return
+		}
+		query := req.URL.Query()
+		if id := query.Get("id"); id != "" {
+			if err := service.ReplayWebhookEvent(req.Context(), id); err != nil {
+				respondError(w, statusForError(err), err.Error())
+				return
+			}
+			respondJSON(w, payments.ReplayResult{Processed: 1}, http.StatusOK)
+			return
+		}
+
+		from, fromErr := parseTimeParam(query.Get("from"))
+		to, toErr := parseTimeParam(query.Get("to"))
+		if fromErr != nil || toErr != nil || !from.Before(to) {
+			respondError(w, http.StatusBadRequest, "informe id ou um intervalo v\u00e1lido em from e to")
+			return
+		}
+		result, err := service.ReplayWebhookEvents(req.Context(), from, to)
+		if err != nil {
+			respondError(w, statusForError(err), err.Error())
+			return
+		}
+		respondJSON(w, result, http.StatusOK)
+	}
+
 	mux.HandleFunc("/webhooks/asaas", webhookHandler)
+	mux.HandleFunc("/webhooks/asaas/replay", webhookReplayHandler)
 
 	mux.Handle("/swagger/", http.StripPrefix("/swagger/", http.FileServer(http.Dir("swagger"))))
 }
@@ -289,6 +684,67 @@ func withRecovery(next http.Handler) http.Handler {
 	})
 }
 
+// listHandler serves a local list page filtered by the query string.
+func listHandler[T any](w http.ResponseWriter, req *http.Request, list func(context.Context, payments.ListFilter) (payments.Page[T], error)) {
+	filter, err := parseListFilter(req.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := list(req.Context(), filter)
+	if err != nil {
+		respondError(w, statusForError(err), err.Error())
+		return
+	}
+	respondJSON(w, page, http.StatusOK)
+}
+
+// parseListFilter reads the filters, page size and cursor accepted by the list endpoints.
+func parseListFilter(query url.Values) (payments.ListFilter, error) {
+	filter := payments.ListFilter{
+		Status:         query.Get("status"),
+		CustomerID:     query.Get("customer"),
+		SubscriptionID: query.Get("subscription"),
+		BillingType:    query.Get("billingType"),
+		Cursor:         query.Get("cursor"),
+	}
+	dates := []struct {
+		name   string
+		target *time.Time
+	}{
+		{"dueDateFrom", &filter.DueDateFrom},
+		{"dueDateTo", &filter.DueDateTo},
+		{"createdFrom", &filter.CreatedFrom},
+		{"createdTo", &filter.CreatedTo},
+	}
+	for _, date := range dates {
+		value := query.Get(date.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeParam(value)
+		if err != nil {
+			return payments.ListFilter{}, fmt.Errorf("%s inv\u00e1lido", date.name)
+		}
+		*date.target = t
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return payments.ListFilter{}, errors.New("limit inv\u00e1lido")
+		}
+		filter.Limit = limit
+	}
+	if value := query.Get("includeDeleted"); value != "" {
+		includeDeleted, err := strconv.ParseBool(value)
+		if err != nil {
+			return payments.ListFilter{}, errors.New("includeDeleted inv\u00e1lido")
+		}
+		filter.IncludeDeleted = includeDeleted
+	}
+	return filter, nil
+}
+
 func respondJSON(w http.ResponseWriter, payload any, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -307,9 +763,47 @@ func respondError(w http.ResponseWriter, status int, message string) {
 	_ = json.NewEncoder(w).Encode(errorResponse{Error: message})
 }
 
+// statusForWebhookError keeps 400 for malformed payloads and asks Asaas to retry when storage fails.
+func statusForWebhookError(err error) int {
+	if errors.Is(err, payments.ErrInvalidPayload) || errors.Is(err, payments.ErrUnsupportedEvent) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or yyyy-mm-dd dates.
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
 func statusForError(err error) int {
-	if errors.Is(err, sql.ErrNoRows) {
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, payments.ErrNotFound) {
 		return http.StatusNotFound
 	}
+	if errors.Is(err, payments.ErrCustomerDeleted) || errors.Is(err, payments.ErrBillingTypeMismatch) || errors.Is(err, payments.ErrInvalidAuthorization) ||
+		errors.Is(err, payments.ErrSubscriptionInactive) || errors.Is(err, payments.ErrWebhookEventBusy) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, payments.ErrInvalidCursor) || errors.Is(err, payments.ErrInvalidListFilter) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, payments.ErrInvalidRefund) || errors.Is(err, payments.ErrInvalidCreditCard) || errors.Is(err, payments.ErrInvalidInstallment) ||
+		errors.Is(err, payments.ErrInvalidChargeTerms) || errors.Is(err, payments.ErrInvalidSplit) || errors.Is(err, payments.ErrInvalidSubscriptionUpdate) {
+		return http.StatusUnprocessableEntity
+	}
+	var asaasErr *payments.AsaasError
+	if errors.As(err, &asaasErr) && asaasErr.StatusCode >= 400 && asaasErr.StatusCode < 500 {
+		switch asaasErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			// Asaas refused the API key of this service, not the request of the client.
+			return http.StatusBadGateway
+		case http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests:
+			return asaasErr.StatusCode
+		}
+		return http.StatusUnprocessableEntity
+	}
 	return http.StatusBadGateway
 }
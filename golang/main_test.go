@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"asaas/src/payments"
+)
+
+// TestRegisterRoutes builds the mux, so conflicting patterns panic here instead of
+// at startup, and checks that paths shared by several patterns reach the right one.
+func TestRegisterRoutes(t *testing.T) {
+	client := payments.NewAsaasClient(payments.Config{})
+	mux := http.NewServeMux()
+	registerRoutes(mux, payments.NewService(payments.NewMemoryRepository(), client), client, idempotencyGuard{})
+
+	tests := []struct {
+		method, target, pattern string
+	}{
+		{http.MethodPost, "/subscriptions/cancel?id=sub_1", "POST /subscriptions/cancel"},
+		{http.MethodPost, "/subscriptions/cancel/?id=sub_1", "POST /subscriptions/cancel/{$}"},
+		{http.MethodGet, "/subscriptions/sub-1/splits", "GET /subscriptions/{id}/splits"},
+		{http.MethodPatch, "/subscriptions/sub-1", "PATCH /subscriptions/{id}"},
+		{http.MethodGet, "/subscriptions", "/subscriptions"},
+		{http.MethodPost, "/subscriptions/sub-1/payments/sync", "POST /subscriptions/{id}/payments/sync"},
+		{http.MethodPut, "/customers/cust-1", "PUT /customers/{id}"},
+		{http.MethodGet, "/customers/", "/customers/"},
+		{http.MethodGet, "/payments/pay-1/boleto.pdf", "GET /payments/{id}/boleto.pdf"},
+		{http.MethodPost, "/payments/pay-1/refund", "POST /payments/{id}/refund"},
+		{http.MethodPost, "/webhooks/asaas/replay", "/webhooks/asaas/replay"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			_, pattern := mux.Handler(httptest.NewRequest(tt.method, tt.target, nil))
+			if pattern != tt.pattern {
+				t.Fatalf("expected %q, got %q", tt.pattern, pattern)
+			}
+		})
+	}
+}
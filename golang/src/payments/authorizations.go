@@ -0,0 +1,141 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Authorization statuses of pre-authorized card payments. An AUTHORIZED payment
+// ends CAPTURED, REFUSED when Asaas refuses the capture, or CANCELLED.
+const (
+	AuthorizationStatusAuthorized = "AUTHORIZED"
+	AuthorizationStatusCaptured   = "CAPTURED"
+	AuthorizationStatusRefused    = "REFUSED"
+	AuthorizationStatusCancelled  = "CANCELLED"
+)
+
+// ErrInvalidAuthorization is returned when a payment has no authorization in a
+// status that allows the operation.
+var ErrInvalidAuthorization = errors.New("pré-autorização inválida")
+
+// authorizationEvents maps the payment events that end an authorization to the
+// status they lead to.
+var authorizationEvents = map[string]string{
+	"PAYMENT_CONFIRMED":                   AuthorizationStatusCaptured,
+	"PAYMENT_RECEIVED":                    AuthorizationStatusCaptured,
+	"PAYMENT_CREDIT_CARD_CAPTURE_REFUSED": AuthorizationStatusRefused,
+	"PAYMENT_REFUNDED":                    AuthorizationStatusCancelled,
+	"PAYMENT_DELETED":                     AuthorizationStatusCancelled,
+}
+
+// CapturePayment captures a pre-authorized card payment.
+func (s *Service) CapturePayment(ctx context.Context, id string) (PaymentRecord, PaymentResponse, error) {
+	payment, err := s.repo.FindPaymentByID(ctx, id)
+	if err != nil {
+		return PaymentRecord{}, PaymentResponse{}, fmt.Errorf("falha ao localizar pagamento %s: %w", id, err)
+	}
+	if payment.AuthorizationStatus != AuthorizationStatusAuthorized {
+		return PaymentRecord{}, PaymentResponse{}, fmt.Errorf("%w: pagamento %s não está autorizado", ErrInvalidAuthorization, id)
+	}
+
+	remoteID, err := s.remotePaymentID(ctx, payment)
+	if err != nil {
+		return PaymentRecord{}, PaymentResponse{}, fmt.Errorf("falha ao buscar pagamento no Asaas para id %s: %w", id, err)
+	}
+	remote, err := s.client.CapturePayment(ctx, remoteID)
+	if err != nil {
+		return PaymentRecord{}, PaymentResponse{}, fmt.Errorf("falha ao capturar pagamento no Asaas: %w", err)
+	}
+
+	// The PAYMENT_CONFIRMED webhook may have captured it locally already.
+	payment, err = s.finishAuthorization(ctx, payment, AuthorizationStatusCaptured, remote)
+	if err != nil {
+		return PaymentRecord{}, PaymentResponse{}, err
+	}
+	return payment, remote.WithoutCardToken(), nil
+}
+
+// CancelExpiredAuthorizations cancels in Asaas the authorizations older than maxAge
+// that were not captured, releasing the amount held on the card. It returns how
+// many were cancelled.
+func (s *Service) CancelExpiredAuthorizations(ctx context.Context, maxAge time.Duration) (int, error) {
+	expired, err := s.repo.ListExpiredAuthorizations(ctx, time.Now().UTC().Add(-maxAge), 100)
+	if err != nil {
+		return 0, fmt.Errorf("falha ao listar pré-autorizações expiradas: %w", err)
+	}
+
+	cancelled := 0
+	var errs []error
+	for _, payment := range expired {
+		if err := s.cancelAuthorization(ctx, payment); err != nil {
+			if ctx.Err() != nil {
+				return cancelled, ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("pagamento %s: %w", payment.ID, err))
+			continue
+		}
+		cancelled++
+	}
+	return cancelled, errors.Join(errs...)
+}
+
+// cancelAuthorization refunds an authorized payment, which is how Asaas cancels an authorization.
+func (s *Service) cancelAuthorization(ctx context.Context, payment PaymentRecord) error {
+	remoteID, err := s.remotePaymentID(ctx, payment)
+	if err != nil {
+		return fmt.Errorf("falha ao buscar pagamento no Asaas: %w", err)
+	}
+	remote, err := s.client.RefundPayment(ctx, remoteID, RefundRequest{Description: "Pré-autorização expirada"})
+	if err != nil {
+		return fmt.Errorf("falha ao cancelar pré-autorização no Asaas: %w", err)
+	}
+	_, err = s.finishAuthorization(ctx, payment, AuthorizationStatusCancelled, remote)
+	return err
+}
+
+// finishAuthorization moves an authorized payment to status and copies the remote
+// payment status. When the authorization already left AUTHORIZED, through a webhook,
+// the stored payment is returned unchanged.
+func (s *Service) finishAuthorization(ctx context.Context, payment PaymentRecord, status string, remote PaymentResponse) (PaymentRecord, error) {
+	moved, err := s.repo.TransitionPaymentAuthorization(ctx, payment.ID, AuthorizationStatusAuthorized, status)
+	if err != nil {
+		return PaymentRecord{}, fmt.Errorf("falha ao atualizar pré-autorização local: %w", err)
+	}
+	if !moved {
+		stored, err := s.repo.FindPaymentByID(ctx, payment.ID)
+		if err != nil {
+			return PaymentRecord{}, fmt.Errorf("falha ao localizar pagamento %s: %w", payment.ID, err)
+		}
+		return stored, nil
+	}
+	payment.AuthorizationStatus = status
+	if remote.Status != "" {
+		if err := s.repo.UpdatePaymentStatus(ctx, payment.ID, remote.Status, remote.InvoiceURL, remote.TransactionReceiptURL); err != nil {
+			return PaymentRecord{}, fmt.Errorf("falha ao atualizar pagamento local: %w", err)
+		}
+		payment.Status = remote.Status
+		payment.InvoiceURL = remote.InvoiceURL
+		payment.TransactionReceiptURL = remote.TransactionReceiptURL
+	}
+	return payment, nil
+}
+
+// advanceAuthorization applies a payment event to the authorization of the payment.
+// Events that do not end an authorization, and payments that are not authorized,
+// are left alone.
+func (s *Service) advanceAuthorization(ctx context.Context, payment PaymentRecord, eventType string) (PaymentRecord, error) {
+	status, ok := authorizationEvents[eventType]
+	if !ok || payment.AuthorizationStatus != AuthorizationStatusAuthorized {
+		return payment, nil
+	}
+	moved, err := s.repo.TransitionPaymentAuthorization(ctx, payment.ID, AuthorizationStatusAuthorized, status)
+	if err != nil {
+		return PaymentRecord{}, fmt.Errorf("falha ao atualizar pré-autorização local: %w", err)
+	}
+	if moved {
+		payment.AuthorizationStatus = status
+	}
+	return payment, nil
+}
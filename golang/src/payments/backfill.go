@@ -0,0 +1,92 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// BackfillResult summarizes a run of BackfillAsaasIDs.
+type BackfillResult struct {
+	Updated  int
+	NotFound int
+	Failed   int
+}
+
+// BackfillAsaasIDs resolves, once, the Asaas IDs of local rows created before they were persisted.
+func (s *Service) BackfillAsaasIDs(ctx context.Context) (BackfillResult, error) {
+	var result BackfillResult
+	steps := []struct {
+		name    string
+		list    func(context.Context) ([]string, error)
+		resolve func(context.Context, string) (string, error)
+		store   func(context.Context, string, string) error
+	}{
+		{
+			name: "clientes",
+			list: s.repo.ListCustomerIDsWithoutAsaasID,
+			resolve: func(ctx context.Context, id string) (string, error) {
+				remote, err := s.client.GetCustomer(ctx, id)
+				return remote.ID, err
+			},
+			store: s.repo.SetCustomerAsaasID,
+		},
+		{
+			name: "pagamentos",
+			list: s.repo.ListPaymentIDsWithoutAsaasID,
+			resolve: func(ctx context.Context, id string) (string, error) {
+				remote, err := s.client.GetPayment(ctx, id)
+				return remote.ID, err
+			},
+			store: s.repo.SetPaymentAsaasID,
+		},
+		{
+			name: "assinaturas",
+			list: s.repo.ListSubscriptionIDsWithoutAsaasID,
+			resolve: func(ctx context.Context, id string) (string, error) {
+				remote, err := s.client.GetSubscription(ctx, id)
+				return remote.ID, err
+			},
+			store: s.repo.SetSubscriptionAsaasID,
+		},
+		{
+			name: "notas fiscais",
+			list: s.repo.ListInvoiceIDsWithoutAsaasID,
+			resolve: func(ctx context.Context, id string) (string, error) {
+				remote, err := s.client.GetInvoice(ctx, id)
+				return remote.ID, err
+			},
+			store: s.repo.SetInvoiceAsaasID,
+		},
+	}
+
+	var errs []error
+	for _, step := range steps {
+		ids, err := step.list(ctx)
+		if err != nil {
+			return result, fmt.Errorf("falha ao listar %s sem id do Asaas: %w", step.name, err)
+		}
+		for _, id := range ids {
+			asaasID, err := step.resolve(ctx, id)
+			if err != nil {
+				if errors.Is(err, ErrNotFound) {
+					result.NotFound++
+					continue
+				}
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+				result.Failed++
+				errs = append(errs, fmt.Errorf("%s %s: %w", step.name, id, err))
+				continue
+			}
+			if err := step.store(ctx, id, asaasID); err != nil {
+				result.Failed++
+				errs = append(errs, fmt.Errorf("%s %s: %w", step.name, id, err))
+				continue
+			}
+			result.Updated++
+		}
+	}
+	return result, errors.Join(errs...)
+}
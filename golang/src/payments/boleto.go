@@ -0,0 +1,92 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// PaymentBoleto returns the boleto identification of a payment, fetching it from
+// Asaas and storing it on first use. The stored one is served until the value or
+// billing type of the payment is changed through its subscription, which clears it.
+func (s *Service) PaymentBoleto(ctx context.Context, id string) (Boleto, error) {
+	payment, err := s.boletoPayment(ctx, id)
+	if err != nil {
+		return Boleto{}, err
+	}
+
+	stored, err := s.repo.FindBoleto(ctx, payment.ID)
+	if err == nil {
+		return stored, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Boleto{}, fmt.Errorf("falha ao buscar boleto local do pagamento %s: %w", id, err)
+	}
+
+	remoteID, err := s.remotePaymentID(ctx, payment)
+	if err != nil {
+		return Boleto{}, fmt.Errorf("falha ao buscar pagamento no Asaas para id %s: %w", id, err)
+	}
+	field, err := s.client.GetIdentificationField(ctx, remoteID)
+	if err != nil {
+		return Boleto{}, fmt.Errorf("falha ao buscar linha digitável no Asaas: %w", err)
+	}
+	remote, err := s.client.GetPaymentByID(ctx, remoteID)
+	if err != nil {
+		return Boleto{}, fmt.Errorf("falha ao buscar pagamento no Asaas: %w", err)
+	}
+
+	boleto := Boleto{
+		IdentificationField: field.IdentificationField,
+		NossoNumero:         field.NossoNumero,
+		BarCode:             field.BarCode,
+		BankSlipURL:         remote.BankSlipURL,
+	}
+	if err := s.repo.SaveBoleto(ctx, payment.ID, boleto); err != nil {
+		return Boleto{}, fmt.Errorf("falha ao salvar boleto local: %w", err)
+	}
+	return boleto, nil
+}
+
+// PaymentBankSlipPDF returns the boleto PDF of a payment, downloading it from
+// Asaas and storing it on first use.
+func (s *Service) PaymentBankSlipPDF(ctx context.Context, id string) ([]byte, error) {
+	payment, err := s.boletoPayment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := s.repo.FindBankSlipPDF(ctx, payment.ID)
+	if err == nil {
+		return stored, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("falha ao buscar PDF local do boleto %s: %w", id, err)
+	}
+
+	remoteID, err := s.remotePaymentID(ctx, payment)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao buscar pagamento no Asaas para id %s: %w", id, err)
+	}
+	pdf, err := s.client.DownloadBankSlip(ctx, remoteID)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao baixar PDF do boleto no Asaas: %w", err)
+	}
+	if err := s.repo.SaveBankSlipPDF(ctx, payment.ID, pdf); err != nil {
+		return nil, fmt.Errorf("falha ao salvar PDF do boleto local: %w", err)
+	}
+	return pdf, nil
+}
+
+// boletoPayment loads a payment that can be paid by boleto.
+func (s *Service) boletoPayment(ctx context.Context, id string) (PaymentRecord, error) {
+	payment, err := s.repo.FindPaymentByID(ctx, id)
+	if err != nil {
+		return PaymentRecord{}, fmt.Errorf("falha ao localizar pagamento %s: %w", id, err)
+	}
+	if payment.BillingType != "BOLETO" && payment.BillingType != "UNDEFINED" {
+		return PaymentRecord{}, fmt.Errorf("%w: pagamento %s é %s", ErrBillingTypeMismatch, id, payment.BillingType)
+	}
+	return payment, nil
+}
@@ -0,0 +1,124 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidChargeTerms is returned when a discount, interest or fine is not valid for the charge.
+var ErrInvalidChargeTerms = errors.New("desconto, juros ou multa inválidos")
+
+// maxPercentage is 100%.
+const maxPercentage = Percentage(100 * percentageScale)
+
+// ChargeDefaults are applied to payments and subscriptions whose request omits
+// the discount, interest or fine. Nil fields have no default.
+type ChargeDefaults struct {
+	Discount *Discount
+	Interest *Interest
+	Fine     *Fine
+}
+
+// SetChargeDefaults replaces the default discount, interest and fine.
+func (s *Service) SetChargeDefaults(defaults ChargeDefaults) {
+	s.chargeDefaults = defaults
+}
+
+// Validate checks the defaults as for a charge of unknown value.
+func (d ChargeDefaults) Validate() error {
+	return validateChargeTerms(0, d.Discount, d.Interest, d.Fine)
+}
+
+// applyChargeDefaults fills the terms a request omitted from the defaults. A term
+// sent with a zero value turns its default off and is not sent to Asaas.
+func (s *Service) applyChargeDefaults(discount **Discount, interest **Interest, fine **Fine) {
+	*discount = withDefault(*discount, s.chargeDefaults.Discount, func(d *Discount) bool { return d.Value == 0 })
+	*interest = withDefault(*interest, s.chargeDefaults.Interest, func(i *Interest) bool { return i.Value == 0 })
+	*fine = withDefault(*fine, s.chargeDefaults.Fine, func(f *Fine) bool { return f.Value == 0 })
+	if *fine != nil && (*fine).Type == "" {
+		normalized := **fine
+		normalized.Type = ValueTypePercentage
+		*fine = &normalized
+	}
+}
+
+func withDefault[T any](value, fallback *T, empty func(*T) bool) *T {
+	if value == nil {
+		if fallback == nil {
+			return nil
+		}
+		copied := *fallback
+		return &copied
+	}
+	if empty(value) {
+		return nil
+	}
+	return value
+}
+
+// validateChargeTerms checks the terms of a charge of the given value. Percentages
+// cannot exceed 100%, and a fixed discount must be smaller than the charge; the
+// value is only compared when it is known.
+func validateChargeTerms(value Money, discount *Discount, interest *Interest, fine *Fine) error {
+	if discount != nil {
+		switch {
+		case discount.Value < 0:
+			return fmt.Errorf("%w: desconto negativo", ErrInvalidChargeTerms)
+		case discount.DueDateLimitDays < 0:
+			return fmt.Errorf("%w: dueDateLimitDays negativo", ErrInvalidChargeTerms)
+		case discount.Type == ValueTypePercentage && discount.Value > maxPercentage:
+			return fmt.Errorf("%w: desconto de %s%% acima de 100%%", ErrInvalidChargeTerms, discount.Value)
+		case discount.Type == ValueTypeFixed && value > 0 && discount.Value.amount() >= value:
+			return fmt.Errorf("%w: desconto de %s não é menor que o valor de %s", ErrInvalidChargeTerms, discount.Value, value)
+		case discount.Type != ValueTypeFixed && discount.Type != ValueTypePercentage:
+			return fmt.Errorf("%w: tipo de desconto %q, use FIXED ou PERCENTAGE", ErrInvalidChargeTerms, discount.Type)
+		}
+	}
+	if interest != nil && (interest.Value < 0 || interest.Value > maxPercentage) {
+		return fmt.Errorf("%w: juros de %s%% fora de 0%% a 100%%", ErrInvalidChargeTerms, interest.Value)
+	}
+	if fine != nil {
+		switch {
+		case fine.Value < 0:
+			return fmt.Errorf("%w: multa negativa", ErrInvalidChargeTerms)
+		case fine.Type == ValueTypeFixed:
+		case fine.Type == ValueTypePercentage || fine.Type == "":
+			if fine.Value > maxPercentage {
+				return fmt.Errorf("%w: multa de %s%% acima de 100%%", ErrInvalidChargeTerms, fine.Value)
+			}
+		default:
+			return fmt.Errorf("%w: tipo de multa %q, use FIXED ou PERCENTAGE", ErrInvalidChargeTerms, fine.Type)
+		}
+	}
+	return nil
+}
+
+// newChargeTerms flattens the terms sent to Asaas for local storage.
+func newChargeTerms(discount *Discount, interest *Interest, fine *Fine) ChargeTerms {
+	var terms ChargeTerms
+	if discount != nil {
+		terms.DiscountValue = discount.Value
+		terms.DiscountDueDateLimitDays = discount.DueDateLimitDays
+		terms.DiscountType = discount.Type
+	}
+	if interest != nil {
+		terms.InterestValue = interest.Value
+	}
+	if fine != nil {
+		terms.FineValue = fine.Value
+		terms.FineType = fine.Type
+	}
+	return terms
+}
+
+// chargeValue is the value of each payment a request creates, or zero when unknown.
+func chargeValue(req PaymentRequest) Money {
+	switch {
+	case req.InstallmentValue > 0:
+		return req.InstallmentValue
+	case req.TotalValue > 0 && req.InstallmentCount > 0:
+		return req.TotalValue / Money(req.InstallmentCount)
+	default:
+		return req.Value
+	}
+}
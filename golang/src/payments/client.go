@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -18,6 +19,8 @@ type AsaasClient struct {
 	httpClient *http.Client
 	baseURL    string
 	token      string
+	retry      RetryPolicy
+	limiter    *rateLimiter
 }
 
 // NewAsaasClient creates an AsaasClient using the provided configuration.
@@ -26,6 +29,8 @@ func NewAsaasClient(cfg Config) *AsaasClient {
 		httpClient: &http.Client{Timeout: 30 * time.Second},
 		baseURL:    cfg.APIURL,
 		token:      cfg.APIToken,
+		retry:      cfg.Retry,
+		limiter:    newRateLimiter(cfg.RateLimit),
 	}
 }
 
@@ -46,28 +51,172 @@ type CustomerRequest struct {
 	AdditionalEmails     string `json:"additionalEmails,omitempty"`
 }
 
+// UpdateCustomerRequest is the payload for changing a customer in Asaas. Nil fields
+// are left out of the request and keep their current value; a field set to an empty
+// string is cleared.
+type UpdateCustomerRequest struct {
+	Name                 *string `json:"name,omitempty"`
+	Email                *string `json:"email,omitempty"`
+	CpfCnpj              *string `json:"cpfCnpj,omitempty"`
+	Phone                *string `json:"phone,omitempty"`
+	MobilePhone          *string `json:"mobilePhone,omitempty"`
+	Address              *string `json:"address,omitempty"`
+	AddressNumber        *string `json:"addressNumber,omitempty"`
+	Complement           *string `json:"complement,omitempty"`
+	Province             *string `json:"province,omitempty"`
+	PostalCode           *string `json:"postalCode,omitempty"`
+	ExternalID           string  `json:"externalReference,omitempty"`
+	NotificationDisabled *bool   `json:"notificationDisabled,omitempty"`
+	AdditionalEmails     *string `json:"additionalEmails,omitempty"`
+}
+
 // CustomerResponse is the subset of Asaas response used by this module.
 type CustomerResponse struct {
-	ID         string `json:"id"`
-	Name       string `json:"name"`
-	Email      string `json:"email"`
-	ExternalID string `json:"externalReference"`
+	ID                   string `json:"id"`
+	Name                 string `json:"name"`
+	Email                string `json:"email"`
+	CpfCnpj              string `json:"cpfCnpj,omitempty"`
+	Phone                string `json:"phone,omitempty"`
+	MobilePhone          string `json:"mobilePhone,omitempty"`
+	Address              string `json:"address,omitempty"`
+	AddressNumber        string `json:"addressNumber,omitempty"`
+	Complement           string `json:"complement,omitempty"`
+	Province             string `json:"province,omitempty"`
+	PostalCode           string `json:"postalCode,omitempty"`
+	NotificationDisabled bool   `json:"notificationDisabled,omitempty"`
+	AdditionalEmails     string `json:"additionalEmails,omitempty"`
+	ExternalID           string `json:"externalReference"`
+	Deleted              bool   `json:"deleted"`
 }
 
-type CustomerListResponse struct {
-	Data []CustomerResponse `json:"data"`
-}
+type CustomerListResponse = ListResponse[CustomerResponse]
 
 // PaymentRequest represents the payload for creating a payment.
 type PaymentRequest struct {
 	Customer         string           `json:"customer"`
 	BillingType      string           `json:"billingType"`
-	Value            float64          `json:"value"`
+	Value            Money            `json:"value"`
 	DueDate          string           `json:"dueDate"`
 	Description      string           `json:"description,omitempty"`
 	InstallmentCount int              `json:"installmentCount,omitempty"`
+	InstallmentValue Money            `json:"installmentValue,omitempty"`
+	TotalValue       Money            `json:"totalValue,omitempty"`
 	ExternalID       string           `json:"externalReference,omitempty"`
 	Callback         *PaymentCallback `json:"callback,omitempty"`
+	// CreditCard and CreditCardHolderInfo charge a CREDIT_CARD payment directly;
+	// CreditCardToken charges a card tokenized before. RemoteIP is the buyer's IP.
+	CreditCard           *CreditCard           `json:"creditCard,omitempty"`
+	CreditCardHolderInfo *CreditCardHolderInfo `json:"creditCardHolderInfo,omitempty"`
+	CreditCardToken      string                `json:"creditCardToken,omitempty"`
+	RemoteIP             string                `json:"remoteIp,omitempty"`
+	// UseSavedCard charges the card saved for the customer, and SaveCreditCard saves
+	// the card charged on the customer. Service reads and clears both, so they are
+	// not sent to Asaas.
+	UseSavedCard   bool `json:"useSavedCard,omitempty"`
+	SaveCreditCard bool `json:"saveCreditCard,omitempty"`
+	// AuthorizeOnly only authorizes a CREDIT_CARD charge; it is captured later by CapturePayment.
+	AuthorizeOnly bool `json:"authorizeOnly,omitempty"`
+	// Discount, Interest and Fine configure early payment and late charges.
+	Discount *Discount `json:"discount,omitempty"`
+	Interest *Interest `json:"interest,omitempty"`
+	Fine     *Fine     `json:"fine,omitempty"`
+	// Split sends part of the received value to other Asaas wallets.
+	Split []Split `json:"split,omitempty"`
+}
+
+// Value types of Discount and Fine.
+const (
+	ValueTypeFixed      = "FIXED"
+	ValueTypePercentage = "PERCENTAGE"
+)
+
+// Discount is granted when the payment is made up to DueDateLimitDays days before
+// the due date. Value is a percentage, or an amount in reais when Type is FIXED.
+type Discount struct {
+	Value            Percentage `json:"value"`
+	DueDateLimitDays int        `json:"dueDateLimitDays"`
+	Type             string     `json:"type"`
+}
+
+// Interest is the percentage charged per month after the due date.
+type Interest struct {
+	Value Percentage `json:"value"`
+}
+
+// Fine is charged once after the due date. Asaas reads an empty Type as PERCENTAGE;
+// with FIXED, Value is an amount in reais.
+type Fine struct {
+	Value Percentage `json:"value"`
+	Type  string     `json:"type,omitempty"`
+}
+
+// Split is the share of a charge credited to another Asaas wallet: a FixedValue,
+// a PercentualValue of the net value, or both. TotalFixedValue splits the total of
+// an installment plan instead. ID, TotalValue, Status and CancellationReason are
+// filled by Asaas.
+type Split struct {
+	ID                 string     `json:"id,omitempty"`
+	WalletID           string     `json:"walletId"`
+	FixedValue         Money      `json:"fixedValue,omitempty"`
+	PercentualValue    Percentage `json:"percentualValue,omitempty"`
+	TotalFixedValue    Money      `json:"totalFixedValue,omitempty"`
+	TotalValue         Money      `json:"totalValue,omitempty"`
+	Status             string     `json:"status,omitempty"`
+	CancellationReason string     `json:"cancellationReason,omitempty"`
+}
+
+// CreditCard is the raw card data sent to Asaas. It must never be stored or
+// logged, so its String and GoString methods hide the number and security code.
+type CreditCard struct {
+	HolderName  string `json:"holderName"`
+	Number      string `json:"number"`
+	ExpiryMonth string `json:"expiryMonth"`
+	ExpiryYear  string `json:"expiryYear"`
+	CCV         string `json:"ccv"`
+}
+
+func (c CreditCard) String() string {
+	return fmt.Sprintf("CreditCard{HolderName:%s Number:%s}", c.HolderName, maskCardNumber(c.Number))
+}
+
+func (c CreditCard) GoString() string {
+	return c.String()
+}
+
+// maskCardNumber keeps only the last four digits of a card number.
+func maskCardNumber(number string) string {
+	if len(number) <= 4 {
+		return strings.Repeat("*", len(number))
+	}
+	return strings.Repeat("*", len(number)-4) + number[len(number)-4:]
+}
+
+// CreditCardHolderInfo identifies the card holder for the anti-fraud analysis.
+type CreditCardHolderInfo struct {
+	Name              string `json:"name"`
+	Email             string `json:"email"`
+	CpfCnpj           string `json:"cpfCnpj"`
+	PostalCode        string `json:"postalCode"`
+	AddressNumber     string `json:"addressNumber"`
+	AddressComplement string `json:"addressComplement,omitempty"`
+	Phone             string `json:"phone"`
+	MobilePhone       string `json:"mobilePhone,omitempty"`
+}
+
+// TokenizeCreditCardRequest is the payload to tokenize a card for a customer.
+type TokenizeCreditCardRequest struct {
+	Customer             string                `json:"customer"`
+	CreditCard           *CreditCard           `json:"creditCard"`
+	CreditCardHolderInfo *CreditCardHolderInfo `json:"creditCardHolderInfo"`
+	RemoteIP             string                `json:"remoteIp"`
+}
+
+// CreditCardTokenResponse is a tokenized card. CreditCardNumber holds only the
+// last four digits.
+type CreditCardTokenResponse struct {
+	CreditCardNumber string `json:"creditCardNumber"`
+	CreditCardBrand  string `json:"creditCardBrand"`
+	CreditCardToken  string `json:"creditCardToken"`
 }
 
 type PaymentCallback struct {
@@ -77,48 +226,121 @@ type PaymentCallback struct {
 
 // PaymentResponse represents the relevant payment details returned by Asaas.
 type PaymentResponse struct {
-	ID                    string  `json:"id"`
-	Customer              string  `json:"customer"`
-	BillingType           string  `json:"billingType"`
-	Value                 float64 `json:"value"`
-	Status                string  `json:"status"`
-	Description           string  `json:"description,omitempty"`
-	DueDate               string  `json:"dueDate,omitempty"`
-	ExternalReference     string  `json:"externalReference"`
-	Subscription          string  `json:"subscription,omitempty"`
-	InvoiceURL            string  `json:"invoiceUrl,omitempty"`
-	TransactionReceiptURL string  `json:"transactionReceiptUrl,omitempty"`
-}
-
-type PaymentListResponse struct {
-	Data []PaymentResponse `json:"data"`
-}
+	ID                    string          `json:"id"`
+	Customer              string          `json:"customer"`
+	BillingType           string          `json:"billingType"`
+	Value                 Money           `json:"value"`
+	Status                string          `json:"status"`
+	Description           string          `json:"description,omitempty"`
+	DueDate               string          `json:"dueDate,omitempty"`
+	ExternalReference     string          `json:"externalReference"`
+	Subscription          string          `json:"subscription,omitempty"`
+	Installment           string          `json:"installment,omitempty"`
+	InstallmentNumber     int             `json:"installmentNumber,omitempty"`
+	InvoiceURL            string          `json:"invoiceUrl,omitempty"`
+	BankSlipURL           string          `json:"bankSlipUrl,omitempty"`
+	TransactionReceiptURL string          `json:"transactionReceiptUrl,omitempty"`
+	Refunds               []PaymentRefund `json:"refunds,omitempty"`
+	// CreditCard is the masked card charged, including its token. Service removes
+	// the token with WithoutCardToken before returning the response.
+	CreditCard *CreditCardTokenResponse `json:"creditCard,omitempty"`
+	Discount   *Discount                `json:"discount,omitempty"`
+	Interest   *Interest                `json:"interest,omitempty"`
+	Fine       *Fine                    `json:"fine,omitempty"`
+	Split      []Split                  `json:"split,omitempty"`
+}
+
+// PaymentRefund is a refund listed in a payment returned by Asaas.
+type PaymentRefund struct {
+	DateCreated           string `json:"dateCreated"`
+	Status                string `json:"status"`
+	Value                 Money  `json:"value"`
+	Description           string `json:"description,omitempty"`
+	TransactionReceiptURL string `json:"transactionReceiptUrl,omitempty"`
+}
+
+// PixQrCodeResponse is the PIX QR code of a payment. EncodedImage is a base64 PNG
+// and Payload the copy-and-paste code.
+type PixQrCodeResponse struct {
+	EncodedImage   string `json:"encodedImage"`
+	Payload        string `json:"payload"`
+	ExpirationDate string `json:"expirationDate"`
+}
+
+// IdentificationFieldResponse identifies the boleto of a payment. IdentificationField
+// is the linha digitável typed by the payer and BarCode the number encoded in the bars.
+type IdentificationFieldResponse struct {
+	IdentificationField string `json:"identificationField"`
+	NossoNumero         string `json:"nossoNumero"`
+	BarCode             string `json:"barCode"`
+}
+
+// RefundRequest is the payload of a payment refund. A zero Value refunds the whole
+// remaining amount.
+type RefundRequest struct {
+	Value       Money  `json:"value,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type PaymentListResponse = ListResponse[PaymentResponse]
 
 // SubscriptionRequest represents creation of an Asaas subscription.
 type SubscriptionRequest struct {
-	Customer    string  `json:"customer"`
-	BillingType string  `json:"billingType"`
-	Value       float64 `json:"value"`
-	NextDueDate string  `json:"nextDueDate"`
-	Cycle       string  `json:"cycle"`
-	ExternalID  string  `json:"externalReference,omitempty"`
-	Description string  `json:"description,omitempty"`
-	EndDate     string  `json:"endDate,omitempty"`
-	MaxPayments int     `json:"maxPayments,omitempty"`
+	Customer    string `json:"customer"`
+	BillingType string `json:"billingType"`
+	Value       Money  `json:"value"`
+	NextDueDate string `json:"nextDueDate"`
+	Cycle       string `json:"cycle"`
+	ExternalID  string `json:"externalReference,omitempty"`
+	Description string `json:"description,omitempty"`
+	EndDate     string `json:"endDate,omitempty"`
+	MaxPayments int    `json:"maxPayments,omitempty"`
+	// CreditCard, CreditCardHolderInfo, CreditCardToken, RemoteIP, UseSavedCard and
+	// SaveCreditCard work as in PaymentRequest.
+	CreditCard           *CreditCard           `json:"creditCard,omitempty"`
+	CreditCardHolderInfo *CreditCardHolderInfo `json:"creditCardHolderInfo,omitempty"`
+	CreditCardToken      string                `json:"creditCardToken,omitempty"`
+	RemoteIP             string                `json:"remoteIp,omitempty"`
+	UseSavedCard         bool                  `json:"useSavedCard,omitempty"`
+	SaveCreditCard       bool                  `json:"saveCreditCard,omitempty"`
+	// Discount, Interest, Fine and Split are copied to every payment of the subscription.
+	Discount *Discount `json:"discount,omitempty"`
+	Interest *Interest `json:"interest,omitempty"`
+	Fine     *Fine     `json:"fine,omitempty"`
+	Split    []Split   `json:"split,omitempty"`
+}
+
+// UpdateSubscriptionRequest changes a subscription. Empty fields keep their current
+// value. UpdatePendingPayments also applies the new value and billing type to the
+// payments already generated and not yet paid.
+type UpdateSubscriptionRequest struct {
+	BillingType           string `json:"billingType,omitempty"`
+	Value                 Money  `json:"value,omitempty"`
+	NextDueDate           string `json:"nextDueDate,omitempty"`
+	Cycle                 string `json:"cycle,omitempty"`
+	UpdatePendingPayments bool   `json:"updatePendingPayments,omitempty"`
 }
 
 // SubscriptionResponse captures required subscription fields.
 type SubscriptionResponse struct {
-	ID         string  `json:"id"`
-	Customer   string  `json:"customer"`
-	Status     string  `json:"status"`
-	Value      float64 `json:"value"`
-	ExternalID string  `json:"externalReference"`
-}
-
-type SubscriptionListResponse struct {
-	Data []SubscriptionResponse `json:"data"`
-}
+	ID          string `json:"id"`
+	Customer    string `json:"customer"`
+	BillingType string `json:"billingType,omitempty"`
+	Status      string `json:"status"`
+	Value       Money  `json:"value"`
+	NextDueDate string `json:"nextDueDate,omitempty"`
+	Cycle       string `json:"cycle,omitempty"`
+	ExternalID  string `json:"externalReference"`
+	// CreditCard is the masked card charged, including its token. Service removes
+	// the token with WithoutCardToken before returning the response.
+	CreditCard *CreditCardTokenResponse `json:"creditCard,omitempty"`
+	Discount   *Discount                `json:"discount,omitempty"`
+	Interest   *Interest                `json:"interest,omitempty"`
+	Fine       *Fine                    `json:"fine,omitempty"`
+	Split      []Split                  `json:"split,omitempty"`
+}
+
+type SubscriptionListResponse = ListResponse[SubscriptionResponse]
 
 // InvoiceRequest represents the payload to create an invoice in Asaas.
 type InvoiceRequest struct {
@@ -126,8 +348,8 @@ type InvoiceRequest struct {
 	ServiceDescription   string       `json:"serviceDescription"`
 	Observations         string       `json:"observations"`
 	ExternalID           string       `json:"externalReference,omitempty"`
-	Value                float64      `json:"value"`
-	Deductions           float64      `json:"deductions"`
+	Value                Money        `json:"value"`
+	Deductions           Money        `json:"deductions"`
 	EffectiveDate        string       `json:"effectiveDate"`
 	MunicipalServiceID   string       `json:"municipalServiceId,omitempty"`
 	MunicipalServiceCode string       `json:"municipalServiceCode,omitempty"`
@@ -136,33 +358,34 @@ type InvoiceRequest struct {
 	Taxes                InvoiceTaxes `json:"taxes"`
 }
 
+// InvoiceTaxes holds the tax rates of an invoice, as percentages.
 type InvoiceTaxes struct {
-	RetainISS bool    `json:"retainIss"`
-	Cofins    float64 `json:"cofins"`
-	Csll      float64 `json:"csll"`
-	INSS      float64 `json:"inss"`
-	IR        float64 `json:"ir"`
-	PIS       float64 `json:"pis"`
-	ISS       float64 `json:"iss"`
+	RetainISS bool       `json:"retainIss"`
+	Cofins    Percentage `json:"cofins"`
+	Csll      Percentage `json:"csll"`
+	INSS      Percentage `json:"inss"`
+	IR        Percentage `json:"ir"`
+	PIS       Percentage `json:"pis"`
+	ISS       Percentage `json:"iss"`
 }
 
 // InvoiceResponse captures invoice fields from Asaas.
 type InvoiceResponse struct {
-	ID          string  `json:"id"`
-	Customer    string  `json:"customer"`
-	Status      string  `json:"status"`
-	Value       float64 `json:"value"`
-	ExternalID  string  `json:"externalReference"`
-	PaymentLink string  `json:"paymentLink"`
+	ID          string `json:"id"`
+	Customer    string `json:"customer"`
+	Status      string `json:"status"`
+	Value       Money  `json:"value"`
+	ExternalID  string `json:"externalReference"`
+	PaymentLink string `json:"paymentLink"`
 }
 
-type InvoiceListResponse struct {
-	Data []InvoiceResponse `json:"data"`
-}
+type InvoiceListResponse = ListResponse[InvoiceResponse]
 
 // NotificationEvent represents webhook payloads sent by Asaas.
 type NotificationEvent struct {
+	ID           string                `json:"id,omitempty"`
 	Event        string                `json:"event"`
+	DateCreated  string                `json:"dateCreated,omitempty"`
 	Payment      *PaymentResponse      `json:"payment,omitempty"`
 	Invoice      *InvoiceResponse      `json:"invoice,omitempty"`
 	Subscription *SubscriptionResponse `json:"subscription,omitempty"`
@@ -173,6 +396,10 @@ type AsaasError struct {
 	Code        string
 	Description string
 	Raw         string
+	// Attempts is the number of requests sent before giving up.
+	Attempts int
+	// RetryAfter is how long Asaas asked to wait after a 429 response.
+	RetryAfter time.Duration
 }
 
 func (e *AsaasError) Error() string {
@@ -185,6 +412,25 @@ func (e *AsaasError) Error() string {
 	return fmt.Sprintf("erro do Asaas %d", e.StatusCode)
 }
 
+// ErrNotFound is matched by errors returned when a lookup by externalReference finds nothing in Asaas.
+var ErrNotFound = errors.New("recurso n\u00e3o encontrado no Asaas")
+
+type notFoundError struct {
+	message string
+}
+
+func (e *notFoundError) Error() string {
+	return e.message
+}
+
+func (e *notFoundError) Unwrap() error {
+	return ErrNotFound
+}
+
+func newNotFoundError(format string, args ...any) error {
+	return &notFoundError{message: fmt.Sprintf(format, args...)}
+}
+
 func (c *AsaasClient) doRequest(ctx context.Context, method, endpoint string, payload any, v any) error {
 	return c.doRequestWithQuery(ctx, method, endpoint, nil, payload, v)
 }
@@ -207,56 +453,113 @@ func (c *AsaasClient) doRequestWithQuery(ctx context.Context, method, endpoint s
 		}
 	}
 
-	var body io.Reader
+	var data []byte
 	if payload != nil {
-		data, err := json.Marshal(payload)
+		data, err = json.Marshal(payload)
 		if err != nil {
 			return fmt.Errorf("falha ao serializar payload: %w", err)
 		}
-		body = bytes.NewBuffer(data)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, base.String(), body)
-	if err != nil {
-		return fmt.Errorf("falha ao criar requisi\u00e7\u00e3o: %w", err)
+	policy := c.retry
+	maxAttempts := 1
+	if isIdempotentRequest(ctx, method) {
+		maxAttempts = policy.attempts()
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("accept", "application/json")
-	req.Header.Set("access_token", c.token)
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("falha na requisi\u00e7\u00e3o: %w", err)
-	}
-	defer resp.Body.Close()
+	for attempt := 1; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return fmt.Errorf("falha na requisi\u00e7\u00e3o: %w", err)
+		}
 
-	if resp.StatusCode >= 400 {
-		respBody, _ := io.ReadAll(resp.Body)
-		var asaasError struct {
-			Errors []struct {
-				Code        string `json:"code"`
-				Description string `json:"description"`
-			} `json:"errors"`
+		var body io.Reader
+		if data != nil {
+			body = bytes.NewReader(data)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, base.String(), body)
+		if err != nil {
+			return fmt.Errorf("falha ao criar requisi\u00e7\u00e3o: %w", err)
 		}
-		if err := json.Unmarshal(respBody, &asaasError); err == nil {
-			if len(asaasError.Errors) > 0 && strings.TrimSpace(asaasError.Errors[0].Description) != "" {
-				return &AsaasError{
-					StatusCode:  resp.StatusCode,
-					Code:        asaasError.Errors[0].Code,
-					Description: asaasError.Errors[0].Description,
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("accept", "application/json")
+		req.Header.Set("access_token", c.token)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			markRemoteWrite(ctx, method)
+			if attempt < maxAttempts && policy.retryableError(err) {
+				if sleepErr := sleepContext(ctx, policy.backoff(attempt)); sleepErr != nil {
+					return fmt.Errorf("falha na requisi\u00e7\u00e3o ap\u00f3s %d tentativas: %w", attempt, err)
 				}
+				continue
 			}
+			if attempt > 1 {
+				return fmt.Errorf("falha na requisi\u00e7\u00e3o ap\u00f3s %d tentativas: %w", attempt, err)
+			}
+			return fmt.Errorf("falha na requisi\u00e7\u00e3o: %w", err)
+		}
+
+		if resp.StatusCode < 400 || resp.StatusCode >= 500 {
+			markRemoteWrite(ctx, method)
 		}
-		return &AsaasError{
-			StatusCode: resp.StatusCode,
-			Raw:        string(respBody),
+		if resp.StatusCode >= 400 {
+			asaasErr := readAsaasError(resp)
+			asaasErr.Attempts = attempt
+			if resp.StatusCode == http.StatusTooManyRequests {
+				// The quota is per account, so every caller of this client has to back off.
+				asaasErr.RetryAfter = c.limiter.pauseFor429(resp.Header)
+				c.limiter.Pause(asaasErr.RetryAfter, asaasErr)
+			}
+			if attempt < maxAttempts && policy.retryableStatus(resp.StatusCode) {
+				delay := policy.backoff(attempt)
+				if resp.StatusCode == http.StatusTooManyRequests {
+					// The limiter pause already covers the advertised window.
+					delay = 0
+				} else if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok && retryAfter > delay {
+					delay = retryAfter
+				}
+				if err := sleepContext(ctx, delay); err != nil {
+					return asaasErr
+				}
+				continue
+			}
+			return asaasErr
 		}
+
+		err = decodeResponse(resp, v)
+		resp.Body.Close()
+		return err
 	}
+}
 
+func readAsaasError(resp *http.Response) *AsaasError {
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	var asaasError struct {
+		Errors []struct {
+			Code        string `json:"code"`
+			Description string `json:"description"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(respBody, &asaasError); err == nil {
+		if len(asaasError.Errors) > 0 && strings.TrimSpace(asaasError.Errors[0].Description) != "" {
+			return &AsaasError{
+				StatusCode:  resp.StatusCode,
+				Code:        asaasError.Errors[0].Code,
+				Description: asaasError.Errors[0].Description,
+			}
+		}
+	}
+	return &AsaasError{
+		StatusCode: resp.StatusCode,
+		Raw:        string(respBody),
+	}
+}
+
+func decodeResponse(resp *http.Response, v any) error {
 	if v == nil {
 		return nil
 	}
-
 	decoder := json.NewDecoder(resp.Body)
 	if err := decoder.Decode(v); err != nil {
 		return fmt.Errorf("falha ao decodificar resposta: %w", err)
@@ -281,11 +584,34 @@ func (c *AsaasClient) GetCustomer(ctx context.Context, id string) (CustomerRespo
 		return CustomerResponse{}, err
 	}
 	if len(resp.Data) == 0 {
-		return CustomerResponse{}, fmt.Errorf("cliente n\u00e3o encontrado para externalReference=%s", id)
+		return CustomerResponse{}, newNotFoundError("cliente n\u00e3o encontrado para externalReference=%s", id)
 	}
 	return resp.Data[0], nil
 }
 
+// UpdateCustomer changes the registration data of a customer by its Asaas ID.
+// Nil fields in req are kept by Asaas.
+func (c *AsaasClient) UpdateCustomer(ctx context.Context, id string, req UpdateCustomerRequest) (CustomerResponse, error) {
+	var resp CustomerResponse
+	endpoint := path.Join("customers", id)
+	err := c.doRequest(ctx, http.MethodPut, endpoint, req, &resp)
+	return resp, err
+}
+
+// DeleteCustomer removes a customer in Asaas by its Asaas ID.
+func (c *AsaasClient) DeleteCustomer(ctx context.Context, id string) error {
+	endpoint := path.Join("customers", id)
+	return c.doRequest(ctx, http.MethodDelete, endpoint, nil, nil)
+}
+
+// RestoreCustomer undoes the removal of a customer by its Asaas ID.
+func (c *AsaasClient) RestoreCustomer(ctx context.Context, id string) (CustomerResponse, error) {
+	var resp CustomerResponse
+	endpoint := path.Join("customers", id, "restore")
+	err := c.doRequest(WithIdempotentRequest(ctx), http.MethodPost, endpoint, nil, &resp)
+	return resp, err
+}
+
 // CreatePayment creates a payment for a customer.
 func (c *AsaasClient) CreatePayment(ctx context.Context, req PaymentRequest) (PaymentResponse, error) {
 	var resp PaymentResponse
@@ -303,18 +629,104 @@ func (c *AsaasClient) GetPayment(ctx context.Context, id string) (PaymentRespons
 		return PaymentResponse{}, err
 	}
 	if len(resp.Data) == 0 {
-		return PaymentResponse{}, fmt.Errorf("pagamento n\u00e3o encontrado para externalReference=%s", id)
+		return PaymentResponse{}, newNotFoundError("pagamento n\u00e3o encontrado para externalReference=%s", id)
 	}
 	return resp.Data[0], nil
 }
 
+// GetPaymentByID retrieves a payment by its Asaas ID.
+func (c *AsaasClient) GetPaymentByID(ctx context.Context, id string) (PaymentResponse, error) {
+	var resp PaymentResponse
+	endpoint := path.Join("payments", id)
+	err := c.doRequest(ctx, http.MethodGet, endpoint, nil, &resp)
+	return resp, err
+}
+
 // UpdatePaymentExternalReference updates the external reference for a payment.
 func (c *AsaasClient) UpdatePaymentExternalReference(ctx context.Context, id, externalReference string) error {
 	payload := struct {
 		ExternalReference string `json:"externalReference"`
 	}{ExternalReference: externalReference}
 	endpoint := path.Join("payments", id)
-	return c.doRequest(ctx, http.MethodPost, endpoint, payload, nil)
+	return c.doRequest(WithIdempotentRequest(ctx), http.MethodPost, endpoint, payload, nil)
+}
+
+// DeletePayment removes a payment in Asaas by its Asaas ID.
+func (c *AsaasClient) DeletePayment(ctx context.Context, id string) error {
+	endpoint := path.Join("payments", id)
+	return c.doRequest(ctx, http.MethodDelete, endpoint, nil, nil)
+}
+
+// RefundPayment refunds a received payment by its Asaas ID, fully or partially.
+// It is not retried, as a repeated partial refund would refund twice.
+func (c *AsaasClient) RefundPayment(ctx context.Context, id string, req RefundRequest) (PaymentResponse, error) {
+	var resp PaymentResponse
+	endpoint := path.Join("payments", id, "refund")
+	err := c.doRequest(ctx, http.MethodPost, endpoint, req, &resp)
+	return resp, err
+}
+
+// CapturePayment captures a pre-authorized card payment by its Asaas ID.
+func (c *AsaasClient) CapturePayment(ctx context.Context, id string) (PaymentResponse, error) {
+	var resp PaymentResponse
+	endpoint := path.Join("payments", id, "captureAuthorizedPayment")
+	err := c.doRequest(ctx, http.MethodPost, endpoint, nil, &resp)
+	return resp, err
+}
+
+// GetPixQrCode retrieves the PIX QR code of a payment by its Asaas ID.
+func (c *AsaasClient) GetPixQrCode(ctx context.Context, id string) (PixQrCodeResponse, error) {
+	var resp PixQrCodeResponse
+	endpoint := path.Join("payments", id, "pixQrCode")
+	err := c.doRequest(ctx, http.MethodGet, endpoint, nil, &resp)
+	return resp, err
+}
+
+// GetIdentificationField retrieves the boleto identification field of a payment by its Asaas ID.
+func (c *AsaasClient) GetIdentificationField(ctx context.Context, id string) (IdentificationFieldResponse, error) {
+	var resp IdentificationFieldResponse
+	endpoint := path.Join("payments", id, "identificationField")
+	err := c.doRequest(ctx, http.MethodGet, endpoint, nil, &resp)
+	return resp, err
+}
+
+// DownloadBankSlip downloads the boleto PDF of a payment by its Asaas ID. The PDF
+// is served from the public bankSlipUrl of the payment, so the API token is not sent.
+func (c *AsaasClient) DownloadBankSlip(ctx context.Context, id string) ([]byte, error) {
+	payment, err := c.GetPaymentByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if payment.BankSlipURL == "" {
+		return nil, fmt.Errorf("pagamento %s n\u00e3o possui boleto", id)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, payment.BankSlipURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao criar requisi\u00e7\u00e3o: %w", err)
+	}
+	req.Header.Set("accept", "application/pdf")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao baixar boleto: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, readAsaasError(resp)
+	}
+	defer resp.Body.Close()
+	pdf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao baixar boleto: %w", err)
+	}
+	return pdf, nil
+}
+
+// TokenizeCreditCard tokenizes a card for a customer so later charges can use the
+// token instead of the card data. It is not retried, as Asaas may have validated
+// the card with a charge.
+func (c *AsaasClient) TokenizeCreditCard(ctx context.Context, req TokenizeCreditCardRequest) (CreditCardTokenResponse, error) {
+	var resp CreditCardTokenResponse
+	err := c.doRequest(ctx, http.MethodPost, "creditCard/tokenizeCreditCard", req, &resp)
+	return resp, err
 }
 
 // CreateSubscription creates a recurring subscription.
@@ -334,7 +746,7 @@ func (c *AsaasClient) GetSubscription(ctx context.Context, externalReference str
 		return SubscriptionResponse{}, err
 	}
 	if len(resp.Data) == 0 {
-		return SubscriptionResponse{}, fmt.Errorf("assinatura n\u00e3o encontrada para externalReference=%s", externalReference)
+		return SubscriptionResponse{}, newNotFoundError("assinatura n\u00e3o encontrada para externalReference=%s", externalReference)
 	}
 	return resp.Data[0], nil
 }
@@ -347,15 +759,33 @@ func (c *AsaasClient) GetSubscriptionByID(ctx context.Context, id string) (Subsc
 	return resp, err
 }
 
+// UpdateSubscription changes a subscription by its Asaas ID.
+func (c *AsaasClient) UpdateSubscription(ctx context.Context, id string, req UpdateSubscriptionRequest) (SubscriptionResponse, error) {
+	var resp SubscriptionResponse
+	endpoint := path.Join("subscriptions", id)
+	err := c.doRequest(ctx, http.MethodPut, endpoint, req, &resp)
+	return resp, err
+}
+
+// ListSubscriptionPayments iterates over the payments generated for a subscription by its Asaas ID.
+func (c *AsaasClient) ListSubscriptionPayments(ctx context.Context, id string) *ListIterator[PaymentResponse] {
+	return listEndpoint[PaymentResponse](ctx, c, path.Join("subscriptions", id, "payments"), url.Values{}, MaxPageSize)
+}
+
 // CancelSubscription cancels a subscription in Asaas.
 func (c *AsaasClient) CancelSubscription(ctx context.Context, externalReference string) (SubscriptionResponse, error) {
 	subscription, err := c.GetSubscription(ctx, externalReference)
 	if err != nil {
 		return SubscriptionResponse{}, err
 	}
-	endpoint := path.Join("subscriptions", subscription.ID)
+	return c.CancelSubscriptionByID(ctx, subscription.ID)
+}
+
+// CancelSubscriptionByID cancels a subscription in Asaas by its Asaas ID.
+func (c *AsaasClient) CancelSubscriptionByID(ctx context.Context, id string) (SubscriptionResponse, error) {
+	endpoint := path.Join("subscriptions", id)
 	var resp SubscriptionResponse
-	err = c.doRequest(ctx, http.MethodDelete, endpoint, nil, &resp)
+	err := c.doRequest(ctx, http.MethodDelete, endpoint, nil, &resp)
 	return resp, err
 }
 
@@ -376,7 +806,15 @@ func (c *AsaasClient) GetInvoice(ctx context.Context, externalReference string)
 		return InvoiceResponse{}, err
 	}
 	if len(resp.Data) == 0 {
-		return InvoiceResponse{}, fmt.Errorf("nota fiscal n\u00e3o encontrada para externalReference=%s", externalReference)
+		return InvoiceResponse{}, newNotFoundError("nota fiscal n\u00e3o encontrada para externalReference=%s", externalReference)
 	}
 	return resp.Data[0], nil
 }
+
+// CancelInvoice cancels a scheduled or authorized invoice in Asaas by its Asaas ID.
+func (c *AsaasClient) CancelInvoice(ctx context.Context, id string) (InvoiceResponse, error) {
+	var resp InvoiceResponse
+	endpoint := path.Join("invoices", id, "cancel")
+	err := c.doRequest(WithIdempotentRequest(ctx), http.MethodPost, endpoint, nil, &resp)
+	return resp, err
+}
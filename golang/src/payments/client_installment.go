@@ -0,0 +1,60 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// InstallmentResponse is an installment plan in Asaas. Value is the total of the
+// plan and PaymentValue the value of each installment.
+type InstallmentResponse struct {
+	ID               string `json:"id"`
+	Customer         string `json:"customer"`
+	BillingType      string `json:"billingType"`
+	Value            Money  `json:"value"`
+	PaymentValue     Money  `json:"paymentValue"`
+	InstallmentCount int    `json:"installmentCount"`
+	Description      string `json:"description,omitempty"`
+	Deleted          bool   `json:"deleted"`
+}
+
+// ListInstallmentPayments iterates over the payments of an installment plan by its Asaas ID.
+func (c *AsaasClient) ListInstallmentPayments(ctx context.Context, id string) *ListIterator[PaymentResponse] {
+	return listEndpoint[PaymentResponse](ctx, c, path.Join("installments", id, "payments"), url.Values{}, MaxPageSize)
+}
+
+// RefundInstallment refunds every paid installment of a plan by its Asaas ID. It is
+// not retried, as Asaas may have refunded part of the plan before failing.
+func (c *AsaasClient) RefundInstallment(ctx context.Context, id string) (InstallmentResponse, error) {
+	var resp InstallmentResponse
+	endpoint := path.Join("installments", id, "refund")
+	err := c.doRequest(ctx, http.MethodPost, endpoint, nil, &resp)
+	return resp, err
+}
+
+// CancelRemainingInstallments removes the installments of a plan that were not paid
+// yet, by the plan's Asaas ID, and returns the removed payments. Paid installments
+// are kept. When a removal fails, the payments removed before it are returned with
+// the error.
+func (c *AsaasClient) CancelRemainingInstallments(ctx context.Context, id string) ([]PaymentResponse, error) {
+	pending, err := c.ListInstallmentPayments(ctx, id).All()
+	if err != nil {
+		return nil, err
+	}
+	var cancelled []PaymentResponse
+	var errs []error
+	for _, payment := range pending {
+		if payment.Status != "PENDING" && payment.Status != "OVERDUE" {
+			continue
+		}
+		if err := c.DeletePayment(ctx, payment.ID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cancelled = append(cancelled, payment)
+	}
+	return cancelled, errors.Join(errs...)
+}
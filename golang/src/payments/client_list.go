@@ -0,0 +1,260 @@
+package payments
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MaxPageSize is the largest page Asaas returns from list endpoints.
+const MaxPageSize = 100
+
+// ListResponse is one page of an Asaas list endpoint.
+type ListResponse[T any] struct {
+	HasMore    bool `json:"hasMore"`
+	TotalCount int  `json:"totalCount"`
+	Limit      int  `json:"limit"`
+	Offset     int  `json:"offset"`
+	Data       []T  `json:"data"`
+}
+
+// CustomerFilter narrows ListCustomers. Empty fields are not sent, and creation
+// date bounds are inclusive and compared by day.
+type CustomerFilter struct {
+	Name              string
+	Email             string
+	CpfCnpj           string
+	ExternalReference string
+	CreatedFrom       time.Time
+	CreatedTo         time.Time
+	// PageSize is the number of items fetched per request; zero means MaxPageSize.
+	PageSize int
+}
+
+// PaymentFilter narrows ListPayments. Customer, Subscription and Installment are Asaas IDs,
+// and date bounds are inclusive and compared by day.
+type PaymentFilter struct {
+	Customer          string
+	Subscription      string
+	Installment       string
+	Status            string
+	BillingType       string
+	ExternalReference string
+	CreatedFrom       time.Time
+	CreatedTo         time.Time
+	DueDateFrom       time.Time
+	DueDateTo         time.Time
+	// PageSize is the number of items fetched per request; zero means MaxPageSize.
+	PageSize int
+}
+
+// SubscriptionFilter narrows ListSubscriptions. Customer is an Asaas ID, and
+// creation date bounds are inclusive and compared by day.
+type SubscriptionFilter struct {
+	Customer          string
+	Status            string
+	BillingType       string
+	ExternalReference string
+	CreatedFrom       time.Time
+	CreatedTo         time.Time
+	// PageSize is the number of items fetched per request; zero means MaxPageSize.
+	PageSize int
+}
+
+// InvoiceFilter narrows ListInvoices. Customer and Payment are Asaas IDs, and
+// effective date bounds are inclusive.
+type InvoiceFilter struct {
+	Customer          string
+	Payment           string
+	Status            string
+	ExternalReference string
+	EffectiveFrom     time.Time
+	EffectiveTo       time.Time
+	// PageSize is the number of items fetched per request; zero means MaxPageSize.
+	PageSize int
+}
+
+func (f CustomerFilter) query() url.Values {
+	query := url.Values{}
+	setQuery(query, "name", f.Name)
+	setQuery(query, "email", f.Email)
+	setQuery(query, "cpfCnpj", f.CpfCnpj)
+	setQuery(query, "externalReference", f.ExternalReference)
+	setDateQuery(query, "dateCreated[ge]", f.CreatedFrom)
+	setDateQuery(query, "dateCreated[le]", f.CreatedTo)
+	return query
+}
+
+func (f PaymentFilter) query() url.Values {
+	query := url.Values{}
+	setQuery(query, "customer", f.Customer)
+	setQuery(query, "subscription", f.Subscription)
+	setQuery(query, "installment", f.Installment)
+	setQuery(query, "status", f.Status)
+	setQuery(query, "billingType", f.BillingType)
+	setQuery(query, "externalReference", f.ExternalReference)
+	setDateQuery(query, "dateCreated[ge]", f.CreatedFrom)
+	setDateQuery(query, "dateCreated[le]", f.CreatedTo)
+	setDateQuery(query, "dueDate[ge]", f.DueDateFrom)
+	setDateQuery(query, "dueDate[le]", f.DueDateTo)
+	return query
+}
+
+func (f SubscriptionFilter) query() url.Values {
+	query := url.Values{}
+	setQuery(query, "customer", f.Customer)
+	setQuery(query, "status", f.Status)
+	setQuery(query, "billingType", f.BillingType)
+	setQuery(query, "externalReference", f.ExternalReference)
+	setDateQuery(query, "dateCreated[ge]", f.CreatedFrom)
+	setDateQuery(query, "dateCreated[le]", f.CreatedTo)
+	return query
+}
+
+func (f InvoiceFilter) query() url.Values {
+	query := url.Values{}
+	setQuery(query, "customer", f.Customer)
+	setQuery(query, "payment", f.Payment)
+	setQuery(query, "status", f.Status)
+	setQuery(query, "externalReference", f.ExternalReference)
+	setDateQuery(query, "effectiveDate[ge]", f.EffectiveFrom)
+	setDateQuery(query, "effectiveDate[le]", f.EffectiveTo)
+	return query
+}
+
+func setQuery(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+func setDateQuery(query url.Values, key string, value time.Time) {
+	if !value.IsZero() {
+		query.Set(key, value.Format("2006-01-02"))
+	}
+}
+
+// ListCustomers iterates over the customers matching filter.
+func (c *AsaasClient) ListCustomers(ctx context.Context, filter CustomerFilter) *ListIterator[CustomerResponse] {
+	return listEndpoint[CustomerResponse](ctx, c, "customers", filter.query(), filter.PageSize)
+}
+
+// ListPayments iterates over the payments matching filter.
+func (c *AsaasClient) ListPayments(ctx context.Context, filter PaymentFilter) *ListIterator[PaymentResponse] {
+	return listEndpoint[PaymentResponse](ctx, c, "payments", filter.query(), filter.PageSize)
+}
+
+// ListSubscriptions iterates over the subscriptions matching filter.
+func (c *AsaasClient) ListSubscriptions(ctx context.Context, filter SubscriptionFilter) *ListIterator[SubscriptionResponse] {
+	return listEndpoint[SubscriptionResponse](ctx, c, "subscriptions", filter.query(), filter.PageSize)
+}
+
+// ListInvoices iterates over the invoices matching filter.
+func (c *AsaasClient) ListInvoices(ctx context.Context, filter InvoiceFilter) *ListIterator[InvoiceResponse] {
+	return listEndpoint[InvoiceResponse](ctx, c, "invoices", filter.query(), filter.PageSize)
+}
+
+// listEndpoint builds an iterator that pages through endpoint with offset and limit.
+func listEndpoint[T any](ctx context.Context, c *AsaasClient, endpoint string, query url.Values, pageSize int) *ListIterator[T] {
+	if pageSize <= 0 || pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	return &ListIterator[T]{
+		ctx:      ctx,
+		pageSize: pageSize,
+		fetch: func(ctx context.Context, offset, limit int) (ListResponse[T], error) {
+			pageQuery := url.Values{}
+			for key, values := range query {
+				pageQuery[key] = values
+			}
+			pageQuery.Set("offset", strconv.Itoa(offset))
+			pageQuery.Set("limit", strconv.Itoa(limit))
+			var resp ListResponse[T]
+			err := c.doRequestWithQuery(ctx, http.MethodGet, endpoint, pageQuery, nil, &resp)
+			return resp, err
+		},
+	}
+}
+
+// ListIterator walks an Asaas list, requesting the next page only when the
+// current one has been consumed. Pages are addressed by offset, so items created
+// or removed during the iteration may be skipped or seen twice.
+//
+//	it := client.ListPayments(ctx, payments.PaymentFilter{Status: "OVERDUE"})
+//	for it.Next() {
+//		payment := it.Item()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// A ListIterator is not safe for concurrent use.
+type ListIterator[T any] struct {
+	ctx      context.Context
+	fetch    func(ctx context.Context, offset, limit int) (ListResponse[T], error)
+	pageSize int
+	offset   int
+	page     []T
+	item     T
+	total    int
+	fetched  bool
+	hasMore  bool
+	err      error
+}
+
+// Next advances to the next item, fetching a page when needed. It returns false
+// at the end of the list, after a request fails or once the context is done.
+func (it *ListIterator[T]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		return false
+	}
+	for len(it.page) == 0 {
+		if it.fetched && !it.hasMore {
+			return false
+		}
+		resp, err := it.fetch(it.ctx, it.offset, it.pageSize)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.fetched = true
+		it.page = resp.Data
+		it.offset += len(resp.Data)
+		it.total = resp.TotalCount
+		// An empty page never advances the offset, so it ends the list even if hasMore is set.
+		it.hasMore = resp.HasMore && len(resp.Data) > 0
+	}
+	it.item, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Item returns the item loaded by the last call to Next.
+func (it *ListIterator[T]) Item() T {
+	return it.item
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *ListIterator[T]) Err() error {
+	return it.err
+}
+
+// TotalCount returns the total reported by Asaas, known after the first call to Next.
+func (it *ListIterator[T]) TotalCount() int {
+	return it.total
+}
+
+// All consumes the remaining items.
+func (it *ListIterator[T]) All() ([]T, error) {
+	var items []T
+	for it.Next() {
+		items = append(items, it.Item())
+	}
+	return items, it.Err()
+}
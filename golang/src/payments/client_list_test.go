@@ -0,0 +1,30 @@
+package payments
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestListFilterQueries(t *testing.T) {
+	from := time.Date(2024, 6, 1, 15, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query url.Values
+		want  string
+	}{
+		{"customers", CustomerFilter{Email: "ana@example.com", CreatedFrom: from, CreatedTo: to}.query(), "dateCreated%5Bge%5D=2024-06-01&dateCreated%5Ble%5D=2024-06-30&email=ana%40example.com"},
+		{"customers without dates", CustomerFilter{Name: "Ana"}.query(), "name=Ana"},
+		{"payments", PaymentFilter{Customer: "cus_1", CreatedFrom: from, DueDateTo: to}.query(), "customer=cus_1&dateCreated%5Bge%5D=2024-06-01&dueDate%5Ble%5D=2024-06-30"},
+		{"subscriptions", SubscriptionFilter{Status: "ACTIVE", CreatedTo: to}.query(), "dateCreated%5Ble%5D=2024-06-30&status=ACTIVE"},
+		{"invoices", InvoiceFilter{Payment: "pay_1", EffectiveFrom: from}.query(), "effectiveDate%5Bge%5D=2024-06-01&payment=pay_1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.Encode(); got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
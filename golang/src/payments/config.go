@@ -3,12 +3,16 @@ package payments
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Config holds credentials and endpoints for the Asaas API.
 type Config struct {
-	APIURL   string
-	APIToken string
+	APIURL    string
+	APIToken  string
+	Retry     RetryPolicy
+	RateLimit RateLimit
 }
 
 // LoadConfigFromEnv builds a Config using environment variables.
@@ -21,5 +25,147 @@ func LoadConfigFromEnv() (Config, error) {
 	if token == "" {
 		return Config{}, fmt.Errorf("ASAAS_API_TOKEN n\u00e3o est\u00e1 definido")
 	}
-	return Config{APIURL: apiURL, APIToken: token}, nil
+
+	retry := DefaultRetryPolicy()
+	var err error
+	if retry.MaxAttempts, err = envInt("ASAAS_RETRY_MAX_ATTEMPTS", retry.MaxAttempts); err != nil {
+		return Config{}, err
+	}
+	if retry.BaseDelay, err = envDuration("ASAAS_RETRY_BASE_DELAY", retry.BaseDelay); err != nil {
+		return Config{}, err
+	}
+	if retry.MaxDelay, err = envDuration("ASAAS_RETRY_MAX_DELAY", retry.MaxDelay); err != nil {
+		return Config{}, err
+	}
+	if retry.Jitter, err = envFloat("ASAAS_RETRY_JITTER", retry.Jitter); err != nil {
+		return Config{}, err
+	}
+
+	rateLimit := DefaultRateLimit()
+	if rateLimit.RequestsPerSecond, err = envFloat("ASAAS_RATE_LIMIT_RPS", rateLimit.RequestsPerSecond); err != nil {
+		return Config{}, err
+	}
+	if rateLimit.Burst, err = envInt("ASAAS_RATE_LIMIT_BURST", rateLimit.Burst); err != nil {
+		return Config{}, err
+	}
+	if rateLimit.PauseOn429, err = envDuration("ASAAS_RATE_LIMIT_PAUSE", rateLimit.PauseOn429); err != nil {
+		return Config{}, err
+	}
+
+	return Config{APIURL: apiURL, APIToken: token, Retry: retry, RateLimit: rateLimit}, nil
+}
+
+// LoadWebhookQueueConfigFromEnv builds the webhook queue settings from optional environment variables.
+func LoadWebhookQueueConfigFromEnv() (WebhookQueueConfig, error) {
+	cfg := DefaultWebhookQueueConfig()
+	var err error
+	if cfg.Workers, err = envInt("WEBHOOK_WORKERS", cfg.Workers); err != nil {
+		return WebhookQueueConfig{}, err
+	}
+	if cfg.PollInterval, err = envDuration("WEBHOOK_POLL_INTERVAL", cfg.PollInterval); err != nil {
+		return WebhookQueueConfig{}, err
+	}
+	if cfg.LockTimeout, err = envDuration("WEBHOOK_LOCK_TIMEOUT", cfg.LockTimeout); err != nil {
+		return WebhookQueueConfig{}, err
+	}
+	if cfg.Retry.MaxAttempts, err = envInt("WEBHOOK_MAX_ATTEMPTS", cfg.Retry.MaxAttempts); err != nil {
+		return WebhookQueueConfig{}, err
+	}
+	if cfg.Retry.BaseDelay, err = envDuration("WEBHOOK_RETRY_BASE_DELAY", cfg.Retry.BaseDelay); err != nil {
+		return WebhookQueueConfig{}, err
+	}
+	if cfg.Retry.MaxDelay, err = envDuration("WEBHOOK_RETRY_MAX_DELAY", cfg.Retry.MaxDelay); err != nil {
+		return WebhookQueueConfig{}, err
+	}
+	return cfg, nil
+}
+
+// LoadChargeDefaultsFromEnv builds the default discount, interest and fine from
+// optional environment variables. Each term is only set when its value is.
+func LoadChargeDefaultsFromEnv() (ChargeDefaults, error) {
+	var defaults ChargeDefaults
+	discount, err := envPercentage("CHARGE_DEFAULT_DISCOUNT_VALUE")
+	if err != nil {
+		return ChargeDefaults{}, err
+	}
+	if discount != 0 {
+		days, err := envInt("CHARGE_DEFAULT_DISCOUNT_DUE_DATE_LIMIT_DAYS", 0)
+		if err != nil {
+			return ChargeDefaults{}, err
+		}
+		defaults.Discount = &Discount{Value: discount, DueDateLimitDays: days, Type: envString("CHARGE_DEFAULT_DISCOUNT_TYPE", ValueTypePercentage)}
+	}
+	interest, err := envPercentage("CHARGE_DEFAULT_INTEREST_VALUE")
+	if err != nil {
+		return ChargeDefaults{}, err
+	}
+	if interest != 0 {
+		defaults.Interest = &Interest{Value: interest}
+	}
+	fine, err := envPercentage("CHARGE_DEFAULT_FINE_VALUE")
+	if err != nil {
+		return ChargeDefaults{}, err
+	}
+	if fine != 0 {
+		defaults.Fine = &Fine{Value: fine, Type: envString("CHARGE_DEFAULT_FINE_TYPE", ValueTypePercentage)}
+	}
+	if err := defaults.Validate(); err != nil {
+		return ChargeDefaults{}, err
+	}
+	return defaults, nil
+}
+
+func envString(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func envPercentage(name string) (Percentage, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := ParsePercentage(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s inv\u00e1lida: %w", name, err)
+	}
+	return parsed, nil
+}
+
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s inv\u00e1lida: %w", name, err)
+	}
+	return parsed, nil
+}
+
+func envFloat(name string, fallback float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s inv\u00e1lida: %w", name, err)
+	}
+	return parsed, nil
+}
+
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s inv\u00e1lida: %w", name, err)
+	}
+	return parsed, nil
 }
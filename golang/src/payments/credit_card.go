@@ -0,0 +1,94 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrInvalidCreditCard is returned when a card cannot be tokenized with the data given.
+var ErrInvalidCreditCard = errors.New("cartão de crédito inválido")
+
+// TokenizeCreditCard tokenizes a card in Asaas and saves the token, brand and last
+// four digits on the customer, so later CREDIT_CARD charges and subscriptions of
+// the customer can set UseSavedCard instead of the card. The card data itself is
+// only sent to Asaas.
+func (s *Service) TokenizeCreditCard(ctx context.Context, customerID string, req TokenizeCreditCardRequest) (CustomerRecord, error) {
+	if req.CreditCard == nil || req.CreditCardHolderInfo == nil {
+		return CustomerRecord{}, fmt.Errorf("%w: creditCard e creditCardHolderInfo são obrigatórios", ErrInvalidCreditCard)
+	}
+	customer, err := s.activeCustomer(ctx, customerID)
+	if err != nil {
+		return CustomerRecord{}, err
+	}
+	remoteCustomerID, err := s.remoteCustomerID(ctx, customer)
+	if err != nil {
+		return CustomerRecord{}, fmt.Errorf("falha ao buscar cliente no Asaas para id %s: %w", customerID, err)
+	}
+
+	req.Customer = remoteCustomerID
+	card, err := s.client.TokenizeCreditCard(ctx, req)
+	if err != nil {
+		return CustomerRecord{}, fmt.Errorf("falha ao tokenizar cartão no Asaas: %w", err)
+	}
+	if err := s.repo.SetCustomerCreditCard(ctx, customer.ID, card.CreditCardToken, card.CreditCardBrand, card.CreditCardNumber); err != nil {
+		return CustomerRecord{}, fmt.Errorf("falha ao salvar cartão do cliente %s: %w", customer.ID, err)
+	}
+	customer.CreditCardToken = card.CreditCardToken
+	customer.CreditCardBrand = card.CreditCardBrand
+	customer.CreditCardLast4 = card.CreditCardNumber
+	return customer, nil
+}
+
+// savedCreditCardToken returns the token to charge: the one in the request, or the
+// card saved for the customer when the request sets useSaved. The saved card is
+// never charged implicitly.
+func savedCreditCardToken(customer CustomerRecord, billingType string, useSaved bool, card *CreditCard, token string) (string, error) {
+	if !useSaved {
+		return token, nil
+	}
+	switch {
+	case billingType != "CREDIT_CARD":
+		return "", fmt.Errorf("%w: useSavedCard exige CREDIT_CARD", ErrInvalidCreditCard)
+	case card != nil || token != "":
+		return "", fmt.Errorf("%w: useSavedCard não pode ser combinado com creditCard ou creditCardToken", ErrInvalidCreditCard)
+	case customer.CreditCardToken == "":
+		return "", fmt.Errorf("%w: cliente %s não tem cartão salvo", ErrInvalidCreditCard, customer.ID)
+	}
+	return customer.CreditCardToken, nil
+}
+
+// rememberCreditCard saves the card Asaas charged for a customer when the request
+// asked for it and the card differs from the saved one. The charge already
+// succeeded, so failures are only logged.
+func (s *Service) rememberCreditCard(ctx context.Context, customer CustomerRecord, save bool, card *CreditCardTokenResponse) {
+	if !save || card == nil || card.CreditCardToken == "" || card.CreditCardToken == customer.CreditCardToken {
+		return
+	}
+	if err := s.repo.SetCustomerCreditCard(ctx, customer.ID, card.CreditCardToken, card.CreditCardBrand, card.CreditCardNumber); err != nil {
+		log.Printf("failed to save credit card of customer %s: %v", customer.ID, err)
+	}
+}
+
+// WithoutCardToken returns the payment without the token of the card charged, so
+// responses leaving the service cannot be used to charge the card again.
+func (p PaymentResponse) WithoutCardToken() PaymentResponse {
+	p.CreditCard = withoutCardToken(p.CreditCard)
+	return p
+}
+
+// WithoutCardToken returns the subscription without the token of its card.
+func (r SubscriptionResponse) WithoutCardToken() SubscriptionResponse {
+	r.CreditCard = withoutCardToken(r.CreditCard)
+	return r
+}
+
+func withoutCardToken(card *CreditCardTokenResponse) *CreditCardTokenResponse {
+	if card == nil || card.CreditCardToken == "" {
+		return card
+	}
+	masked := *card
+	masked.CreditCardToken = ""
+	return &masked
+}
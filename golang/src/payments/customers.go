@@ -0,0 +1,116 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrCustomerDeleted is returned when an operation targets a removed customer.
+var ErrCustomerDeleted = errors.New("cliente removido")
+
+// UpdateCustomer changes a customer in Asaas and stores the resulting data locally.
+// Nil fields in req keep their current value.
+func (s *Service) UpdateCustomer(ctx context.Context, id string, req UpdateCustomerRequest) (CustomerRecord, CustomerResponse, error) {
+	customer, err := s.activeCustomer(ctx, id)
+	if err != nil {
+		return CustomerRecord{}, CustomerResponse{}, err
+	}
+
+	remoteID, err := s.remoteCustomerID(ctx, customer)
+	if err != nil {
+		return CustomerRecord{}, CustomerResponse{}, fmt.Errorf("falha ao buscar cliente no Asaas para id %s: %w", id, err)
+	}
+
+	req.ExternalID = customer.ID
+	remote, err := s.client.UpdateCustomer(ctx, remoteID, req)
+	if err != nil {
+		return CustomerRecord{}, CustomerResponse{}, fmt.Errorf("falha ao atualizar cliente no Asaas: %w", err)
+	}
+
+	// Asaas answers with the full customer, so the local copy mirrors what it kept.
+	customer.Name = remote.Name
+	customer.Email = remote.Email
+	customer.CpfCnpj = remote.CpfCnpj
+	customer.Phone = remote.Phone
+	customer.MobilePhone = remote.MobilePhone
+	customer.Address = remote.Address
+	customer.AddressNumber = remote.AddressNumber
+	customer.Complement = remote.Complement
+	customer.Province = remote.Province
+	customer.PostalCode = remote.PostalCode
+	customer.NotificationDisabled = remote.NotificationDisabled
+	customer.AdditionalEmails = remote.AdditionalEmails
+	customer.UpdatedAt = time.Now().UTC()
+	if err := s.repo.UpdateCustomer(ctx, customer); err != nil {
+		return CustomerRecord{}, CustomerResponse{}, fmt.Errorf("falha ao atualizar cliente local: %w", err)
+	}
+
+	return customer, remote, nil
+}
+
+// DeleteCustomer removes a customer in Asaas and marks it as removed locally.
+// Removing a customer that is already removed is a no-op.
+func (s *Service) DeleteCustomer(ctx context.Context, id string) error {
+	customer, err := s.repo.FindCustomerByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("falha ao localizar cliente %s: %w", id, err)
+	}
+	if !customer.DeletedAt.IsZero() {
+		return nil
+	}
+
+	remoteID, err := s.remoteCustomerID(ctx, customer)
+	if err != nil {
+		return fmt.Errorf("falha ao buscar cliente no Asaas para id %s: %w", id, err)
+	}
+
+	var asaasErr *AsaasError
+	if err := s.client.DeleteCustomer(ctx, remoteID); err != nil && !(errors.As(err, &asaasErr) && asaasErr.StatusCode == http.StatusNotFound) {
+		return fmt.Errorf("falha ao remover cliente no Asaas: %w", err)
+	}
+
+	if err := s.repo.SetCustomerDeletedAt(ctx, customer.ID, time.Now().UTC()); err != nil {
+		return fmt.Errorf("falha ao marcar cliente local como removido: %w", err)
+	}
+	return nil
+}
+
+// RestoreCustomer restores a removed customer in Asaas and locally.
+func (s *Service) RestoreCustomer(ctx context.Context, id string) (CustomerRecord, CustomerResponse, error) {
+	customer, err := s.repo.FindCustomerByID(ctx, id)
+	if err != nil {
+		return CustomerRecord{}, CustomerResponse{}, fmt.Errorf("falha ao localizar cliente %s: %w", id, err)
+	}
+
+	remoteID, err := s.remoteCustomerID(ctx, customer)
+	if err != nil {
+		return CustomerRecord{}, CustomerResponse{}, fmt.Errorf("falha ao buscar cliente no Asaas para id %s: %w", id, err)
+	}
+
+	remote, err := s.client.RestoreCustomer(ctx, remoteID)
+	if err != nil {
+		return CustomerRecord{}, CustomerResponse{}, fmt.Errorf("falha ao restaurar cliente no Asaas: %w", err)
+	}
+
+	if err := s.repo.SetCustomerDeletedAt(ctx, customer.ID, time.Time{}); err != nil {
+		return CustomerRecord{}, CustomerResponse{}, fmt.Errorf("falha ao restaurar cliente local: %w", err)
+	}
+	customer.DeletedAt = time.Time{}
+
+	return customer, remote, nil
+}
+
+// activeCustomer loads a customer and rejects it when it was removed.
+func (s *Service) activeCustomer(ctx context.Context, id string) (CustomerRecord, error) {
+	customer, err := s.repo.FindCustomerByID(ctx, id)
+	if err != nil {
+		return CustomerRecord{}, fmt.Errorf("falha ao localizar cliente %s: %w", id, err)
+	}
+	if !customer.DeletedAt.IsZero() {
+		return CustomerRecord{}, fmt.Errorf("cliente %s: %w", id, ErrCustomerDeleted)
+	}
+	return customer, nil
+}
@@ -0,0 +1,249 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Local installment plan statuses.
+const (
+	InstallmentStatusActive    = "ACTIVE"
+	InstallmentStatusRefunded  = "REFUNDED"
+	InstallmentStatusCancelled = "CANCELLED"
+)
+
+// PaymentStatusDeleted is the local status of a payment removed from Asaas, which
+// keeps its last status there and only flags it as deleted.
+const PaymentStatusDeleted = "DELETED"
+
+// ErrInvalidInstallment is returned when the installment fields of a payment request do not describe a plan.
+var ErrInvalidInstallment = errors.New("parcelamento inválido")
+
+// InstallmentDetails is an installment plan with its payments in installment order.
+type InstallmentDetails struct {
+	InstallmentRecord
+	Payments []PaymentRecord `json:"payments"`
+}
+
+// validateInstallment checks the installment fields of a payment request. A plan
+// takes either the value of each installment or the total value, never both.
+func validateInstallment(req PaymentRequest) error {
+	if req.InstallmentValue == 0 && req.TotalValue == 0 {
+		return nil
+	}
+	switch {
+	case req.InstallmentValue < 0 || req.TotalValue < 0:
+		return fmt.Errorf("%w: valores negativos", ErrInvalidInstallment)
+	case req.InstallmentValue != 0 && req.TotalValue != 0:
+		return fmt.Errorf("%w: informe installmentValue ou totalValue, não ambos", ErrInvalidInstallment)
+	case req.InstallmentCount < 2:
+		return fmt.Errorf("%w: installmentCount deve ser maior que 1", ErrInvalidInstallment)
+	}
+	return nil
+}
+
+// newInstallmentRecord describes the plan Asaas created for a payment request.
+func newInstallmentRecord(customerID, asaasID string, req PaymentRequest) InstallmentRecord {
+	installmentValue, totalValue := req.InstallmentValue, req.TotalValue
+	if installmentValue == 0 {
+		installmentValue = totalValue / Money(req.InstallmentCount)
+	}
+	if totalValue == 0 {
+		totalValue = installmentValue * Money(req.InstallmentCount)
+	}
+	now := time.Now().UTC()
+	return InstallmentRecord{
+		ID:               generateID(),
+		AsaasID:          asaasID,
+		CustomerID:       customerID,
+		BillingType:      req.BillingType,
+		InstallmentCount: req.InstallmentCount,
+		InstallmentValue: installmentValue,
+		TotalValue:       totalValue,
+		Description:      req.Description,
+		Status:           InstallmentStatusActive,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+}
+
+// Installment returns an installment plan and its payments from the local database.
+func (s *Service) Installment(ctx context.Context, id string) (InstallmentDetails, error) {
+	installment, err := s.repo.FindInstallmentByID(ctx, id)
+	if err != nil {
+		return InstallmentDetails{}, fmt.Errorf("falha ao localizar parcelamento %s: %w", id, err)
+	}
+	return s.installmentDetails(ctx, installment)
+}
+
+// RefundInstallment refunds every paid installment of a plan in Asaas and updates
+// the local payments. The refunds themselves are recorded by the webhooks Asaas
+// sends for each payment.
+func (s *Service) RefundInstallment(ctx context.Context, id string) (InstallmentDetails, error) {
+	installment, err := s.repo.FindInstallmentByID(ctx, id)
+	if err != nil {
+		return InstallmentDetails{}, fmt.Errorf("falha ao localizar parcelamento %s: %w", id, err)
+	}
+	if installment.Status == InstallmentStatusRefunded {
+		return InstallmentDetails{}, fmt.Errorf("%w: parcelamento %s já estornado", ErrInvalidRefund, id)
+	}
+	if _, err := s.client.RefundInstallment(ctx, installment.AsaasID); err != nil {
+		return InstallmentDetails{}, fmt.Errorf("falha ao estornar parcelamento no Asaas: %w", err)
+	}
+	if err := s.repo.UpdateInstallmentStatus(ctx, installment.ID, InstallmentStatusRefunded); err != nil {
+		return InstallmentDetails{}, fmt.Errorf("falha ao atualizar parcelamento local: %w", err)
+	}
+	installment.Status = InstallmentStatusRefunded
+	if err := s.syncInstallmentPayments(ctx, installment); err != nil {
+		return InstallmentDetails{}, fmt.Errorf("falha ao sincronizar parcelas do parcelamento %s: %w", id, err)
+	}
+	return s.installmentDetails(ctx, installment)
+}
+
+// CancelRemainingInstallments removes from Asaas the installments of a plan that were
+// not paid yet and marks them as DELETED locally. Paid installments are kept, and the
+// plan is cancelled even when some removals fail; those are reported in the error.
+func (s *Service) CancelRemainingInstallments(ctx context.Context, id string) (InstallmentDetails, error) {
+	installment, err := s.repo.FindInstallmentByID(ctx, id)
+	if err != nil {
+		return InstallmentDetails{}, fmt.Errorf("falha ao localizar parcelamento %s: %w", id, err)
+	}
+	cancelled, cancelErr := s.client.CancelRemainingInstallments(ctx, installment.AsaasID)
+	if cancelErr != nil && len(cancelled) == 0 {
+		return InstallmentDetails{}, fmt.Errorf("falha ao cancelar parcelas no Asaas: %w", cancelErr)
+	}
+	for _, remote := range cancelled {
+		payment, err := s.repo.FindPaymentByAsaasID(ctx, remote.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err == nil {
+			err = s.repo.UpdatePaymentStatus(ctx, payment.ID, PaymentStatusDeleted, payment.InvoiceURL, payment.TransactionReceiptURL)
+		}
+		if err != nil {
+			return InstallmentDetails{}, fmt.Errorf("falha ao atualizar parcela %s: %w", remote.ID, err)
+		}
+	}
+	if err := s.repo.UpdateInstallmentStatus(ctx, installment.ID, InstallmentStatusCancelled); err != nil {
+		return InstallmentDetails{}, fmt.Errorf("falha ao atualizar parcelamento local: %w", err)
+	}
+	installment.Status = InstallmentStatusCancelled
+	details, err := s.installmentDetails(ctx, installment)
+	if err != nil {
+		return InstallmentDetails{}, err
+	}
+	if cancelErr != nil {
+		return details, fmt.Errorf("falha ao cancelar parcelas no Asaas: %w", cancelErr)
+	}
+	return details, nil
+}
+
+func (s *Service) installmentDetails(ctx context.Context, installment InstallmentRecord) (InstallmentDetails, error) {
+	payments, err := s.repo.ListPaymentsByInstallmentID(ctx, installment.ID)
+	if err != nil {
+		return InstallmentDetails{}, fmt.Errorf("falha ao listar parcelas do parcelamento %s: %w", installment.ID, err)
+	}
+	if payments == nil {
+		payments = []PaymentRecord{}
+	}
+	return InstallmentDetails{InstallmentRecord: installment, Payments: payments}, nil
+}
+
+// syncInstallmentPayments imports the payments of a plan that are not stored locally
+// and copies the status of the others.
+func (s *Service) syncInstallmentPayments(ctx context.Context, installment InstallmentRecord) error {
+	remotes, err := s.client.ListInstallmentPayments(ctx, installment.AsaasID).All()
+	if err != nil {
+		return fmt.Errorf("falha ao listar parcelas no Asaas: %w", err)
+	}
+	var errs []error
+	for _, remote := range remotes {
+		payment, err := s.repo.FindPaymentByAsaasID(ctx, remote.ID)
+		switch {
+		case err == nil:
+			if payment.Status != remote.Status && payment.Status != PaymentStatusDeleted {
+				err = s.repo.UpdatePaymentStatus(ctx, payment.ID, remote.Status, remote.InvoiceURL, remote.TransactionReceiptURL)
+			}
+			if err == nil {
+				err = s.linkExternalReference(ctx, payment, remote)
+			}
+		case errors.Is(err, sql.ErrNoRows):
+			_, err = s.importInstallmentPayment(ctx, installment, remote)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parcela %s: %w", remote.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncNewInstallment imports the other payments of a plan just created. The first
+// payment was already saved, so failures are only logged; the PAYMENT_CREATED
+// webhooks of the missing payments import them later.
+func (s *Service) syncNewInstallment(ctx context.Context, installment InstallmentRecord) {
+	if err := s.syncInstallmentPayments(ctx, installment); err != nil {
+		log.Printf("failed to import payments of installment %s: %v", installment.ID, err)
+	}
+}
+
+// importInstallmentPaymentEvent stores a payment announced by PAYMENT_CREATED that
+// belongs to a known installment plan. Plans created by this service are saved with
+// their first payment before the others are listed, so unknown plans are ignored;
+// events of a plan still being created are retried.
+func (s *Service) importInstallmentPaymentEvent(ctx context.Context, remote PaymentResponse) error {
+	if payment, err := s.repo.FindPaymentByAsaasID(ctx, remote.ID); err == nil {
+		return s.linkExternalReference(ctx, payment, remote)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err := s.checkPendingOperation(ctx, remote.ExternalReference); err != nil {
+		return err
+	}
+	installment, err := s.repo.FindInstallmentByAsaasID(ctx, remote.Installment)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	_, err = s.importInstallmentPayment(ctx, installment, remote)
+	return err
+}
+
+// importInstallmentPayment saves a payment of a plan under a new local ID and points
+// its externalReference in Asaas to it. When a concurrent import stored the payment
+// first, that payment is returned and only its externalReference is fixed if needed.
+func (s *Service) importInstallmentPayment(ctx context.Context, installment InstallmentRecord, remote PaymentResponse) (PaymentRecord, error) {
+	now := time.Now().UTC()
+	payment := PaymentRecord{
+		ID:                    generateID(),
+		AsaasID:               remote.ID,
+		CustomerID:            installment.CustomerID,
+		BillingType:           remote.BillingType,
+		Value:                 remote.Value,
+		DueDate:               parseDate(remote.DueDate),
+		Description:           remote.Description,
+		InstallmentCount:      installment.InstallmentCount,
+		InstallmentID:         installment.ID,
+		InstallmentNumber:     remote.InstallmentNumber,
+		Status:                remote.Status,
+		InvoiceURL:            remote.InvoiceURL,
+		TransactionReceiptURL: remote.TransactionReceiptURL,
+		ChargeTerms:           newChargeTerms(remote.Discount, remote.Interest, remote.Fine),
+		CreatedAt:             now,
+		UpdatedAt:             now,
+	}
+	payment, inserted, err := s.repo.SaveImportedPayment(ctx, payment)
+	if err != nil {
+		return PaymentRecord{}, fmt.Errorf("falha ao salvar pagamento local: %w", err)
+	}
+	if !inserted {
+		return payment, s.linkExternalReference(ctx, payment, remote)
+	}
+	s.saveNewSplits(ctx, payment.ID, "", remote.Split, nil)
+	return payment, s.linkExternalReference(ctx, payment, remote)
+}
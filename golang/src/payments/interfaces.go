@@ -0,0 +1,128 @@
+package payments
+
+import (
+	"context"
+	"time"
+)
+
+// Repository is the local persistence used by Service.
+type Repository interface {
+	SaveCustomer(ctx context.Context, customer CustomerRecord) error
+	FindCustomerByID(ctx context.Context, id string) (CustomerRecord, error)
+	FindCustomerByAsaasID(ctx context.Context, asaasID string) (CustomerRecord, error)
+	UpdateCustomer(ctx context.Context, customer CustomerRecord) error
+	SetCustomerDeletedAt(ctx context.Context, id string, deletedAt time.Time) error
+	SetCustomerAsaasID(ctx context.Context, id, asaasID string) error
+	SetCustomerCreditCard(ctx context.Context, id, token, brand, last4 string) error
+	ListCustomerIDsWithoutAsaasID(ctx context.Context) ([]string, error)
+	ListCustomers(ctx context.Context, filter ListFilter) (Page[CustomerRecord], error)
+
+	SavePayment(ctx context.Context, payment PaymentRecord) error
+	SaveImportedPayment(ctx context.Context, payment PaymentRecord) (PaymentRecord, bool, error)
+	FindPaymentByID(ctx context.Context, id string) (PaymentRecord, error)
+	FindPaymentByAsaasID(ctx context.Context, asaasID string) (PaymentRecord, error)
+	UpdatePaymentStatus(ctx context.Context, id, status, invoiceURL, receiptURL string) error
+	SetPaymentAsaasID(ctx context.Context, id, asaasID string) error
+	ListPaymentIDsWithoutAsaasID(ctx context.Context) ([]string, error)
+	TransitionPaymentAuthorization(ctx context.Context, id, from, to string) (bool, error)
+	ListExpiredAuthorizations(ctx context.Context, before time.Time, limit int) ([]PaymentRecord, error)
+	ListPayments(ctx context.Context, filter ListFilter) (Page[PaymentRecord], error)
+	ListPaymentsByInstallmentID(ctx context.Context, installmentID string) ([]PaymentRecord, error)
+
+	SaveInstallment(ctx context.Context, installment InstallmentRecord) error
+	SaveInstallmentPayment(ctx context.Context, installment InstallmentRecord, payment PaymentRecord) error
+	FindInstallmentByID(ctx context.Context, id string) (InstallmentRecord, error)
+	FindInstallmentByAsaasID(ctx context.Context, asaasID string) (InstallmentRecord, error)
+	UpdateInstallmentStatus(ctx context.Context, id, status string) error
+
+	SaveRefund(ctx context.Context, refund RefundRecord) error
+	ListRefundsByPaymentID(ctx context.Context, paymentID string) ([]RefundRecord, error)
+	UpdateRefund(ctx context.Context, refund RefundRecord) error
+
+	SaveSplit(ctx context.Context, split SplitRecord) error
+	ListSplitsByPaymentID(ctx context.Context, paymentID string) ([]SplitRecord, error)
+	ListSplitsBySubscriptionID(ctx context.Context, subscriptionID string) ([]SplitRecord, error)
+	UpdateSplit(ctx context.Context, split SplitRecord) error
+
+	SavePixQrCode(ctx context.Context, paymentID string, code PixQrCode) error
+	FindPixQrCode(ctx context.Context, paymentID string) (PixQrCode, error)
+	SaveBoleto(ctx context.Context, paymentID string, boleto Boleto) error
+	FindBoleto(ctx context.Context, paymentID string) (Boleto, error)
+	SaveBankSlipPDF(ctx context.Context, paymentID string, pdf []byte) error
+	FindBankSlipPDF(ctx context.Context, paymentID string) ([]byte, error)
+
+	SaveSubscription(ctx context.Context, subscription SubscriptionRecord) error
+	FindSubscriptionByID(ctx context.Context, id string) (SubscriptionRecord, error)
+	FindSubscriptionByAsaasID(ctx context.Context, asaasID string) (SubscriptionRecord, error)
+	UpdateSubscriptionStatus(ctx context.Context, id, status string) error
+	UpdateSubscription(ctx context.Context, subscription SubscriptionRecord) error
+	UpdatePendingSubscriptionPayments(ctx context.Context, subscriptionID, billingType string, value Money) error
+	SaveSubscriptionChange(ctx context.Context, change SubscriptionChangeRecord) error
+	ListSubscriptionChanges(ctx context.Context, subscriptionID string) ([]SubscriptionChangeRecord, error)
+	SetSubscriptionAsaasID(ctx context.Context, id, asaasID string) error
+	ListSubscriptionIDsWithoutAsaasID(ctx context.Context) ([]string, error)
+	ListSubscriptions(ctx context.Context, filter ListFilter) (Page[SubscriptionRecord], error)
+
+	SaveInvoice(ctx context.Context, invoice InvoiceRecord) error
+	FindInvoiceByID(ctx context.Context, id string) (InvoiceRecord, error)
+	FindInvoiceByAsaasID(ctx context.Context, asaasID string) (InvoiceRecord, error)
+	FindInvoiceByPaymentID(ctx context.Context, paymentID string) (InvoiceRecord, error)
+	UpdateInvoiceStatus(ctx context.Context, id, status string) error
+	SetInvoiceAsaasID(ctx context.Context, id, asaasID string) error
+	ListInvoiceIDsWithoutAsaasID(ctx context.Context) ([]string, error)
+	ListInvoices(ctx context.Context, filter ListFilter) (Page[InvoiceRecord], error)
+
+	SavePendingOperation(ctx context.Context, op PendingOperation) error
+	UpdatePendingOperation(ctx context.Context, op PendingOperation) error
+	FindPendingOperationByLocalID(ctx context.Context, localID string) (PendingOperation, error)
+	ListPendingOperations(ctx context.Context, before time.Time, limit int) ([]PendingOperation, error)
+
+	SaveWebhookEvent(ctx context.Context, event WebhookEventRecord) (WebhookEventRecord, bool, error)
+	FindWebhookEventByEventID(ctx context.Context, eventID string) (WebhookEventRecord, error)
+	ListWebhookEvents(ctx context.Context, from, to time.Time) ([]WebhookEventRecord, error)
+	UpdateWebhookEventStatus(ctx context.Context, id, status, message string, nextAttemptAt time.Time) error
+	ClaimWebhookEvent(ctx context.Context, staleBefore time.Time) (WebhookEventRecord, bool, error)
+	ClaimWebhookEventByID(ctx context.Context, id string, staleBefore time.Time) (WebhookEventRecord, bool, error)
+}
+
+// Gateway is the subset of the Asaas API used by Service.
+type Gateway interface {
+	CreateCustomer(ctx context.Context, req CustomerRequest) (CustomerResponse, error)
+	GetCustomer(ctx context.Context, id string) (CustomerResponse, error)
+	UpdateCustomer(ctx context.Context, id string, req UpdateCustomerRequest) (CustomerResponse, error)
+	DeleteCustomer(ctx context.Context, id string) error
+	RestoreCustomer(ctx context.Context, id string) (CustomerResponse, error)
+	TokenizeCreditCard(ctx context.Context, req TokenizeCreditCardRequest) (CreditCardTokenResponse, error)
+
+	CreatePayment(ctx context.Context, req PaymentRequest) (PaymentResponse, error)
+	GetPayment(ctx context.Context, id string) (PaymentResponse, error)
+	GetPaymentByID(ctx context.Context, id string) (PaymentResponse, error)
+	UpdatePaymentExternalReference(ctx context.Context, id, externalReference string) error
+	DeletePayment(ctx context.Context, id string) error
+	RefundPayment(ctx context.Context, id string, req RefundRequest) (PaymentResponse, error)
+	CapturePayment(ctx context.Context, id string) (PaymentResponse, error)
+	GetPixQrCode(ctx context.Context, id string) (PixQrCodeResponse, error)
+	GetIdentificationField(ctx context.Context, id string) (IdentificationFieldResponse, error)
+	DownloadBankSlip(ctx context.Context, id string) ([]byte, error)
+
+	ListInstallmentPayments(ctx context.Context, id string) *ListIterator[PaymentResponse]
+	RefundInstallment(ctx context.Context, id string) (InstallmentResponse, error)
+	CancelRemainingInstallments(ctx context.Context, id string) ([]PaymentResponse, error)
+
+	CreateSubscription(ctx context.Context, req SubscriptionRequest) (SubscriptionResponse, error)
+	GetSubscription(ctx context.Context, externalReference string) (SubscriptionResponse, error)
+	GetSubscriptionByID(ctx context.Context, id string) (SubscriptionResponse, error)
+	UpdateSubscription(ctx context.Context, id string, req UpdateSubscriptionRequest) (SubscriptionResponse, error)
+	ListSubscriptionPayments(ctx context.Context, id string) *ListIterator[PaymentResponse]
+	CancelSubscriptionByID(ctx context.Context, id string) (SubscriptionResponse, error)
+
+	CreateInvoice(ctx context.Context, req InvoiceRequest) (InvoiceResponse, error)
+	GetInvoice(ctx context.Context, externalReference string) (InvoiceResponse, error)
+	CancelInvoice(ctx context.Context, id string) (InvoiceResponse, error)
+}
+
+var (
+	_ Repository = (*PostgresRepository)(nil)
+	_ Repository = (*MemoryRepository)(nil)
+	_ Gateway    = (*AsaasClient)(nil)
+)
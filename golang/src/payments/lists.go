@@ -0,0 +1,42 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+)
+
+// ListCustomers lists local customers, newest first.
+func (s *Service) ListCustomers(ctx context.Context, filter ListFilter) (Page[CustomerRecord], error) {
+	page, err := s.repo.ListCustomers(ctx, filter)
+	if err != nil {
+		return Page[CustomerRecord]{}, fmt.Errorf("falha ao listar clientes: %w", err)
+	}
+	return page, nil
+}
+
+// ListPayments lists local payments, newest first.
+func (s *Service) ListPayments(ctx context.Context, filter ListFilter) (Page[PaymentRecord], error) {
+	page, err := s.repo.ListPayments(ctx, filter)
+	if err != nil {
+		return Page[PaymentRecord]{}, fmt.Errorf("falha ao listar cobranças: %w", err)
+	}
+	return page, nil
+}
+
+// ListSubscriptions lists local subscriptions, newest first.
+func (s *Service) ListSubscriptions(ctx context.Context, filter ListFilter) (Page[SubscriptionRecord], error) {
+	page, err := s.repo.ListSubscriptions(ctx, filter)
+	if err != nil {
+		return Page[SubscriptionRecord]{}, fmt.Errorf("falha ao listar assinaturas: %w", err)
+	}
+	return page, nil
+}
+
+// ListInvoices lists local invoices, newest first.
+func (s *Service) ListInvoices(ctx context.Context, filter ListFilter) (Page[InvoiceRecord], error) {
+	page, err := s.repo.ListInvoices(ctx, filter)
+	if err != nil {
+		return Page[InvoiceRecord]{}, fmt.Errorf("falha ao listar notas fiscais: %w", err)
+	}
+	return page, nil
+}
@@ -0,0 +1,1172 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRepository is an in-memory Repository for tests and local experiments.
+// It mirrors the constraints enforced by the PostgreSQL schema: unique IDs and
+// references to existing customers, payments, subscriptions and installment plans.
+type MemoryRepository struct {
+	mu                sync.Mutex
+	customers         map[string]CustomerRecord
+	payments          map[string]PaymentRecord
+	installments      map[string]InstallmentRecord
+	subscriptions     map[string]SubscriptionRecord
+	invoices          map[string]InvoiceRecord
+	refunds           map[string]RefundRecord
+	splits            map[string]SplitRecord
+	subscriptionLog   map[string]SubscriptionChangeRecord
+	pixQrCodes        map[string]PixQrCode
+	boletos           map[string]Boleto
+	bankSlipPDFs      map[string][]byte
+	pendingOperations map[string]PendingOperation
+	webhookEvents     map[string]WebhookEventRecord
+}
+
+// NewMemoryRepository builds an empty in-memory repository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		customers:         make(map[string]CustomerRecord),
+		payments:          make(map[string]PaymentRecord),
+		installments:      make(map[string]InstallmentRecord),
+		subscriptions:     make(map[string]SubscriptionRecord),
+		invoices:          make(map[string]InvoiceRecord),
+		refunds:           make(map[string]RefundRecord),
+		splits:            make(map[string]SplitRecord),
+		subscriptionLog:   make(map[string]SubscriptionChangeRecord),
+		pixQrCodes:        make(map[string]PixQrCode),
+		boletos:           make(map[string]Boleto),
+		bankSlipPDFs:      make(map[string][]byte),
+		pendingOperations: make(map[string]PendingOperation),
+		webhookEvents:     make(map[string]WebhookEventRecord),
+	}
+}
+
+// constraintError is a constraint violation, the equivalent of a Postgres class 23 error.
+type constraintError struct {
+	message string
+}
+
+func (e *constraintError) Error() string {
+	return e.message
+}
+
+func errDuplicateKey(table, id string) error {
+	return &constraintError{fmt.Sprintf("chave duplicada em %s: %s", table, id)}
+}
+
+func errMissingReference(table, id string) error {
+	return &constraintError{fmt.Sprintf("referência inexistente em %s: %s", table, id)}
+}
+
+// SaveCustomer inserts a new customer.
+func (r *MemoryRepository) SaveCustomer(ctx context.Context, customer CustomerRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.customers[customer.ID]; ok {
+		return errDuplicateKey("payment_customers", customer.ID)
+	}
+	r.customers[customer.ID] = customer
+	return nil
+}
+
+// FindCustomerByID returns a customer record by ID.
+func (r *MemoryRepository) FindCustomerByID(ctx context.Context, id string) (CustomerRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	customer, ok := r.customers[id]
+	if !ok {
+		return CustomerRecord{}, sql.ErrNoRows
+	}
+	return customer, nil
+}
+
+// FindCustomerByAsaasID returns a customer record by its Asaas ID.
+func (r *MemoryRepository) FindCustomerByAsaasID(ctx context.Context, asaasID string) (CustomerRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found CustomerRecord
+	for _, customer := range r.customers {
+		if asaasID == "" || customer.AsaasID != asaasID {
+			continue
+		}
+		if found.ID == "" || customer.CreatedAt.Before(found.CreatedAt) {
+			found = customer
+		}
+	}
+	if found.ID == "" {
+		return CustomerRecord{}, sql.ErrNoRows
+	}
+	return found, nil
+}
+
+// UpdateCustomer overwrites the registration data of a customer.
+func (r *MemoryRepository) UpdateCustomer(ctx context.Context, customer CustomerRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.customers[customer.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	customer.AsaasID = stored.AsaasID
+	customer.CreditCardToken = stored.CreditCardToken
+	customer.CreditCardBrand = stored.CreditCardBrand
+	customer.CreditCardLast4 = stored.CreditCardLast4
+	customer.DeletedAt = stored.DeletedAt
+	customer.CreatedAt = stored.CreatedAt
+	customer.UpdatedAt = time.Now().UTC()
+	r.customers[customer.ID] = customer
+	return nil
+}
+
+// SetCustomerDeletedAt marks a customer as removed, or restores it when deletedAt is zero.
+func (r *MemoryRepository) SetCustomerDeletedAt(ctx context.Context, id string, deletedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	customer, ok := r.customers[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	customer.DeletedAt = deletedAt
+	customer.UpdatedAt = time.Now().UTC()
+	r.customers[id] = customer
+	return nil
+}
+
+// SetCustomerAsaasID stores the Asaas ID of a customer.
+func (r *MemoryRepository) SetCustomerAsaasID(ctx context.Context, id, asaasID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	customer, ok := r.customers[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	customer.AsaasID = asaasID
+	customer.UpdatedAt = time.Now().UTC()
+	r.customers[id] = customer
+	return nil
+}
+
+// SetCustomerCreditCard stores the token and masked data of the card saved for a customer.
+func (r *MemoryRepository) SetCustomerCreditCard(ctx context.Context, id, token, brand, last4 string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	customer, ok := r.customers[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	customer.CreditCardToken = token
+	customer.CreditCardBrand = brand
+	customer.CreditCardLast4 = last4
+	customer.UpdatedAt = time.Now().UTC()
+	r.customers[id] = customer
+	return nil
+}
+
+// ListCustomerIDsWithoutAsaasID returns local customer IDs whose Asaas ID is unknown.
+func (r *MemoryRepository) ListCustomerIDsWithoutAsaasID(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rows []CustomerRecord
+	for _, customer := range r.customers {
+		if customer.AsaasID == "" {
+			rows = append(rows, customer)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.Before(rows[j].CreatedAt) })
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	return ids, nil
+}
+
+// SavePayment inserts a new payment row.
+func (r *MemoryRepository) SavePayment(ctx context.Context, payment PaymentRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.insertPayment(payment)
+}
+
+// SaveImportedPayment inserts a payment imported from Asaas unless a payment with
+// the same Asaas ID is already stored, and returns the stored payment and whether
+// this call inserted it.
+func (r *MemoryRepository) SaveImportedPayment(ctx context.Context, payment PaymentRecord) (PaymentRecord, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if stored, ok := r.paymentByAsaasID(payment.AsaasID); ok {
+		return stored, false, nil
+	}
+	if err := r.insertPayment(payment); err != nil {
+		return PaymentRecord{}, false, err
+	}
+	return payment, true, nil
+}
+
+// insertPayment checks the constraints of payment_payments and stores a payment.
+// The caller holds r.mu.
+func (r *MemoryRepository) insertPayment(payment PaymentRecord) error {
+	if _, ok := r.payments[payment.ID]; ok {
+		return errDuplicateKey("payment_payments", payment.ID)
+	}
+	if _, ok := r.customers[payment.CustomerID]; !ok {
+		return errMissingReference("payment_customers", payment.CustomerID)
+	}
+	if _, ok := r.installments[payment.InstallmentID]; payment.InstallmentID != "" && !ok {
+		return errMissingReference("payment_installments", payment.InstallmentID)
+	}
+	if _, ok := r.paymentByAsaasID(payment.AsaasID); ok {
+		return errDuplicateKey("payment_payments", payment.AsaasID)
+	}
+	r.payments[payment.ID] = payment
+	return nil
+}
+
+// paymentByAsaasID finds a payment by a non-empty Asaas ID. The caller holds r.mu.
+func (r *MemoryRepository) paymentByAsaasID(asaasID string) (PaymentRecord, bool) {
+	for _, payment := range r.payments {
+		if asaasID != "" && payment.AsaasID == asaasID {
+			return payment, true
+		}
+	}
+	return PaymentRecord{}, false
+}
+
+// FindPaymentByID returns a payment record by ID.
+func (r *MemoryRepository) FindPaymentByID(ctx context.Context, id string) (PaymentRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	payment, ok := r.payments[id]
+	if !ok {
+		return PaymentRecord{}, sql.ErrNoRows
+	}
+	return payment, nil
+}
+
+// FindPaymentByAsaasID returns a payment record by its Asaas ID.
+func (r *MemoryRepository) FindPaymentByAsaasID(ctx context.Context, asaasID string) (PaymentRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found PaymentRecord
+	for _, payment := range r.payments {
+		if asaasID == "" || payment.AsaasID != asaasID {
+			continue
+		}
+		if found.ID == "" || payment.CreatedAt.Before(found.CreatedAt) {
+			found = payment
+		}
+	}
+	if found.ID == "" {
+		return PaymentRecord{}, sql.ErrNoRows
+	}
+	return found, nil
+}
+
+// ListPaymentsByInstallmentID returns the payments of an installment plan in installment order.
+func (r *MemoryRepository) ListPaymentsByInstallmentID(ctx context.Context, installmentID string) ([]PaymentRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var payments []PaymentRecord
+	for _, payment := range r.payments {
+		if payment.InstallmentID == installmentID {
+			payments = append(payments, payment)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool {
+		if payments[i].InstallmentNumber != payments[j].InstallmentNumber {
+			return payments[i].InstallmentNumber < payments[j].InstallmentNumber
+		}
+		return payments[i].CreatedAt.Before(payments[j].CreatedAt)
+	})
+	return payments, nil
+}
+
+// SaveInstallment inserts an installment plan row.
+func (r *MemoryRepository) SaveInstallment(ctx context.Context, installment InstallmentRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.insertInstallment(installment)
+}
+
+// SaveInstallmentPayment inserts an installment plan and its first payment at once,
+// so the plan is never visible without that payment.
+func (r *MemoryRepository) SaveInstallmentPayment(ctx context.Context, installment InstallmentRecord, payment PaymentRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.insertInstallment(installment); err != nil {
+		return err
+	}
+	if err := r.insertPayment(payment); err != nil {
+		delete(r.installments, installment.ID)
+		return err
+	}
+	return nil
+}
+
+// insertInstallment checks the constraints of payment_installments and stores a plan.
+// The caller holds r.mu.
+func (r *MemoryRepository) insertInstallment(installment InstallmentRecord) error {
+	if _, ok := r.installments[installment.ID]; ok {
+		return errDuplicateKey("payment_installments", installment.ID)
+	}
+	if _, ok := r.customers[installment.CustomerID]; !ok {
+		return errMissingReference("payment_customers", installment.CustomerID)
+	}
+	r.installments[installment.ID] = installment
+	return nil
+}
+
+// FindInstallmentByID returns an installment plan by ID.
+func (r *MemoryRepository) FindInstallmentByID(ctx context.Context, id string) (InstallmentRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	installment, ok := r.installments[id]
+	if !ok {
+		return InstallmentRecord{}, sql.ErrNoRows
+	}
+	return installment, nil
+}
+
+// FindInstallmentByAsaasID returns an installment plan by its Asaas ID.
+func (r *MemoryRepository) FindInstallmentByAsaasID(ctx context.Context, asaasID string) (InstallmentRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, installment := range r.installments {
+		if asaasID != "" && installment.AsaasID == asaasID {
+			return installment, nil
+		}
+	}
+	return InstallmentRecord{}, sql.ErrNoRows
+}
+
+// UpdateInstallmentStatus updates the status of an installment plan.
+func (r *MemoryRepository) UpdateInstallmentStatus(ctx context.Context, id, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	installment, ok := r.installments[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	installment.Status = status
+	installment.UpdatedAt = time.Now().UTC()
+	r.installments[id] = installment
+	return nil
+}
+
+// UpdatePaymentStatus updates the status and links of a payment.
+func (r *MemoryRepository) UpdatePaymentStatus(ctx context.Context, id, status, invoiceURL, receiptURL string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	payment, ok := r.payments[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	payment.Status = status
+	payment.InvoiceURL = invoiceURL
+	payment.TransactionReceiptURL = receiptURL
+	payment.UpdatedAt = time.Now().UTC()
+	r.payments[id] = payment
+	return nil
+}
+
+// TransitionPaymentAuthorization moves the authorization of a payment from one
+// status to another, reporting false when the payment is not in the from status.
+func (r *MemoryRepository) TransitionPaymentAuthorization(ctx context.Context, id, from, to string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	payment, ok := r.payments[id]
+	if !ok || payment.AuthorizationStatus != from {
+		return false, nil
+	}
+	payment.AuthorizationStatus = to
+	payment.UpdatedAt = time.Now().UTC()
+	r.payments[id] = payment
+	return true, nil
+}
+
+// ListExpiredAuthorizations returns payments still authorized since before the given time, oldest first.
+func (r *MemoryRepository) ListExpiredAuthorizations(ctx context.Context, before time.Time, limit int) ([]PaymentRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var payments []PaymentRecord
+	for _, payment := range r.payments {
+		if payment.AuthorizationStatus == AuthorizationStatusAuthorized && payment.AuthorizedAt.Before(before) {
+			payments = append(payments, payment)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].AuthorizedAt.Before(payments[j].AuthorizedAt) })
+	if limit > 0 && len(payments) > limit {
+		payments = payments[:limit]
+	}
+	return payments, nil
+}
+
+// SetPaymentAsaasID stores the Asaas ID of a payment.
+func (r *MemoryRepository) SetPaymentAsaasID(ctx context.Context, id, asaasID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	payment, ok := r.payments[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if other, ok := r.paymentByAsaasID(asaasID); ok && other.ID != id {
+		return errDuplicateKey("payment_payments", asaasID)
+	}
+	payment.AsaasID = asaasID
+	payment.UpdatedAt = time.Now().UTC()
+	r.payments[id] = payment
+	return nil
+}
+
+// ListPaymentIDsWithoutAsaasID returns local payment IDs whose Asaas ID is unknown.
+func (r *MemoryRepository) ListPaymentIDsWithoutAsaasID(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rows []PaymentRecord
+	for _, payment := range r.payments {
+		if payment.AsaasID == "" {
+			rows = append(rows, payment)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.Before(rows[j].CreatedAt) })
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	return ids, nil
+}
+
+// SavePixQrCode stores the PIX QR code of a payment.
+func (r *MemoryRepository) SavePixQrCode(ctx context.Context, paymentID string, code PixQrCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.payments[paymentID]; !ok {
+		return sql.ErrNoRows
+	}
+	r.pixQrCodes[paymentID] = code
+	return nil
+}
+
+// FindPixQrCode returns the PIX QR code stored for a payment.
+func (r *MemoryRepository) FindPixQrCode(ctx context.Context, paymentID string) (PixQrCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	code, ok := r.pixQrCodes[paymentID]
+	if !ok || code.Payload == "" {
+		return PixQrCode{}, sql.ErrNoRows
+	}
+	return code, nil
+}
+
+// SaveBoleto stores the boleto identification of a payment.
+func (r *MemoryRepository) SaveBoleto(ctx context.Context, paymentID string, boleto Boleto) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.payments[paymentID]; !ok {
+		return sql.ErrNoRows
+	}
+	r.boletos[paymentID] = boleto
+	return nil
+}
+
+// FindBoleto returns the boleto identification stored for a payment.
+func (r *MemoryRepository) FindBoleto(ctx context.Context, paymentID string) (Boleto, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	boleto, ok := r.boletos[paymentID]
+	if !ok || boleto.IdentificationField == "" {
+		return Boleto{}, sql.ErrNoRows
+	}
+	return boleto, nil
+}
+
+// SaveBankSlipPDF stores the boleto PDF of a payment.
+func (r *MemoryRepository) SaveBankSlipPDF(ctx context.Context, paymentID string, pdf []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.payments[paymentID]; !ok {
+		return sql.ErrNoRows
+	}
+	r.bankSlipPDFs[paymentID] = append([]byte(nil), pdf...)
+	return nil
+}
+
+// FindBankSlipPDF returns the boleto PDF stored for a payment.
+func (r *MemoryRepository) FindBankSlipPDF(ctx context.Context, paymentID string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pdf, ok := r.bankSlipPDFs[paymentID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return append([]byte(nil), pdf...), nil
+}
+
+// SaveRefund inserts a refund of a payment.
+func (r *MemoryRepository) SaveRefund(ctx context.Context, refund RefundRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.refunds[refund.ID]; ok {
+		return errDuplicateKey("payment_refunds", refund.ID)
+	}
+	if _, ok := r.payments[refund.PaymentID]; !ok {
+		return errMissingReference("payment_payments", refund.PaymentID)
+	}
+	r.refunds[refund.ID] = refund
+	return nil
+}
+
+// ListRefundsByPaymentID returns the refunds of a payment, oldest first.
+func (r *MemoryRepository) ListRefundsByPaymentID(ctx context.Context, paymentID string) ([]RefundRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var refunds []RefundRecord
+	for _, refund := range r.refunds {
+		if refund.PaymentID == paymentID {
+			refunds = append(refunds, refund)
+		}
+	}
+	sort.Slice(refunds, func(i, j int) bool {
+		if refunds[i].CreatedAt.Equal(refunds[j].CreatedAt) {
+			return refunds[i].ID < refunds[j].ID
+		}
+		return refunds[i].CreatedAt.Before(refunds[j].CreatedAt)
+	})
+	return refunds, nil
+}
+
+// UpdateRefund updates the status, receipt and Asaas date of a refund.
+func (r *MemoryRepository) UpdateRefund(ctx context.Context, update RefundRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	refund, ok := r.refunds[update.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	refund.Status = update.Status
+	refund.TransactionReceiptURL = update.TransactionReceiptURL
+	refund.AsaasDateCreated = update.AsaasDateCreated
+	refund.UpdatedAt = time.Now().UTC()
+	r.refunds[update.ID] = refund
+	return nil
+}
+
+// SaveSplit inserts a split of a payment or subscription.
+func (r *MemoryRepository) SaveSplit(ctx context.Context, split SplitRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.splits[split.ID]; ok {
+		return errDuplicateKey("payment_splits", split.ID)
+	}
+	if (split.PaymentID == "") == (split.SubscriptionID == "") {
+		return fmt.Errorf("split %s deve pertencer a um pagamento ou a uma assinatura", split.ID)
+	}
+	if _, ok := r.payments[split.PaymentID]; split.PaymentID != "" && !ok {
+		return errMissingReference("payment_payments", split.PaymentID)
+	}
+	if _, ok := r.subscriptions[split.SubscriptionID]; split.SubscriptionID != "" && !ok {
+		return errMissingReference("payment_subscriptions", split.SubscriptionID)
+	}
+	r.splits[split.ID] = split
+	return nil
+}
+
+// ListSplitsByPaymentID returns the splits of a payment, oldest first.
+func (r *MemoryRepository) ListSplitsByPaymentID(ctx context.Context, paymentID string) ([]SplitRecord, error) {
+	return r.listSplits(func(split SplitRecord) bool { return split.PaymentID == paymentID }), nil
+}
+
+// ListSplitsBySubscriptionID returns the splits of a subscription, oldest first.
+func (r *MemoryRepository) ListSplitsBySubscriptionID(ctx context.Context, subscriptionID string) ([]SplitRecord, error) {
+	return r.listSplits(func(split SplitRecord) bool { return split.SubscriptionID == subscriptionID }), nil
+}
+
+func (r *MemoryRepository) listSplits(match func(SplitRecord) bool) []SplitRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var splits []SplitRecord
+	for _, split := range r.splits {
+		if match(split) {
+			splits = append(splits, split)
+		}
+	}
+	sort.Slice(splits, func(i, j int) bool {
+		if splits[i].CreatedAt.Equal(splits[j].CreatedAt) {
+			return splits[i].ID < splits[j].ID
+		}
+		return splits[i].CreatedAt.Before(splits[j].CreatedAt)
+	})
+	return splits
+}
+
+// UpdateSplit stores the Asaas ID, total value, status and cancellation reason of a split.
+func (r *MemoryRepository) UpdateSplit(ctx context.Context, split SplitRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.splits[split.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.AsaasID = split.AsaasID
+	stored.TotalValue = split.TotalValue
+	stored.Status = split.Status
+	stored.CancellationReason = split.CancellationReason
+	stored.UpdatedAt = time.Now().UTC()
+	r.splits[split.ID] = stored
+	return nil
+}
+
+// SaveSubscription inserts a subscription row.
+func (r *MemoryRepository) SaveSubscription(ctx context.Context, subscription SubscriptionRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.subscriptions[subscription.ID]; ok {
+		return errDuplicateKey("payment_subscriptions", subscription.ID)
+	}
+	if _, ok := r.customers[subscription.CustomerID]; !ok {
+		return errMissingReference("payment_customers", subscription.CustomerID)
+	}
+	r.subscriptions[subscription.ID] = subscription
+	return nil
+}
+
+// FindSubscriptionByID returns a subscription record by ID.
+func (r *MemoryRepository) FindSubscriptionByID(ctx context.Context, id string) (SubscriptionRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subscription, ok := r.subscriptions[id]
+	if !ok {
+		return SubscriptionRecord{}, sql.ErrNoRows
+	}
+	return subscription, nil
+}
+
+// FindSubscriptionByAsaasID returns a subscription record by its Asaas ID.
+func (r *MemoryRepository) FindSubscriptionByAsaasID(ctx context.Context, asaasID string) (SubscriptionRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found SubscriptionRecord
+	for _, subscription := range r.subscriptions {
+		if asaasID == "" || subscription.AsaasID != asaasID {
+			continue
+		}
+		if found.ID == "" || subscription.CreatedAt.Before(found.CreatedAt) {
+			found = subscription
+		}
+	}
+	if found.ID == "" {
+		return SubscriptionRecord{}, sql.ErrNoRows
+	}
+	return found, nil
+}
+
+// UpdateSubscriptionStatus updates the subscription status locally.
+func (r *MemoryRepository) UpdateSubscriptionStatus(ctx context.Context, id, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subscription, ok := r.subscriptions[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	subscription.Status = status
+	subscription.UpdatedAt = time.Now().UTC()
+	r.subscriptions[id] = subscription
+	return nil
+}
+
+// UpdateSubscription stores the billing type, value, cycle, next due date and status of a subscription.
+func (r *MemoryRepository) UpdateSubscription(ctx context.Context, subscription SubscriptionRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.subscriptions[subscription.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.BillingType = subscription.BillingType
+	stored.Value = subscription.Value
+	stored.Cycle = subscription.Cycle
+	stored.NextDueDate = subscription.NextDueDate
+	stored.Status = subscription.Status
+	stored.UpdatedAt = time.Now().UTC()
+	r.subscriptions[subscription.ID] = stored
+	return nil
+}
+
+// UpdatePendingSubscriptionPayments sets the billing type and value of the pending
+// and overdue payments of a subscription. Asaas reissues their boleto and PIX QR
+// code, so the stored ones are cleared.
+func (r *MemoryRepository) UpdatePendingSubscriptionPayments(ctx context.Context, subscriptionID, billingType string, value Money) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, payment := range r.payments {
+		if payment.SubscriptionID != subscriptionID || (payment.Status != "PENDING" && payment.Status != "OVERDUE") {
+			continue
+		}
+		payment.BillingType = billingType
+		payment.Value = value
+		payment.UpdatedAt = time.Now().UTC()
+		r.payments[id] = payment
+		delete(r.pixQrCodes, id)
+		delete(r.boletos, id)
+		delete(r.bankSlipPDFs, id)
+	}
+	return nil
+}
+
+// SaveSubscriptionChange inserts a change of a subscription field.
+func (r *MemoryRepository) SaveSubscriptionChange(ctx context.Context, change SubscriptionChangeRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.subscriptions[change.SubscriptionID]; !ok {
+		return errMissingReference("payment_subscriptions", change.SubscriptionID)
+	}
+	if _, ok := r.subscriptionLog[change.ID]; ok {
+		return errDuplicateKey("payment_subscription_changes", change.ID)
+	}
+	r.subscriptionLog[change.ID] = change
+	return nil
+}
+
+// ListSubscriptionChanges returns the changes of a subscription, oldest first.
+func (r *MemoryRepository) ListSubscriptionChanges(ctx context.Context, subscriptionID string) ([]SubscriptionChangeRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var changes []SubscriptionChangeRecord
+	for _, change := range r.subscriptionLog {
+		if change.SubscriptionID == subscriptionID {
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].CreatedAt.Equal(changes[j].CreatedAt) {
+			return changes[i].Field < changes[j].Field
+		}
+		return changes[i].CreatedAt.Before(changes[j].CreatedAt)
+	})
+	return changes, nil
+}
+
+// SetSubscriptionAsaasID stores the Asaas ID of a subscription.
+func (r *MemoryRepository) SetSubscriptionAsaasID(ctx context.Context, id, asaasID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subscription, ok := r.subscriptions[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	subscription.AsaasID = asaasID
+	subscription.UpdatedAt = time.Now().UTC()
+	r.subscriptions[id] = subscription
+	return nil
+}
+
+// ListSubscriptionIDsWithoutAsaasID returns local subscription IDs whose Asaas ID is unknown.
+func (r *MemoryRepository) ListSubscriptionIDsWithoutAsaasID(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rows []SubscriptionRecord
+	for _, subscription := range r.subscriptions {
+		if subscription.AsaasID == "" {
+			rows = append(rows, subscription)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.Before(rows[j].CreatedAt) })
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	return ids, nil
+}
+
+// SaveInvoice inserts an invoice row.
+func (r *MemoryRepository) SaveInvoice(ctx context.Context, invoice InvoiceRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.invoices[invoice.ID]; ok {
+		return errDuplicateKey("payment_invoices", invoice.ID)
+	}
+	if _, ok := r.payments[invoice.PaymentID]; !ok {
+		return errMissingReference("payment_payments", invoice.PaymentID)
+	}
+	r.invoices[invoice.ID] = invoice
+	return nil
+}
+
+// FindInvoiceByID returns an invoice record by ID.
+func (r *MemoryRepository) FindInvoiceByID(ctx context.Context, id string) (InvoiceRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	invoice, ok := r.invoices[id]
+	if !ok {
+		return InvoiceRecord{}, sql.ErrNoRows
+	}
+	return invoice, nil
+}
+
+// FindInvoiceByAsaasID returns an invoice record by its Asaas ID.
+func (r *MemoryRepository) FindInvoiceByAsaasID(ctx context.Context, asaasID string) (InvoiceRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found InvoiceRecord
+	for _, invoice := range r.invoices {
+		if asaasID == "" || invoice.AsaasID != asaasID {
+			continue
+		}
+		if found.ID == "" || invoice.CreatedAt.Before(found.CreatedAt) {
+			found = invoice
+		}
+	}
+	if found.ID == "" {
+		return InvoiceRecord{}, sql.ErrNoRows
+	}
+	return found, nil
+}
+
+// FindInvoiceByPaymentID returns the first invoice linked to a payment.
+func (r *MemoryRepository) FindInvoiceByPaymentID(ctx context.Context, paymentID string) (InvoiceRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, invoice := range r.invoices {
+		if invoice.PaymentID == paymentID {
+			return invoice, nil
+		}
+	}
+	return InvoiceRecord{}, sql.ErrNoRows
+}
+
+// UpdateInvoiceStatus updates invoice status locally.
+func (r *MemoryRepository) UpdateInvoiceStatus(ctx context.Context, id, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	invoice, ok := r.invoices[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	invoice.Status = status
+	invoice.UpdatedAt = time.Now().UTC()
+	r.invoices[id] = invoice
+	return nil
+}
+
+// SetInvoiceAsaasID stores the Asaas ID of an invoice.
+func (r *MemoryRepository) SetInvoiceAsaasID(ctx context.Context, id, asaasID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	invoice, ok := r.invoices[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	invoice.AsaasID = asaasID
+	invoice.UpdatedAt = time.Now().UTC()
+	r.invoices[id] = invoice
+	return nil
+}
+
+// ListInvoiceIDsWithoutAsaasID returns local invoice IDs whose Asaas ID is unknown.
+func (r *MemoryRepository) ListInvoiceIDsWithoutAsaasID(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rows []InvoiceRecord
+	for _, invoice := range r.invoices {
+		if invoice.AsaasID == "" {
+			rows = append(rows, invoice)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.Before(rows[j].CreatedAt) })
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	return ids, nil
+}
+
+// SavePendingOperation inserts a pending operation row.
+func (r *MemoryRepository) SavePendingOperation(ctx context.Context, op PendingOperation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.pendingOperations[op.ID]; ok {
+		return errDuplicateKey("payment_pending_operations", op.ID)
+	}
+	r.pendingOperations[op.ID] = op
+	return nil
+}
+
+// UpdatePendingOperation stores the Asaas ID, record snapshot, status and error of an operation.
+func (r *MemoryRepository) UpdatePendingOperation(ctx context.Context, op PendingOperation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.pendingOperations[op.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.AsaasID = op.AsaasID
+	stored.Record = op.Record
+	stored.Status = op.Status
+	stored.Error = op.Error
+	stored.Attempts = op.Attempts
+	stored.UpdatedAt = time.Now().UTC()
+	r.pendingOperations[op.ID] = stored
+	return nil
+}
+
+// FindPendingOperationByLocalID returns the latest operation that creates the given local record.
+func (r *MemoryRepository) FindPendingOperationByLocalID(ctx context.Context, localID string) (PendingOperation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found PendingOperation
+	for _, op := range r.pendingOperations {
+		if op.LocalID == localID && (found.ID == "" || op.CreatedAt.After(found.CreatedAt)) {
+			found = op
+		}
+	}
+	if found.ID == "" {
+		return PendingOperation{}, sql.ErrNoRows
+	}
+	return found, nil
+}
+
+// ListPendingOperations returns operations still pending that were last touched before the given time.
+func (r *MemoryRepository) ListPendingOperations(ctx context.Context, before time.Time, limit int) ([]PendingOperation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ops []PendingOperation
+	for _, op := range r.pendingOperations {
+		if op.Status == PendingOperationStatusPending && op.UpdatedAt.Before(before) {
+			ops = append(ops, op)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].UpdatedAt.Before(ops[j].UpdatedAt) })
+	if limit > 0 && len(ops) > limit {
+		ops = ops[:limit]
+	}
+	return ops, nil
+}
+
+// SaveWebhookEvent journals a webhook delivery. When the Asaas event ID was already
+// journaled it returns the existing row and false.
+func (r *MemoryRepository) SaveWebhookEvent(ctx context.Context, event WebhookEventRecord) (WebhookEventRecord, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.webhookEvents {
+		if existing.EventID == event.EventID {
+			return existing, false, nil
+		}
+	}
+	r.webhookEvents[event.ID] = event
+	return event, true, nil
+}
+
+// FindWebhookEventByEventID returns a journaled event by its Asaas event ID.
+func (r *MemoryRepository) FindWebhookEventByEventID(ctx context.Context, eventID string) (WebhookEventRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range r.webhookEvents {
+		if event.EventID == eventID {
+			return event, nil
+		}
+	}
+	return WebhookEventRecord{}, sql.ErrNoRows
+}
+
+// ListWebhookEvents returns events received in the [from, to) interval, oldest first.
+func (r *MemoryRepository) ListWebhookEvents(ctx context.Context, from, to time.Time) ([]WebhookEventRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []WebhookEventRecord
+	for _, event := range r.webhookEvents {
+		if !event.ReceivedAt.Before(from) && event.ReceivedAt.Before(to) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ReceivedAt.Before(events[j].ReceivedAt) })
+	return events, nil
+}
+
+// UpdateWebhookEventStatus records the outcome of processing a journaled event and releases its lock.
+func (r *MemoryRepository) UpdateWebhookEventStatus(ctx context.Context, id, status, message string, nextAttemptAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	event, ok := r.webhookEvents[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	now := time.Now().UTC()
+	event.Status = status
+	event.Error = message
+	event.Attempts++
+	if status == WebhookEventStatusProcessed {
+		event.ProcessedAt = now
+	}
+	if !nextAttemptAt.IsZero() {
+		event.NextAttemptAt = nextAttemptAt
+	}
+	event.LockedAt = time.Time{}
+	event.UpdatedAt = now
+	r.webhookEvents[id] = event
+	return nil
+}
+
+// ClaimWebhookEvent locks the next event due for processing.
+func (r *MemoryRepository) ClaimWebhookEvent(ctx context.Context, staleBefore time.Time) (WebhookEventRecord, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	var next *WebhookEventRecord
+	for _, event := range r.webhookEvents {
+		due := (event.Status == WebhookEventStatusReceived || event.Status == WebhookEventStatusFailed) && !event.NextAttemptAt.After(now)
+		stale := event.Status == WebhookEventStatusProcessing && event.LockedAt.Before(staleBefore)
+		if !due && !stale {
+			continue
+		}
+		if next == nil || event.NextAttemptAt.Before(next.NextAttemptAt) {
+			candidate := event
+			next = &candidate
+		}
+	}
+	if next == nil {
+		return WebhookEventRecord{}, false, nil
+	}
+	return r.lockWebhookEvent(*next, now), true, nil
+}
+
+// ClaimWebhookEventByID locks an event for processing unless another caller holds it.
+func (r *MemoryRepository) ClaimWebhookEventByID(ctx context.Context, id string, staleBefore time.Time) (WebhookEventRecord, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	event, ok := r.webhookEvents[id]
+	if !ok || (event.Status == WebhookEventStatusProcessing && !event.LockedAt.Before(staleBefore)) {
+		return WebhookEventRecord{}, false, nil
+	}
+	return r.lockWebhookEvent(event, time.Now().UTC()), true, nil
+}
+
+// lockWebhookEvent moves an event to PROCESSING, counting the attempt of a stale lock.
+func (r *MemoryRepository) lockWebhookEvent(event WebhookEventRecord, now time.Time) WebhookEventRecord {
+	if event.Status == WebhookEventStatusProcessing {
+		event.Attempts++
+	}
+	event.Status = WebhookEventStatusProcessing
+	event.LockedAt = now
+	event.UpdatedAt = now
+	r.webhookEvents[event.ID] = event
+	return event
+}
+
+// listPage sorts rows newest first and applies the filter cursor and page size.
+func listPage[T any](rows []T, filter ListFilter, key func(T) (time.Time, string)) (Page[T], error) {
+	cursorAt, cursorID, hasCursor, err := filter.after()
+	if err != nil {
+		return Page[T]{}, err
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		ai, ii := key(rows[i])
+		aj, ij := key(rows[j])
+		return beforeCursor(aj, ij, ai, ii)
+	})
+	var items []T
+	for _, row := range rows {
+		createdAt, id := key(row)
+		if hasCursor && !beforeCursor(createdAt, id, cursorAt, cursorID) {
+			continue
+		}
+		items = append(items, row)
+		if len(items) > filter.pageSize() {
+			break
+		}
+	}
+	return newPage(items, filter.pageSize(), key), nil
+}
+
+// ListCustomers returns a page of customers, newest first.
+func (r *MemoryRepository) ListCustomers(ctx context.Context, filter ListFilter) (Page[CustomerRecord], error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rows []CustomerRecord
+	for _, customer := range r.customers {
+		if !filter.IncludeDeleted && !customer.DeletedAt.IsZero() {
+			continue
+		}
+		if !inRange(customer.CreatedAt, filter.CreatedFrom, filter.CreatedTo) {
+			continue
+		}
+		rows = append(rows, customer)
+	}
+	return listPage(rows, filter, func(c CustomerRecord) (time.Time, string) { return c.CreatedAt, c.ID })
+}
+
+// ListPayments returns a page of payments, newest first.
+func (r *MemoryRepository) ListPayments(ctx context.Context, filter ListFilter) (Page[PaymentRecord], error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rows []PaymentRecord
+	for _, payment := range r.payments {
+		switch {
+		case filter.Status != "" && payment.Status != filter.Status:
+			continue
+		case filter.CustomerID != "" && payment.CustomerID != filter.CustomerID:
+			continue
+		case filter.SubscriptionID != "" && payment.SubscriptionID != filter.SubscriptionID:
+			continue
+		case filter.BillingType != "" && payment.BillingType != filter.BillingType:
+			continue
+		case !inRange(payment.DueDate, filter.DueDateFrom, filter.DueDateTo):
+			continue
+		case !inRange(payment.CreatedAt, filter.CreatedFrom, filter.CreatedTo):
+			continue
+		}
+		rows = append(rows, payment)
+	}
+	return listPage(rows, filter, func(p PaymentRecord) (time.Time, string) { return p.CreatedAt, p.ID })
+}
+
+// ListSubscriptions returns a page of subscriptions, newest first.
+func (r *MemoryRepository) ListSubscriptions(ctx context.Context, filter ListFilter) (Page[SubscriptionRecord], error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rows []SubscriptionRecord
+	for _, subscription := range r.subscriptions {
+		switch {
+		case filter.Status != "" && subscription.Status != filter.Status:
+			continue
+		case filter.CustomerID != "" && subscription.CustomerID != filter.CustomerID:
+			continue
+		case filter.BillingType != "" && subscription.BillingType != filter.BillingType:
+			continue
+		case !inRange(subscription.NextDueDate, filter.DueDateFrom, filter.DueDateTo):
+			continue
+		case !inRange(subscription.CreatedAt, filter.CreatedFrom, filter.CreatedTo):
+			continue
+		}
+		rows = append(rows, subscription)
+	}
+	return listPage(rows, filter, func(s SubscriptionRecord) (time.Time, string) { return s.CreatedAt, s.ID })
+}
+
+// ListInvoices returns a page of invoices, newest first. Customer and subscription
+// filters apply to the invoiced payment.
+func (r *MemoryRepository) ListInvoices(ctx context.Context, filter ListFilter) (Page[InvoiceRecord], error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rows []InvoiceRecord
+	for _, invoice := range r.invoices {
+		payment := r.payments[invoice.PaymentID]
+		switch {
+		case filter.Status != "" && invoice.Status != filter.Status:
+			continue
+		case filter.CustomerID != "" && payment.CustomerID != filter.CustomerID:
+			continue
+		case filter.SubscriptionID != "" && payment.SubscriptionID != filter.SubscriptionID:
+			continue
+		case !inRange(invoice.CreatedAt, filter.CreatedFrom, filter.CreatedTo):
+			continue
+		}
+		rows = append(rows, invoice)
+	}
+	return listPage(rows, filter, func(i InvoiceRecord) (time.Time, string) { return i.CreatedAt, i.ID })
+}
@@ -4,80 +4,226 @@ import "time"
 
 // CustomerRecord represents a customer stored in the local database.
 type CustomerRecord struct {
-	ID                   string
-	Name                 string
-	Email                string
-	CpfCnpj              string
-	Phone                string
-	MobilePhone          string
-	Address              string
-	AddressNumber        string
-	Complement           string
-	Province             string
-	PostalCode           string
-	NotificationDisabled bool
-	AdditionalEmails     string
-	CreatedAt            time.Time
-	UpdatedAt            time.Time
+	ID                   string `json:"id"`
+	AsaasID              string `json:"asaasId"`
+	Name                 string `json:"name"`
+	Email                string `json:"email"`
+	CpfCnpj              string `json:"cpfCnpj"`
+	Phone                string `json:"phone"`
+	MobilePhone          string `json:"mobilePhone"`
+	Address              string `json:"address"`
+	AddressNumber        string `json:"addressNumber"`
+	Complement           string `json:"complement"`
+	Province             string `json:"province"`
+	PostalCode           string `json:"postalCode"`
+	NotificationDisabled bool   `json:"notificationDisabled"`
+	AdditionalEmails     string `json:"additionalEmails"`
+	// CreditCardToken charges the saved card; it is not exposed in JSON. Brand and
+	// Last4 describe the card for display.
+	CreditCardToken string    `json:"-"`
+	CreditCardBrand string    `json:"creditCardBrand"`
+	CreditCardLast4 string    `json:"creditCardLast4"`
+	DeletedAt       time.Time `json:"deletedAt"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
 }
 
 // PaymentRecord represents a payment persisted locally.
 type PaymentRecord struct {
-	ID                    string
-	CustomerID            string
-	SubscriptionID        string
-	BillingType           string
-	Value                 float64
-	DueDate               time.Time
-	Description           string
-	InstallmentCount      int
-	CallbackSuccessURL    string
-	CallbackAutoRedirect  bool
-	Status                string
-	InvoiceURL            string
-	TransactionReceiptURL string
-	CreatedAt             time.Time
-	UpdatedAt             time.Time
+	ID                    string    `json:"id"`
+	AsaasID               string    `json:"asaasId"`
+	CustomerID            string    `json:"customerId"`
+	SubscriptionID        string    `json:"subscriptionId"`
+	BillingType           string    `json:"billingType"`
+	Value                 Money     `json:"value"`
+	DueDate               time.Time `json:"dueDate"`
+	Description           string    `json:"description"`
+	InstallmentCount      int       `json:"installmentCount"`
+	InstallmentID         string    `json:"installmentId"`
+	InstallmentNumber     int       `json:"installmentNumber"`
+	CallbackSuccessURL    string    `json:"callbackSuccessUrl"`
+	CallbackAutoRedirect  bool      `json:"callbackAutoRedirect"`
+	Status                string    `json:"status"`
+	InvoiceURL            string    `json:"invoiceUrl"`
+	TransactionReceiptURL string    `json:"transactionReceiptUrl"`
+	ChargeTerms
+	// AuthorizationStatus tracks a card pre-authorization and is empty for other payments.
+	AuthorizationStatus string    `json:"authorizationStatus"`
+	AuthorizedAt        time.Time `json:"authorizedAt"`
+	CreatedAt           time.Time `json:"createdAt"`
+	UpdatedAt           time.Time `json:"updatedAt"`
+}
+
+// InstallmentRecord represents an installment plan persisted locally. Its payments
+// are stored as PaymentRecord rows pointing to it.
+type InstallmentRecord struct {
+	ID               string    `json:"id"`
+	AsaasID          string    `json:"asaasId"`
+	CustomerID       string    `json:"customerId"`
+	BillingType      string    `json:"billingType"`
+	InstallmentCount int       `json:"installmentCount"`
+	InstallmentValue Money     `json:"installmentValue"`
+	TotalValue       Money     `json:"totalValue"`
+	Description      string    `json:"description"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
 }
 
 // SubscriptionRecord represents a subscription persisted locally.
 type SubscriptionRecord struct {
-	ID          string
-	CustomerID  string
-	BillingType string
-	Status      string
-	Value       float64
-	Cycle       string
-	NextDueDate time.Time
-	Description string
-	EndDate     time.Time
-	MaxPayments int
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID          string    `json:"id"`
+	AsaasID     string    `json:"asaasId"`
+	CustomerID  string    `json:"customerId"`
+	BillingType string    `json:"billingType"`
+	Status      string    `json:"status"`
+	Value       Money     `json:"value"`
+	Cycle       string    `json:"cycle"`
+	NextDueDate time.Time `json:"nextDueDate"`
+	Description string    `json:"description"`
+	EndDate     time.Time `json:"endDate"`
+	MaxPayments int       `json:"maxPayments"`
+	ChargeTerms
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SubscriptionChangeRecord is one field of a subscription changed by an update, with
+// its values before and after it.
+type SubscriptionChangeRecord struct {
+	ID                    string    `json:"id"`
+	SubscriptionID        string    `json:"subscriptionId"`
+	Field                 string    `json:"field"`
+	PreviousValue         string    `json:"previousValue"`
+	NewValue              string    `json:"newValue"`
+	UpdatePendingPayments bool      `json:"updatePendingPayments"`
+	CreatedAt             time.Time `json:"createdAt"`
+}
+
+// ChargeTerms are the discount, interest and fine stored with payments and
+// subscriptions. Zero values mean the term is not applied.
+type ChargeTerms struct {
+	DiscountValue            Percentage `json:"discountValue"`
+	DiscountDueDateLimitDays int        `json:"discountDueDateLimitDays"`
+	DiscountType             string     `json:"discountType"`
+	InterestValue            Percentage `json:"interestValue"`
+	FineValue                Percentage `json:"fineValue"`
+	FineType                 string     `json:"fineType"`
+}
+
+// SplitRecord is the share of a payment or subscription credited to another Asaas
+// wallet. Exactly one of PaymentID and SubscriptionID is set.
+type SplitRecord struct {
+	ID                 string     `json:"id"`
+	AsaasID            string     `json:"asaasId"`
+	PaymentID          string     `json:"paymentId"`
+	SubscriptionID     string     `json:"subscriptionId"`
+	WalletID           string     `json:"walletId"`
+	FixedValue         Money      `json:"fixedValue"`
+	PercentualValue    Percentage `json:"percentualValue"`
+	TotalFixedValue    Money      `json:"totalFixedValue"`
+	TotalValue         Money      `json:"totalValue"`
+	Status             string     `json:"status"`
+	CancellationReason string     `json:"cancellationReason"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	UpdatedAt          time.Time  `json:"updatedAt"`
 }
 
 // InvoiceRecord represents an invoice persisted locally.
 type InvoiceRecord struct {
-	ID                   string
-	PaymentID            string
-	ServiceDescription   string
-	Observations         string
-	Value                float64
-	Deductions           float64
-	EffectiveDate        time.Time
-	MunicipalServiceID   string
-	MunicipalServiceCode string
-	MunicipalServiceName string
-	UpdatePayment        bool
-	TaxesRetainISS       bool
-	TaxesCofins          float64
-	TaxesCsll            float64
-	TaxesINSS            float64
-	TaxesIR              float64
-	TaxesPIS             float64
-	TaxesISS             float64
-	Status               string
-	PaymentLink          string
-	CreatedAt            time.Time
-	UpdatedAt            time.Time
+	ID                   string     `json:"id"`
+	AsaasID              string     `json:"asaasId"`
+	PaymentID            string     `json:"paymentId"`
+	ServiceDescription   string     `json:"serviceDescription"`
+	Observations         string     `json:"observations"`
+	Value                Money      `json:"value"`
+	Deductions           Money      `json:"deductions"`
+	EffectiveDate        time.Time  `json:"effectiveDate"`
+	MunicipalServiceID   string     `json:"municipalServiceId"`
+	MunicipalServiceCode string     `json:"municipalServiceCode"`
+	MunicipalServiceName string     `json:"municipalServiceName"`
+	UpdatePayment        bool       `json:"updatePayment"`
+	TaxesRetainISS       bool       `json:"taxesRetainIss"`
+	TaxesCofins          Percentage `json:"taxesCofins"`
+	TaxesCsll            Percentage `json:"taxesCsll"`
+	TaxesINSS            Percentage `json:"taxesInss"`
+	TaxesIR              Percentage `json:"taxesIr"`
+	TaxesPIS             Percentage `json:"taxesPis"`
+	TaxesISS             Percentage `json:"taxesIss"`
+	Status               string     `json:"status"`
+	PaymentLink          string     `json:"paymentLink"`
+	CreatedAt            time.Time  `json:"createdAt"`
+	UpdatedAt            time.Time  `json:"updatedAt"`
+}
+
+// RefundRecord is a full or partial refund of a local payment. Asaas refunds have
+// no ID, so AsaasDateCreated keeps the dateCreated of the matching Asaas refund,
+// empty until Asaas lists it, and the date, value and description identify it.
+type RefundRecord struct {
+	ID                    string    `json:"id"`
+	PaymentID             string    `json:"paymentId"`
+	Value                 Money     `json:"value"`
+	Description           string    `json:"description"`
+	Status                string    `json:"status"`
+	TransactionReceiptURL string    `json:"transactionReceiptUrl"`
+	AsaasDateCreated      string    `json:"asaasDateCreated"`
+	CreatedAt             time.Time `json:"createdAt"`
+	UpdatedAt             time.Time `json:"updatedAt"`
+}
+
+// PixQrCode is the PIX QR code stored for a payment.
+type PixQrCode struct {
+	EncodedImage   string    `json:"encodedImage"`
+	Payload        string    `json:"payload"`
+	ExpirationDate time.Time `json:"expirationDate"`
+}
+
+// Boleto is the boleto identification stored for a payment.
+type Boleto struct {
+	IdentificationField string `json:"identificationField"`
+	NossoNumero         string `json:"nossoNumero"`
+	BarCode             string `json:"barCode"`
+	BankSlipURL         string `json:"bankSlipUrl"`
+}
+
+// PendingOperation tracks a remote create whose local save has not been confirmed yet.
+type PendingOperation struct {
+	ID        string
+	Kind      string
+	LocalID   string
+	AsaasID   string
+	Status    string
+	Record    []byte
+	Error     string
+	Attempts  int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// IdempotencyRecord stores the first response given to a request carrying an Idempotency-Key.
+type IdempotencyRecord struct {
+	Key          string
+	Method       string
+	Path         string
+	RequestHash  string
+	StatusCode   int
+	ResponseBody []byte
+	CreatedAt    time.Time
+	CompletedAt  time.Time
+}
+
+// WebhookEventRecord is an Asaas webhook delivery stored in the event journal.
+type WebhookEventRecord struct {
+	ID            string
+	EventID       string
+	EventType     string
+	Payload       []byte
+	Status        string
+	Error         string
+	Attempts      int
+	NextAttemptAt time.Time
+	LockedAt      time.Time
+	ReceivedAt    time.Time
+	ProcessedAt   time.Time
+	UpdatedAt     time.Time
 }
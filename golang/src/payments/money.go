@@ -0,0 +1,257 @@
+package payments
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Money is an exact amount in centavos. It is written to JSON as a decimal number
+// with two places, as Asaas expects, and to NUMERIC columns without going through float64.
+type Money int64
+
+// NewMoney builds an amount from whole reais and centavos.
+func NewMoney(reais, centavos int64) Money {
+	return Money(reais*100 + centavos)
+}
+
+// ParseMoney parses a decimal string such as "100", "100.5" or "-0.25".
+// Values with non-zero digits beyond the centavos are rejected.
+func ParseMoney(value string) (Money, error) {
+	cents, err := parseDecimal(value, 100, false)
+	if err != nil {
+		return 0, fmt.Errorf("valor monetário %w: %q", err, value)
+	}
+	return Money(cents), nil
+}
+
+// Cents returns the amount in centavos.
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// String formats the amount as a decimal with two places, e.g. "100.50".
+func (m Money) String() string {
+	return formatDecimal(int64(m), 100, 2)
+}
+
+// MarshalJSON writes the amount as a JSON number.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number, a numeric string or null. Digits beyond the
+// centavos are rounded half away from zero, so a payload with extra precision is
+// still read.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value, ok, err := jsonDecimal(data)
+	if err != nil || !ok {
+		*m = 0
+		return err
+	}
+	cents, err := parseDecimal(value, 100, true)
+	if err != nil {
+		return fmt.Errorf("valor monetário %w: %q", err, value)
+	}
+	*m = Money(cents)
+	return nil
+}
+
+// Value implements driver.Valuer so the amount is stored as an exact NUMERIC.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan implements sql.Scanner for NUMERIC columns.
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	case int64:
+		*m = Money(v * 100)
+		return nil
+	case float64:
+		*m = Money(math.Round(v * 100))
+		return nil
+	default:
+		return fmt.Errorf("tipo não suportado para Money: %T", src)
+	}
+}
+
+func (m *Money) scanString(value string) error {
+	parsed, err := ParseMoney(value)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Percentage is an exact percentage with four decimal places, so 2.5% is 2.5 and
+// a third is 33.3333. It is written to JSON as a decimal number with at least two
+// places. The values of Discount and Fine are amounts in reais when their type is
+// FIXED; Percentage represents those exactly as well.
+type Percentage int64
+
+// percentageScale is the number of Percentage units in 1%.
+const percentageScale = 10000
+
+// NewPercentage builds a percentage from its whole part and hundredths, e.g.
+// NewPercentage(2, 50) for 2.5%.
+func NewPercentage(whole, hundredths int64) Percentage {
+	return Percentage(whole*percentageScale + hundredths*100)
+}
+
+// ParsePercentage parses a decimal string such as "2", "2.5" or "33.3333".
+// Values with non-zero digits beyond the fourth decimal place are rejected.
+func ParsePercentage(value string) (Percentage, error) {
+	units, err := parseDecimal(value, percentageScale, false)
+	if err != nil {
+		return 0, fmt.Errorf("percentual %w: %q", err, value)
+	}
+	return Percentage(units), nil
+}
+
+// Of returns p percent of m, rounded half away from zero to the centavo.
+func (p Percentage) Of(m Money) Money {
+	product := new(big.Rat).SetFrac(
+		new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(int64(p))),
+		big.NewInt(100*percentageScale),
+	)
+	return Money(roundRat(product).Int64())
+}
+
+// amount reads a FIXED discount or fine value in reais, rounded to the centavo.
+func (p Percentage) amount() Money {
+	return Money(roundRat(big.NewRat(int64(p), percentageScale/100)).Int64())
+}
+
+// String formats the percentage with two to four decimal places, e.g. "2.50" or "33.3333".
+func (p Percentage) String() string {
+	s := formatDecimal(int64(p), percentageScale, 4)
+	return strings.TrimSuffix(strings.TrimSuffix(s, "0"), "0")
+}
+
+// MarshalJSON writes the percentage as a JSON number.
+func (p Percentage) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number, a numeric string or null. Digits beyond the
+// fourth decimal place are rounded half away from zero.
+func (p *Percentage) UnmarshalJSON(data []byte) error {
+	value, ok, err := jsonDecimal(data)
+	if err != nil || !ok {
+		*p = 0
+		return err
+	}
+	units, err := parseDecimal(value, percentageScale, true)
+	if err != nil {
+		return fmt.Errorf("percentual %w: %q", err, value)
+	}
+	*p = Percentage(units)
+	return nil
+}
+
+// Value implements driver.Valuer so the percentage is stored as an exact NUMERIC.
+func (p Percentage) Value() (driver.Value, error) {
+	return p.String(), nil
+}
+
+// Scan implements sql.Scanner for NUMERIC columns.
+func (p *Percentage) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*p = 0
+		return nil
+	case []byte:
+		return p.scanString(string(v))
+	case string:
+		return p.scanString(v)
+	case int64:
+		*p = Percentage(v * percentageScale)
+		return nil
+	case float64:
+		*p = Percentage(math.Round(v * percentageScale))
+		return nil
+	default:
+		return fmt.Errorf("tipo não suportado para Percentage: %T", src)
+	}
+}
+
+func (p *Percentage) scanString(value string) error {
+	parsed, err := ParsePercentage(value)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// jsonDecimal returns the decimal text of a JSON number or numeric string, and false for null.
+func jsonDecimal(data []byte) (string, bool, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return "", false, nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return "", false, err
+		}
+		return s, true, nil
+	}
+	return string(data), true, nil
+}
+
+// parseDecimal parses a decimal string into units of 1/scale. Digits beyond the
+// scale are rounded half away from zero when round is set, and rejected otherwise.
+func parseDecimal(value string, scale int64, round bool) (int64, error) {
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return 0, errors.New("inválido")
+	}
+	rat.Mul(rat, big.NewRat(scale, 1))
+	if !rat.IsInt() && !round {
+		return 0, errors.New("com casas decimais demais")
+	}
+	units := roundRat(rat)
+	if !units.IsInt64() {
+		return 0, errors.New("fora do intervalo")
+	}
+	return units.Int64(), nil
+}
+
+// roundRat rounds to the nearest integer, half away from zero.
+func roundRat(rat *big.Rat) *big.Int {
+	num := new(big.Int).Abs(rat.Num())
+	quo, rem := new(big.Int).QuoRem(num, rat.Denom(), new(big.Int))
+	if rem.Lsh(rem, 1).Cmp(rat.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+	if rat.Sign() < 0 {
+		quo.Neg(quo)
+	}
+	return quo
+}
+
+// formatDecimal writes units of 1/scale with the given number of decimal places.
+func formatDecimal(units, scale int64, places int) string {
+	sign := ""
+	abs := uint64(units)
+	if units < 0 {
+		sign = "-"
+		abs = uint64(-(units + 1)) + 1
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, abs/uint64(scale), places, abs%uint64(scale))
+}
@@ -0,0 +1,203 @@
+package payments
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Money
+		wantErr bool
+	}{
+		{"100", NewMoney(100, 0), false},
+		{"100.5", NewMoney(100, 50), false},
+		{"100.50", NewMoney(100, 50), false},
+		{"0.01", 1, false},
+		{"-0.25", -25, false},
+		{"-12.30", -NewMoney(12, 30), false},
+		{"1.230", NewMoney(1, 23), false},
+		{"1.005", 0, true},
+		{"abc", 0, true},
+		{"", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v; want %d, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{0, "0.00"},
+		{1, "0.01"},
+		{NewMoney(100, 50), "100.50"},
+		{-5, "-0.05"},
+		{-NewMoney(12, 30), "-12.30"},
+		{Money(-1 << 63), "-92233720368547758.08"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.money), got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    Money
+		wantErr bool
+	}{
+		{`49.9`, NewMoney(49, 90), false},
+		{`"49.90"`, NewMoney(49, 90), false},
+		{`null`, 0, false},
+		{`-3.5`, -NewMoney(3, 50), false},
+		// Extra precision in remote payloads is rounded half away from zero.
+		{`10.005`, NewMoney(10, 1), false},
+		{`10.0049`, NewMoney(10, 0), false},
+		{`-10.005`, -NewMoney(10, 1), false},
+		{`"x"`, 0, true},
+		{`true`, 0, true},
+	}
+	for _, tt := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(tt.json), &got)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("unmarshal %s = %s, %v; want %s, error %v", tt.json, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	type payload struct {
+		Value Money `json:"value"`
+	}
+	for _, money := range []Money{0, 1, NewMoney(1234, 56), -NewMoney(7, 5)} {
+		data, err := json.Marshal(payload{Value: money})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded payload
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded.Value != money {
+			t.Errorf("round trip of %s through %s gave %s, %v", money, data, decoded.Value, err)
+		}
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		src     any
+		want    Money
+		wantErr bool
+	}{
+		{[]byte("12.34"), NewMoney(12, 34), false},
+		{"0.10", 10, false},
+		{int64(3), NewMoney(3, 0), false},
+		{12.345, NewMoney(12, 35), false},
+		{nil, 0, false},
+		{[]byte("1.001"), 0, true},
+		{true, 0, true},
+	}
+	for _, tt := range tests {
+		var got Money
+		err := got.Scan(tt.src)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Scan(%v) = %s, %v; want %s, error %v", tt.src, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPercentage(t *testing.T) {
+	parseTests := []struct {
+		value   string
+		want    Percentage
+		wantErr bool
+	}{
+		{"2.5", NewPercentage(2, 50), false},
+		{"100", NewPercentage(100, 0), false},
+		{"33.3333", 333333, false},
+		{"-1.25", -NewPercentage(1, 25), false},
+		{"33.33333", 0, true},
+		{"%", 0, true},
+	}
+	for _, tt := range parseTests {
+		got, err := ParsePercentage(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePercentage(%q) = %d, %v; want %d, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	formatTests := []struct {
+		percentage Percentage
+		want       string
+	}{
+		{NewPercentage(2, 50), "2.50"},
+		{0, "0.00"},
+		{333333, "33.3333"},
+		{333330, "33.333"},
+		{-NewPercentage(1, 5), "-1.05"},
+	}
+	for _, tt := range formatTests {
+		if got := tt.percentage.String(); got != tt.want {
+			t.Errorf("Percentage(%d).String() = %q, want %q", int64(tt.percentage), got, tt.want)
+		}
+	}
+
+	jsonTests := []struct {
+		json string
+		want Percentage
+	}{
+		{`33.333`, 333330},
+		{`"1.5"`, NewPercentage(1, 50)},
+		{`12.345678`, 123457},
+		{`null`, 0},
+	}
+	for _, tt := range jsonTests {
+		var got Percentage
+		if err := json.Unmarshal([]byte(tt.json), &got); err != nil || got != tt.want {
+			t.Errorf("unmarshal %s = %s, %v; want %s", tt.json, got, err, tt.want)
+		}
+		data, _ := json.Marshal(got)
+		var decoded Percentage
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != got {
+			t.Errorf("round trip of %s through %s gave %s, %v", got, data, decoded, err)
+		}
+	}
+}
+
+func TestPercentageOf(t *testing.T) {
+	tests := []struct {
+		percentage Percentage
+		money      Money
+		want       Money
+	}{
+		{NewPercentage(10, 0), NewMoney(100, 0), NewMoney(10, 0)},
+		{333333, NewMoney(100, 0), NewMoney(33, 33)},
+		{NewPercentage(50, 0), 1, 1},
+		{NewPercentage(2, 50), NewMoney(19, 90), 50},
+		{NewPercentage(100, 0), NewMoney(7, 77), NewMoney(7, 77)},
+		{NewPercentage(50, 0), -1, -1},
+	}
+	for _, tt := range tests {
+		if got := tt.percentage.Of(tt.money); got != tt.want {
+			t.Errorf("%s%% of %s = %s, want %s", tt.percentage, tt.money, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSplitsWithFractionalPercentages(t *testing.T) {
+	third := []Split{{WalletID: "a", PercentualValue: 333333}, {WalletID: "b", PercentualValue: 333333}, {WalletID: "c", PercentualValue: 333333}}
+	if err := validateSplits(NewMoney(100, 0), 0, third); err != nil {
+		t.Fatalf("thirds rejected: %v", err)
+	}
+	over := append(third, Split{WalletID: "d", FixedValue: 2})
+	if err := validateSplits(NewMoney(100, 0), 0, over); err == nil {
+		t.Fatal("expected the fixed value above the remaining centavo to be rejected")
+	}
+}
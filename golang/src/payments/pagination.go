@@ -0,0 +1,117 @@
+package payments
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Page size limits for local list queries.
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+// ErrInvalidCursor is returned when a list cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("cursor inválido")
+
+// ErrInvalidListFilter is returned when a list filter holds a value the repository
+// cannot compare, such as a customer ID that is not a UUID.
+var ErrInvalidListFilter = errors.New("filtro inválido")
+
+// ListFilter narrows local list queries. Zero values are ignored, as are fields
+// that do not apply to the listed type (customers only use the created-at range,
+// and invoices have no billing type or due date).
+type ListFilter struct {
+	Status         string
+	CustomerID     string
+	SubscriptionID string
+	BillingType    string
+	// DueDateFrom and DueDateTo bound the due date of payments and the next due
+	// date of subscriptions, in the [from, to) interval.
+	DueDateFrom time.Time
+	DueDateTo   time.Time
+	// CreatedFrom and CreatedTo bound the creation time, in the [from, to) interval.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// IncludeDeleted also lists removed customers.
+	IncludeDeleted bool
+	// Limit is the page size; zero means DefaultListLimit and it is capped at MaxListLimit.
+	Limit int
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+}
+
+// Page is one page of a list ordered from the newest record to the oldest.
+type Page[T any] struct {
+	Items      []T    `json:"data"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+func (f ListFilter) pageSize() int {
+	switch {
+	case f.Limit <= 0:
+		return DefaultListLimit
+	case f.Limit > MaxListLimit:
+		return MaxListLimit
+	default:
+		return f.Limit
+	}
+}
+
+// after decodes the cursor into the creation time and ID of the last record already listed.
+func (f ListFilter) after() (time.Time, string, bool, error) {
+	if f.Cursor == "" {
+		return time.Time{}, "", false, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil {
+		return time.Time{}, "", false, ErrInvalidCursor
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return time.Time{}, "", false, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Time{}, "", false, ErrInvalidCursor
+	}
+	return t, id, true, nil
+}
+
+func encodeCursor(createdAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+// newPage trims the extra row fetched to detect a following page and builds its cursor.
+func newPage[T any](items []T, size int, key func(T) (time.Time, string)) Page[T] {
+	if len(items) <= size {
+		if items == nil {
+			items = []T{}
+		}
+		return Page[T]{Items: items}
+	}
+	items = items[:size]
+	createdAt, id := key(items[size-1])
+	return Page[T]{Items: items, NextCursor: encodeCursor(createdAt, id)}
+}
+
+// beforeCursor reports whether a record sorts after the cursor in newest-first order.
+func beforeCursor(createdAt time.Time, id string, cursorAt time.Time, cursorID string) bool {
+	if createdAt.Equal(cursorAt) {
+		return id < cursorID
+	}
+	return createdAt.Before(cursorAt)
+}
+
+// inRange reports whether t is within [from, to), ignoring zero bounds.
+func inRange(t, from, to time.Time) bool {
+	if !from.IsZero() && t.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !t.Before(to) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestListPaymentsPaginates(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	seedCustomer(t, repo)
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		billingType := "PIX"
+		if i%2 == 1 {
+			billingType = "BOLETO"
+		}
+		payment := PaymentRecord{
+			ID: fmt.Sprintf("pay-%d", i), CustomerID: "cust-1", BillingType: billingType, Status: "PENDING",
+			DueDate: base.AddDate(0, 0, i), CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		}
+		if err := repo.SavePayment(ctx, payment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	filter := ListFilter{CustomerID: "cust-1", Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not stop")
+		}
+		page, err := repo.ListPayments(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, payment := range page.Items {
+			ids = append(ids, payment.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor = page.NextCursor
+	}
+	if fmt.Sprint(ids) != "[pay-4 pay-3 pay-2 pay-1 pay-0]" {
+		t.Fatalf("unexpected order: %v", ids)
+	}
+
+	page, err := repo.ListPayments(ctx, ListFilter{BillingType: "PIX", DueDateFrom: base.AddDate(0, 0, 1), DueDateTo: base.AddDate(0, 0, 4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].ID != "pay-2" || page.NextCursor != "" {
+		t.Fatalf("unexpected filtered page: %+v", page)
+	}
+
+	if _, err := repo.ListPayments(ctx, ListFilter{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestPostgresListQueryValidatesIDs(t *testing.T) {
+	id := generateID()
+	created := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		build   func(q *listQuery)
+		cursor  string
+		wantErr error
+	}{
+		{"valid ids", func(q *listQuery) { q.whereID("customer_id = ?", "customer", id) }, encodeCursor(created, id), nil},
+		{"customer", func(q *listQuery) { q.whereID("customer_id = ?", "customer", "cust-1") }, "", ErrInvalidListFilter},
+		{"subscription", func(q *listQuery) { q.whereID("subscription_id = ?", "subscription", "1") }, "", ErrInvalidListFilter},
+		{"cursor id", func(q *listQuery) {}, encodeCursor(created, "pay-0"), ErrInvalidCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var q listQuery
+			tt.build(&q)
+			_, _, err := q.sql(paymentColumns, "payment_payments", ListFilter{Cursor: tt.cursor})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPostgresFindPaymentByIDSkipsForeignIDs(t *testing.T) {
+	// A nil database panics if queried: foreign ids must not reach Postgres.
+	repo := NewPostgresRepository(nil)
+	for _, id := range []string{"", "ext-1", "pay_123"} {
+		if _, err := repo.FindPaymentByID(context.Background(), id); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("expected sql.ErrNoRows for %q, got %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBillingTypeMismatch is returned when an operation does not apply to the billing type of a payment.
+var ErrBillingTypeMismatch = errors.New("forma de pagamento incompatível")
+
+// asaasLocation is the time zone of the dates and times returned by Asaas.
+var asaasLocation = time.FixedZone("BRT", -3*60*60)
+
+// PaymentPixQrCode returns the PIX QR code of a payment. The stored code is served
+// until it expires; then a new one is fetched from Asaas and stored.
+func (s *Service) PaymentPixQrCode(ctx context.Context, id string) (PixQrCode, error) {
+	payment, err := s.repo.FindPaymentByID(ctx, id)
+	if err != nil {
+		return PixQrCode{}, fmt.Errorf("falha ao localizar pagamento %s: %w", id, err)
+	}
+	if payment.BillingType != "PIX" && payment.BillingType != "UNDEFINED" {
+		return PixQrCode{}, fmt.Errorf("%w: pagamento %s é %s", ErrBillingTypeMismatch, id, payment.BillingType)
+	}
+
+	stored, err := s.repo.FindPixQrCode(ctx, payment.ID)
+	if err == nil && time.Now().Before(stored.ExpirationDate) {
+		return stored, nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return PixQrCode{}, fmt.Errorf("falha ao buscar QR code local do pagamento %s: %w", id, err)
+	}
+
+	remoteID, err := s.remotePaymentID(ctx, payment)
+	if err != nil {
+		return PixQrCode{}, fmt.Errorf("falha ao buscar pagamento no Asaas para id %s: %w", id, err)
+	}
+	remote, err := s.client.GetPixQrCode(ctx, remoteID)
+	if err != nil {
+		return PixQrCode{}, fmt.Errorf("falha ao buscar QR code PIX no Asaas: %w", err)
+	}
+
+	// A code whose expiration cannot be parsed is served but fetched again next time.
+	expiration, _ := time.ParseInLocation("2006-01-02 15:04:05", remote.ExpirationDate, asaasLocation)
+	code := PixQrCode{
+		EncodedImage:   remote.EncodedImage,
+		Payload:        remote.Payload,
+		ExpirationDate: expiration,
+	}
+	if err := s.repo.SavePixQrCode(ctx, payment.ID, code); err != nil {
+		return PixQrCode{}, fmt.Errorf("falha ao salvar QR code PIX local: %w", err)
+	}
+	return code, nil
+}
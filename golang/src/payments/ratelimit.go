@@ -0,0 +1,143 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit configures the client-side token bucket used by AsaasClient.
+type RateLimit struct {
+	// RequestsPerSecond is the refill rate of the bucket. Zero disables the limiter,
+	// although 429 pauses are still honored.
+	RequestsPerSecond float64
+	// Burst is the bucket capacity.
+	Burst int
+	// PauseOn429 is used when a 429 response carries no Retry-After or RateLimit-Reset header.
+	PauseOn429 time.Duration
+}
+
+// DefaultRateLimit returns the limiter settings used when none is configured.
+func DefaultRateLimit() RateLimit {
+	return RateLimit{
+		RequestsPerSecond: 5,
+		Burst:             10,
+		PauseOn429:        60 * time.Second,
+	}
+}
+
+// rateLimiter is a token bucket shared by every request of a client.
+type rateLimiter struct {
+	mu          sync.Mutex
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	// pauseCause is the error that started the current pause, if any.
+	pauseCause error
+	pauseOn429 time.Duration
+}
+
+func newRateLimiter(cfg RateLimit) *rateLimiter {
+	burst := float64(cfg.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:       cfg.RequestsPerSecond,
+		burst:      burst,
+		tokens:     burst,
+		last:       time.Now(),
+		pauseOn429: cfg.PauseOn429,
+	}
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	for {
+		wait := l.reserve(time.Now())
+		if wait <= 0 {
+			return nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			if cause := l.pausedBy(time.Now()); cause != nil {
+				// Keep the 429 so that callers can tell throttling apart from a timeout.
+				return fmt.Errorf("limite de requisições do Asaas: pausa de %s excede o prazo da requisição: %w: %w", wait.Round(time.Millisecond), cause, context.DeadlineExceeded)
+			}
+			return fmt.Errorf("limite de requisições do Asaas: espera de %s excede o prazo da requisição: %w", wait.Round(time.Millisecond), context.DeadlineExceeded)
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes a token when possible and otherwise returns how long to wait.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	if l.rate <= 0 {
+		return 0
+	}
+
+	elapsed := now.Sub(l.last).Seconds()
+	if elapsed > 0 {
+		l.tokens += elapsed * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Pause stops every caller from sending requests for d. cause is the error that
+// triggered the pause; Wait returns it when the pause outlasts the caller's deadline.
+func (l *rateLimiter) Pause(d time.Duration, cause error) {
+	if d <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until := time.Now().Add(d)
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+		l.pauseCause = cause
+	}
+	l.tokens = 0
+	l.last = until
+}
+
+// pausedBy returns the cause of the pause in effect at now, if any.
+func (l *rateLimiter) pausedBy(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Before(l.pausedUntil) {
+		return l.pauseCause
+	}
+	return nil
+}
+
+// pauseFor429 derives the pause window from the headers of a 429 response.
+func (l *rateLimiter) pauseFor429(header http.Header) time.Duration {
+	if wait, ok := parseRetryAfter(header.Get("Retry-After"), time.Now()); ok {
+		return wait
+	}
+	if seconds, err := strconv.Atoi(header.Get("RateLimit-Reset")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return l.pauseOn429
+}
@@ -0,0 +1,148 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{RequestsPerSecond: 2, Burst: 2})
+	start := limiter.last
+
+	for i := 0; i < 2; i++ {
+		if wait := limiter.reserve(start); wait != 0 {
+			t.Fatalf("request %d within the burst waited %s", i+1, wait)
+		}
+	}
+	if wait := limiter.reserve(start); wait != 500*time.Millisecond {
+		t.Fatalf("expected to wait 500ms for the next token, got %s", wait)
+	}
+	if wait := limiter.reserve(start.Add(500 * time.Millisecond)); wait != 0 {
+		t.Fatalf("token not refilled after 500ms, wait %s", wait)
+	}
+
+	// A long idle period refills up to the burst only.
+	later := start.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if wait := limiter.reserve(later); wait != 0 {
+			t.Fatalf("request %d after idle waited %s", i+1, wait)
+		}
+	}
+	if wait := limiter.reserve(later); wait <= 0 {
+		t.Fatal("bucket refilled above its burst")
+	}
+}
+
+func TestRateLimiterWithoutRate(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{})
+	for i := 0; i < 100; i++ {
+		if wait := limiter.reserve(time.Now()); wait != 0 {
+			t.Fatalf("disabled limiter waited %s", wait)
+		}
+	}
+	limiter.Pause(time.Minute, nil)
+	if wait := limiter.reserve(time.Now()); wait <= 0 || wait > time.Minute {
+		t.Fatalf("expected the pause to apply without a rate, got %s", wait)
+	}
+}
+
+func TestRateLimiterPauseFor429(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{PauseOn429: 42 * time.Second})
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"Retry-After", http.Header{"Retry-After": {"3"}}, 3 * time.Second},
+		{"RateLimit-Reset", http.Header{"Ratelimit-Reset": {"7"}}, 7 * time.Second},
+		{"Retry-After wins", http.Header{"Retry-After": {"3"}, "Ratelimit-Reset": {"7"}}, 3 * time.Second},
+		{"no header", http.Header{}, 42 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := limiter.pauseFor429(tt.header); got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWaitFailsWhenPauseExceedsDeadline(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{})
+	limiter.Pause(time.Minute, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := limiter.Wait(ctx); err == nil {
+		t.Fatal("expected Wait to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Wait slept %s instead of failing fast", elapsed)
+	}
+}
+
+func TestClientKeeps429WhenPauseExceedsDeadline(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	client := NewAsaasClient(Config{
+		APIURL: server.URL,
+		Retry:  RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, RetryableStatusCodes: []int{http.StatusTooManyRequests}},
+	})
+
+	// The first request hits the 429; the second one finds the client already paused.
+	for i := 1; i <= 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := client.doRequest(ctx, http.MethodGet, "payments", nil, &struct{}{})
+		cancel()
+		var asaasErr *AsaasError
+		if !errors.As(err, &asaasErr) || asaasErr.StatusCode != http.StatusTooManyRequests || asaasErr.RetryAfter != time.Minute {
+			t.Fatalf("request %d: expected the 429 with its Retry-After, got %v", i, err)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("request %d: expected the deadline to be reported too, got %v", i, err)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected 1 call, got %d", got)
+	}
+}
+
+func TestClientPausesOn429(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	pause := 100 * time.Millisecond
+	client := NewAsaasClient(Config{
+		APIURL:    server.URL,
+		Retry:     RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour, RetryableStatusCodes: []int{http.StatusTooManyRequests}},
+		RateLimit: RateLimit{PauseOn429: pause},
+	})
+
+	start := time.Now()
+	if err := client.doRequest(context.Background(), http.MethodGet, "payments", nil, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	// The retry waits for the limiter pause, not for the hour of backoff.
+	if elapsed := time.Since(start); elapsed < pause || elapsed > 10*time.Second {
+		t.Fatalf("expected the retry after the %s pause, took %s", pause, elapsed)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected 2 calls, got %d", got)
+	}
+}
@@ -0,0 +1,231 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Local refund statuses.
+const (
+	RefundStatusPending   = "PENDING"
+	RefundStatusDone      = "DONE"
+	RefundStatusCancelled = "CANCELLED"
+	RefundStatusDenied    = "DENIED"
+)
+
+// ErrInvalidRefund is returned when a refund value is negative or exceeds what is left to refund.
+var ErrInvalidRefund = errors.New("estorno inválido")
+
+// RefundPayment refunds a payment in Asaas, fully when req.Value is zero, and
+// records the refund locally.
+func (s *Service) RefundPayment(ctx context.Context, id string, req RefundRequest) (RefundRecord, PaymentResponse, error) {
+	payment, err := s.repo.FindPaymentByID(ctx, id)
+	if err != nil {
+		return RefundRecord{}, PaymentResponse{}, fmt.Errorf("falha ao localizar pagamento %s: %w", id, err)
+	}
+	refunds, err := s.repo.ListRefundsByPaymentID(ctx, payment.ID)
+	if err != nil {
+		return RefundRecord{}, PaymentResponse{}, fmt.Errorf("falha ao listar estornos do pagamento %s: %w", id, err)
+	}
+
+	remaining := payment.Value - refundedValue(refunds)
+	switch {
+	case remaining <= 0:
+		return RefundRecord{}, PaymentResponse{}, fmt.Errorf("%w: pagamento %s já estornado", ErrInvalidRefund, id)
+	case req.Value < 0 || req.Value > remaining:
+		return RefundRecord{}, PaymentResponse{}, fmt.Errorf("%w: valor %s acima do saldo de %s", ErrInvalidRefund, req.Value, remaining)
+	}
+	value := req.Value
+	if value == 0 {
+		value = remaining
+	}
+	// Asaas refunds the whole payment when no value is sent, which is more than what
+	// is left after partial refunds; ask for the value recorded locally.
+	req.Value = value
+
+	remotePaymentID, err := s.remotePaymentID(ctx, payment)
+	if err != nil {
+		return RefundRecord{}, PaymentResponse{}, fmt.Errorf("falha ao buscar pagamento no Asaas para id %s: %w", id, err)
+	}
+	remote, err := s.client.RefundPayment(ctx, remotePaymentID, req)
+	if err != nil {
+		return RefundRecord{}, PaymentResponse{}, fmt.Errorf("falha ao estornar pagamento no Asaas: %w", err)
+	}
+	remote = remote.WithoutCardToken()
+
+	refund, err := s.recordRefund(ctx, payment.ID, remote, value, req.Description)
+	if err != nil {
+		return RefundRecord{}, remote, fmt.Errorf("falha ao salvar estorno local: %w", err)
+	}
+	if remote.Status != "" {
+		if err := s.repo.UpdatePaymentStatus(ctx, payment.ID, remote.Status, remote.InvoiceURL, remote.TransactionReceiptURL); err != nil {
+			return refund, remote, fmt.Errorf("falha ao atualizar pagamento local: %w", err)
+		}
+	}
+	return refund, remote, nil
+}
+
+// recordRefund stores the refund just requested. Its webhook may have been handled
+// already, so when Asaas lists the refunds they are reconciled instead of inserted.
+func (s *Service) recordRefund(ctx context.Context, paymentID string, remote PaymentResponse, value Money, description string) (RefundRecord, error) {
+	if len(remote.Refunds) > 0 {
+		synced, err := s.syncRefunds(ctx, paymentID, remote.Refunds)
+		if err != nil {
+			return RefundRecord{}, err
+		}
+		for i := len(remote.Refunds) - 1; i >= 0; i-- {
+			if remote.Refunds[i].Value == value && remote.Refunds[i].Description == description {
+				return synced[i], nil
+			}
+		}
+	}
+	now := time.Now().UTC()
+	refund := RefundRecord{
+		ID:          generateID(),
+		PaymentID:   paymentID,
+		Value:       value,
+		Description: description,
+		Status:      RefundStatusPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	return refund, s.repo.SaveRefund(ctx, refund)
+}
+
+// syncRefunds matches the refunds listed by Asaas to the local ones, updating their
+// status and inserting the ones made elsewhere. A remote refund matches the local
+// refund already linked to its date, value and description, or else a refund
+// requested here with the same value and description that Asaas had not listed
+// yet. The result holds the local refund of each remote refund.
+func (s *Service) syncRefunds(ctx context.Context, paymentID string, remote []PaymentRefund) ([]RefundRecord, error) {
+	local, err := s.repo.ListRefundsByPaymentID(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]bool, len(local))
+	match := func(remoteRefund PaymentRefund, dateCreated string) int {
+		for k, refund := range local {
+			if !matched[k] && refund.AsaasDateCreated == dateCreated && refund.Value == remoteRefund.Value && refund.Description == remoteRefund.Description {
+				return k
+			}
+		}
+		return -1
+	}
+	synced := make([]RefundRecord, len(remote))
+	for i, remoteRefund := range remote {
+		status := refundStatusFromAsaas(remoteRefund.Status)
+		j := match(remoteRefund, remoteRefund.DateCreated)
+		if j < 0 {
+			j = match(remoteRefund, "")
+		}
+		if j < 0 {
+			now := time.Now().UTC()
+			refund := RefundRecord{
+				ID:                    generateID(),
+				PaymentID:             paymentID,
+				Value:                 remoteRefund.Value,
+				Description:           remoteRefund.Description,
+				Status:                status,
+				TransactionReceiptURL: remoteRefund.TransactionReceiptURL,
+				AsaasDateCreated:      remoteRefund.DateCreated,
+				CreatedAt:             now,
+				UpdatedAt:             now,
+			}
+			if err := s.repo.SaveRefund(ctx, refund); err != nil {
+				return nil, err
+			}
+			synced[i] = refund
+			continue
+		}
+
+		matched[j] = true
+		refund := local[j]
+		if refund.Status != status || refund.TransactionReceiptURL != remoteRefund.TransactionReceiptURL || refund.AsaasDateCreated != remoteRefund.DateCreated {
+			refund.Status = status
+			refund.TransactionReceiptURL = remoteRefund.TransactionReceiptURL
+			refund.AsaasDateCreated = remoteRefund.DateCreated
+			if err := s.repo.UpdateRefund(ctx, refund); err != nil {
+				return nil, err
+			}
+		}
+		synced[i] = refund
+	}
+	return synced, nil
+}
+
+// reconcileRefunds brings payment_refunds in line with a refund webhook. When the
+// payload does not list the refunds, the event settles the pending ones.
+func (s *Service) reconcileRefunds(ctx context.Context, payment PaymentRecord, event NotificationEvent) error {
+	listed := len(event.Payment.Refunds) > 0
+	if listed {
+		if _, err := s.syncRefunds(ctx, payment.ID, event.Payment.Refunds); err != nil {
+			return fmt.Errorf("falha ao conciliar estornos do pagamento %s: %w", payment.ID, err)
+		}
+	}
+
+	var status string
+	switch event.Event {
+	case "PAYMENT_REFUND_DENIED":
+		status = RefundStatusDenied
+	case "PAYMENT_REFUNDED", "PAYMENT_PARTIALLY_REFUNDED":
+		if listed {
+			return nil
+		}
+		status = RefundStatusDone
+	default:
+		return nil
+	}
+
+	refunds, err := s.repo.ListRefundsByPaymentID(ctx, payment.ID)
+	if err != nil {
+		return err
+	}
+	if len(refunds) == 0 && event.Event == "PAYMENT_REFUNDED" {
+		// Refunded in the Asaas dashboard, and the payload has no details: the whole value was returned.
+		now := time.Now().UTC()
+		return s.repo.SaveRefund(ctx, RefundRecord{
+			ID:        generateID(),
+			PaymentID: payment.ID,
+			Value:     payment.Value,
+			Status:    RefundStatusDone,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+	for _, refund := range refunds {
+		if refund.Status != RefundStatusPending {
+			continue
+		}
+		refund.Status = status
+		if err := s.repo.UpdateRefund(ctx, refund); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refundedValue sums the refunds that were not cancelled or denied.
+func refundedValue(refunds []RefundRecord) Money {
+	var total Money
+	for _, refund := range refunds {
+		if refund.Status != RefundStatusCancelled && refund.Status != RefundStatusDenied {
+			total += refund.Value
+		}
+	}
+	return total
+}
+
+// refundStatusFromAsaas maps the status of an Asaas refund to a local refund status.
+// Refunds awaiting authorization are still pending.
+func refundStatusFromAsaas(status string) string {
+	switch status {
+	case "DONE":
+		return RefundStatusDone
+	case "CANCELLED":
+		return RefundStatusCancelled
+	default:
+		return RefundStatusPending
+	}
+}
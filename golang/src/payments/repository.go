@@ -3,7 +3,9 @@ package payments
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -12,6 +14,11 @@ type PostgresRepository struct {
 	db *sql.DB
 }
 
+// execer is implemented by *sql.DB and *sql.Tx, so inserts can run inside a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
 // NewPostgresRepository builds a repository backed by PostgreSQL.
 func NewPostgresRepository(db *sql.DB) *PostgresRepository {
 	return &PostgresRepository{db: db}
@@ -22,6 +29,7 @@ func (r *PostgresRepository) EnsureSchema(ctx context.Context) error {
 	stmts := []string{
 		`CREATE TABLE IF NOT EXISTS payment_customers (
 id UUID PRIMARY KEY,
+asaas_id TEXT DEFAULT '',
 name TEXT NOT NULL,
 email TEXT DEFAULT '',
 cpfCnpj TEXT DEFAULT '',
@@ -39,6 +47,7 @@ cpfCnpj TEXT DEFAULT '',
 );`,
 		`CREATE TABLE IF NOT EXISTS payment_payments (
 id UUID PRIMARY KEY,
+asaas_id TEXT DEFAULT '',
 customer_id UUID NOT NULL REFERENCES payment_customers(id),
 subscription_id UUID DEFAULT NULL,
 billing_type TEXT NOT NULL,
@@ -57,6 +66,7 @@ due_date TIMESTAMPTZ NOT NULL,
 		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS subscription_id UUID;`,
 		`CREATE TABLE IF NOT EXISTS payment_subscriptions (
 id UUID PRIMARY KEY,
+asaas_id TEXT DEFAULT '',
 customer_id UUID NOT NULL REFERENCES payment_customers(id),
 billing_type TEXT NOT NULL,
 status TEXT DEFAULT '',
@@ -71,6 +81,7 @@ value NUMERIC NOT NULL,
 );`,
 		`CREATE TABLE IF NOT EXISTS payment_invoices (
 id UUID PRIMARY KEY,
+asaas_id TEXT DEFAULT '',
 payment_id UUID NOT NULL REFERENCES payment_payments(id),
 service_description TEXT NOT NULL,
 observations TEXT NOT NULL,
@@ -93,6 +104,153 @@ observations TEXT NOT NULL,
             created_at TIMESTAMPTZ NOT NULL,
             updated_at TIMESTAMPTZ NOT NULL
         );`,
+		`ALTER TABLE payment_customers ADD COLUMN IF NOT EXISTS asaas_id TEXT DEFAULT '';`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS asaas_id TEXT DEFAULT '';`,
+		`ALTER TABLE payment_subscriptions ADD COLUMN IF NOT EXISTS asaas_id TEXT DEFAULT '';`,
+		`ALTER TABLE payment_invoices ADD COLUMN IF NOT EXISTS asaas_id TEXT DEFAULT '';`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_payments_asaas_id_unique ON payment_payments (asaas_id) WHERE asaas_id <> '';`,
+		`DROP INDEX IF EXISTS idx_payment_payments_asaas_id;`,
+		`CREATE TABLE IF NOT EXISTS payment_pending_operations (
+id UUID PRIMARY KEY,
+kind TEXT NOT NULL,
+local_id UUID NOT NULL,
+asaas_id TEXT DEFAULT '',
+status TEXT NOT NULL,
+record JSONB NOT NULL,
+error TEXT DEFAULT '',
+attempts INTEGER NOT NULL DEFAULT 0,
+            created_at TIMESTAMPTZ NOT NULL,
+            updated_at TIMESTAMPTZ NOT NULL
+);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_pending_operations_status ON payment_pending_operations (status, updated_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_pending_operations_local_id ON payment_pending_operations (local_id);`,
+		`CREATE TABLE IF NOT EXISTS payment_idempotency_keys (
+key TEXT NOT NULL,
+method TEXT NOT NULL,
+path TEXT NOT NULL,
+request_hash TEXT NOT NULL,
+status_code INTEGER NOT NULL DEFAULT 0,
+response_body BYTEA,
+            created_at TIMESTAMPTZ NOT NULL,
+            completed_at TIMESTAMPTZ,
+            PRIMARY KEY (key, method, path)
+);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_idempotency_keys_created_at ON payment_idempotency_keys (created_at);`,
+		`CREATE TABLE IF NOT EXISTS payment_webhook_events (
+id UUID PRIMARY KEY,
+event_id TEXT NOT NULL UNIQUE,
+event_type TEXT NOT NULL,
+payload JSONB NOT NULL,
+status TEXT NOT NULL,
+error TEXT DEFAULT '',
+attempts INTEGER NOT NULL DEFAULT 0,
+            received_at TIMESTAMPTZ NOT NULL,
+            processed_at TIMESTAMPTZ,
+            updated_at TIMESTAMPTZ NOT NULL
+);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_webhook_events_received_at ON payment_webhook_events (received_at);`,
+		`ALTER TABLE payment_webhook_events ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW();`,
+		`ALTER TABLE payment_webhook_events ADD COLUMN IF NOT EXISTS locked_at TIMESTAMPTZ;`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_webhook_events_queue ON payment_webhook_events (status, next_attempt_at);`,
+		`ALTER TABLE payment_customers ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_customers_created_at ON payment_customers (created_at, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_payments_created_at ON payment_payments (created_at, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_payments_customer ON payment_payments (customer_id, created_at, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_payments_subscription ON payment_payments (subscription_id, created_at, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_payments_status ON payment_payments (status, created_at, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_payments_due_date ON payment_payments (due_date);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_subscriptions_created_at ON payment_subscriptions (created_at, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_subscriptions_customer ON payment_subscriptions (customer_id, created_at, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_subscriptions_status ON payment_subscriptions (status, created_at, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_invoices_created_at ON payment_invoices (created_at, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_invoices_payment ON payment_invoices (payment_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_invoices_status ON payment_invoices (status, created_at, id);`,
+		`CREATE TABLE IF NOT EXISTS payment_refunds (
+id UUID PRIMARY KEY,
+payment_id UUID NOT NULL REFERENCES payment_payments(id),
+value NUMERIC NOT NULL,
+description TEXT DEFAULT '',
+status TEXT NOT NULL,
+transaction_receipt_url TEXT DEFAULT '',
+            created_at TIMESTAMPTZ NOT NULL,
+            updated_at TIMESTAMPTZ NOT NULL
+);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_refunds_payment ON payment_refunds (payment_id, created_at);`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS pix_encoded_image TEXT DEFAULT '';`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS pix_payload TEXT DEFAULT '';`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS pix_expiration_date TIMESTAMPTZ;`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS authorization_status TEXT DEFAULT '';`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS authorized_at TIMESTAMPTZ;`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_payments_authorized ON payment_payments (authorized_at) WHERE authorization_status = 'AUTHORIZED';`,
+		`ALTER TABLE payment_customers ADD COLUMN IF NOT EXISTS credit_card_token TEXT DEFAULT '';`,
+		`ALTER TABLE payment_customers ADD COLUMN IF NOT EXISTS credit_card_brand TEXT DEFAULT '';`,
+		`ALTER TABLE payment_customers ADD COLUMN IF NOT EXISTS credit_card_last4 TEXT DEFAULT '';`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS boleto_identification_field TEXT DEFAULT '';`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS boleto_nosso_numero TEXT DEFAULT '';`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS boleto_bar_code TEXT DEFAULT '';`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS bank_slip_url TEXT DEFAULT '';`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS bank_slip_pdf BYTEA;`,
+		`CREATE TABLE IF NOT EXISTS payment_installments (
+id UUID PRIMARY KEY,
+asaas_id TEXT DEFAULT '',
+customer_id UUID NOT NULL REFERENCES payment_customers(id),
+billing_type TEXT NOT NULL,
+installment_count INTEGER NOT NULL,
+installment_value NUMERIC NOT NULL,
+total_value NUMERIC NOT NULL,
+description TEXT DEFAULT '',
+            status TEXT NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL,
+            updated_at TIMESTAMPTZ NOT NULL
+);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_installments_asaas_id ON payment_installments (asaas_id);`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS installment_id UUID REFERENCES payment_installments(id);`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS installment_number INTEGER NOT NULL DEFAULT 0;`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_payments_installment ON payment_payments (installment_id, installment_number);`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS discount_value NUMERIC NOT NULL DEFAULT 0;`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS discount_due_date_limit_days INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS discount_type TEXT DEFAULT '';`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS interest_value NUMERIC NOT NULL DEFAULT 0;`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS fine_value NUMERIC NOT NULL DEFAULT 0;`,
+		`ALTER TABLE payment_payments ADD COLUMN IF NOT EXISTS fine_type TEXT DEFAULT '';`,
+		`ALTER TABLE payment_subscriptions ADD COLUMN IF NOT EXISTS discount_value NUMERIC NOT NULL DEFAULT 0;`,
+		`ALTER TABLE payment_subscriptions ADD COLUMN IF NOT EXISTS discount_due_date_limit_days INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE payment_subscriptions ADD COLUMN IF NOT EXISTS discount_type TEXT DEFAULT '';`,
+		`ALTER TABLE payment_subscriptions ADD COLUMN IF NOT EXISTS interest_value NUMERIC NOT NULL DEFAULT 0;`,
+		`ALTER TABLE payment_subscriptions ADD COLUMN IF NOT EXISTS fine_value NUMERIC NOT NULL DEFAULT 0;`,
+		`ALTER TABLE payment_subscriptions ADD COLUMN IF NOT EXISTS fine_type TEXT DEFAULT '';`,
+		`CREATE TABLE IF NOT EXISTS payment_splits (
+id UUID PRIMARY KEY,
+asaas_id TEXT DEFAULT '',
+payment_id UUID REFERENCES payment_payments(id),
+subscription_id UUID REFERENCES payment_subscriptions(id),
+wallet_id TEXT NOT NULL,
+fixed_value NUMERIC NOT NULL DEFAULT 0,
+percentual_value NUMERIC NOT NULL DEFAULT 0,
+total_fixed_value NUMERIC NOT NULL DEFAULT 0,
+total_value NUMERIC NOT NULL DEFAULT 0,
+status TEXT NOT NULL,
+cancellation_reason TEXT DEFAULT '',
+            created_at TIMESTAMPTZ NOT NULL,
+            updated_at TIMESTAMPTZ NOT NULL,
+            CHECK ((payment_id IS NULL) <> (subscription_id IS NULL))
+);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_splits_payment ON payment_splits (payment_id, created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_splits_subscription ON payment_splits (subscription_id, created_at);`,
+		`CREATE TABLE IF NOT EXISTS payment_subscription_changes (
+id UUID PRIMARY KEY,
+subscription_id UUID NOT NULL REFERENCES payment_subscriptions(id),
+field TEXT NOT NULL,
+previous_value TEXT DEFAULT '',
+new_value TEXT DEFAULT '',
+update_pending_payments BOOLEAN NOT NULL DEFAULT FALSE,
+            created_at TIMESTAMPTZ NOT NULL
+);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_subscription_changes_subscription ON payment_subscription_changes (subscription_id, created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_customers_asaas_id ON payment_customers (asaas_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_subscriptions_asaas_id ON payment_subscriptions (asaas_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_invoices_asaas_id ON payment_invoices (asaas_id);`,
+		`ALTER TABLE payment_refunds ADD COLUMN IF NOT EXISTS asaas_date_created TEXT DEFAULT '';`,
 	}
 
 	for _, stmt := range stmts {
@@ -108,6 +266,7 @@ func (r *PostgresRepository) SaveCustomer(ctx context.Context, customer Customer
 	_, err := r.db.ExecContext(ctx, `
 INSERT INTO payment_customers (
 id,
+asaas_id,
 name,
 email,
 cpfCnpj,
@@ -123,9 +282,10 @@ additional_emails,
 created_at,
 updated_at
 )
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)
 `,
 		customer.ID,
+		customer.AsaasID,
 		customer.Name,
 		customer.Email,
 		customer.CpfCnpj,
@@ -144,12 +304,9 @@ VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
 	return err
 }
 
-// FindCustomerByID returns a customer record by ID.
-func (r *PostgresRepository) FindCustomerByID(ctx context.Context, id string) (CustomerRecord, error) {
-	var customer CustomerRecord
-	row := r.db.QueryRowContext(ctx, `
-SELECT
+const customerColumns = `
 id,
+asaas_id,
 name,
 email,
 cpfCnpj,
@@ -162,13 +319,20 @@ province,
 postal_code,
 notification_disabled,
 additional_emails,
+credit_card_token,
+credit_card_brand,
+credit_card_last4,
+deleted_at,
 created_at,
 updated_at
-FROM payment_customers
-WHERE id = $1
-`, id)
+`
+
+func scanCustomer(row rowScanner) (CustomerRecord, error) {
+	var customer CustomerRecord
+	var deletedAt sql.NullTime
 	if err := row.Scan(
 		&customer.ID,
+		&customer.AsaasID,
 		&customer.Name,
 		&customer.Email,
 		&customer.CpfCnpj,
@@ -181,23 +345,138 @@ WHERE id = $1
 		&customer.PostalCode,
 		&customer.NotificationDisabled,
 		&customer.AdditionalEmails,
+		&customer.CreditCardToken,
+		&customer.CreditCardBrand,
+		&customer.CreditCardLast4,
+		&deletedAt,
 		&customer.CreatedAt,
 		&customer.UpdatedAt,
 	); err != nil {
 		return CustomerRecord{}, err
 	}
+	if deletedAt.Valid {
+		customer.DeletedAt = deletedAt.Time
+	}
 	return customer, nil
 }
 
+// FindCustomerByID returns a customer record by ID.
+func (r *PostgresRepository) FindCustomerByID(ctx context.Context, id string) (CustomerRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT`+customerColumns+`FROM payment_customers
+WHERE id = $1
+`, id)
+	return scanCustomer(row)
+}
+
+// FindCustomerByAsaasID returns a customer record by its Asaas ID.
+func (r *PostgresRepository) FindCustomerByAsaasID(ctx context.Context, asaasID string) (CustomerRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT`+customerColumns+`FROM payment_customers
+WHERE asaas_id = $1 AND asaas_id <> ''
+ORDER BY created_at
+LIMIT 1
+`, asaasID)
+	return scanCustomer(row)
+}
+
+// UpdateCustomer overwrites the registration data of a customer.
+func (r *PostgresRepository) UpdateCustomer(ctx context.Context, customer CustomerRecord) error {
+	result, err := r.db.ExecContext(ctx, `
+UPDATE payment_customers SET
+name=$1,
+email=$2,
+cpfCnpj=$3,
+phone=$4,
+mobile_phone=$5,
+address=$6,
+address_number=$7,
+complement=$8,
+province=$9,
+postal_code=$10,
+notification_disabled=$11,
+additional_emails=$12,
+updated_at=$13
+WHERE id=$14
+`,
+		customer.Name,
+		customer.Email,
+		customer.CpfCnpj,
+		customer.Phone,
+		customer.MobilePhone,
+		customer.Address,
+		customer.AddressNumber,
+		customer.Complement,
+		customer.Province,
+		customer.PostalCode,
+		customer.NotificationDisabled,
+		customer.AdditionalEmails,
+		time.Now().UTC(),
+		customer.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetCustomerDeletedAt marks a customer as removed, or restores it when deletedAt is zero.
+func (r *PostgresRepository) SetCustomerDeletedAt(ctx context.Context, id string, deletedAt time.Time) error {
+	var value any
+	if !deletedAt.IsZero() {
+		value = deletedAt
+	}
+	result, err := r.db.ExecContext(ctx, `UPDATE payment_customers SET deleted_at=$1, updated_at=$2 WHERE id=$3`, value, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // SavePayment inserts a new payment row.
 func (r *PostgresRepository) SavePayment(ctx context.Context, payment PaymentRecord) error {
+	_, err := r.insertPayment(ctx, r.db, payment, "")
+	return err
+}
+
+// SaveImportedPayment inserts a payment imported from Asaas unless a payment with
+// the same Asaas ID is already stored. It returns the stored payment and whether
+// this call inserted it, so concurrent imports keep a single row.
+func (r *PostgresRepository) SaveImportedPayment(ctx context.Context, payment PaymentRecord) (PaymentRecord, bool, error) {
+	rows, err := r.insertPayment(ctx, r.db, payment, "ON CONFLICT (asaas_id) WHERE asaas_id <> '' DO NOTHING")
+	if err != nil {
+		return PaymentRecord{}, false, err
+	}
+	if rows == 0 {
+		stored, err := r.FindPaymentByAsaasID(ctx, payment.AsaasID)
+		return stored, false, err
+	}
+	return payment, true, nil
+}
+
+// insertPayment inserts a payment row followed by the optional conflict clause and
+// returns the number of rows inserted.
+func (r *PostgresRepository) insertPayment(ctx context.Context, db execer, payment PaymentRecord, onConflict string) (int64, error) {
 	var subscriptionID any
 	if payment.SubscriptionID != "" {
 		subscriptionID = payment.SubscriptionID
 	}
-	_, err := r.db.ExecContext(ctx, `
+	var installmentID any
+	if payment.InstallmentID != "" {
+		installmentID = payment.InstallmentID
+	}
+	var authorizedAt any
+	if !payment.AuthorizedAt.IsZero() {
+		authorizedAt = payment.AuthorizedAt
+	}
+	result, err := db.ExecContext(ctx, `
 INSERT INTO payment_payments (
 id,
+asaas_id,
 customer_id,
 subscription_id,
 billing_type,
@@ -210,12 +489,23 @@ callback_auto_redirect,
 status,
 invoice_url,
 transaction_receipt_url,
+authorization_status,
+authorized_at,
+installment_id,
+installment_number,
+discount_value,
+discount_due_date_limit_days,
+discount_type,
+interest_value,
+fine_value,
+fine_type,
 created_at,
 updated_at
 )
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
-`,
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26)
+`+onConflict,
 		payment.ID,
+		payment.AsaasID,
 		payment.CustomerID,
 		subscriptionID,
 		payment.BillingType,
@@ -228,10 +518,23 @@ VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
 		payment.Status,
 		payment.InvoiceURL,
 		payment.TransactionReceiptURL,
+		payment.AuthorizationStatus,
+		authorizedAt,
+		installmentID,
+		payment.InstallmentNumber,
+		payment.DiscountValue,
+		payment.DiscountDueDateLimitDays,
+		payment.DiscountType,
+		payment.InterestValue,
+		payment.FineValue,
+		payment.FineType,
 		payment.CreatedAt,
 		payment.UpdatedAt,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 // UpdatePaymentStatus updates the status and links of a payment.
@@ -254,12 +557,9 @@ func (r *PostgresRepository) UpdatePaymentStatus(ctx context.Context, id, status
 	return nil
 }
 
-// FindPaymentByID returns a payment record by ID.
-func (r *PostgresRepository) FindPaymentByID(ctx context.Context, id string) (PaymentRecord, error) {
-	var payment PaymentRecord
-	row := r.db.QueryRowContext(ctx, `
-SELECT
+const paymentColumns = `
 id,
+asaas_id,
 customer_id,
 subscription_id,
 billing_type,
@@ -272,14 +572,28 @@ callback_auto_redirect,
 status,
 invoice_url,
 transaction_receipt_url,
+authorization_status,
+authorized_at,
+installment_id,
+installment_number,
+discount_value,
+discount_due_date_limit_days,
+discount_type,
+interest_value,
+fine_value,
+fine_type,
 created_at,
 updated_at
-FROM payment_payments
-WHERE id = $1
-`, id)
+`
+
+func scanPayment(row rowScanner) (PaymentRecord, error) {
+	var payment PaymentRecord
 	var subscriptionID sql.NullString
+	var authorizedAt sql.NullTime
+	var installmentID sql.NullString
 	if err := row.Scan(
 		&payment.ID,
+		&payment.AsaasID,
 		&payment.CustomerID,
 		&subscriptionID,
 		&payment.BillingType,
@@ -292,22 +606,199 @@ WHERE id = $1
 		&payment.Status,
 		&payment.InvoiceURL,
 		&payment.TransactionReceiptURL,
+		&payment.AuthorizationStatus,
+		&authorizedAt,
+		&installmentID,
+		&payment.InstallmentNumber,
+		&payment.DiscountValue,
+		&payment.DiscountDueDateLimitDays,
+		&payment.DiscountType,
+		&payment.InterestValue,
+		&payment.FineValue,
+		&payment.FineType,
 		&payment.CreatedAt,
 		&payment.UpdatedAt,
 	); err != nil {
 		return PaymentRecord{}, err
 	}
+	if installmentID.Valid {
+		payment.InstallmentID = installmentID.String
+	}
 	if subscriptionID.Valid {
 		payment.SubscriptionID = subscriptionID.String
 	}
+	if authorizedAt.Valid {
+		payment.AuthorizedAt = authorizedAt.Time
+	}
 	return payment, nil
 }
 
+// FindPaymentByID returns a payment record by ID.
+func (r *PostgresRepository) FindPaymentByID(ctx context.Context, id string) (PaymentRecord, error) {
+	if !isUUID(id) {
+		// The id column is a UUID; Postgres rejects any other text as invalid input.
+		return PaymentRecord{}, sql.ErrNoRows
+	}
+	row := r.db.QueryRowContext(ctx, `SELECT`+paymentColumns+`FROM payment_payments
+WHERE id = $1
+`, id)
+	return scanPayment(row)
+}
+
+// FindPaymentByAsaasID returns a payment record by its Asaas ID.
+func (r *PostgresRepository) FindPaymentByAsaasID(ctx context.Context, asaasID string) (PaymentRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT`+paymentColumns+`FROM payment_payments
+WHERE asaas_id = $1 AND asaas_id <> ''
+ORDER BY created_at
+LIMIT 1
+`, asaasID)
+	return scanPayment(row)
+}
+
+// ListPaymentsByInstallmentID returns the payments of an installment plan in installment order.
+func (r *PostgresRepository) ListPaymentsByInstallmentID(ctx context.Context, installmentID string) ([]PaymentRecord, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT`+paymentColumns+`FROM payment_payments
+WHERE installment_id = $1
+ORDER BY installment_number, created_at
+`, installmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []PaymentRecord
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+// SaveInstallment inserts an installment plan row.
+func (r *PostgresRepository) SaveInstallment(ctx context.Context, installment InstallmentRecord) error {
+	return r.insertInstallment(ctx, r.db, installment)
+}
+
+// SaveInstallmentPayment inserts an installment plan and its first payment in one
+// transaction, so the plan is never visible without that payment.
+func (r *PostgresRepository) SaveInstallmentPayment(ctx context.Context, installment InstallmentRecord, payment PaymentRecord) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := r.insertInstallment(ctx, tx, installment); err != nil {
+		return err
+	}
+	if _, err := r.insertPayment(ctx, tx, payment, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresRepository) insertInstallment(ctx context.Context, db execer, installment InstallmentRecord) error {
+	_, err := db.ExecContext(ctx, `
+INSERT INTO payment_installments (
+id,
+asaas_id,
+customer_id,
+billing_type,
+installment_count,
+installment_value,
+total_value,
+description,
+status,
+created_at,
+updated_at
+)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+`,
+		installment.ID,
+		installment.AsaasID,
+		installment.CustomerID,
+		installment.BillingType,
+		installment.InstallmentCount,
+		installment.InstallmentValue,
+		installment.TotalValue,
+		installment.Description,
+		installment.Status,
+		installment.CreatedAt,
+		installment.UpdatedAt,
+	)
+	return err
+}
+
+const installmentColumns = `
+id,
+asaas_id,
+customer_id,
+billing_type,
+installment_count,
+installment_value,
+total_value,
+description,
+status,
+created_at,
+updated_at
+`
+
+func scanInstallment(row rowScanner) (InstallmentRecord, error) {
+	var installment InstallmentRecord
+	if err := row.Scan(
+		&installment.ID,
+		&installment.AsaasID,
+		&installment.CustomerID,
+		&installment.BillingType,
+		&installment.InstallmentCount,
+		&installment.InstallmentValue,
+		&installment.TotalValue,
+		&installment.Description,
+		&installment.Status,
+		&installment.CreatedAt,
+		&installment.UpdatedAt,
+	); err != nil {
+		return InstallmentRecord{}, err
+	}
+	return installment, nil
+}
+
+// FindInstallmentByID returns an installment plan by ID.
+func (r *PostgresRepository) FindInstallmentByID(ctx context.Context, id string) (InstallmentRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT`+installmentColumns+`FROM payment_installments
+WHERE id = $1
+`, id)
+	return scanInstallment(row)
+}
+
+// FindInstallmentByAsaasID returns an installment plan by its Asaas ID.
+func (r *PostgresRepository) FindInstallmentByAsaasID(ctx context.Context, asaasID string) (InstallmentRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT`+installmentColumns+`FROM payment_installments
+WHERE asaas_id = $1 AND asaas_id <> ''
+`, asaasID)
+	return scanInstallment(row)
+}
+
+// UpdateInstallmentStatus updates the status of an installment plan.
+func (r *PostgresRepository) UpdateInstallmentStatus(ctx context.Context, id, status string) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE payment_installments SET status=$1, updated_at=$2 WHERE id=$3`, status, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // SaveSubscription inserts a subscription row.
 func (r *PostgresRepository) SaveSubscription(ctx context.Context, subscription SubscriptionRecord) error {
 	_, err := r.db.ExecContext(ctx, `
 INSERT INTO payment_subscriptions (
 id,
+asaas_id,
 customer_id,
 billing_type,
 status,
@@ -317,12 +808,19 @@ next_due_date,
 description,
 end_date,
 max_payments,
+discount_value,
+discount_due_date_limit_days,
+discount_type,
+interest_value,
+fine_value,
+fine_type,
 created_at,
 updated_at
 )
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19)
 `,
 		subscription.ID,
+		subscription.AsaasID,
 		subscription.CustomerID,
 		subscription.BillingType,
 		subscription.Status,
@@ -332,18 +830,21 @@ VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
 		subscription.Description,
 		subscription.EndDate,
 		subscription.MaxPayments,
+		subscription.DiscountValue,
+		subscription.DiscountDueDateLimitDays,
+		subscription.DiscountType,
+		subscription.InterestValue,
+		subscription.FineValue,
+		subscription.FineType,
 		subscription.CreatedAt,
 		subscription.UpdatedAt,
 	)
 	return err
 }
 
-// FindSubscriptionByID returns a subscription record by ID.
-func (r *PostgresRepository) FindSubscriptionByID(ctx context.Context, id string) (SubscriptionRecord, error) {
-	var subscription SubscriptionRecord
-	row := r.db.QueryRowContext(ctx, `
-SELECT
+const subscriptionColumns = `
 id,
+asaas_id,
 customer_id,
 billing_type,
 status,
@@ -353,13 +854,21 @@ next_due_date,
 description,
 end_date,
 max_payments,
+discount_value,
+discount_due_date_limit_days,
+discount_type,
+interest_value,
+fine_value,
+fine_type,
 created_at,
 updated_at
-FROM payment_subscriptions
-WHERE id = $1
-`, id)
+`
+
+func scanSubscription(row rowScanner) (SubscriptionRecord, error) {
+	var subscription SubscriptionRecord
 	if err := row.Scan(
 		&subscription.ID,
+		&subscription.AsaasID,
 		&subscription.CustomerID,
 		&subscription.BillingType,
 		&subscription.Status,
@@ -369,6 +878,12 @@ WHERE id = $1
 		&subscription.Description,
 		&subscription.EndDate,
 		&subscription.MaxPayments,
+		&subscription.DiscountValue,
+		&subscription.DiscountDueDateLimitDays,
+		&subscription.DiscountType,
+		&subscription.InterestValue,
+		&subscription.FineValue,
+		&subscription.FineType,
 		&subscription.CreatedAt,
 		&subscription.UpdatedAt,
 	); err != nil {
@@ -377,6 +892,24 @@ WHERE id = $1
 	return subscription, nil
 }
 
+// FindSubscriptionByID returns a subscription record by ID.
+func (r *PostgresRepository) FindSubscriptionByID(ctx context.Context, id string) (SubscriptionRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT`+subscriptionColumns+`FROM payment_subscriptions
+WHERE id = $1
+`, id)
+	return scanSubscription(row)
+}
+
+// FindSubscriptionByAsaasID returns a subscription record by its Asaas ID.
+func (r *PostgresRepository) FindSubscriptionByAsaasID(ctx context.Context, asaasID string) (SubscriptionRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT`+subscriptionColumns+`FROM payment_subscriptions
+WHERE asaas_id = $1 AND asaas_id <> ''
+ORDER BY created_at
+LIMIT 1
+`, asaasID)
+	return scanSubscription(row)
+}
+
 // UpdateSubscriptionStatus updates the subscription status locally.
 func (r *PostgresRepository) UpdateSubscriptionStatus(ctx context.Context, id, status string) error {
 	result, err := r.db.ExecContext(ctx, `UPDATE payment_subscriptions SET status=$1, updated_at=$2 WHERE id=$3`, status, time.Now().UTC(), id)
@@ -389,11 +922,107 @@ func (r *PostgresRepository) UpdateSubscriptionStatus(ctx context.Context, id, s
 	return nil
 }
 
+// UpdateSubscription stores the billing type, value, cycle, next due date and status of a subscription.
+func (r *PostgresRepository) UpdateSubscription(ctx context.Context, subscription SubscriptionRecord) error {
+	result, err := r.db.ExecContext(ctx, `
+UPDATE payment_subscriptions
+SET billing_type=$1, value=$2, cycle=$3, next_due_date=$4, status=$5, updated_at=$6
+WHERE id=$7
+`,
+		subscription.BillingType,
+		subscription.Value,
+		subscription.Cycle,
+		subscription.NextDueDate,
+		subscription.Status,
+		time.Now().UTC(),
+		subscription.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UpdatePendingSubscriptionPayments sets the billing type and value of the pending
+// and overdue payments of a subscription. Asaas reissues their boleto and PIX QR
+// code, so the stored ones are cleared.
+func (r *PostgresRepository) UpdatePendingSubscriptionPayments(ctx context.Context, subscriptionID, billingType string, value Money) error {
+	_, err := r.db.ExecContext(ctx, `
+UPDATE payment_payments
+SET billing_type=$1, value=$2, updated_at=$3,
+pix_encoded_image='', pix_payload='', pix_expiration_date=NULL,
+boleto_identification_field='', boleto_nosso_numero='', boleto_bar_code='', bank_slip_url='', bank_slip_pdf=NULL
+WHERE subscription_id=$4 AND status IN ('PENDING', 'OVERDUE')
+`, billingType, value, time.Now().UTC(), subscriptionID)
+	return err
+}
+
+// SaveSubscriptionChange inserts a change of a subscription field.
+func (r *PostgresRepository) SaveSubscriptionChange(ctx context.Context, change SubscriptionChangeRecord) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO payment_subscription_changes (
+id,
+subscription_id,
+field,
+previous_value,
+new_value,
+update_pending_payments,
+created_at
+)
+VALUES ($1,$2,$3,$4,$5,$6,$7)
+`,
+		change.ID,
+		change.SubscriptionID,
+		change.Field,
+		change.PreviousValue,
+		change.NewValue,
+		change.UpdatePendingPayments,
+		change.CreatedAt,
+	)
+	return err
+}
+
+// ListSubscriptionChanges returns the changes of a subscription, oldest first.
+func (r *PostgresRepository) ListSubscriptionChanges(ctx context.Context, subscriptionID string) ([]SubscriptionChangeRecord, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, subscription_id, field, previous_value, new_value, update_pending_payments, created_at
+FROM payment_subscription_changes
+WHERE subscription_id = $1
+ORDER BY created_at, field
+`, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []SubscriptionChangeRecord
+	for rows.Next() {
+		var change SubscriptionChangeRecord
+		if err := rows.Scan(
+			&change.ID,
+			&change.SubscriptionID,
+			&change.Field,
+			&change.PreviousValue,
+			&change.NewValue,
+			&change.UpdatePendingPayments,
+			&change.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
 // SaveInvoice inserts an invoice row.
 func (r *PostgresRepository) SaveInvoice(ctx context.Context, invoice InvoiceRecord) error {
 	_, err := r.db.ExecContext(ctx, `
 INSERT INTO payment_invoices (
 id,
+asaas_id,
 payment_id,
 service_description,
 observations,
@@ -416,9 +1045,10 @@ payment_link,
 created_at,
 updated_at
 )
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23)
 `,
 		invoice.ID,
+		invoice.AsaasID,
 		invoice.PaymentID,
 		invoice.ServiceDescription,
 		invoice.Observations,
@@ -444,12 +1074,9 @@ VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$
 	return err
 }
 
-// FindInvoiceByPaymentID returns the first invoice linked to a payment.
-func (r *PostgresRepository) FindInvoiceByPaymentID(ctx context.Context, paymentID string) (InvoiceRecord, error) {
-	var invoice InvoiceRecord
-	row := r.db.QueryRowContext(ctx, `
-SELECT
+const invoiceColumns = `
 id,
+asaas_id,
 payment_id,
 service_description,
 observations,
@@ -471,12 +1098,13 @@ status,
 payment_link,
 created_at,
 updated_at
-FROM payment_invoices
-WHERE payment_id = $1
-LIMIT 1
-`, paymentID)
+`
+
+func scanInvoice(row rowScanner) (InvoiceRecord, error) {
+	var invoice InvoiceRecord
 	if err := row.Scan(
 		&invoice.ID,
+		&invoice.AsaasID,
 		&invoice.PaymentID,
 		&invoice.ServiceDescription,
 		&invoice.Observations,
@@ -501,18 +1129,1117 @@ LIMIT 1
 	); err != nil {
 		return InvoiceRecord{}, err
 	}
-
 	return invoice, nil
 }
 
-// UpdateInvoiceStatus updates invoice status locally.
-func (r *PostgresRepository) UpdateInvoiceStatus(ctx context.Context, id, status string) error {
-	result, err := r.db.ExecContext(ctx, `UPDATE payment_invoices SET status=$1, updated_at=$2 WHERE id=$3`, status, time.Now().UTC(), id)
-	if err != nil {
-		return err
-	}
-	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
-		return sql.ErrNoRows
-	}
+// FindInvoiceByPaymentID returns the first invoice linked to a payment.
+func (r *PostgresRepository) FindInvoiceByPaymentID(ctx context.Context, paymentID string) (InvoiceRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT`+invoiceColumns+`FROM payment_invoices
+WHERE payment_id = $1
+LIMIT 1
+`, paymentID)
+	return scanInvoice(row)
+}
+
+// FindInvoiceByID returns an invoice record by ID.
+func (r *PostgresRepository) FindInvoiceByID(ctx context.Context, id string) (InvoiceRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT`+invoiceColumns+`FROM payment_invoices
+WHERE id = $1
+`, id)
+	return scanInvoice(row)
+}
+
+// FindInvoiceByAsaasID returns an invoice record by its Asaas ID.
+func (r *PostgresRepository) FindInvoiceByAsaasID(ctx context.Context, asaasID string) (InvoiceRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT`+invoiceColumns+`FROM payment_invoices
+WHERE asaas_id = $1 AND asaas_id <> ''
+ORDER BY created_at
+LIMIT 1
+`, asaasID)
+	return scanInvoice(row)
+}
+
+// UpdateInvoiceStatus updates invoice status locally.
+func (r *PostgresRepository) UpdateInvoiceStatus(ctx context.Context, id, status string) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE payment_invoices SET status=$1, updated_at=$2 WHERE id=$3`, status, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetCustomerAsaasID stores the Asaas ID of a customer.
+func (r *PostgresRepository) SetCustomerAsaasID(ctx context.Context, id, asaasID string) error {
+	return r.setAsaasID(ctx, "payment_customers", id, asaasID)
+}
+
+// SetCustomerCreditCard stores the token and masked data of the card saved for a customer.
+func (r *PostgresRepository) SetCustomerCreditCard(ctx context.Context, id, token, brand, last4 string) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`UPDATE payment_customers SET credit_card_token=$1, credit_card_brand=$2, credit_card_last4=$3, updated_at=$4 WHERE id=$5`,
+		token,
+		brand,
+		last4,
+		time.Now().UTC(),
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TransitionPaymentAuthorization moves the authorization of a payment from one
+// status to another. It reports false, without changes, when the payment is not in
+// the from status, so concurrent transitions apply only once.
+func (r *PostgresRepository) TransitionPaymentAuthorization(ctx context.Context, id, from, to string) (bool, error) {
+	result, err := r.db.ExecContext(
+		ctx,
+		`UPDATE payment_payments SET authorization_status=$1, updated_at=$2 WHERE id=$3 AND authorization_status=$4`,
+		to,
+		time.Now().UTC(),
+		id,
+		from,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// ListExpiredAuthorizations returns payments still authorized since before the given
+// time, oldest first.
+func (r *PostgresRepository) ListExpiredAuthorizations(ctx context.Context, before time.Time, limit int) ([]PaymentRecord, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT`+paymentColumns+`FROM payment_payments
+WHERE authorization_status = $1 AND authorized_at < $2
+ORDER BY authorized_at, id
+LIMIT $3
+`, AuthorizationStatusAuthorized, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []PaymentRecord
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+// SetPaymentAsaasID stores the Asaas ID of a payment.
+func (r *PostgresRepository) SetPaymentAsaasID(ctx context.Context, id, asaasID string) error {
+	return r.setAsaasID(ctx, "payment_payments", id, asaasID)
+}
+
+// SetSubscriptionAsaasID stores the Asaas ID of a subscription.
+func (r *PostgresRepository) SetSubscriptionAsaasID(ctx context.Context, id, asaasID string) error {
+	return r.setAsaasID(ctx, "payment_subscriptions", id, asaasID)
+}
+
+// SetInvoiceAsaasID stores the Asaas ID of an invoice.
+func (r *PostgresRepository) SetInvoiceAsaasID(ctx context.Context, id, asaasID string) error {
+	return r.setAsaasID(ctx, "payment_invoices", id, asaasID)
+}
+
+// ListCustomerIDsWithoutAsaasID returns local customer IDs whose Asaas ID is unknown.
+func (r *PostgresRepository) ListCustomerIDsWithoutAsaasID(ctx context.Context) ([]string, error) {
+	return r.listIDsWithoutAsaasID(ctx, "payment_customers")
+}
+
+// ListPaymentIDsWithoutAsaasID returns local payment IDs whose Asaas ID is unknown.
+func (r *PostgresRepository) ListPaymentIDsWithoutAsaasID(ctx context.Context) ([]string, error) {
+	return r.listIDsWithoutAsaasID(ctx, "payment_payments")
+}
+
+// ListSubscriptionIDsWithoutAsaasID returns local subscription IDs whose Asaas ID is unknown.
+func (r *PostgresRepository) ListSubscriptionIDsWithoutAsaasID(ctx context.Context) ([]string, error) {
+	return r.listIDsWithoutAsaasID(ctx, "payment_subscriptions")
+}
+
+// ListInvoiceIDsWithoutAsaasID returns local invoice IDs whose Asaas ID is unknown.
+func (r *PostgresRepository) ListInvoiceIDsWithoutAsaasID(ctx context.Context) ([]string, error) {
+	return r.listIDsWithoutAsaasID(ctx, "payment_invoices")
+}
+
+func (r *PostgresRepository) setAsaasID(ctx context.Context, table, id, asaasID string) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE `+table+` SET asaas_id=$1, updated_at=$2 WHERE id=$3`, asaasID, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
+
+func (r *PostgresRepository) listIDsWithoutAsaasID(ctx context.Context, table string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id FROM `+table+` WHERE asaas_id IS NULL OR asaas_id = '' ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SavePendingOperation inserts a pending operation row.
+func (r *PostgresRepository) SavePendingOperation(ctx context.Context, op PendingOperation) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO payment_pending_operations (
+id,
+kind,
+local_id,
+asaas_id,
+status,
+record,
+error,
+attempts,
+created_at,
+updated_at
+)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+`,
+		op.ID,
+		op.Kind,
+		op.LocalID,
+		op.AsaasID,
+		op.Status,
+		op.Record,
+		op.Error,
+		op.Attempts,
+		op.CreatedAt,
+		op.UpdatedAt,
+	)
+	return err
+}
+
+// UpdatePendingOperation stores the Asaas ID, record snapshot, status and error of an operation.
+func (r *PostgresRepository) UpdatePendingOperation(ctx context.Context, op PendingOperation) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`UPDATE payment_pending_operations SET asaas_id=$1, record=$2, status=$3, error=$4, attempts=$5, updated_at=$6 WHERE id=$7`,
+		op.AsaasID,
+		op.Record,
+		op.Status,
+		op.Error,
+		op.Attempts,
+		time.Now().UTC(),
+		op.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// FindPendingOperationByLocalID returns the latest operation that creates the given local record.
+func (r *PostgresRepository) FindPendingOperationByLocalID(ctx context.Context, localID string) (PendingOperation, error) {
+	var op PendingOperation
+	err := r.db.QueryRowContext(ctx, `
+SELECT
+id,
+kind,
+local_id,
+asaas_id,
+status,
+record,
+error,
+attempts,
+created_at,
+updated_at
+FROM payment_pending_operations
+WHERE local_id = $1
+ORDER BY created_at DESC
+LIMIT 1
+`, localID).Scan(
+		&op.ID,
+		&op.Kind,
+		&op.LocalID,
+		&op.AsaasID,
+		&op.Status,
+		&op.Record,
+		&op.Error,
+		&op.Attempts,
+		&op.CreatedAt,
+		&op.UpdatedAt,
+	)
+	return op, err
+}
+
+// ListPendingOperations returns operations still pending that were last touched before the given time.
+func (r *PostgresRepository) ListPendingOperations(ctx context.Context, before time.Time, limit int) ([]PendingOperation, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT
+id,
+kind,
+local_id,
+asaas_id,
+status,
+record,
+error,
+attempts,
+created_at,
+updated_at
+FROM payment_pending_operations
+WHERE status = $1 AND updated_at < $2
+ORDER BY updated_at
+LIMIT $3
+`, PendingOperationStatusPending, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []PendingOperation
+	for rows.Next() {
+		var op PendingOperation
+		if err := rows.Scan(
+			&op.ID,
+			&op.Kind,
+			&op.LocalID,
+			&op.AsaasID,
+			&op.Status,
+			&op.Record,
+			&op.Error,
+			&op.Attempts,
+			&op.CreatedAt,
+			&op.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// ReserveIdempotencyKey claims a key for a new request. When the key is already taken it
+// returns the stored record and false. Records created before expiredBefore are discarded first.
+func (r *PostgresRepository) ReserveIdempotencyKey(ctx context.Context, record IdempotencyRecord, expiredBefore time.Time) (IdempotencyRecord, bool, error) {
+	if _, err := r.db.ExecContext(
+		ctx,
+		`DELETE FROM payment_idempotency_keys WHERE key=$1 AND method=$2 AND path=$3 AND created_at < $4`,
+		record.Key,
+		record.Method,
+		record.Path,
+		expiredBefore,
+	); err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+INSERT INTO payment_idempotency_keys (
+key,
+method,
+path,
+request_hash,
+status_code,
+created_at
+)
+VALUES ($1,$2,$3,$4,0,$5)
+ON CONFLICT (key, method, path) DO NOTHING
+`,
+		record.Key,
+		record.Method,
+		record.Path,
+		record.RequestHash,
+		record.CreatedAt,
+	)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 1 {
+		return record, true, nil
+	}
+
+	var existing IdempotencyRecord
+	var completedAt sql.NullTime
+	row := r.db.QueryRowContext(ctx, `
+SELECT
+key,
+method,
+path,
+request_hash,
+status_code,
+response_body,
+created_at,
+completed_at
+FROM payment_idempotency_keys
+WHERE key = $1 AND method = $2 AND path = $3
+`, record.Key, record.Method, record.Path)
+	if err := row.Scan(
+		&existing.Key,
+		&existing.Method,
+		&existing.Path,
+		&existing.RequestHash,
+		&existing.StatusCode,
+		&existing.ResponseBody,
+		&existing.CreatedAt,
+		&completedAt,
+	); err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if completedAt.Valid {
+		existing.CompletedAt = completedAt.Time
+	}
+	return existing, false, nil
+}
+
+// CompleteIdempotencyKey stores the response sent for a reserved key.
+func (r *PostgresRepository) CompleteIdempotencyKey(ctx context.Context, key, method, path string, statusCode int, body []byte) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`UPDATE payment_idempotency_keys SET status_code=$1, response_body=$2, completed_at=$3 WHERE key=$4 AND method=$5 AND path=$6`,
+		statusCode,
+		body,
+		time.Now().UTC(),
+		key,
+		method,
+		path,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes a reservation so the request can be retried.
+func (r *PostgresRepository) ReleaseIdempotencyKey(ctx context.Context, key, method, path string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM payment_idempotency_keys WHERE key=$1 AND method=$2 AND path=$3`, key, method, path)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes keys created before the given time.
+func (r *PostgresRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM payment_idempotency_keys WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const webhookEventColumns = `
+id,
+event_id,
+event_type,
+payload,
+status,
+error,
+attempts,
+next_attempt_at,
+locked_at,
+received_at,
+processed_at,
+updated_at
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhookEvent(row rowScanner) (WebhookEventRecord, error) {
+	var event WebhookEventRecord
+	var lockedAt, processedAt sql.NullTime
+	if err := row.Scan(
+		&event.ID,
+		&event.EventID,
+		&event.EventType,
+		&event.Payload,
+		&event.Status,
+		&event.Error,
+		&event.Attempts,
+		&event.NextAttemptAt,
+		&lockedAt,
+		&event.ReceivedAt,
+		&processedAt,
+		&event.UpdatedAt,
+	); err != nil {
+		return WebhookEventRecord{}, err
+	}
+	if lockedAt.Valid {
+		event.LockedAt = lockedAt.Time
+	}
+	if processedAt.Valid {
+		event.ProcessedAt = processedAt.Time
+	}
+	return event, nil
+}
+
+// SavePixQrCode stores the PIX QR code of a payment.
+func (r *PostgresRepository) SavePixQrCode(ctx context.Context, paymentID string, code PixQrCode) error {
+	var expiration any
+	if !code.ExpirationDate.IsZero() {
+		expiration = code.ExpirationDate
+	}
+	result, err := r.db.ExecContext(
+		ctx,
+		`UPDATE payment_payments SET pix_encoded_image=$1, pix_payload=$2, pix_expiration_date=$3, updated_at=$4 WHERE id=$5`,
+		code.EncodedImage,
+		code.Payload,
+		expiration,
+		time.Now().UTC(),
+		paymentID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// FindPixQrCode returns the PIX QR code stored for a payment. It returns
+// sql.ErrNoRows when the payment has none.
+func (r *PostgresRepository) FindPixQrCode(ctx context.Context, paymentID string) (PixQrCode, error) {
+	var code PixQrCode
+	var expiration sql.NullTime
+	err := r.db.QueryRowContext(ctx, `
+SELECT pix_encoded_image, pix_payload, pix_expiration_date
+FROM payment_payments
+WHERE id = $1 AND pix_payload <> ''
+`, paymentID).Scan(&code.EncodedImage, &code.Payload, &expiration)
+	if err != nil {
+		return PixQrCode{}, err
+	}
+	if expiration.Valid {
+		code.ExpirationDate = expiration.Time
+	}
+	return code, nil
+}
+
+// SaveBoleto stores the boleto identification of a payment.
+func (r *PostgresRepository) SaveBoleto(ctx context.Context, paymentID string, boleto Boleto) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`UPDATE payment_payments SET boleto_identification_field=$1, boleto_nosso_numero=$2, boleto_bar_code=$3, bank_slip_url=$4, updated_at=$5 WHERE id=$6`,
+		boleto.IdentificationField,
+		boleto.NossoNumero,
+		boleto.BarCode,
+		boleto.BankSlipURL,
+		time.Now().UTC(),
+		paymentID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// FindBoleto returns the boleto identification stored for a payment. It returns
+// sql.ErrNoRows when the payment has none.
+func (r *PostgresRepository) FindBoleto(ctx context.Context, paymentID string) (Boleto, error) {
+	var boleto Boleto
+	err := r.db.QueryRowContext(ctx, `
+SELECT boleto_identification_field, boleto_nosso_numero, boleto_bar_code, bank_slip_url
+FROM payment_payments
+WHERE id = $1 AND boleto_identification_field <> ''
+`, paymentID).Scan(&boleto.IdentificationField, &boleto.NossoNumero, &boleto.BarCode, &boleto.BankSlipURL)
+	if err != nil {
+		return Boleto{}, err
+	}
+	return boleto, nil
+}
+
+// SaveBankSlipPDF stores the boleto PDF of a payment.
+func (r *PostgresRepository) SaveBankSlipPDF(ctx context.Context, paymentID string, pdf []byte) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`UPDATE payment_payments SET bank_slip_pdf=$1, updated_at=$2 WHERE id=$3`,
+		pdf,
+		time.Now().UTC(),
+		paymentID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// FindBankSlipPDF returns the boleto PDF stored for a payment. It returns
+// sql.ErrNoRows when the payment has none.
+func (r *PostgresRepository) FindBankSlipPDF(ctx context.Context, paymentID string) ([]byte, error) {
+	var pdf []byte
+	err := r.db.QueryRowContext(ctx, `
+SELECT bank_slip_pdf
+FROM payment_payments
+WHERE id = $1 AND bank_slip_pdf IS NOT NULL
+`, paymentID).Scan(&pdf)
+	if err != nil {
+		return nil, err
+	}
+	return pdf, nil
+}
+
+// SaveRefund inserts a refund of a payment.
+func (r *PostgresRepository) SaveRefund(ctx context.Context, refund RefundRecord) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO payment_refunds (
+id,
+payment_id,
+value,
+description,
+status,
+transaction_receipt_url,
+asaas_date_created,
+created_at,
+updated_at
+)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+`,
+		refund.ID,
+		refund.PaymentID,
+		refund.Value,
+		refund.Description,
+		refund.Status,
+		refund.TransactionReceiptURL,
+		refund.AsaasDateCreated,
+		refund.CreatedAt,
+		refund.UpdatedAt,
+	)
+	return err
+}
+
+// ListRefundsByPaymentID returns the refunds of a payment, oldest first.
+func (r *PostgresRepository) ListRefundsByPaymentID(ctx context.Context, paymentID string) ([]RefundRecord, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, payment_id, value, description, status, transaction_receipt_url, asaas_date_created, created_at, updated_at
+FROM payment_refunds
+WHERE payment_id = $1
+ORDER BY created_at, id
+`, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []RefundRecord
+	for rows.Next() {
+		var refund RefundRecord
+		if err := rows.Scan(
+			&refund.ID,
+			&refund.PaymentID,
+			&refund.Value,
+			&refund.Description,
+			&refund.Status,
+			&refund.TransactionReceiptURL,
+			&refund.AsaasDateCreated,
+			&refund.CreatedAt,
+			&refund.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+	return refunds, rows.Err()
+}
+
+// UpdateRefund updates the status, receipt and Asaas date of a refund.
+func (r *PostgresRepository) UpdateRefund(ctx context.Context, refund RefundRecord) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE payment_refunds SET status=$1, transaction_receipt_url=$2, asaas_date_created=$3, updated_at=$4 WHERE id=$5`,
+		refund.Status, refund.TransactionReceiptURL, refund.AsaasDateCreated, time.Now().UTC(), refund.ID)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SaveSplit inserts a split of a payment or subscription.
+func (r *PostgresRepository) SaveSplit(ctx context.Context, split SplitRecord) error {
+	var paymentID, subscriptionID any
+	if split.PaymentID != "" {
+		paymentID = split.PaymentID
+	}
+	if split.SubscriptionID != "" {
+		subscriptionID = split.SubscriptionID
+	}
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO payment_splits (
+id,
+asaas_id,
+payment_id,
+subscription_id,
+wallet_id,
+fixed_value,
+percentual_value,
+total_fixed_value,
+total_value,
+status,
+cancellation_reason,
+created_at,
+updated_at
+)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+`,
+		split.ID,
+		split.AsaasID,
+		paymentID,
+		subscriptionID,
+		split.WalletID,
+		split.FixedValue,
+		split.PercentualValue,
+		split.TotalFixedValue,
+		split.TotalValue,
+		split.Status,
+		split.CancellationReason,
+		split.CreatedAt,
+		split.UpdatedAt,
+	)
+	return err
+}
+
+const splitColumns = `
+id,
+asaas_id,
+payment_id,
+subscription_id,
+wallet_id,
+fixed_value,
+percentual_value,
+total_fixed_value,
+total_value,
+status,
+cancellation_reason,
+created_at,
+updated_at
+`
+
+func scanSplit(row rowScanner) (SplitRecord, error) {
+	var split SplitRecord
+	var paymentID, subscriptionID sql.NullString
+	if err := row.Scan(
+		&split.ID,
+		&split.AsaasID,
+		&paymentID,
+		&subscriptionID,
+		&split.WalletID,
+		&split.FixedValue,
+		&split.PercentualValue,
+		&split.TotalFixedValue,
+		&split.TotalValue,
+		&split.Status,
+		&split.CancellationReason,
+		&split.CreatedAt,
+		&split.UpdatedAt,
+	); err != nil {
+		return SplitRecord{}, err
+	}
+	split.PaymentID = paymentID.String
+	split.SubscriptionID = subscriptionID.String
+	return split, nil
+}
+
+// ListSplitsByPaymentID returns the splits of a payment, oldest first.
+func (r *PostgresRepository) ListSplitsByPaymentID(ctx context.Context, paymentID string) ([]SplitRecord, error) {
+	return r.listSplits(ctx, `payment_id`, paymentID)
+}
+
+// ListSplitsBySubscriptionID returns the splits of a subscription, oldest first.
+func (r *PostgresRepository) ListSplitsBySubscriptionID(ctx context.Context, subscriptionID string) ([]SplitRecord, error) {
+	return r.listSplits(ctx, `subscription_id`, subscriptionID)
+}
+
+func (r *PostgresRepository) listSplits(ctx context.Context, column, id string) ([]SplitRecord, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT`+splitColumns+`FROM payment_splits
+WHERE `+column+` = $1
+ORDER BY created_at, id
+`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var splits []SplitRecord
+	for rows.Next() {
+		split, err := scanSplit(rows)
+		if err != nil {
+			return nil, err
+		}
+		splits = append(splits, split)
+	}
+	return splits, rows.Err()
+}
+
+// UpdateSplit stores the Asaas ID, total value, status and cancellation reason of a split.
+func (r *PostgresRepository) UpdateSplit(ctx context.Context, split SplitRecord) error {
+	result, err := r.db.ExecContext(ctx, `
+UPDATE payment_splits
+SET asaas_id=$1, total_value=$2, status=$3, cancellation_reason=$4, updated_at=$5
+WHERE id=$6
+`, split.AsaasID, split.TotalValue, split.Status, split.CancellationReason, time.Now().UTC(), split.ID)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SaveWebhookEvent journals a webhook delivery. When the Asaas event ID was already
+// journaled it returns the existing row and false.
+func (r *PostgresRepository) SaveWebhookEvent(ctx context.Context, event WebhookEventRecord) (WebhookEventRecord, bool, error) {
+	result, err := r.db.ExecContext(ctx, `
+INSERT INTO payment_webhook_events (
+id,
+event_id,
+event_type,
+payload,
+status,
+error,
+attempts,
+next_attempt_at,
+received_at,
+updated_at
+)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+ON CONFLICT (event_id) DO NOTHING
+`,
+		event.ID,
+		event.EventID,
+		event.EventType,
+		event.Payload,
+		event.Status,
+		event.Error,
+		event.Attempts,
+		event.NextAttemptAt,
+		event.ReceivedAt,
+		event.UpdatedAt,
+	)
+	if err != nil {
+		return WebhookEventRecord{}, false, err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 1 {
+		return event, true, nil
+	}
+	existing, err := r.FindWebhookEventByEventID(ctx, event.EventID)
+	if err != nil {
+		return WebhookEventRecord{}, false, err
+	}
+	return existing, false, nil
+}
+
+// FindWebhookEventByEventID returns a journaled event by its Asaas event ID.
+func (r *PostgresRepository) FindWebhookEventByEventID(ctx context.Context, eventID string) (WebhookEventRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT`+webhookEventColumns+`FROM payment_webhook_events
+WHERE event_id = $1
+`, eventID)
+	return scanWebhookEvent(row)
+}
+
+// ListWebhookEvents returns events received in the [from, to) interval, oldest first.
+func (r *PostgresRepository) ListWebhookEvents(ctx context.Context, from, to time.Time) ([]WebhookEventRecord, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT`+webhookEventColumns+`FROM payment_webhook_events
+WHERE received_at >= $1 AND received_at < $2
+ORDER BY received_at
+`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []WebhookEventRecord
+	for rows.Next() {
+		event, err := scanWebhookEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// UpdateWebhookEventStatus records the outcome of processing a journaled event and releases its lock.
+// A non-zero nextAttemptAt schedules the next queue attempt.
+func (r *PostgresRepository) UpdateWebhookEventStatus(ctx context.Context, id, status, message string, nextAttemptAt time.Time) error {
+	now := time.Now().UTC()
+	var processedAt, nextAttempt any
+	if status == WebhookEventStatusProcessed {
+		processedAt = now
+	}
+	if !nextAttemptAt.IsZero() {
+		nextAttempt = nextAttemptAt
+	}
+	result, err := r.db.ExecContext(
+		ctx,
+		`UPDATE payment_webhook_events SET status=$1, error=$2, attempts=attempts+1, processed_at=COALESCE($3::timestamptz, processed_at), next_attempt_at=COALESCE($4::timestamptz, next_attempt_at), locked_at=NULL, updated_at=$5 WHERE id=$6`,
+		status,
+		message,
+		processedAt,
+		nextAttempt,
+		now,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, rowsErr := result.RowsAffected(); rowsErr == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ClaimWebhookEvent locks the next event due for processing. Events stuck in PROCESSING
+// since before staleBefore are claimed again, and the attempt that left them there
+// is counted. It returns false when the queue is empty.
+func (r *PostgresRepository) ClaimWebhookEvent(ctx context.Context, staleBefore time.Time) (WebhookEventRecord, bool, error) {
+	now := time.Now().UTC()
+	row := r.db.QueryRowContext(ctx, `
+UPDATE payment_webhook_events SET status=$1, locked_at=$2, updated_at=$2,
+attempts = attempts + CASE WHEN status = $1 THEN 1 ELSE 0 END
+WHERE id = (
+SELECT id FROM payment_webhook_events
+WHERE (status IN ($3, $4) AND next_attempt_at <= $2)
+OR (status = $1 AND locked_at < $5)
+ORDER BY next_attempt_at
+LIMIT 1
+FOR UPDATE SKIP LOCKED
+)
+RETURNING`+webhookEventColumns,
+		WebhookEventStatusProcessing,
+		now,
+		WebhookEventStatusReceived,
+		WebhookEventStatusFailed,
+		staleBefore,
+	)
+	event, err := scanWebhookEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WebhookEventRecord{}, false, nil
+	}
+	if err != nil {
+		return WebhookEventRecord{}, false, err
+	}
+	return event, true, nil
+}
+
+// ClaimWebhookEventByID locks an event for processing whatever its status, unless it
+// is in PROCESSING since staleBefore or later. Reclaimed events count the attempt that
+// left them in PROCESSING. It returns false when the event is locked by someone else.
+func (r *PostgresRepository) ClaimWebhookEventByID(ctx context.Context, id string, staleBefore time.Time) (WebhookEventRecord, bool, error) {
+	now := time.Now().UTC()
+	row := r.db.QueryRowContext(ctx, `
+UPDATE payment_webhook_events SET status=$1, locked_at=$2, updated_at=$2,
+attempts = attempts + CASE WHEN status = $1 THEN 1 ELSE 0 END
+WHERE id=$3 AND (status <> $1 OR locked_at < $4)
+RETURNING`+webhookEventColumns,
+		WebhookEventStatusProcessing,
+		now,
+		id,
+		staleBefore,
+	)
+	event, err := scanWebhookEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WebhookEventRecord{}, false, nil
+	}
+	if err != nil {
+		return WebhookEventRecord{}, false, err
+	}
+	return event, true, nil
+}
+
+// listQuery builds the WHERE clause of keyset-paginated list queries.
+type listQuery struct {
+	conditions []string
+	args       []any
+	err        error
+}
+
+// where adds a condition whose single placeholder is written as ?.
+func (q *listQuery) where(condition string, arg any) {
+	q.args = append(q.args, arg)
+	q.conditions = append(q.conditions, strings.Replace(condition, "?", fmt.Sprintf("$%d", len(q.args)), 1))
+}
+
+// whereID adds a condition on a UUID column, rejecting values Postgres could not cast.
+func (q *listQuery) whereID(condition, name, id string) {
+	if !isUUID(id) {
+		q.err = errors.Join(q.err, fmt.Errorf("%w: %s %q", ErrInvalidListFilter, name, id))
+		return
+	}
+	q.where(condition, id)
+}
+
+func (q *listQuery) whereRange(column string, from, to time.Time) {
+	if !from.IsZero() {
+		q.where(column+" >= ?", from)
+	}
+	if !to.IsZero() {
+		q.where(column+" < ?", to)
+	}
+}
+
+// sql returns the statement listing newest rows first, fetching one extra row to detect a next page.
+func (q *listQuery) sql(columns, table string, filter ListFilter) (string, []any, error) {
+	if q.err != nil {
+		return "", nil, q.err
+	}
+	if createdAt, id, ok, err := filter.after(); err != nil {
+		return "", nil, err
+	} else if ok && !isUUID(id) {
+		return "", nil, ErrInvalidCursor
+	} else if ok {
+		q.args = append(q.args, createdAt, id)
+		q.conditions = append(q.conditions, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(q.args)-1, len(q.args)))
+	}
+	stmt := `SELECT` + columns + `FROM ` + table
+	if len(q.conditions) > 0 {
+		stmt += "\nWHERE " + strings.Join(q.conditions, "\nAND ")
+	}
+	q.args = append(q.args, filter.pageSize()+1)
+	stmt += fmt.Sprintf("\nORDER BY created_at DESC, id DESC\nLIMIT $%d", len(q.args))
+	return stmt, q.args, nil
+}
+
+func queryPage[T any](ctx context.Context, db *sql.DB, q *listQuery, columns, table string, filter ListFilter, scan func(rowScanner) (T, error), key func(T) (time.Time, string)) (Page[T], error) {
+	stmt, args, err := q.sql(columns, table, filter)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	defer rows.Close()
+
+	var items []T
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return Page[T]{}, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[T]{}, err
+	}
+	return newPage(items, filter.pageSize(), key), nil
+}
+
+// ListCustomers returns a page of customers, newest first.
+func (r *PostgresRepository) ListCustomers(ctx context.Context, filter ListFilter) (Page[CustomerRecord], error) {
+	var q listQuery
+	if !filter.IncludeDeleted {
+		q.conditions = append(q.conditions, "deleted_at IS NULL")
+	}
+	q.whereRange("created_at", filter.CreatedFrom, filter.CreatedTo)
+	return queryPage(ctx, r.db, &q, customerColumns, "payment_customers", filter, scanCustomer, func(c CustomerRecord) (time.Time, string) {
+		return c.CreatedAt, c.ID
+	})
+}
+
+// ListPayments returns a page of payments, newest first.
+func (r *PostgresRepository) ListPayments(ctx context.Context, filter ListFilter) (Page[PaymentRecord], error) {
+	var q listQuery
+	if filter.Status != "" {
+		q.where("status = ?", filter.Status)
+	}
+	if filter.CustomerID != "" {
+		q.whereID("customer_id = ?", "customer", filter.CustomerID)
+	}
+	if filter.SubscriptionID != "" {
+		q.whereID("subscription_id = ?", "subscription", filter.SubscriptionID)
+	}
+	if filter.BillingType != "" {
+		q.where("billing_type = ?", filter.BillingType)
+	}
+	q.whereRange("due_date", filter.DueDateFrom, filter.DueDateTo)
+	q.whereRange("created_at", filter.CreatedFrom, filter.CreatedTo)
+	return queryPage(ctx, r.db, &q, paymentColumns, "payment_payments", filter, scanPayment, func(p PaymentRecord) (time.Time, string) {
+		return p.CreatedAt, p.ID
+	})
+}
+
+// ListSubscriptions returns a page of subscriptions, newest first.
+func (r *PostgresRepository) ListSubscriptions(ctx context.Context, filter ListFilter) (Page[SubscriptionRecord], error) {
+	var q listQuery
+	if filter.Status != "" {
+		q.where("status = ?", filter.Status)
+	}
+	if filter.CustomerID != "" {
+		q.whereID("customer_id = ?", "customer", filter.CustomerID)
+	}
+	if filter.BillingType != "" {
+		q.where("billing_type = ?", filter.BillingType)
+	}
+	q.whereRange("next_due_date", filter.DueDateFrom, filter.DueDateTo)
+	q.whereRange("created_at", filter.CreatedFrom, filter.CreatedTo)
+	return queryPage(ctx, r.db, &q, subscriptionColumns, "payment_subscriptions", filter, scanSubscription, func(s SubscriptionRecord) (time.Time, string) {
+		return s.CreatedAt, s.ID
+	})
+}
+
+// ListInvoices returns a page of invoices, newest first. Customer and subscription
+// filters apply to the invoiced payment.
+func (r *PostgresRepository) ListInvoices(ctx context.Context, filter ListFilter) (Page[InvoiceRecord], error) {
+	var q listQuery
+	if filter.Status != "" {
+		q.where("status = ?", filter.Status)
+	}
+	if filter.CustomerID != "" {
+		q.whereID("payment_id IN (SELECT id FROM payment_payments WHERE customer_id = ?)", "customer", filter.CustomerID)
+	}
+	if filter.SubscriptionID != "" {
+		q.whereID("payment_id IN (SELECT id FROM payment_payments WHERE subscription_id = ?)", "subscription", filter.SubscriptionID)
+	}
+	q.whereRange("created_at", filter.CreatedFrom, filter.CreatedTo)
+	return queryPage(ctx, r.db, &q, invoiceColumns, "payment_invoices", filter, scanInvoice, func(i InvoiceRecord) (time.Time, string) {
+		return i.CreatedAt, i.ID
+	})
+}
+
+// isUUID reports whether s is a UUID in its canonical hyphenated form.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if c != '-' {
+				return false
+			}
+		case '0' <= c && c <= '9', 'a' <= c && c <= 'f', 'A' <= c && c <= 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
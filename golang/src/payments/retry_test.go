@@ -0,0 +1,199 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsIdempotentRequest(t *testing.T) {
+	marked := WithIdempotentRequest(context.Background())
+	tests := []struct {
+		method string
+		ctx    context.Context
+		want   bool
+	}{
+		{http.MethodGet, context.Background(), true},
+		{http.MethodHead, context.Background(), true},
+		{http.MethodOptions, context.Background(), true},
+		{http.MethodPut, context.Background(), true},
+		{http.MethodDelete, context.Background(), true},
+		{http.MethodPost, context.Background(), false},
+		{http.MethodPatch, context.Background(), false},
+		{http.MethodPost, marked, true},
+		{http.MethodPatch, marked, true},
+	}
+	for _, tt := range tests {
+		name := tt.method
+		if tt.ctx == marked {
+			name += " marked"
+		}
+		t.Run(name, func(t *testing.T) {
+			if got := isIdempotentRequest(tt.ctx, tt.method); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for retry, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 50: time.Second} {
+		if got := policy.backoff(retry); got != want {
+			t.Fatalf("retry %d: expected %s, got %s", retry, want, got)
+		}
+	}
+
+	policy.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(3); got < 320*time.Millisecond || got > 480*time.Millisecond {
+			t.Fatalf("retry 3 with jitter: %s outside 320ms-480ms", got)
+		}
+		if got := policy.backoff(20); got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("capped retry with jitter: %s outside 800ms-1.2s", got)
+		}
+	}
+
+	if got := (RetryPolicy{MaxDelay: time.Second}).backoff(3); got != 0 {
+		t.Fatalf("expected no delay without BaseDelay, got %s", got)
+	}
+	if got := (RetryPolicy{MaxAttempts: -1}).attempts(); got != 1 {
+		t.Fatalf("expected a single attempt, got %d", got)
+	}
+}
+
+func TestRetryPolicyRetryableError(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"connection refused", fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{"timeout", timeoutError{}, true},
+		{"canceled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"other", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.retryableError(tt.err); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+	policy.RetryNetworkErrors = false
+	if policy.retryableError(timeoutError{}) {
+		t.Fatal("network errors retried with RetryNetworkErrors off")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"-1", 0, false},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Fatalf("%q: expected %s %v, got %s %v", tt.value, tt.want, tt.wantOK, got, ok)
+		}
+	}
+}
+
+func TestClientRetriesOnlyIdempotentRequests(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if calls.Add(1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	client := NewAsaasClient(Config{APIURL: server.URL, Retry: RetryPolicy{MaxAttempts: 3, RetryableStatusCodes: []int{http.StatusServiceUnavailable}}})
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		method    string
+		wantCalls int32
+		wantErr   bool
+	}{
+		{"GET is retried", context.Background(), http.MethodGet, 2, false},
+		{"POST is not retried", context.Background(), http.MethodPost, 1, true},
+		{"marked POST is retried", WithIdempotentRequest(context.Background()), http.MethodPost, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			err := client.doRequest(tt.ctx, tt.method, "payments", nil, &struct{}{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("expected %d calls, got %d", tt.wantCalls, got)
+			}
+			var asaasErr *AsaasError
+			if tt.wantErr && (!errors.As(err, &asaasErr) || asaasErr.Attempts != 1) {
+				t.Fatalf("expected AsaasError after 1 attempt, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteTracking(t *testing.T) {
+	var status atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+	client := NewAsaasClient(Config{APIURL: server.URL})
+
+	ctx, remoteWrite := WithRemoteWriteTracking(context.Background())
+	status.Store(http.StatusBadGateway)
+	_ = client.doRequest(ctx, http.MethodGet, "payments", nil, &struct{}{})
+	if remoteWrite() {
+		t.Fatal("GET counted as a remote write")
+	}
+	// Asaas changed nothing when it rejected the request.
+	status.Store(http.StatusBadRequest)
+	if err := client.doRequest(ctx, http.MethodPost, "payments", nil, &struct{}{}); err == nil {
+		t.Fatal("expected the POST to fail")
+	}
+	if remoteWrite() {
+		t.Fatal("POST rejected with 400 counted as a remote write")
+	}
+	status.Store(http.StatusBadGateway)
+	// A failed write may still have been applied by Asaas.
+	if err := client.doRequest(ctx, http.MethodPost, "payments", nil, &struct{}{}); err == nil {
+		t.Fatal("expected the POST to fail")
+	}
+	if !remoteWrite() {
+		t.Fatal("failed POST not counted as a remote write")
+	}
+	// Requests without tracking are unaffected.
+	_ = client.doRequest(context.Background(), http.MethodPost, "payments", nil, &struct{}{})
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
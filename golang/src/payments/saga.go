@@ -0,0 +1,353 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Kinds of remote objects tracked by pending operations.
+const (
+	PendingOperationCustomer     = "CUSTOMER"
+	PendingOperationPayment      = "PAYMENT"
+	PendingOperationSubscription = "SUBSCRIPTION"
+	PendingOperationInvoice      = "INVOICE"
+)
+
+// Pending operation statuses.
+const (
+	// PendingOperationStatusPending means the remote create may exist without a local row.
+	PendingOperationStatusPending = "PENDING"
+	// PendingOperationStatusConfirmed means the local row was saved and matches the remote object.
+	PendingOperationStatusConfirmed = "CONFIRMED"
+	// PendingOperationStatusCompensated means the orphan remote object was removed from Asaas.
+	PendingOperationStatusCompensated = "COMPENSATED"
+	// PendingOperationStatusAbandoned means the remote object was never created.
+	PendingOperationStatusAbandoned = "ABANDONED"
+)
+
+// ErrOperationInProgress is returned for a webhook about a record this service is
+// still creating, so the event is retried after the local row is saved.
+var ErrOperationInProgress = errors.New("criação local em andamento")
+
+// RecoveryResult summarizes a run of RecoverPendingOperations.
+type RecoveryResult struct {
+	Confirmed   int
+	Linked      int
+	Compensated int
+	Abandoned   int
+	Failed      int
+}
+
+// beginPendingOperation records the intent to create a remote object before calling Asaas.
+func (s *Service) beginPendingOperation(ctx context.Context, kind, localID string, record any) (PendingOperation, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return PendingOperation{}, fmt.Errorf("falha ao serializar operação pendente: %w", err)
+	}
+	now := time.Now().UTC()
+	op := PendingOperation{
+		ID:        generateID(),
+		Kind:      kind,
+		LocalID:   localID,
+		Status:    PendingOperationStatusPending,
+		Record:    data,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.repo.SavePendingOperation(ctx, op); err != nil {
+		return PendingOperation{}, fmt.Errorf("falha ao registrar operação pendente: %w", err)
+	}
+	return op, nil
+}
+
+// linkPendingOperation stores the Asaas ID and the final local record so recovery can finish the save.
+// Bookkeeping errors are ignored: recovery resolves the remote object by externalReference anyway.
+func (s *Service) linkPendingOperation(ctx context.Context, op *PendingOperation, asaasID string, record any) {
+	op.AsaasID = asaasID
+	if data, err := json.Marshal(record); err == nil {
+		op.Record = data
+	}
+	_ = s.repo.UpdatePendingOperation(context.WithoutCancel(ctx), *op)
+}
+
+// failPendingOperation records why a step failed. Requests rejected by Asaas did not create
+// anything, so they are closed; any other failure is left pending for recovery.
+func (s *Service) failPendingOperation(ctx context.Context, op PendingOperation, cause error) {
+	op.Error = cause.Error()
+	var asaasErr *AsaasError
+	if op.AsaasID == "" && errors.As(cause, &asaasErr) && asaasErr.StatusCode >= 400 && asaasErr.StatusCode < 500 &&
+		asaasErr.StatusCode != http.StatusRequestTimeout && asaasErr.StatusCode != http.StatusTooManyRequests {
+		op.Status = PendingOperationStatusAbandoned
+	}
+	_ = s.repo.UpdatePendingOperation(context.WithoutCancel(ctx), op)
+}
+
+// checkPendingOperation returns ErrOperationInProgress when localID belongs to an
+// operation still pending, so an event racing the create is not imported under a new
+// local ID.
+func (s *Service) checkPendingOperation(ctx context.Context, localID string) error {
+	if !isUUID(localID) {
+		return nil
+	}
+	op, err := s.repo.FindPendingOperationByLocalID(ctx, localID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("falha ao buscar operação pendente de %s: %w", localID, err)
+	}
+	if op.Status == PendingOperationStatusPending {
+		return fmt.Errorf("%w: %s %s", ErrOperationInProgress, op.Kind, localID)
+	}
+	return nil
+}
+
+// confirmPendingOperation closes an operation whose local row was saved.
+func (s *Service) confirmPendingOperation(ctx context.Context, op PendingOperation) {
+	op.Status = PendingOperationStatusConfirmed
+	op.Error = ""
+	_ = s.repo.UpdatePendingOperation(context.WithoutCancel(ctx), op)
+}
+
+// RecoverPendingOperations finishes or compensates operations left pending for longer than olderThan.
+// When the remote object exists but no local row has its local or Asaas ID, the stored record is
+// saved again; if that fails with an error that will keep failing, the orphan is removed from Asaas.
+// Other save errors leave the operation pending for the next run.
+func (s *Service) RecoverPendingOperations(ctx context.Context, olderThan time.Duration) (RecoveryResult, error) {
+	var result RecoveryResult
+	ops, err := s.repo.ListPendingOperations(ctx, time.Now().UTC().Add(-olderThan), 100)
+	if err != nil {
+		return result, fmt.Errorf("falha ao listar operações pendentes: %w", err)
+	}
+
+	var errs []error
+	for _, op := range ops {
+		status, linked, err := s.recoverPendingOperation(ctx, op)
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			op.Attempts++
+			op.Error = err.Error()
+			if updateErr := s.repo.UpdatePendingOperation(ctx, op); updateErr != nil {
+				err = errors.Join(err, updateErr)
+			}
+			result.Failed++
+			errs = append(errs, fmt.Errorf("operação %s (%s %s): %w", op.ID, op.Kind, op.LocalID, err))
+			continue
+		}
+		switch {
+		case status == PendingOperationStatusConfirmed && linked:
+			result.Linked++
+		case status == PendingOperationStatusConfirmed:
+			result.Confirmed++
+		case status == PendingOperationStatusCompensated:
+			result.Compensated++
+		case status == PendingOperationStatusAbandoned:
+			result.Abandoned++
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+func (s *Service) recoverPendingOperation(ctx context.Context, op PendingOperation) (string, bool, error) {
+	exists, err := s.pendingRecordExists(ctx, op, false)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		return s.closePendingOperation(ctx, op, PendingOperationStatusConfirmed, "", false)
+	}
+
+	if op.AsaasID == "" {
+		asaasID, err := s.lookupPendingRemoteID(ctx, op)
+		if errors.Is(err, ErrNotFound) {
+			return s.closePendingOperation(ctx, op, PendingOperationStatusAbandoned, op.Error, false)
+		}
+		if err != nil {
+			return "", false, err
+		}
+		op.AsaasID = asaasID
+	}
+
+	// A webhook or sync may have stored the remote object under another local ID.
+	exists, err = s.pendingRecordExists(ctx, op, true)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		return s.closePendingOperation(ctx, op, PendingOperationStatusConfirmed, "", true)
+	}
+
+	saveErr := s.savePendingRecord(ctx, op)
+	if saveErr == nil {
+		return s.closePendingOperation(ctx, op, PendingOperationStatusConfirmed, "", true)
+	}
+	if !permanentSaveError(saveErr) {
+		return "", false, fmt.Errorf("falha ao salvar registro local: %w", saveErr)
+	}
+
+	if err := s.compensatePendingOperation(ctx, op); err != nil {
+		return "", false, errors.Join(saveErr, err)
+	}
+	return s.closePendingOperation(ctx, op, PendingOperationStatusCompensated, saveErr.Error(), false)
+}
+
+func (s *Service) closePendingOperation(ctx context.Context, op PendingOperation, status, message string, linked bool) (string, bool, error) {
+	op.Status = status
+	op.Error = message
+	if err := s.repo.UpdatePendingOperation(ctx, op); err != nil {
+		return "", false, err
+	}
+	return status, linked, nil
+}
+
+// pendingRecordExists looks up the local row of an operation by its local ID, or by its
+// Asaas ID when byAsaasID is set.
+func (s *Service) pendingRecordExists(ctx context.Context, op PendingOperation, byAsaasID bool) (bool, error) {
+	var err error
+	switch {
+	case op.Kind == PendingOperationCustomer && byAsaasID:
+		_, err = s.repo.FindCustomerByAsaasID(ctx, op.AsaasID)
+	case op.Kind == PendingOperationCustomer:
+		_, err = s.repo.FindCustomerByID(ctx, op.LocalID)
+	case op.Kind == PendingOperationPayment && byAsaasID:
+		_, err = s.repo.FindPaymentByAsaasID(ctx, op.AsaasID)
+	case op.Kind == PendingOperationPayment:
+		_, err = s.repo.FindPaymentByID(ctx, op.LocalID)
+	case op.Kind == PendingOperationSubscription && byAsaasID:
+		_, err = s.repo.FindSubscriptionByAsaasID(ctx, op.AsaasID)
+	case op.Kind == PendingOperationSubscription:
+		_, err = s.repo.FindSubscriptionByID(ctx, op.LocalID)
+	case op.Kind == PendingOperationInvoice && byAsaasID:
+		_, err = s.repo.FindInvoiceByAsaasID(ctx, op.AsaasID)
+	case op.Kind == PendingOperationInvoice:
+		_, err = s.repo.FindInvoiceByID(ctx, op.LocalID)
+	default:
+		return false, fmt.Errorf("tipo de operação pendente desconhecido: %s", op.Kind)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Service) lookupPendingRemoteID(ctx context.Context, op PendingOperation) (string, error) {
+	switch op.Kind {
+	case PendingOperationCustomer:
+		remote, err := s.client.GetCustomer(ctx, op.LocalID)
+		return remote.ID, err
+	case PendingOperationPayment:
+		remote, err := s.client.GetPayment(ctx, op.LocalID)
+		return remote.ID, err
+	case PendingOperationSubscription:
+		remote, err := s.client.GetSubscription(ctx, op.LocalID)
+		return remote.ID, err
+	case PendingOperationInvoice:
+		remote, err := s.client.GetInvoice(ctx, op.LocalID)
+		return remote.ID, err
+	default:
+		return "", fmt.Errorf("tipo de operação pendente desconhecido: %s", op.Kind)
+	}
+}
+
+func (s *Service) savePendingRecord(ctx context.Context, op PendingOperation) error {
+	switch op.Kind {
+	case PendingOperationCustomer:
+		var record CustomerRecord
+		if err := json.Unmarshal(op.Record, &record); err != nil {
+			return err
+		}
+		record.AsaasID = op.AsaasID
+		return s.repo.SaveCustomer(ctx, record)
+	case PendingOperationPayment:
+		var record pendingPayment
+		if err := json.Unmarshal(op.Record, &record); err != nil {
+			return err
+		}
+		record.AsaasID = op.AsaasID
+		if err := s.savePendingPayment(ctx, record); err != nil {
+			return err
+		}
+		if record.Installment != nil {
+			s.syncNewInstallment(ctx, *record.Installment)
+		}
+		return nil
+	case PendingOperationSubscription:
+		var record SubscriptionRecord
+		if err := json.Unmarshal(op.Record, &record); err != nil {
+			return err
+		}
+		record.AsaasID = op.AsaasID
+		return s.repo.SaveSubscription(ctx, record)
+	case PendingOperationInvoice:
+		var record InvoiceRecord
+		if err := json.Unmarshal(op.Record, &record); err != nil {
+			return err
+		}
+		record.AsaasID = op.AsaasID
+		return s.repo.SaveInvoice(ctx, record)
+	default:
+		return fmt.Errorf("tipo de operação pendente desconhecido: %s", op.Kind)
+	}
+}
+
+// permanentSaveError reports whether saving a recovered record will keep failing: a
+// constraint violation or invalid data. Anything else, such as a lost connection,
+// may succeed on the next run.
+func permanentSaveError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		class := pqErr.Code.Class()
+		return class == "22" || class == "23"
+	}
+	var constraintErr *constraintError
+	return errors.As(err, &constraintErr)
+}
+
+// pendingPayment is the record of a payment operation. Installment is set when the
+// payment is the first one of a plan, which is saved together with it.
+type pendingPayment struct {
+	PaymentRecord
+	Installment *InstallmentRecord `json:"installment,omitempty"`
+}
+
+// savePendingPayment saves a payment and, for the first payment of a plan, the plan
+// in the same transaction.
+func (s *Service) savePendingPayment(ctx context.Context, record pendingPayment) error {
+	if record.Installment == nil {
+		return s.repo.SavePayment(ctx, record.PaymentRecord)
+	}
+	return s.repo.SaveInstallmentPayment(ctx, *record.Installment, record.PaymentRecord)
+}
+
+func (s *Service) compensatePendingOperation(ctx context.Context, op PendingOperation) error {
+	var err error
+	switch op.Kind {
+	case PendingOperationCustomer:
+		err = s.client.DeleteCustomer(ctx, op.AsaasID)
+	case PendingOperationPayment:
+		err = s.client.DeletePayment(ctx, op.AsaasID)
+	case PendingOperationSubscription:
+		_, err = s.client.CancelSubscriptionByID(ctx, op.AsaasID)
+	case PendingOperationInvoice:
+		_, err = s.client.CancelInvoice(ctx, op.AsaasID)
+	default:
+		return fmt.Errorf("tipo de operação pendente desconhecido: %s", op.Kind)
+	}
+	var asaasErr *AsaasError
+	if errors.As(err, &asaasErr) && asaasErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("falha ao compensar %s %s no Asaas: %w", op.Kind, op.AsaasID, err)
+	}
+	return nil
+}
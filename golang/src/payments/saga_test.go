@@ -0,0 +1,144 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingSaveRepository fails every payment save with a connection error.
+type failingSaveRepository struct {
+	*MemoryRepository
+}
+
+func (r failingSaveRepository) SavePayment(ctx context.Context, payment PaymentRecord) error {
+	return errors.New("conexão encerrada")
+}
+
+func TestRecoverPendingOperations(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name        string
+		asaasID     string
+		customerID  string
+		seed        func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway, localID string)
+		failSave    bool
+		want        RecoveryResult
+		wantStatus  string
+		wantDeleted bool
+	}{
+		{
+			name:       "local row saved",
+			asaasID:    "pay_1",
+			customerID: "cust-1",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway, localID string) {
+				payment := PaymentRecord{ID: localID, AsaasID: "pay_1", CustomerID: "cust-1", CreatedAt: now, UpdatedAt: now}
+				if err := repo.SavePayment(context.Background(), payment); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:       RecoveryResult{Confirmed: 1},
+			wantStatus: PendingOperationStatusConfirmed,
+		},
+		{
+			name:       "imported under another local ID",
+			asaasID:    "pay_1",
+			customerID: "cust-1",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway, localID string) {
+				payment := PaymentRecord{ID: generateID(), AsaasID: "pay_1", CustomerID: "cust-1", CreatedAt: now, UpdatedAt: now}
+				if err := repo.SavePayment(context.Background(), payment); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:       RecoveryResult{Linked: 1},
+			wantStatus: PendingOperationStatusConfirmed,
+		},
+		{
+			name:       "record saved again",
+			asaasID:    "pay_1",
+			customerID: "cust-1",
+			want:       RecoveryResult{Linked: 1},
+			wantStatus: PendingOperationStatusConfirmed,
+		},
+		{
+			name:       "remote ID resolved by externalReference",
+			customerID: "cust-1",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway, localID string) {
+				gateway.payments[localID] = PaymentResponse{ID: "pay_1", ExternalReference: localID}
+			},
+			want:       RecoveryResult{Linked: 1},
+			wantStatus: PendingOperationStatusConfirmed,
+		},
+		{
+			name:        "record rejected by a constraint",
+			asaasID:     "pay_1",
+			customerID:  "missing",
+			want:        RecoveryResult{Compensated: 1},
+			wantStatus:  PendingOperationStatusCompensated,
+			wantDeleted: true,
+		},
+		{
+			name:       "save fails with a connection error",
+			asaasID:    "pay_1",
+			customerID: "cust-1",
+			failSave:   true,
+			want:       RecoveryResult{Failed: 1},
+			wantStatus: PendingOperationStatusPending,
+		},
+		{
+			name:       "remote object never created",
+			customerID: "cust-1",
+			want:       RecoveryResult{Abandoned: 1},
+			wantStatus: PendingOperationStatusAbandoned,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			memory := NewMemoryRepository()
+			gateway := newFakeGateway()
+			seedCustomer(t, memory)
+			localID := generateID()
+			if tt.seed != nil {
+				tt.seed(t, memory, gateway, localID)
+			}
+			var repo Repository = memory
+			if tt.failSave {
+				repo = failingSaveRepository{memory}
+			}
+			service := NewService(repo, gateway)
+
+			record, _ := json.Marshal(pendingPayment{PaymentRecord: PaymentRecord{ID: localID, CustomerID: tt.customerID, CreatedAt: now, UpdatedAt: now}})
+			op := PendingOperation{ID: generateID(), Kind: PendingOperationPayment, LocalID: localID, AsaasID: tt.asaasID, Status: PendingOperationStatusPending, Record: record, CreatedAt: now, UpdatedAt: now}
+			if err := memory.SavePendingOperation(ctx, op); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := service.RecoverPendingOperations(ctx, -time.Minute)
+			if (err != nil) != (tt.want.Failed > 0) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != tt.want {
+				t.Fatalf("expected %+v, got %+v", tt.want, result)
+			}
+			stored, err := memory.FindPendingOperationByLocalID(ctx, localID)
+			if err != nil || stored.Status != tt.wantStatus {
+				t.Fatalf("expected status %s, got %+v %v", tt.wantStatus, stored, err)
+			}
+			if deleted := len(gateway.deletedPayments) > 0; deleted != tt.wantDeleted {
+				t.Fatalf("expected deleted %v, got %v", tt.wantDeleted, gateway.deletedPayments)
+			}
+		})
+	}
+}
+
+func TestPermanentSaveError(t *testing.T) {
+	if !permanentSaveError(errDuplicateKey("payment_payments", "pay-1")) {
+		t.Fatal("constraint violation reported as transient")
+	}
+	if permanentSaveError(errors.New("conexão encerrada")) || permanentSaveError(context.DeadlineExceeded) {
+		t.Fatal("transient error reported as permanent")
+	}
+}
@@ -12,13 +12,21 @@ import (
 
 // Service orchestrates local persistence and remote Asaas calls.
 type Service struct {
-	repo   *PostgresRepository
-	client *AsaasClient
+	repo           Repository
+	client         Gateway
+	webhookQueue   WebhookQueueConfig
+	webhooks       *webhookRegistry
+	chargeDefaults ChargeDefaults
 }
 
 // NewService creates a payment service.
-func NewService(repo *PostgresRepository, client *AsaasClient) *Service {
-	return &Service{repo: repo, client: client}
+func NewService(repo Repository, client Gateway) *Service {
+	return &Service{
+		repo:         repo,
+		client:       client,
+		webhookQueue: DefaultWebhookQueueConfig(),
+		webhooks:     newWebhookRegistry(),
+	}
 }
 
 // RegisterCustomer stores a local customer and creates it in Asaas.
@@ -43,37 +51,55 @@ func (s *Service) RegisterCustomer(ctx context.Context, req CustomerRequest) (Cu
 	}
 	req.ExternalID = local.ID
 
+	op, err := s.beginPendingOperation(ctx, PendingOperationCustomer, local.ID, local)
+	if err != nil {
+		return CustomerRecord{}, CustomerResponse{}, err
+	}
+
 	remote, err := s.client.CreateCustomer(ctx, req)
 	if err != nil {
+		s.failPendingOperation(ctx, op, err)
 		return CustomerRecord{}, CustomerResponse{}, fmt.Errorf("falha ao criar cliente no Asaas: %w", err)
 	}
+	local.AsaasID = remote.ID
+	s.linkPendingOperation(ctx, &op, remote.ID, local)
 
 	if err := s.repo.SaveCustomer(ctx, local); err != nil {
+		s.failPendingOperation(ctx, op, err)
 		return CustomerRecord{}, CustomerResponse{}, fmt.Errorf("falha ao salvar cliente local: %w", err)
 	}
+	s.confirmPendingOperation(ctx, op)
 
 	return local, remote, nil
 }
 
 // CreatePayment persists the payment locally and in Asaas.
 func (s *Service) CreatePayment(ctx context.Context, req PaymentRequest) (PaymentRecord, PaymentResponse, error) {
-	customer, err := s.repo.FindCustomerByID(ctx, req.Customer)
+	if req.AuthorizeOnly && req.BillingType != "CREDIT_CARD" {
+		return PaymentRecord{}, PaymentResponse{}, fmt.Errorf("%w: authorizeOnly exige CREDIT_CARD", ErrBillingTypeMismatch)
+	}
+	if err := validateInstallment(req); err != nil {
+		return PaymentRecord{}, PaymentResponse{}, err
+	}
+	s.applyChargeDefaults(&req.Discount, &req.Interest, &req.Fine)
+	if err := validateChargeTerms(chargeValue(req), req.Discount, req.Interest, req.Fine); err != nil {
+		return PaymentRecord{}, PaymentResponse{}, err
+	}
+	if err := validateSplits(chargeValue(req), installmentTotal(req), req.Split); err != nil {
+		return PaymentRecord{}, PaymentResponse{}, err
+	}
+	customer, err := s.activeCustomer(ctx, req.Customer)
 	if err != nil {
-		return PaymentRecord{}, PaymentResponse{}, fmt.Errorf("falha ao localizar cliente %s: %w", req.Customer, err)
+		return PaymentRecord{}, PaymentResponse{}, err
 	}
-
-	remoteCustomer, err := s.client.GetCustomer(ctx, customer.ID)
+	creditCardToken, err := savedCreditCardToken(customer, req.BillingType, req.UseSavedCard, req.CreditCard, req.CreditCardToken)
 	if err != nil {
-		return PaymentRecord{}, PaymentResponse{}, fmt.Errorf("falha ao buscar cliente no Asaas para id %s: %w", req.Customer, err)
+		return PaymentRecord{}, PaymentResponse{}, err
 	}
 
-	localID := generateID()
-	req.ExternalID = localID
-	asaasReq := req
-	asaasReq.Customer = remoteCustomer.ID
-	remote, err := s.client.CreatePayment(ctx, asaasReq)
+	remoteCustomerID, err := s.remoteCustomerID(ctx, customer)
 	if err != nil {
-		return PaymentRecord{}, PaymentResponse{}, fmt.Errorf("falha ao criar pagamento no Asaas: %w", err)
+		return PaymentRecord{}, PaymentResponse{}, fmt.Errorf("falha ao buscar cliente no Asaas para id %s: %w", req.Customer, err)
 	}
 
 	callbackSuccessURL := ""
@@ -85,71 +111,138 @@ func (s *Service) CreatePayment(ctx context.Context, req PaymentRequest) (Paymen
 
 	now := time.Now().UTC()
 	local := PaymentRecord{
-		ID:                    localID,
-		CustomerID:            customer.ID,
-		BillingType:           req.BillingType,
-		Value:                 req.Value,
-		DueDate:               parseDate(req.DueDate),
-		Description:           req.Description,
-		InstallmentCount:      req.InstallmentCount,
-		CallbackSuccessURL:    callbackSuccessURL,
-		CallbackAutoRedirect:  callbackAutoRedirect,
-		Status:                remote.Status,
-		InvoiceURL:            remote.InvoiceURL,
-		TransactionReceiptURL: remote.TransactionReceiptURL,
-		CreatedAt:             now,
-		UpdatedAt:             now,
-	}
-
-	if err := s.repo.SavePayment(ctx, local); err != nil {
+		ID:                   generateID(),
+		CustomerID:           customer.ID,
+		BillingType:          req.BillingType,
+		Value:                req.Value,
+		DueDate:              parseDate(req.DueDate),
+		Description:          req.Description,
+		InstallmentCount:     req.InstallmentCount,
+		CallbackSuccessURL:   callbackSuccessURL,
+		CallbackAutoRedirect: callbackAutoRedirect,
+		ChargeTerms:          newChargeTerms(req.Discount, req.Interest, req.Fine),
+		CreatedAt:            now,
+		UpdatedAt:            now,
+	}
+
+	op, err := s.beginPendingOperation(ctx, PendingOperationPayment, local.ID, local)
+	if err != nil {
+		return PaymentRecord{}, PaymentResponse{}, err
+	}
+
+	req.ExternalID = local.ID
+	asaasReq := req
+	asaasReq.Customer = remoteCustomerID
+	asaasReq.CreditCardToken = creditCardToken
+	asaasReq.UseSavedCard, asaasReq.SaveCreditCard = false, false
+	remote, err := s.client.CreatePayment(ctx, asaasReq)
+	if err != nil {
+		s.failPendingOperation(ctx, op, err)
+		return PaymentRecord{}, PaymentResponse{}, fmt.Errorf("falha ao criar pagamento no Asaas: %w", err)
+	}
+	local.AsaasID = remote.ID
+	local.Status = remote.Status
+	local.InvoiceURL = remote.InvoiceURL
+	local.TransactionReceiptURL = remote.TransactionReceiptURL
+	if req.AuthorizeOnly && remote.Status == AuthorizationStatusAuthorized {
+		local.AuthorizationStatus = AuthorizationStatusAuthorized
+		local.AuthorizedAt = time.Now().UTC()
+	}
+
+	// The response is the first payment of an installment plan; the plan is saved
+	// together with it so a webhook never finds the plan without this payment.
+	record := pendingPayment{PaymentRecord: local}
+	if remote.Installment != "" {
+		installment := newInstallmentRecord(customer.ID, remote.Installment, req)
+		record.Installment = &installment
+		local.Value = remote.Value
+		local.InstallmentID = installment.ID
+		local.InstallmentNumber = max(remote.InstallmentNumber, 1)
+		record.PaymentRecord = local
+	}
+	s.linkPendingOperation(ctx, &op, remote.ID, record)
+
+	if err := s.savePendingPayment(ctx, record); err != nil {
+		s.failPendingOperation(ctx, op, err)
 		return PaymentRecord{}, PaymentResponse{}, fmt.Errorf("falha ao salvar pagamento local: %w", err)
 	}
+	s.confirmPendingOperation(ctx, op)
+	s.rememberCreditCard(ctx, customer, req.SaveCreditCard, remote.CreditCard)
+	s.saveNewSplits(ctx, local.ID, "", remote.Split, req.Split)
+	if record.Installment != nil {
+		s.syncNewInstallment(ctx, *record.Installment)
+	}
 
-	return local, remote, nil
+	return local, remote.WithoutCardToken(), nil
 }
 
 // CreateSubscription persists the subscription locally and remotely.
 func (s *Service) CreateSubscription(ctx context.Context, req SubscriptionRequest) (SubscriptionRecord, SubscriptionResponse, error) {
-	customer, err := s.repo.FindCustomerByID(ctx, req.Customer)
+	s.applyChargeDefaults(&req.Discount, &req.Interest, &req.Fine)
+	if err := validateChargeTerms(req.Value, req.Discount, req.Interest, req.Fine); err != nil {
+		return SubscriptionRecord{}, SubscriptionResponse{}, err
+	}
+	if err := validateSplits(req.Value, 0, req.Split); err != nil {
+		return SubscriptionRecord{}, SubscriptionResponse{}, err
+	}
+	customer, err := s.activeCustomer(ctx, req.Customer)
 	if err != nil {
-		return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao localizar cliente %s: %w", req.Customer, err)
+		return SubscriptionRecord{}, SubscriptionResponse{}, err
 	}
-
-	remoteCustomer, err := s.client.GetCustomer(ctx, customer.ID)
+	creditCardToken, err := savedCreditCardToken(customer, req.BillingType, req.UseSavedCard, req.CreditCard, req.CreditCardToken)
 	if err != nil {
-		return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao buscar cliente no Asaas para id %s: %w", req.Customer, err)
+		return SubscriptionRecord{}, SubscriptionResponse{}, err
 	}
 
-	localID := generateID()
-	req.ExternalID = localID
-	asaasReq := req
-	asaasReq.Customer = remoteCustomer.ID
-	remote, err := s.client.CreateSubscription(ctx, asaasReq)
+	remoteCustomerID, err := s.remoteCustomerID(ctx, customer)
 	if err != nil {
-		return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao criar assinatura no Asaas: %w", err)
+		return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao buscar cliente no Asaas para id %s: %w", req.Customer, err)
 	}
 
 	now := time.Now().UTC()
 	local := SubscriptionRecord{
-		ID:          localID,
+		ID:          generateID(),
 		CustomerID:  customer.ID,
 		BillingType: req.BillingType,
-		Status:      remote.Status,
 		Value:       req.Value,
 		Cycle:       req.Cycle,
 		NextDueDate: parseDate(req.NextDueDate),
 		Description: req.Description,
 		EndDate:     parseDate(req.EndDate),
 		MaxPayments: req.MaxPayments,
+		ChargeTerms: newChargeTerms(req.Discount, req.Interest, req.Fine),
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
 
+	op, err := s.beginPendingOperation(ctx, PendingOperationSubscription, local.ID, local)
+	if err != nil {
+		return SubscriptionRecord{}, SubscriptionResponse{}, err
+	}
+
+	req.ExternalID = local.ID
+	asaasReq := req
+	asaasReq.Customer = remoteCustomerID
+	asaasReq.CreditCardToken = creditCardToken
+	asaasReq.UseSavedCard, asaasReq.SaveCreditCard = false, false
+	remote, err := s.client.CreateSubscription(ctx, asaasReq)
+	if err != nil {
+		s.failPendingOperation(ctx, op, err)
+		return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao criar assinatura no Asaas: %w", err)
+	}
+	local.AsaasID = remote.ID
+	local.Status = remote.Status
+	s.linkPendingOperation(ctx, &op, remote.ID, local)
+
 	if err := s.repo.SaveSubscription(ctx, local); err != nil {
+		s.failPendingOperation(ctx, op, err)
 		return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao salvar assinatura local: %w", err)
 	}
+	s.confirmPendingOperation(ctx, op)
+	s.rememberCreditCard(ctx, customer, req.SaveCreditCard, remote.CreditCard)
+	s.saveNewSplits(ctx, "", local.ID, remote.Split, req.Split)
 
-	return local, remote, nil
+	return local, remote.WithoutCardToken(), nil
 }
 
 // CreateInvoice persists the invoice locally and in Asaas.
@@ -159,7 +252,7 @@ func (s *Service) CreateInvoice(ctx context.Context, req InvoiceRequest) (Invoic
 		return InvoiceRecord{}, InvoiceResponse{}, fmt.Errorf("falha ao localizar pagamento %s: %w", req.Payment, err)
 	}
 
-	remotePayment, err := s.client.GetPayment(ctx, payment.ID)
+	remotePaymentID, err := s.remotePaymentID(ctx, payment)
 	if err != nil {
 		return InvoiceRecord{}, InvoiceResponse{}, fmt.Errorf("falha ao buscar pagamento no Asaas para id %s: %w", req.Payment, err)
 	}
@@ -168,14 +261,6 @@ func (s *Service) CreateInvoice(ctx context.Context, req InvoiceRequest) (Invoic
 	if localID == "" {
 		localID = payment.ID
 	}
-	req.ExternalID = localID
-	asaasReq := req
-	asaasReq.Payment = remotePayment.ID
-	remote, err := s.client.CreateInvoice(ctx, asaasReq)
-	if err != nil {
-		return InvoiceRecord{}, InvoiceResponse{}, fmt.Errorf("falha ao criar nota fiscal no Asaas: %w", err)
-	}
-
 	now := time.Now().UTC()
 	local := InvoiceRecord{
 		ID:                   localID,
@@ -196,15 +281,33 @@ func (s *Service) CreateInvoice(ctx context.Context, req InvoiceRequest) (Invoic
 		TaxesIR:              req.Taxes.IR,
 		TaxesPIS:             req.Taxes.PIS,
 		TaxesISS:             req.Taxes.ISS,
-		Status:               remote.Status,
-		PaymentLink:          remote.PaymentLink,
 		CreatedAt:            now,
 		UpdatedAt:            now,
 	}
 
+	op, err := s.beginPendingOperation(ctx, PendingOperationInvoice, local.ID, local)
+	if err != nil {
+		return InvoiceRecord{}, InvoiceResponse{}, err
+	}
+
+	req.ExternalID = localID
+	asaasReq := req
+	asaasReq.Payment = remotePaymentID
+	remote, err := s.client.CreateInvoice(ctx, asaasReq)
+	if err != nil {
+		s.failPendingOperation(ctx, op, err)
+		return InvoiceRecord{}, InvoiceResponse{}, fmt.Errorf("falha ao criar nota fiscal no Asaas: %w", err)
+	}
+	local.AsaasID = remote.ID
+	local.Status = remote.Status
+	local.PaymentLink = remote.PaymentLink
+	s.linkPendingOperation(ctx, &op, remote.ID, local)
+
 	if err := s.repo.SaveInvoice(ctx, local); err != nil {
+		s.failPendingOperation(ctx, op, err)
 		return InvoiceRecord{}, InvoiceResponse{}, fmt.Errorf("falha ao salvar nota fiscal local: %w", err)
 	}
+	s.confirmPendingOperation(ctx, op)
 
 	return local, remote, nil
 }
@@ -216,10 +319,16 @@ func (s *Service) HandleWebhookNotification(ctx context.Context, event Notificat
 		if event.Payment == nil {
 			return fmt.Errorf("payload de pagamento ausente")
 		}
+		if event.Payment.Installment != "" {
+			return s.importInstallmentPaymentEvent(ctx, *event.Payment)
+		}
 		if event.Payment.Subscription == "" {
 			return nil
 		}
-		if event.Payment.ExternalReference != "" {
+		if err := s.checkPendingOperation(ctx, event.Payment.ExternalReference); err != nil {
+			return err
+		}
+		if isUUID(event.Payment.ExternalReference) {
 			if _, err := s.repo.FindPaymentByID(ctx, event.Payment.ExternalReference); err == nil {
 				return nil
 			} else if !errors.Is(err, sql.ErrNoRows) {
@@ -243,65 +352,145 @@ func (s *Service) HandleWebhookNotification(ctx context.Context, event Notificat
 			return err
 		}
 
-		localID := generateID()
-		now := time.Now().UTC()
-		localPayment := PaymentRecord{
-			ID:                    localID,
-			CustomerID:            localSubscription.CustomerID,
-			SubscriptionID:        localSubscription.ID,
-			BillingType:           event.Payment.BillingType,
-			Value:                 event.Payment.Value,
-			DueDate:               parseDate(event.Payment.DueDate),
-			Description:           event.Payment.Description,
-			InstallmentCount:      0,
-			CallbackSuccessURL:    "",
-			CallbackAutoRedirect:  false,
-			Status:                event.Payment.Status,
-			InvoiceURL:            event.Payment.InvoiceURL,
-			TransactionReceiptURL: event.Payment.TransactionReceiptURL,
-			CreatedAt:             now,
-			UpdatedAt:             now,
-		}
-
-		if err := s.repo.SavePayment(ctx, localPayment); err != nil {
-			return fmt.Errorf("falha ao salvar pagamento local: %w", err)
-		}
-		if event.Payment.ID != "" && event.Payment.ExternalReference != localID {
-			if err := s.client.UpdatePaymentExternalReference(ctx, event.Payment.ID, localID); err != nil {
-				return fmt.Errorf("falha ao atualizar externalReference do pagamento: %w", err)
-			}
-		}
-		return nil
+		_, _, err = s.importSubscriptionPayment(ctx, localSubscription, *event.Payment)
+		return err
 	case "INVOICE_CREATED", "SUBSCRIPTION_CREATED":
 		return nil
-	case "PAYMENT_AUTHORIZED", "PAYMENT_APPROVED_BY_RISK_ANALYSIS", "PAYMENT_CONFIRMED", "PAYMENT_ANTICIPATED", "PAYMENT_DELETED", "PAYMENT_REFUNDED", "PAYMENT_REFUND_DENIED", "PAYMENT_CHARGEBACK_REQUESTED", "PAYMENT_AWAITING_CHARGEBACK_REVERSAL", "PAYMENT_DUNNING_REQUESTED", "PAYMENT_CHECKOUT_VIEWED", "PAYMENT_PARTIALLY_REFUNDED", "PAYMENT_SPLIT_DIVERGENCE_BLOCK", "PAYMENT_AWAITING_RISK_ANALYSIS", "PAYMENT_REPROVED_BY_RISK_ANALYSIS", "PAYMENT_UPDATED", "PAYMENT_RECEIVED", "PAYMENT_OVERDUE", "PAYMENT_RESTORED", "PAYMENT_REFUND_IN_PROGRESS", "PAYMENT_RECEIVED_IN_CASH_UNDONE", "PAYMENT_CHARGEBACK_DISPUTE", "PAYMENT_DUNNING_RECEIVED", "PAYMENT_BANK_SLIP_VIEWED", "PAYMENT_CREDIT_CARD_CAPTURE_REFUSED", "PAYMENT_SPLIT_CANCELLED", "PAYMENT_SPLIT_DIVERGENCE_BLOCK_FINISHED":
-		if event.Payment == nil {
-			return fmt.Errorf("payload de pagamento ausente")
-		}
-		payment, err := s.repo.FindPaymentByID(ctx, event.Payment.ExternalReference)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return nil
-			}
+	case "PAYMENT_APPROVED_BY_RISK_ANALYSIS", "PAYMENT_CONFIRMED", "PAYMENT_ANTICIPATED", "PAYMENT_DELETED", "PAYMENT_CHARGEBACK_REQUESTED", "PAYMENT_AWAITING_CHARGEBACK_REVERSAL", "PAYMENT_DUNNING_REQUESTED", "PAYMENT_CHECKOUT_VIEWED", "PAYMENT_AWAITING_RISK_ANALYSIS", "PAYMENT_REPROVED_BY_RISK_ANALYSIS", "PAYMENT_UPDATED", "PAYMENT_RECEIVED", "PAYMENT_OVERDUE", "PAYMENT_RESTORED", "PAYMENT_RECEIVED_IN_CASH_UNDONE", "PAYMENT_CHARGEBACK_DISPUTE", "PAYMENT_DUNNING_RECEIVED", "PAYMENT_BANK_SLIP_VIEWED":
+		payment, found, err := s.updatePaymentFromEvent(ctx, event)
+		if err != nil || !found {
 			return err
 		}
-		if err := s.repo.UpdatePaymentStatus(ctx, payment.ID, event.Payment.Status, event.Payment.InvoiceURL, event.Payment.TransactionReceiptURL); err != nil {
+		if payment, err = s.advanceAuthorization(ctx, payment, event.Event); err != nil {
 			return err
 		}
 		return s.issueInvoiceForPayment(ctx, payment, *event.Payment)
-	case "SUBSCRIPTION_INACTIVATED", "SUBSCRIPTION_SPLIT_DISABLED", "SUBSCRIPTION_SPLIT_DIVERGENCE_BLOCK_FINISHED", "SUBSCRIPTION_UPDATED", "SUBSCRIPTION_DELETED", "SUBSCRIPTION_SPLIT_DIVERGENCE_BLOCK":
+	case "PAYMENT_AUTHORIZED", "PAYMENT_CREDIT_CARD_CAPTURE_REFUSED":
+		// Nothing was charged yet, so no invoice is issued.
+		payment, found, err := s.updatePaymentFromEvent(ctx, event)
+		if err != nil || !found {
+			return err
+		}
+		_, err = s.advanceAuthorization(ctx, payment, event.Event)
+		return err
+	case "PAYMENT_REFUNDED", "PAYMENT_PARTIALLY_REFUNDED", "PAYMENT_REFUND_IN_PROGRESS", "PAYMENT_REFUND_DENIED":
+		payment, found, err := s.updatePaymentFromEvent(ctx, event)
+		if err != nil || !found {
+			return err
+		}
+		if payment, err = s.advanceAuthorization(ctx, payment, event.Event); err != nil {
+			return err
+		}
+		return s.reconcileRefunds(ctx, payment, event)
+	case "PAYMENT_SPLIT_CANCELLED", "PAYMENT_SPLIT_DIVERGENCE_BLOCK", "PAYMENT_SPLIT_DIVERGENCE_BLOCK_FINISHED":
+		return s.handlePaymentSplitEvent(ctx, event)
+	case "SUBSCRIPTION_INACTIVATED", "SUBSCRIPTION_UPDATED", "SUBSCRIPTION_DELETED":
 		if event.Subscription == nil {
 			return fmt.Errorf("payload de assinatura ausente")
 		}
 		return s.repo.UpdateSubscriptionStatus(ctx, event.Subscription.ExternalID, event.Subscription.Status)
+	case "SUBSCRIPTION_SPLIT_DISABLED", "SUBSCRIPTION_SPLIT_DIVERGENCE_BLOCK", "SUBSCRIPTION_SPLIT_DIVERGENCE_BLOCK_FINISHED":
+		return s.handleSubscriptionSplitEvent(ctx, event)
 	case "INVOICE_SYNCHRONIZED", "INVOICE_PROCESSING_CANCELLATION", "INVOICE_CANCELLATION_DENIED", "INVOICE_UPDATED", "INVOICE_AUTHORIZED", "INVOICE_CANCELED", "INVOICE_ERROR":
 		if event.Invoice == nil {
 			return fmt.Errorf("payload de nota fiscal ausente")
 		}
 		return s.repo.UpdateInvoiceStatus(ctx, event.Invoice.ExternalID, event.Invoice.Status)
 	default:
-		return fmt.Errorf("tipo de evento não suportado: %s", event.Event)
+		return s.handleUnregisteredEvent(ctx, event)
+	}
+}
+
+// updatePaymentFromEvent copies the status and links of a payment event to the local
+// payment. It reports false when the payment is not known locally.
+func (s *Service) updatePaymentFromEvent(ctx context.Context, event NotificationEvent) (PaymentRecord, bool, error) {
+	if event.Payment == nil {
+		return PaymentRecord{}, false, fmt.Errorf("payload de pagamento ausente")
+	}
+	payment, err := s.findPaymentForEvent(ctx, *event.Payment)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PaymentRecord{}, false, nil
+		}
+		return PaymentRecord{}, false, err
+	}
+	if err := s.repo.UpdatePaymentStatus(ctx, payment.ID, event.Payment.Status, event.Payment.InvoiceURL, event.Payment.TransactionReceiptURL); err != nil {
+		return PaymentRecord{}, false, err
+	}
+	payment.Status = event.Payment.Status
+	if payment.AsaasID == "" && event.Payment.ID != "" {
+		if err := s.repo.SetPaymentAsaasID(ctx, payment.ID, event.Payment.ID); err != nil {
+			return PaymentRecord{}, false, err
+		}
+		payment.AsaasID = event.Payment.ID
+	}
+	return payment, true, nil
+}
+
+// findPaymentForEvent finds the local payment of an event by its externalReference,
+// falling back to the Asaas ID. The fallback covers installment payments imported
+// before Asaas stored their own externalReference, which may still carry the one of
+// the first installment. Payments created in the Asaas dashboard have an empty or
+// foreign externalReference, which is not a local ID and is not looked up.
+func (s *Service) findPaymentForEvent(ctx context.Context, remote PaymentResponse) (PaymentRecord, error) {
+	if isUUID(remote.ExternalReference) {
+		payment, err := s.repo.FindPaymentByID(ctx, remote.ExternalReference)
+		if err == nil && (payment.AsaasID == "" || remote.ID == "" || payment.AsaasID == remote.ID) {
+			return payment, nil
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return PaymentRecord{}, err
+		}
+	}
+	if remote.ID == "" {
+		return PaymentRecord{}, sql.ErrNoRows
+	}
+	return s.repo.FindPaymentByAsaasID(ctx, remote.ID)
+}
+
+// remoteCustomerID returns the Asaas ID of a customer, resolving and storing it when it is not known yet.
+func (s *Service) remoteCustomerID(ctx context.Context, customer CustomerRecord) (string, error) {
+	if customer.AsaasID != "" {
+		return customer.AsaasID, nil
+	}
+	remote, err := s.client.GetCustomer(ctx, customer.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.repo.SetCustomerAsaasID(ctx, customer.ID, remote.ID); err != nil {
+		return "", fmt.Errorf("falha ao salvar id do Asaas do cliente %s: %w", customer.ID, err)
+	}
+	return remote.ID, nil
+}
+
+// remotePaymentID returns the Asaas ID of a payment, resolving and storing it when it is not known yet.
+func (s *Service) remotePaymentID(ctx context.Context, payment PaymentRecord) (string, error) {
+	if payment.AsaasID != "" {
+		return payment.AsaasID, nil
+	}
+	remote, err := s.client.GetPayment(ctx, payment.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.repo.SetPaymentAsaasID(ctx, payment.ID, remote.ID); err != nil {
+		return "", fmt.Errorf("falha ao salvar id do Asaas do pagamento %s: %w", payment.ID, err)
+	}
+	return remote.ID, nil
+}
+
+// remoteSubscriptionID returns the Asaas ID of a subscription, resolving and storing it when it is not known yet.
+func (s *Service) remoteSubscriptionID(ctx context.Context, subscription SubscriptionRecord) (string, error) {
+	if subscription.AsaasID != "" {
+		return subscription.AsaasID, nil
+	}
+	remote, err := s.client.GetSubscription(ctx, subscription.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.repo.SetSubscriptionAsaasID(ctx, subscription.ID, remote.ID); err != nil {
+		return "", fmt.Errorf("falha ao salvar id do Asaas da assinatura %s: %w", subscription.ID, err)
 	}
+	return remote.ID, nil
 }
 
 func parseDate(value string) time.Time {
@@ -356,7 +545,7 @@ func (s *Service) issueInvoiceForPayment(ctx context.Context, payment PaymentRec
 			INSS:      0,
 			IR:        0,
 			PIS:       0,
-			ISS:       5,
+			ISS:       NewPercentage(5, 0),
 		},
 	}
 
@@ -0,0 +1,830 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeGateway is an in-memory Gateway that records the calls made by Service.
+type fakeGateway struct {
+	subscriptions      map[string]SubscriptionResponse
+	payments           map[string]PaymentResponse
+	invoices           []InvoiceRequest
+	externalReferences map[string]string
+	externalRefErr     error
+	deletedPayments    []string
+	refunds            []RefundRequest
+}
+
+func newFakeGateway() *fakeGateway {
+	return &fakeGateway{
+		subscriptions:      make(map[string]SubscriptionResponse),
+		payments:           make(map[string]PaymentResponse),
+		externalReferences: make(map[string]string),
+	}
+}
+
+func (g *fakeGateway) CreateCustomer(ctx context.Context, req CustomerRequest) (CustomerResponse, error) {
+	return CustomerResponse{ID: "cus_" + req.ExternalID, Name: req.Name, Email: req.Email, ExternalID: req.ExternalID}, nil
+}
+
+func (g *fakeGateway) GetCustomer(ctx context.Context, id string) (CustomerResponse, error) {
+	return CustomerResponse{}, newNotFoundError("cliente não encontrado para externalReference %s", id)
+}
+
+func (g *fakeGateway) UpdateCustomer(ctx context.Context, id string, req UpdateCustomerRequest) (CustomerResponse, error) {
+	resp := CustomerResponse{ID: id, ExternalID: req.ExternalID}
+	if req.Name != nil {
+		resp.Name = *req.Name
+	}
+	if req.Email != nil {
+		resp.Email = *req.Email
+	}
+	return resp, nil
+}
+
+func (g *fakeGateway) DeleteCustomer(ctx context.Context, id string) error {
+	return nil
+}
+
+func (g *fakeGateway) RestoreCustomer(ctx context.Context, id string) (CustomerResponse, error) {
+	return CustomerResponse{ID: id}, nil
+}
+
+func (g *fakeGateway) TokenizeCreditCard(ctx context.Context, req TokenizeCreditCardRequest) (CreditCardTokenResponse, error) {
+	return CreditCardTokenResponse{CreditCardNumber: "8829", CreditCardBrand: "MASTERCARD", CreditCardToken: "tok_" + req.Customer}, nil
+}
+
+func (g *fakeGateway) CreatePayment(ctx context.Context, req PaymentRequest) (PaymentResponse, error) {
+	return PaymentResponse{ID: "pay_" + req.ExternalID, Customer: req.Customer, Value: req.Value, ExternalReference: req.ExternalID, Status: "PENDING"}, nil
+}
+
+func (g *fakeGateway) GetPayment(ctx context.Context, id string) (PaymentResponse, error) {
+	payment, ok := g.payments[id]
+	if !ok {
+		return PaymentResponse{}, newNotFoundError("pagamento não encontrado para externalReference %s", id)
+	}
+	return payment, nil
+}
+
+func (g *fakeGateway) GetPaymentByID(ctx context.Context, id string) (PaymentResponse, error) {
+	for _, payment := range g.payments {
+		if payment.ID == id {
+			return payment, nil
+		}
+	}
+	return PaymentResponse{ID: id}, nil
+}
+
+func (g *fakeGateway) UpdatePaymentExternalReference(ctx context.Context, id, externalReference string) error {
+	if g.externalRefErr != nil {
+		return g.externalRefErr
+	}
+	g.externalReferences[id] = externalReference
+	return nil
+}
+
+func (g *fakeGateway) DeletePayment(ctx context.Context, id string) error {
+	g.deletedPayments = append(g.deletedPayments, id)
+	return nil
+}
+
+func (g *fakeGateway) RefundPayment(ctx context.Context, id string, req RefundRequest) (PaymentResponse, error) {
+	g.refunds = append(g.refunds, req)
+	return PaymentResponse{ID: id, Status: "REFUNDED"}, nil
+}
+
+func (g *fakeGateway) CapturePayment(ctx context.Context, id string) (PaymentResponse, error) {
+	return PaymentResponse{ID: id, Status: "CONFIRMED"}, nil
+}
+
+func (g *fakeGateway) GetPixQrCode(ctx context.Context, id string) (PixQrCodeResponse, error) {
+	return PixQrCodeResponse{EncodedImage: "aW1n", Payload: "pix-" + id, ExpirationDate: "2999-12-31 23:59:59"}, nil
+}
+
+func (g *fakeGateway) GetIdentificationField(ctx context.Context, id string) (IdentificationFieldResponse, error) {
+	return IdentificationFieldResponse{IdentificationField: "0019" + id, NossoNumero: id, BarCode: "0019" + id}, nil
+}
+
+func (g *fakeGateway) DownloadBankSlip(ctx context.Context, id string) ([]byte, error) {
+	return []byte("%PDF-" + id), nil
+}
+
+func (g *fakeGateway) ListInstallmentPayments(ctx context.Context, id string) *ListIterator[PaymentResponse] {
+	var payments []PaymentResponse
+	for _, payment := range g.payments {
+		if payment.Installment == id {
+			payments = append(payments, payment)
+		}
+	}
+	return &ListIterator[PaymentResponse]{
+		ctx:      ctx,
+		pageSize: MaxPageSize,
+		fetch: func(ctx context.Context, offset, limit int) (ListResponse[PaymentResponse], error) {
+			return ListResponse[PaymentResponse]{Data: payments}, nil
+		},
+	}
+}
+
+func (g *fakeGateway) RefundInstallment(ctx context.Context, id string) (InstallmentResponse, error) {
+	return InstallmentResponse{ID: id}, nil
+}
+
+func (g *fakeGateway) CancelRemainingInstallments(ctx context.Context, id string) ([]PaymentResponse, error) {
+	return nil, nil
+}
+
+func (g *fakeGateway) CreateSubscription(ctx context.Context, req SubscriptionRequest) (SubscriptionResponse, error) {
+	return SubscriptionResponse{ID: "sub_" + req.ExternalID, Customer: req.Customer, Value: req.Value, ExternalID: req.ExternalID, Status: "ACTIVE"}, nil
+}
+
+func (g *fakeGateway) GetSubscription(ctx context.Context, externalReference string) (SubscriptionResponse, error) {
+	return SubscriptionResponse{}, newNotFoundError("assinatura não encontrada para externalReference %s", externalReference)
+}
+
+func (g *fakeGateway) GetSubscriptionByID(ctx context.Context, id string) (SubscriptionResponse, error) {
+	subscription, ok := g.subscriptions[id]
+	if !ok {
+		return SubscriptionResponse{}, &AsaasError{StatusCode: 404}
+	}
+	return subscription, nil
+}
+
+func (g *fakeGateway) UpdateSubscription(ctx context.Context, id string, req UpdateSubscriptionRequest) (SubscriptionResponse, error) {
+	subscription, ok := g.subscriptions[id]
+	if !ok {
+		return SubscriptionResponse{}, &AsaasError{StatusCode: 404}
+	}
+	return subscription, nil
+}
+
+func (g *fakeGateway) ListSubscriptionPayments(ctx context.Context, id string) *ListIterator[PaymentResponse] {
+	var payments []PaymentResponse
+	for _, payment := range g.payments {
+		if payment.Subscription == id {
+			payments = append(payments, payment)
+		}
+	}
+	return &ListIterator[PaymentResponse]{
+		ctx:      ctx,
+		pageSize: MaxPageSize,
+		fetch: func(ctx context.Context, offset, limit int) (ListResponse[PaymentResponse], error) {
+			return ListResponse[PaymentResponse]{Data: payments}, nil
+		},
+	}
+}
+
+func (g *fakeGateway) CancelSubscriptionByID(ctx context.Context, id string) (SubscriptionResponse, error) {
+	return SubscriptionResponse{ID: id, Status: "INACTIVE"}, nil
+}
+
+func (g *fakeGateway) CreateInvoice(ctx context.Context, req InvoiceRequest) (InvoiceResponse, error) {
+	g.invoices = append(g.invoices, req)
+	return InvoiceResponse{ID: "inv_" + req.ExternalID, Status: "SCHEDULED", Value: req.Value, ExternalID: req.ExternalID}, nil
+}
+
+func (g *fakeGateway) GetInvoice(ctx context.Context, externalReference string) (InvoiceResponse, error) {
+	return InvoiceResponse{}, newNotFoundError("nota fiscal não encontrada para externalReference %s", externalReference)
+}
+
+func (g *fakeGateway) CancelInvoice(ctx context.Context, id string) (InvoiceResponse, error) {
+	return InvoiceResponse{ID: id, Status: "CANCELED"}, nil
+}
+
+func seedCustomer(t *testing.T, repo *MemoryRepository) {
+	t.Helper()
+	now := time.Now().UTC()
+	if err := repo.SaveCustomer(context.Background(), CustomerRecord{ID: "cust-1", AsaasID: "cus_1", Name: "Cliente", CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// seededPaymentID is the local ID of the payment stored by seedPayment. Local IDs
+// are UUIDs; events carrying anything else are matched by Asaas ID only.
+const seededPaymentID = "5b0c9a57-3f1e-4c3a-9d2b-7e6f1a2b3c4d"
+
+func seedPayment(t *testing.T, repo *MemoryRepository, asaasID string) {
+	t.Helper()
+	seedCustomer(t, repo)
+	now := time.Now().UTC()
+	payment := PaymentRecord{ID: seededPaymentID, AsaasID: asaasID, CustomerID: "cust-1", BillingType: "PIX", Value: NewMoney(100, 50), Description: "Plano", Status: "PENDING", CreatedAt: now, UpdatedAt: now}
+	if err := repo.SavePayment(context.Background(), payment); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func seedSubscription(t *testing.T, repo *MemoryRepository) {
+	t.Helper()
+	seedCustomer(t, repo)
+	now := time.Now().UTC()
+	subscription := SubscriptionRecord{ID: "subs-1", AsaasID: "sub_1", CustomerID: "cust-1", BillingType: "PIX", Status: "ACTIVE", Value: NewMoney(49, 90), Cycle: "MONTHLY", CreatedAt: now, UpdatedAt: now}
+	if err := repo.SaveSubscription(context.Background(), subscription); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleWebhookNotification(t *testing.T) {
+	tests := []struct {
+		name    string
+		seed    func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway)
+		setup   func(t *testing.T, service *Service)
+		event   NotificationEvent
+		wantErr error
+		check   func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway)
+	}{
+		{
+			name:  "payment created outside a subscription is ignored",
+			event: NotificationEvent{Event: "PAYMENT_CREATED", Payment: &PaymentResponse{ID: "pay_x", ExternalReference: "pay-x"}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				if _, err := repo.FindPaymentByID(context.Background(), "pay-x"); !errors.Is(err, sql.ErrNoRows) {
+					t.Fatalf("expected no local payment, got %v", err)
+				}
+			},
+		},
+		{
+			name: "subscription payment is imported and linked",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedSubscription(t, repo)
+				gateway.subscriptions["sub_1"] = SubscriptionResponse{ID: "sub_1", ExternalID: "subs-1", Status: "ACTIVE"}
+			},
+			event: NotificationEvent{Event: "PAYMENT_CREATED", Payment: &PaymentResponse{
+				ID: "pay_sub", Subscription: "sub_1", BillingType: "PIX", Value: NewMoney(49, 90), DueDate: "2024-05-10", Status: "PENDING",
+			}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				localID, ok := gateway.externalReferences["pay_sub"]
+				if !ok {
+					t.Fatal("expected externalReference to be updated in Asaas")
+				}
+				payment, err := repo.FindPaymentByID(context.Background(), localID)
+				if err != nil {
+					t.Fatalf("expected imported payment: %v", err)
+				}
+				if payment.AsaasID != "pay_sub" || payment.SubscriptionID != "subs-1" || payment.CustomerID != "cust-1" {
+					t.Fatalf("unexpected imported payment: %+v", payment)
+				}
+				if payment.Value != NewMoney(49, 90) || !payment.DueDate.Equal(parseDate("2024-05-10")) {
+					t.Fatalf("unexpected value or due date: %s %s", payment.Value, payment.DueDate)
+				}
+			},
+		},
+		{
+			name: "subscription payment already stored is not imported again",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedPayment(t, repo, "pay_1")
+			},
+			event: NotificationEvent{Event: "PAYMENT_CREATED", Payment: &PaymentResponse{ID: "pay_1", Subscription: "sub_1", ExternalReference: seededPaymentID}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				if len(gateway.externalReferences) != 0 {
+					t.Fatalf("unexpected externalReference updates: %v", gateway.externalReferences)
+				}
+			},
+		},
+		{
+			name: "payment of an unknown local subscription is ignored",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				gateway.subscriptions["sub_2"] = SubscriptionResponse{ID: "sub_2", ExternalID: "subs-2"}
+			},
+			event: NotificationEvent{Event: "PAYMENT_CREATED", Payment: &PaymentResponse{ID: "pay_2", Subscription: "sub_2"}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				if len(gateway.externalReferences) != 0 {
+					t.Fatalf("unexpected externalReference updates: %v", gateway.externalReferences)
+				}
+			},
+		},
+		{
+			name: "installment payment is imported and linked",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedCustomer(t, repo)
+				now := time.Now().UTC()
+				installment := InstallmentRecord{ID: "inst-1", AsaasID: "ins_1", CustomerID: "cust-1", BillingType: "BOLETO", InstallmentCount: 2, Status: InstallmentStatusActive, CreatedAt: now, UpdatedAt: now}
+				if err := repo.SaveInstallment(context.Background(), installment); err != nil {
+					t.Fatal(err)
+				}
+			},
+			event: NotificationEvent{Event: "PAYMENT_CREATED", Payment: &PaymentResponse{
+				ID: "pay_i2", Installment: "ins_1", InstallmentNumber: 2, BillingType: "BOLETO", Value: NewMoney(50, 0), DueDate: "2024-06-10", Status: "PENDING", ExternalReference: "pay-i1",
+			}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				payment, err := repo.FindPaymentByAsaasID(context.Background(), "pay_i2")
+				if err != nil {
+					t.Fatalf("expected imported payment: %v", err)
+				}
+				if payment.InstallmentID != "inst-1" || payment.InstallmentNumber != 2 || payment.InstallmentCount != 2 {
+					t.Fatalf("unexpected imported payment: %+v", payment)
+				}
+				if gateway.externalReferences["pay_i2"] != payment.ID {
+					t.Fatalf("expected externalReference %s, got %v", payment.ID, gateway.externalReferences)
+				}
+			},
+		},
+		{
+			name: "payment event with another payment's externalReference is matched by Asaas ID",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedPayment(t, repo, "pay_1")
+				now := time.Now().UTC()
+				other := PaymentRecord{ID: "pay-2", AsaasID: "pay_2", CustomerID: "cust-1", BillingType: "PIX", Status: "PENDING", CreatedAt: now, UpdatedAt: now}
+				if err := repo.SavePayment(context.Background(), other); err != nil {
+					t.Fatal(err)
+				}
+			},
+			event: NotificationEvent{Event: "PAYMENT_OVERDUE", Payment: &PaymentResponse{ID: "pay_2", ExternalReference: seededPaymentID, Status: "OVERDUE"}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				first, _ := repo.FindPaymentByID(context.Background(), seededPaymentID)
+				second, _ := repo.FindPaymentByID(context.Background(), "pay-2")
+				if first.Status != "PENDING" || second.Status != "OVERDUE" {
+					t.Fatalf("unexpected statuses: %s %s", first.Status, second.Status)
+				}
+			},
+		},
+		{
+			name: "received payment updates status and issues the invoice",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedPayment(t, repo, "")
+			},
+			event: NotificationEvent{Event: "PAYMENT_RECEIVED", Payment: &PaymentResponse{
+				ID: "pay_1", ExternalReference: seededPaymentID, Status: "RECEIVED", InvoiceURL: "https://asaas/i/1", TransactionReceiptURL: "https://asaas/r/1",
+			}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				payment, err := repo.FindPaymentByID(context.Background(), seededPaymentID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if payment.Status != "RECEIVED" || payment.InvoiceURL != "https://asaas/i/1" || payment.TransactionReceiptURL != "https://asaas/r/1" {
+					t.Fatalf("payment not updated: %+v", payment)
+				}
+				if payment.AsaasID != "pay_1" {
+					t.Fatalf("expected Asaas ID pay_1, got %q", payment.AsaasID)
+				}
+				if len(gateway.invoices) != 1 {
+					t.Fatalf("expected one invoice request, got %d", len(gateway.invoices))
+				}
+				if req := gateway.invoices[0]; req.Payment != "pay_1" || req.Value != NewMoney(100, 50) || req.ServiceDescription != "Plano" {
+					t.Fatalf("unexpected invoice request: %+v", req)
+				}
+				invoice, err := repo.FindInvoiceByPaymentID(context.Background(), seededPaymentID)
+				if err != nil {
+					t.Fatalf("expected local invoice: %v", err)
+				}
+				if invoice.AsaasID != "inv_"+seededPaymentID {
+					t.Fatalf("unexpected invoice Asaas ID %q", invoice.AsaasID)
+				}
+			},
+		},
+		{
+			name: "payment with an invoice is not invoiced again",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedPayment(t, repo, "pay_1")
+				if err := repo.SaveInvoice(context.Background(), InvoiceRecord{ID: seededPaymentID, PaymentID: seededPaymentID, Status: "AUTHORIZED"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			event: NotificationEvent{Event: "PAYMENT_CONFIRMED", Payment: &PaymentResponse{ID: "pay_1", ExternalReference: seededPaymentID, Status: "CONFIRMED"}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				if len(gateway.invoices) != 0 {
+					t.Fatalf("expected no invoice request, got %d", len(gateway.invoices))
+				}
+			},
+		},
+		{
+			name:  "status change of an unknown payment is ignored",
+			event: NotificationEvent{Event: "PAYMENT_OVERDUE", Payment: &PaymentResponse{ID: "pay_9", ExternalReference: "pay-9", Status: "OVERDUE"}},
+		},
+		{
+			name:    "payment event without payload fails",
+			event:   NotificationEvent{Event: "PAYMENT_RECEIVED"},
+			wantErr: errAny,
+		},
+		{
+			name: "refund without details records the whole value",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedPayment(t, repo, "pay_1")
+			},
+			event: NotificationEvent{Event: "PAYMENT_REFUNDED", Payment: &PaymentResponse{ID: "pay_1", ExternalReference: seededPaymentID, Status: "REFUNDED"}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				refunds, err := repo.ListRefundsByPaymentID(context.Background(), seededPaymentID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(refunds) != 1 || refunds[0].Value != NewMoney(100, 50) || refunds[0].Status != RefundStatusDone {
+					t.Fatalf("unexpected refunds: %+v", refunds)
+				}
+				if len(gateway.invoices) != 0 {
+					t.Fatalf("refunded payment must not be invoiced, got %d requests", len(gateway.invoices))
+				}
+			},
+		},
+		{
+			name: "listed refunds match by Asaas date and description, not only value",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedPayment(t, repo, "pay_1")
+				created := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+				for _, refund := range []RefundRecord{
+					{ID: "ref-1", PaymentID: seededPaymentID, Value: NewMoney(10, 0), Description: "Frete", Status: RefundStatusDone, TransactionReceiptURL: "receipt-1", AsaasDateCreated: "2024-06-01 10:00:00", CreatedAt: created},
+					{ID: "ref-2", PaymentID: seededPaymentID, Value: NewMoney(10, 0), Description: "Item", Status: RefundStatusPending, CreatedAt: created.AddDate(0, 0, 2)},
+				} {
+					if err := repo.SaveRefund(context.Background(), refund); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			event: NotificationEvent{Event: "PAYMENT_PARTIALLY_REFUNDED", Payment: &PaymentResponse{ID: "pay_1", ExternalReference: seededPaymentID, Status: "RECEIVED", Refunds: []PaymentRefund{
+				{DateCreated: "2024-06-03 09:00:00", Status: "DONE", Value: NewMoney(10, 0), Description: "Item", TransactionReceiptURL: "receipt-2"},
+				{DateCreated: "2024-06-01 10:00:00", Status: "DONE", Value: NewMoney(10, 0), Description: "Frete", TransactionReceiptURL: "receipt-1"},
+			}}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				refunds, err := repo.ListRefundsByPaymentID(context.Background(), seededPaymentID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(refunds) != 2 || refunds[0].TransactionReceiptURL != "receipt-1" {
+					t.Fatalf("unexpected refunds: %+v", refunds)
+				}
+				if second := refunds[1]; second.Status != RefundStatusDone || second.TransactionReceiptURL != "receipt-2" || second.AsaasDateCreated != "2024-06-03 09:00:00" {
+					t.Fatalf("pending refund not linked to its Asaas refund: %+v", second)
+				}
+			},
+		},
+		{
+			name: "refund denied settles pending refunds",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedPayment(t, repo, "pay_1")
+				refund := RefundRecord{ID: "ref-1", PaymentID: seededPaymentID, Value: NewMoney(10, 0), Status: RefundStatusPending}
+				if err := repo.SaveRefund(context.Background(), refund); err != nil {
+					t.Fatal(err)
+				}
+			},
+			event: NotificationEvent{Event: "PAYMENT_REFUND_DENIED", Payment: &PaymentResponse{ID: "pay_1", ExternalReference: seededPaymentID, Status: "RECEIVED"}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				refunds, err := repo.ListRefundsByPaymentID(context.Background(), seededPaymentID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(refunds) != 1 || refunds[0].Status != RefundStatusDenied {
+					t.Fatalf("unexpected refunds: %+v", refunds)
+				}
+			},
+		},
+		{
+			name: "capture refused ends the authorization without an invoice",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedCustomer(t, repo)
+				now := time.Now().UTC()
+				payment := PaymentRecord{ID: seededPaymentID, AsaasID: "pay_1", CustomerID: "cust-1", BillingType: "CREDIT_CARD", Value: NewMoney(80, 0), Status: "AUTHORIZED", AuthorizationStatus: AuthorizationStatusAuthorized, AuthorizedAt: now, CreatedAt: now, UpdatedAt: now}
+				if err := repo.SavePayment(context.Background(), payment); err != nil {
+					t.Fatal(err)
+				}
+			},
+			event: NotificationEvent{Event: "PAYMENT_CREDIT_CARD_CAPTURE_REFUSED", Payment: &PaymentResponse{ID: "pay_1", ExternalReference: seededPaymentID, Status: "PENDING"}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				payment, err := repo.FindPaymentByID(context.Background(), seededPaymentID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if payment.AuthorizationStatus != AuthorizationStatusRefused || payment.Status != "PENDING" {
+					t.Fatalf("unexpected payment: %+v", payment)
+				}
+				if _, err := repo.FindInvoiceByPaymentID(context.Background(), seededPaymentID); !errors.Is(err, sql.ErrNoRows) {
+					t.Fatalf("expected no invoice, got %v", err)
+				}
+			},
+		},
+		{
+			name: "subscription inactivated updates status",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedSubscription(t, repo)
+			},
+			event: NotificationEvent{Event: "SUBSCRIPTION_INACTIVATED", Subscription: &SubscriptionResponse{ID: "sub_1", ExternalID: "subs-1", Status: "INACTIVE"}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				subscription, err := repo.FindSubscriptionByID(context.Background(), "subs-1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if subscription.Status != "INACTIVE" {
+					t.Fatalf("expected INACTIVE, got %s", subscription.Status)
+				}
+			},
+		},
+		{
+			name:    "subscription event of an unknown subscription fails",
+			event:   NotificationEvent{Event: "SUBSCRIPTION_DELETED", Subscription: &SubscriptionResponse{ID: "sub_9", ExternalID: "subs-9", Status: "DELETED"}},
+			wantErr: sql.ErrNoRows,
+		},
+		{
+			name: "invoice authorized updates status",
+			seed: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				seedPayment(t, repo, "pay_1")
+				if err := repo.SaveInvoice(context.Background(), InvoiceRecord{ID: seededPaymentID, PaymentID: seededPaymentID, Status: "SCHEDULED"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			event: NotificationEvent{Event: "INVOICE_AUTHORIZED", Invoice: &InvoiceResponse{ID: "inv_1", ExternalID: seededPaymentID, Status: "AUTHORIZED"}},
+			check: func(t *testing.T, repo *MemoryRepository, gateway *fakeGateway) {
+				invoice, err := repo.FindInvoiceByID(context.Background(), seededPaymentID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if invoice.Status != "AUTHORIZED" {
+					t.Fatalf("expected AUTHORIZED, got %s", invoice.Status)
+				}
+			},
+		},
+		{
+			name:    "events without handler are unsupported",
+			event:   NotificationEvent{Event: "TRANSFER_DONE"},
+			wantErr: ErrUnsupportedEvent,
+		},
+		{
+			name: "registered handler receives unknown events",
+			setup: func(t *testing.T, service *Service) {
+				err := service.RegisterWebhookEventHandler("TRANSFER_DONE", func(ctx context.Context, event NotificationEvent) error {
+					return errHandled
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			event:   NotificationEvent{Event: "TRANSFER_DONE"},
+			wantErr: errHandled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := NewMemoryRepository()
+			gateway := newFakeGateway()
+			if tt.seed != nil {
+				tt.seed(t, repo, gateway)
+			}
+			service := NewService(repo, gateway)
+			if tt.setup != nil {
+				tt.setup(t, service)
+			}
+
+			err := service.HandleWebhookNotification(context.Background(), tt.event)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr == errAny && err == nil:
+				t.Fatal("expected an error")
+			case tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if tt.check != nil {
+				tt.check(t, repo, gateway)
+			}
+		})
+	}
+}
+
+var (
+	errAny     = errors.New("any error")
+	errHandled = errors.New("handled")
+)
+
+// uuidRepository rejects payment lookups by a non-UUID ID like the Postgres id column does.
+type uuidRepository struct {
+	*MemoryRepository
+}
+
+func (r uuidRepository) FindPaymentByID(ctx context.Context, id string) (PaymentRecord, error) {
+	if !isUUID(id) {
+		return PaymentRecord{}, errors.New("pq: invalid input syntax for type uuid")
+	}
+	return r.MemoryRepository.FindPaymentByID(ctx, id)
+}
+
+func TestPaymentEventWithForeignExternalReference(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	seedPayment(t, repo, "pay_1")
+	service := NewService(uuidRepository{repo}, newFakeGateway())
+
+	event := NotificationEvent{Event: "PAYMENT_RECEIVED", Payment: &PaymentResponse{ID: "pay_1", ExternalReference: "pedido-42", Status: "RECEIVED"}}
+	if err := service.HandleWebhookNotification(ctx, event); err != nil {
+		t.Fatalf("expected the event to match by Asaas ID, got %v", err)
+	}
+	payment, err := repo.FindPaymentByID(ctx, seededPaymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != "RECEIVED" {
+		t.Fatalf("expected status RECEIVED, got %q", payment.Status)
+	}
+}
+
+func TestInstallmentPaymentIsImportedOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	gateway := newFakeGateway()
+	service := NewService(repo, gateway)
+	seedCustomer(t, repo)
+	now := time.Now().UTC()
+	installment := InstallmentRecord{ID: "inst-1", AsaasID: "ins_1", CustomerID: "cust-1", BillingType: "BOLETO", InstallmentCount: 3, Status: "PENDING", CreatedAt: now, UpdatedAt: now}
+	if err := repo.SaveInstallment(ctx, installment); err != nil {
+		t.Fatal(err)
+	}
+	remote := PaymentResponse{ID: "pay_2", Installment: "ins_1", InstallmentNumber: 2, BillingType: "BOLETO", Value: NewMoney(30, 0), Status: "PENDING"}
+
+	// The webhook and a sync both miss the payment and import it, as if they ran at once.
+	first, err := service.importInstallmentPayment(ctx, installment, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.importInstallmentPayment(ctx, installment, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != first.ID {
+		t.Fatalf("expected the stored payment %s, got %s", first.ID, second.ID)
+	}
+	payments, err := repo.ListPaymentsByInstallmentID(ctx, installment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 || gateway.externalReferences["pay_2"] != first.ID {
+		t.Fatalf("expected a single imported payment, got %+v", payments)
+	}
+}
+
+func TestInstallmentEventWaitsForPendingPayment(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	service := NewService(repo, newFakeGateway())
+	seedCustomer(t, repo)
+	now := time.Now().UTC()
+	installment := InstallmentRecord{ID: "inst-1", AsaasID: "ins_1", CustomerID: "cust-1", BillingType: "BOLETO", InstallmentCount: 3, Status: "PENDING", CreatedAt: now, UpdatedAt: now}
+	if err := repo.SaveInstallment(ctx, installment); err != nil {
+		t.Fatal(err)
+	}
+	localID := generateID()
+	op, err := service.beginPendingOperation(ctx, PendingOperationPayment, localID, PaymentRecord{ID: localID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := NotificationEvent{Event: "PAYMENT_CREATED", Payment: &PaymentResponse{ID: "pay_1", Installment: "ins_1", InstallmentNumber: 1, ExternalReference: localID, Status: "PENDING"}}
+
+	// CreatePayment has not saved the first payment yet, so the event must not import it.
+	if err := service.HandleWebhookNotification(ctx, event); !errors.Is(err, ErrOperationInProgress) {
+		t.Fatalf("expected ErrOperationInProgress, got %v", err)
+	}
+	if payments, _ := repo.ListPaymentsByInstallmentID(ctx, installment.ID); len(payments) != 0 {
+		t.Fatalf("payment imported while its create was pending: %+v", payments)
+	}
+
+	service.confirmPendingOperation(ctx, op)
+	if err := service.HandleWebhookNotification(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	if payments, _ := repo.ListPaymentsByInstallmentID(ctx, installment.ID); len(payments) != 1 {
+		t.Fatalf("expected the payment to be imported, got %+v", payments)
+	}
+}
+
+func TestSaveInstallmentPaymentIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	seedPayment(t, repo, "pay_1")
+	now := time.Now().UTC()
+	installment := InstallmentRecord{ID: "inst-1", AsaasID: "ins_1", CustomerID: "cust-1", BillingType: "BOLETO", InstallmentCount: 2, Status: "PENDING", CreatedAt: now, UpdatedAt: now}
+	payment := PaymentRecord{ID: "pay-local-2", AsaasID: "pay_1", CustomerID: "cust-1", InstallmentID: installment.ID, InstallmentNumber: 1, CreatedAt: now, UpdatedAt: now}
+
+	if err := repo.SaveInstallmentPayment(ctx, installment, payment); err == nil {
+		t.Fatal("expected the duplicate Asaas ID to be rejected")
+	}
+	if _, err := repo.FindInstallmentByID(ctx, installment.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("plan kept without its payment: %v", err)
+	}
+}
+
+func TestSubscriptionPaymentIsImportedOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	gateway := newFakeGateway()
+	service := NewService(repo, gateway)
+	seedSubscription(t, repo)
+	subscription, err := repo.FindSubscriptionByID(ctx, "subs-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := PaymentResponse{ID: "pay_sub", Subscription: "sub_1", BillingType: "PIX", Value: NewMoney(49, 90), Status: "PENDING"}
+
+	// The webhook and a sync both miss the payment and import it, as if they ran at once.
+	first, inserted, err := service.importSubscriptionPayment(ctx, subscription, remote)
+	if err != nil || !inserted {
+		t.Fatalf("first import: %v %v", inserted, err)
+	}
+	second, inserted, err := service.importSubscriptionPayment(ctx, subscription, remote)
+	if err != nil || inserted {
+		t.Fatalf("second import: %v %v", inserted, err)
+	}
+	if second.ID != first.ID {
+		t.Fatalf("expected the stored payment %s, got %s", first.ID, second.ID)
+	}
+	page, err := repo.ListPayments(ctx, ListFilter{SubscriptionID: subscription.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || gateway.externalReferences["pay_sub"] != first.ID {
+		t.Fatalf("expected a single imported payment, got %+v", page.Items)
+	}
+}
+
+func TestSubscriptionExternalReferenceIsRetried(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	gateway := newFakeGateway()
+	service := NewService(repo, gateway)
+	seedSubscription(t, repo)
+	gateway.payments["pay_sub"] = PaymentResponse{ID: "pay_sub", Subscription: "sub_1", BillingType: "PIX", Value: NewMoney(49, 90), Status: "PENDING"}
+
+	gateway.externalRefErr = errors.New("timeout")
+	imported, err := service.SyncSubscriptionPayments(ctx, "subs-1")
+	if err == nil || len(imported) != 1 {
+		t.Fatalf("expected the payment to be saved and the update to fail, got %+v %v", imported, err)
+	}
+
+	gateway.externalRefErr = nil
+	again, err := service.SyncSubscriptionPayments(ctx, "subs-1")
+	if err != nil || len(again) != 0 {
+		t.Fatalf("expected nothing new to import, got %+v %v", again, err)
+	}
+	if gateway.externalReferences["pay_sub"] != imported[0].ID {
+		t.Fatalf("externalReference not fixed: %q", gateway.externalReferences["pay_sub"])
+	}
+
+	// The PAYMENT_CREATED webhook fixes it as well when it races a sync.
+	gateway.externalReferences = map[string]string{}
+	if _, inserted, err := service.importSubscriptionPayment(ctx, SubscriptionRecord{ID: "subs-1", CustomerID: "cust-1"}, gateway.payments["pay_sub"]); err != nil || inserted {
+		t.Fatalf("expected the stored payment, got inserted %v, %v", inserted, err)
+	}
+	if gateway.externalReferences["pay_sub"] != imported[0].ID {
+		t.Fatalf("externalReference not fixed by the import: %q", gateway.externalReferences["pay_sub"])
+	}
+}
+
+func TestInstallmentExternalReferenceIsRetried(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	gateway := newFakeGateway()
+	service := NewService(repo, gateway)
+	seedCustomer(t, repo)
+	now := time.Now().UTC()
+	installment := InstallmentRecord{ID: "inst-1", AsaasID: "ins_1", CustomerID: "cust-1", BillingType: "BOLETO", InstallmentCount: 2, Status: "PENDING", CreatedAt: now, UpdatedAt: now}
+	if err := repo.SaveInstallment(ctx, installment); err != nil {
+		t.Fatal(err)
+	}
+	gateway.payments["pay_2"] = PaymentResponse{ID: "pay_2", Installment: "ins_1", InstallmentNumber: 2, BillingType: "BOLETO", Value: NewMoney(30, 0), Status: "PENDING"}
+
+	gateway.externalRefErr = errors.New("timeout")
+	if err := service.syncInstallmentPayments(ctx, installment); err == nil {
+		t.Fatal("expected the externalReference update to fail")
+	}
+	payments, _ := repo.ListPaymentsByInstallmentID(ctx, installment.ID)
+	if len(payments) != 1 {
+		t.Fatalf("expected the payment to be saved, got %+v", payments)
+	}
+
+	gateway.externalRefErr = nil
+	event := NotificationEvent{Event: "PAYMENT_CREATED", Payment: &PaymentResponse{ID: "pay_2", Installment: "ins_1", InstallmentNumber: 2, Status: "PENDING"}}
+	if err := service.HandleWebhookNotification(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	if gateway.externalReferences["pay_2"] != payments[0].ID {
+		t.Fatalf("externalReference not fixed by the webhook: %q", gateway.externalReferences["pay_2"])
+	}
+	gateway.externalReferences = map[string]string{}
+	if err := service.syncInstallmentPayments(ctx, installment); err != nil {
+		t.Fatal(err)
+	}
+	if gateway.externalReferences["pay_2"] != payments[0].ID {
+		t.Fatalf("externalReference not fixed by the sync: %q", gateway.externalReferences["pay_2"])
+	}
+}
+
+func TestRefundPaymentSendsRemainingValue(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	gateway := newFakeGateway()
+	service := NewService(repo, gateway)
+	seedPayment(t, repo, "pay_1")
+	now := time.Now().UTC()
+	partial := RefundRecord{ID: generateID(), PaymentID: seededPaymentID, Value: NewMoney(40, 0), Status: RefundStatusDone, CreatedAt: now, UpdatedAt: now}
+	if err := repo.SaveRefund(ctx, partial); err != nil {
+		t.Fatal(err)
+	}
+
+	refund, _, err := service.RefundPayment(ctx, seededPaymentID, RefundRequest{Description: "resto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := NewMoney(60, 50)
+	if refund.Value != want {
+		t.Fatalf("expected local refund of %s, got %s", want, refund.Value)
+	}
+	if len(gateway.refunds) != 1 || gateway.refunds[0].Value != want {
+		t.Fatalf("expected Asaas to be asked for %s, got %+v", want, gateway.refunds)
+	}
+}
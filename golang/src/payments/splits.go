@@ -0,0 +1,280 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Split statuses. Payment splits take the statuses Asaas reports; subscription
+// splits are ACTIVE until Asaas blocks or disables them.
+const (
+	SplitStatusPending   = "PENDING"
+	SplitStatusCancelled = "CANCELLED"
+	SplitStatusBlocked   = "BLOCKED_BY_VALUE_DIVERGENCE"
+	SplitStatusActive    = "ACTIVE"
+	SplitStatusDisabled  = "DISABLED"
+)
+
+// ErrInvalidSplit is returned when the splits of a request cannot be credited.
+var ErrInvalidSplit = errors.New("split inválido")
+
+// splitEventStatuses is the status each split webhook gives to the splits its
+// payload does not describe.
+var splitEventStatuses = map[string]string{
+	"PAYMENT_SPLIT_CANCELLED":                      SplitStatusCancelled,
+	"PAYMENT_SPLIT_DIVERGENCE_BLOCK":               SplitStatusBlocked,
+	"PAYMENT_SPLIT_DIVERGENCE_BLOCK_FINISHED":      SplitStatusPending,
+	"SUBSCRIPTION_SPLIT_DISABLED":                  SplitStatusDisabled,
+	"SUBSCRIPTION_SPLIT_DIVERGENCE_BLOCK":          SplitStatusBlocked,
+	"SUBSCRIPTION_SPLIT_DIVERGENCE_BLOCK_FINISHED": SplitStatusActive,
+}
+
+// validateSplits checks the splits of a charge of the given value. Total is the
+// value of the whole installment plan, and zero for other charges, which cannot use
+// totalFixedValue. Each wallet appears once, percentages add up to at most 100%, and
+// the shares cannot exceed the value; values are only compared when known.
+func validateSplits(value, total Money, splits []Split) error {
+	wallets := make(map[string]bool, len(splits))
+	var fixed, totalFixed Money
+	var percentual Percentage
+	for _, split := range splits {
+		switch {
+		case split.WalletID == "":
+			return fmt.Errorf("%w: walletId ausente", ErrInvalidSplit)
+		case wallets[split.WalletID]:
+			return fmt.Errorf("%w: carteira %s repetida", ErrInvalidSplit, split.WalletID)
+		case split.FixedValue < 0 || split.PercentualValue < 0 || split.TotalFixedValue < 0:
+			return fmt.Errorf("%w: valores negativos para a carteira %s", ErrInvalidSplit, split.WalletID)
+		case split.FixedValue == 0 && split.PercentualValue == 0 && split.TotalFixedValue == 0:
+			return fmt.Errorf("%w: informe fixedValue, percentualValue ou totalFixedValue para a carteira %s", ErrInvalidSplit, split.WalletID)
+		case split.TotalFixedValue > 0 && total == 0:
+			return fmt.Errorf("%w: totalFixedValue só vale para parcelamentos", ErrInvalidSplit)
+		}
+		wallets[split.WalletID] = true
+		fixed += split.FixedValue
+		percentual += split.PercentualValue
+		totalFixed += split.TotalFixedValue
+	}
+	switch {
+	case percentual > maxPercentage:
+		return fmt.Errorf("%w: percentuais somam %s%%, acima de 100%%", ErrInvalidSplit, percentual)
+	case value > 0 && fixed+percentual.Of(value) > value:
+		return fmt.Errorf("%w: divisão acima do valor de %s", ErrInvalidSplit, value)
+	case total > 0 && totalFixed > total:
+		return fmt.Errorf("%w: totalFixedValue de %s acima do total de %s", ErrInvalidSplit, totalFixed, total)
+	}
+	return nil
+}
+
+// installmentTotal is the total value of the plan a payment request creates, or
+// zero when it is not an installment plan.
+func installmentTotal(req PaymentRequest) Money {
+	switch {
+	case req.TotalValue > 0:
+		return req.TotalValue
+	case req.InstallmentValue > 0:
+		return req.InstallmentValue * Money(req.InstallmentCount)
+	default:
+		return 0
+	}
+}
+
+// PaymentSplits returns the splits of a payment from the local database.
+func (s *Service) PaymentSplits(ctx context.Context, id string) ([]SplitRecord, error) {
+	if _, err := s.repo.FindPaymentByID(ctx, id); err != nil {
+		return nil, fmt.Errorf("falha ao localizar pagamento %s: %w", id, err)
+	}
+	splits, err := s.repo.ListSplitsByPaymentID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao listar splits do pagamento %s: %w", id, err)
+	}
+	if splits == nil {
+		splits = []SplitRecord{}
+	}
+	return splits, nil
+}
+
+// SubscriptionSplits returns the splits of a subscription from the local database.
+func (s *Service) SubscriptionSplits(ctx context.Context, id string) ([]SplitRecord, error) {
+	if _, err := s.repo.FindSubscriptionByID(ctx, id); err != nil {
+		return nil, fmt.Errorf("falha ao localizar assinatura %s: %w", id, err)
+	}
+	splits, err := s.repo.ListSplitsBySubscriptionID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao listar splits da assinatura %s: %w", id, err)
+	}
+	if splits == nil {
+		splits = []SplitRecord{}
+	}
+	return splits, nil
+}
+
+// saveNewSplits stores the splits of a charge just saved: those returned by Asaas,
+// or the requested ones when the response omits them. The charge is already saved,
+// so failures are only logged; the split webhooks store the missing splits later.
+func (s *Service) saveNewSplits(ctx context.Context, paymentID, subscriptionID string, remote, requested []Split) {
+	splits := remote
+	if len(splits) == 0 {
+		splits = requested
+	}
+	if len(splits) == 0 {
+		return
+	}
+	status := SplitStatusPending
+	if subscriptionID != "" {
+		status = SplitStatusActive
+	}
+	if err := s.syncSplits(ctx, paymentID, subscriptionID, splits, status); err != nil {
+		log.Printf("failed to save splits of payment %q subscription %q: %v", paymentID, subscriptionID, err)
+	}
+}
+
+// syncSplits matches the splits listed by Asaas to the local ones of a payment or
+// subscription, by Asaas ID or else by wallet, updating those that changed and
+// inserting the others. Splits listed without a status take fallbackStatus.
+func (s *Service) syncSplits(ctx context.Context, paymentID, subscriptionID string, remote []Split, fallbackStatus string) error {
+	local, err := s.listSplits(ctx, paymentID, subscriptionID)
+	if err != nil {
+		return err
+	}
+	matched := make([]bool, len(local))
+	for _, remoteSplit := range remote {
+		status := remoteSplit.Status
+		if status == "" {
+			status = fallbackStatus
+		}
+		j := matchSplit(local, matched, remoteSplit)
+		if j < 0 {
+			now := time.Now().UTC()
+			split := SplitRecord{
+				ID:                 generateID(),
+				AsaasID:            remoteSplit.ID,
+				PaymentID:          paymentID,
+				SubscriptionID:     subscriptionID,
+				WalletID:           remoteSplit.WalletID,
+				FixedValue:         remoteSplit.FixedValue,
+				PercentualValue:    remoteSplit.PercentualValue,
+				TotalFixedValue:    remoteSplit.TotalFixedValue,
+				TotalValue:         remoteSplit.TotalValue,
+				Status:             status,
+				CancellationReason: remoteSplit.CancellationReason,
+				CreatedAt:          now,
+				UpdatedAt:          now,
+			}
+			if err := s.repo.SaveSplit(ctx, split); err != nil {
+				return err
+			}
+			continue
+		}
+
+		matched[j] = true
+		split := local[j]
+		updated := split
+		if remoteSplit.ID != "" {
+			updated.AsaasID = remoteSplit.ID
+		}
+		if remoteSplit.TotalValue != 0 {
+			updated.TotalValue = remoteSplit.TotalValue
+		}
+		updated.Status = status
+		updated.CancellationReason = remoteSplit.CancellationReason
+		if updated == split {
+			continue
+		}
+		if err := s.repo.UpdateSplit(ctx, updated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchSplit returns the index of the unmatched local split with the Asaas ID of
+// remote, or else with its wallet and no other Asaas ID, or -1.
+func matchSplit(local []SplitRecord, matched []bool, remote Split) int {
+	if remote.ID != "" {
+		for i, split := range local {
+			if !matched[i] && split.AsaasID == remote.ID {
+				return i
+			}
+		}
+	}
+	for i, split := range local {
+		if !matched[i] && split.WalletID == remote.WalletID && (split.AsaasID == "" || remote.ID == "") {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Service) listSplits(ctx context.Context, paymentID, subscriptionID string) ([]SplitRecord, error) {
+	if paymentID != "" {
+		return s.repo.ListSplitsByPaymentID(ctx, paymentID)
+	}
+	return s.repo.ListSplitsBySubscriptionID(ctx, subscriptionID)
+}
+
+// applySplitEvent brings the splits of a payment or subscription in line with a split
+// webhook. When the payload does not list the splits, the event status applies to
+// all of them.
+func (s *Service) applySplitEvent(ctx context.Context, paymentID, subscriptionID string, listed []Split, eventType string) error {
+	status := splitEventStatuses[eventType]
+	if len(listed) > 0 {
+		return s.syncSplits(ctx, paymentID, subscriptionID, listed, status)
+	}
+	splits, err := s.listSplits(ctx, paymentID, subscriptionID)
+	if err != nil {
+		return err
+	}
+	for _, split := range splits {
+		if split.Status == status {
+			continue
+		}
+		split.Status = status
+		if err := s.repo.UpdateSplit(ctx, split); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handlePaymentSplitEvent updates the splits of a payment from a PAYMENT_SPLIT_*
+// webhook. The status of the payment itself is left untouched.
+func (s *Service) handlePaymentSplitEvent(ctx context.Context, event NotificationEvent) error {
+	if event.Payment == nil {
+		return fmt.Errorf("payload de pagamento ausente")
+	}
+	payment, err := s.findPaymentForEvent(ctx, *event.Payment)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if err := s.applySplitEvent(ctx, payment.ID, "", event.Payment.Split, event.Event); err != nil {
+		return fmt.Errorf("falha ao atualizar splits do pagamento %s: %w", payment.ID, err)
+	}
+	return nil
+}
+
+// handleSubscriptionSplitEvent updates the splits of a subscription from a
+// SUBSCRIPTION_SPLIT_* webhook. The status of the subscription itself is left untouched.
+func (s *Service) handleSubscriptionSplitEvent(ctx context.Context, event NotificationEvent) error {
+	if event.Subscription == nil {
+		return fmt.Errorf("payload de assinatura ausente")
+	}
+	subscription, err := s.repo.FindSubscriptionByID(ctx, event.Subscription.ExternalID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if err := s.applySplitEvent(ctx, "", subscription.ID, event.Subscription.Split, event.Event); err != nil {
+		return fmt.Errorf("falha ao atualizar splits da assinatura %s: %w", subscription.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Subscription statuses after which Asaas no longer generates payments.
+const (
+	SubscriptionStatusInactive = "INACTIVE"
+	SubscriptionStatusExpired  = "EXPIRED"
+)
+
+var (
+	// ErrInvalidSubscriptionUpdate is returned when an update request changes nothing or has invalid fields.
+	ErrInvalidSubscriptionUpdate = errors.New("alteração de assinatura inválida")
+	// ErrSubscriptionInactive is returned when changing a subscription that was cancelled or has ended.
+	ErrSubscriptionInactive = errors.New("assinatura inativa")
+)
+
+// subscriptionCycles are the billing cycles accepted by Asaas.
+var subscriptionCycles = map[string]bool{
+	"WEEKLY":       true,
+	"BIWEEKLY":     true,
+	"MONTHLY":      true,
+	"BIMONTHLY":    true,
+	"QUARTERLY":    true,
+	"SEMIANNUALLY": true,
+	"YEARLY":       true,
+}
+
+// validateSubscriptionUpdate checks an update request before it is sent to Asaas.
+func validateSubscriptionUpdate(req UpdateSubscriptionRequest) error {
+	switch {
+	case req.BillingType == "" && req.Value == 0 && req.NextDueDate == "" && req.Cycle == "":
+		return fmt.Errorf("%w: nenhum campo para alterar", ErrInvalidSubscriptionUpdate)
+	case req.Value < 0:
+		return fmt.Errorf("%w: valor negativo", ErrInvalidSubscriptionUpdate)
+	case req.Cycle != "" && !subscriptionCycles[req.Cycle]:
+		return fmt.Errorf("%w: ciclo %q desconhecido", ErrInvalidSubscriptionUpdate, req.Cycle)
+	}
+	if req.NextDueDate != "" {
+		if _, err := time.Parse("2006-01-02", req.NextDueDate); err != nil {
+			return fmt.Errorf("%w: nextDueDate %q não está no formato AAAA-MM-DD", ErrInvalidSubscriptionUpdate, req.NextDueDate)
+		}
+	}
+	return nil
+}
+
+// UpdateSubscription changes the value, cycle, billing type or next due date of a
+// subscription in Asaas, stores the result locally and records each changed field
+// in the subscription history. With UpdatePendingPayments the local payments not yet
+// paid take the new value and billing type, as Asaas does with its own.
+func (s *Service) UpdateSubscription(ctx context.Context, id string, req UpdateSubscriptionRequest) (SubscriptionRecord, SubscriptionResponse, error) {
+	if err := validateSubscriptionUpdate(req); err != nil {
+		return SubscriptionRecord{}, SubscriptionResponse{}, err
+	}
+	subscription, err := s.repo.FindSubscriptionByID(ctx, id)
+	if err != nil {
+		return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao localizar assinatura %s: %w", id, err)
+	}
+	if subscription.Status == SubscriptionStatusInactive || subscription.Status == SubscriptionStatusExpired {
+		return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("%w: assinatura %s está %s", ErrSubscriptionInactive, id, subscription.Status)
+	}
+
+	remoteID, err := s.remoteSubscriptionID(ctx, subscription)
+	if err != nil {
+		return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao buscar assinatura no Asaas para id %s: %w", id, err)
+	}
+	remote, err := s.client.UpdateSubscription(ctx, remoteID, req)
+	if err != nil {
+		return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao atualizar assinatura no Asaas: %w", err)
+	}
+
+	// Asaas answers with the full subscription, so the local copy mirrors what it kept.
+	updated := subscription
+	updated.BillingType = remote.BillingType
+	updated.Value = remote.Value
+	updated.Cycle = remote.Cycle
+	updated.NextDueDate = parseDate(remote.NextDueDate)
+	updated.Status = remote.Status
+	updated.UpdatedAt = time.Now().UTC()
+	if err := s.repo.UpdateSubscription(ctx, updated); err != nil {
+		return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao atualizar assinatura local: %w", err)
+	}
+
+	for _, change := range subscriptionChanges(subscription, updated, req.UpdatePendingPayments) {
+		if err := s.repo.SaveSubscriptionChange(ctx, change); err != nil {
+			return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao registrar histórico da assinatura %s: %w", id, err)
+		}
+	}
+	if req.UpdatePendingPayments {
+		if err := s.repo.UpdatePendingSubscriptionPayments(ctx, updated.ID, updated.BillingType, updated.Value); err != nil {
+			return SubscriptionRecord{}, SubscriptionResponse{}, fmt.Errorf("falha ao atualizar cobranças pendentes da assinatura %s: %w", id, err)
+		}
+	}
+
+	return updated, remote.WithoutCardToken(), nil
+}
+
+// SubscriptionChanges returns the history of changes of a subscription, oldest first.
+func (s *Service) SubscriptionChanges(ctx context.Context, id string) ([]SubscriptionChangeRecord, error) {
+	if _, err := s.repo.FindSubscriptionByID(ctx, id); err != nil {
+		return nil, fmt.Errorf("falha ao localizar assinatura %s: %w", id, err)
+	}
+	changes, err := s.repo.ListSubscriptionChanges(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao listar histórico da assinatura %s: %w", id, err)
+	}
+	if changes == nil {
+		changes = []SubscriptionChangeRecord{}
+	}
+	return changes, nil
+}
+
+// SubscriptionPayments lists the local payments of a subscription, newest first.
+func (s *Service) SubscriptionPayments(ctx context.Context, id string, filter ListFilter) (Page[PaymentRecord], error) {
+	if _, err := s.repo.FindSubscriptionByID(ctx, id); err != nil {
+		return Page[PaymentRecord]{}, fmt.Errorf("falha ao localizar assinatura %s: %w", id, err)
+	}
+	filter.SubscriptionID = id
+	return s.ListPayments(ctx, filter)
+}
+
+// SyncSubscriptionPayments imports the payments Asaas generated for a subscription
+// that are not stored locally, as the PAYMENT_CREATED webhook does, and returns them.
+// Payments already stored are left untouched, except for an externalReference in Asaas
+// that an earlier import failed to point to the local ID. Import failures do not stop
+// the sync; they are joined in the returned error.
+func (s *Service) SyncSubscriptionPayments(ctx context.Context, id string) ([]PaymentRecord, error) {
+	subscription, err := s.repo.FindSubscriptionByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao localizar assinatura %s: %w", id, err)
+	}
+	remoteID, err := s.remoteSubscriptionID(ctx, subscription)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao buscar assinatura no Asaas para id %s: %w", id, err)
+	}
+	remotes, err := s.client.ListSubscriptionPayments(ctx, remoteID).All()
+	if err != nil {
+		return nil, fmt.Errorf("falha ao listar cobranças da assinatura no Asaas: %w", err)
+	}
+
+	imported := []PaymentRecord{}
+	var errs []error
+	for _, remote := range remotes {
+		payment, err := s.findPaymentForEvent(ctx, remote)
+		if err == nil {
+			err = s.linkExternalReference(ctx, payment, remote)
+		} else if errors.Is(err, sql.ErrNoRows) {
+			var payment PaymentRecord
+			var inserted bool
+			payment, inserted, err = s.importSubscriptionPayment(ctx, subscription, remote)
+			if inserted {
+				imported = append(imported, payment)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cobrança %s: %w", remote.ID, err))
+		}
+	}
+	return imported, errors.Join(errs...)
+}
+
+// importSubscriptionPayment saves a payment generated by a subscription under a new
+// local ID and points its externalReference in Asaas to it. When a concurrent import
+// stored the payment first, that payment is returned with inserted false, and only its
+// externalReference is fixed if needed.
+func (s *Service) importSubscriptionPayment(ctx context.Context, subscription SubscriptionRecord, remote PaymentResponse) (payment PaymentRecord, inserted bool, err error) {
+	now := time.Now().UTC()
+	payment = PaymentRecord{
+		ID:                    generateID(),
+		AsaasID:               remote.ID,
+		CustomerID:            subscription.CustomerID,
+		SubscriptionID:        subscription.ID,
+		BillingType:           remote.BillingType,
+		Value:                 remote.Value,
+		DueDate:               parseDate(remote.DueDate),
+		Description:           remote.Description,
+		Status:                remote.Status,
+		InvoiceURL:            remote.InvoiceURL,
+		TransactionReceiptURL: remote.TransactionReceiptURL,
+		ChargeTerms:           newChargeTerms(remote.Discount, remote.Interest, remote.Fine),
+		CreatedAt:             now,
+		UpdatedAt:             now,
+	}
+
+	payment, inserted, err = s.repo.SaveImportedPayment(ctx, payment)
+	if err != nil {
+		return PaymentRecord{}, false, fmt.Errorf("falha ao salvar pagamento local: %w", err)
+	}
+	if !inserted {
+		return payment, false, s.linkExternalReference(ctx, payment, remote)
+	}
+	s.saveNewSplits(ctx, payment.ID, "", remote.Split, nil)
+	return payment, true, s.linkExternalReference(ctx, payment, remote)
+}
+
+// linkExternalReference points the externalReference of a payment in Asaas to its
+// local ID when it does not already. The row is saved first, so a failure here is
+// fixed by the next import or sync that finds it.
+func (s *Service) linkExternalReference(ctx context.Context, payment PaymentRecord, remote PaymentResponse) error {
+	if remote.ID == "" || remote.ExternalReference == payment.ID {
+		return nil
+	}
+	if err := s.client.UpdatePaymentExternalReference(ctx, remote.ID, payment.ID); err != nil {
+		return fmt.Errorf("falha ao atualizar externalReference do pagamento: %w", err)
+	}
+	return nil
+}
+
+// subscriptionChanges lists the fields that differ between two versions of a subscription.
+func subscriptionChanges(previous, updated SubscriptionRecord, updatePendingPayments bool) []SubscriptionChangeRecord {
+	fields := []struct {
+		name, before, after string
+	}{
+		{"billingType", previous.BillingType, updated.BillingType},
+		{"value", previous.Value.String(), updated.Value.String()},
+		{"cycle", previous.Cycle, updated.Cycle},
+		{"nextDueDate", formatDate(previous.NextDueDate), formatDate(updated.NextDueDate)},
+	}
+	now := time.Now().UTC()
+	var changes []SubscriptionChangeRecord
+	for _, field := range fields {
+		if field.before == field.after {
+			continue
+		}
+		changes = append(changes, SubscriptionChangeRecord{
+			ID:                    generateID(),
+			SubscriptionID:        updated.ID,
+			Field:                 field.name,
+			PreviousValue:         field.before,
+			NewValue:              field.after,
+			UpdatePendingPayments: updatePendingPayments,
+			CreatedAt:             now,
+		})
+	}
+	return changes
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
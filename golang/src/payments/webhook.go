@@ -2,15 +2,212 @@ package payments
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
 )
 
-// HandleWebhookPayload parses and dispatches webhook events.
+// Webhook journal statuses.
+const (
+	WebhookEventStatusReceived   = "RECEIVED"
+	WebhookEventStatusProcessing = "PROCESSING"
+	WebhookEventStatusProcessed  = "PROCESSED"
+	// WebhookEventStatusFailed events are retried by the queue after a backoff.
+	WebhookEventStatusFailed = "FAILED"
+	// WebhookEventStatusDead events exhausted their attempts and are only run again by a replay.
+	WebhookEventStatusDead = "DEAD"
+	// WebhookEventStatusUnhandled events had no handler when received and wait for a replay.
+	WebhookEventStatusUnhandled = "UNHANDLED"
+)
+
+// ErrInvalidPayload is returned when a webhook body is not a valid Asaas event.
+var ErrInvalidPayload = errors.New("payload inv\u00e1lido")
+
+// ErrWebhookEventBusy is returned when a journaled event is being processed by someone else.
+var ErrWebhookEventBusy = errors.New("evento do webhook em processamento")
+
+// ReplayResult summarizes a replay of journaled webhook events.
+type ReplayResult struct {
+	Processed int
+	Failed    int
+}
+
+// EnqueueWebhookPayload journals a webhook event for the background workers and returns
+// without processing it. Redeliveries of journaled events are acknowledged.
+func (s *Service) EnqueueWebhookPayload(ctx context.Context, payload []byte) error {
+	_, _, _, err := s.admitWebhookPayload(ctx, payload)
+	return err
+}
+
+// HandleWebhookPayload journals, parses and dispatches webhook events synchronously.
+// Events already processed, or being processed by a worker, are acknowledged without
+// running them again.
 func (s *Service) HandleWebhookPayload(ctx context.Context, payload []byte) error {
+	event, record, pending, err := s.admitWebhookPayload(ctx, payload)
+	if err != nil || !pending {
+		return err
+	}
+	err = s.claimAndProcessWebhookEvent(ctx, record, event)
+	if errors.Is(err, ErrWebhookEventBusy) {
+		return nil
+	}
+	return err
+}
+
+// admitWebhookPayload parses a delivery, applies the unknown event policy and journals it.
+// It reports whether the journaled event still has to be processed.
+func (s *Service) admitWebhookPayload(ctx context.Context, payload []byte) (NotificationEvent, WebhookEventRecord, bool, error) {
 	var event NotificationEvent
 	if err := json.Unmarshal(payload, &event); err != nil {
-		return fmt.Errorf("payload inv\u00e1lido")
+		return NotificationEvent{}, WebhookEventRecord{}, false, ErrInvalidPayload
+	}
+	eventID := webhookEventID(event, payload)
+
+	status := WebhookEventStatusReceived
+	if !s.SupportsWebhookEvent(event.Event) {
+		switch s.unknownEventPolicy() {
+		case UnknownEventReject:
+			return NotificationEvent{}, WebhookEventRecord{}, false, fmt.Errorf("%w: %s", ErrUnsupportedEvent, event.Event)
+		case UnknownEventAcknowledge:
+			logUnknownEvent(eventID, event)
+			return event, WebhookEventRecord{}, false, nil
+		default:
+			status = WebhookEventStatusUnhandled
+		}
+	}
+
+	now := time.Now().UTC()
+	record, _, err := s.repo.SaveWebhookEvent(ctx, WebhookEventRecord{
+		ID:            generateID(),
+		EventID:       eventID,
+		EventType:     event.Event,
+		Payload:       payload,
+		Status:        status,
+		NextAttemptAt: now,
+		ReceivedAt:    now,
+		UpdatedAt:     now,
+	})
+	if err != nil {
+		return NotificationEvent{}, WebhookEventRecord{}, false, fmt.Errorf("falha ao registrar evento do webhook: %w", err)
+	}
+	pending := record.Status != WebhookEventStatusProcessed && record.Status != WebhookEventStatusUnhandled
+	return event, record, pending, nil
+}
+
+// ReplayWebhookEvent runs a journaled event again, whatever its current status. Events
+// being processed by a worker are not run twice; ErrWebhookEventBusy is returned instead.
+func (s *Service) ReplayWebhookEvent(ctx context.Context, eventID string) error {
+	record, err := s.repo.FindWebhookEventByEventID(ctx, eventID)
+	if err != nil {
+		return fmt.Errorf("falha ao localizar evento %s: %w", eventID, err)
+	}
+	return s.replayWebhookRecord(ctx, record)
+}
+
+// ReplayWebhookEvents runs again every journaled event received in the [from, to) interval.
+func (s *Service) ReplayWebhookEvents(ctx context.Context, from, to time.Time) (ReplayResult, error) {
+	var result ReplayResult
+	records, err := s.repo.ListWebhookEvents(ctx, from, to)
+	if err != nil {
+		return result, fmt.Errorf("falha ao listar eventos do webhook: %w", err)
+	}
+	var errs []error
+	for _, record := range records {
+		if err := s.replayWebhookRecord(ctx, record); err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			result.Failed++
+			errs = append(errs, fmt.Errorf("evento %s: %w", record.EventID, err))
+			continue
+		}
+		result.Processed++
+	}
+	return result, errors.Join(errs...)
+}
+
+func (s *Service) replayWebhookRecord(ctx context.Context, record WebhookEventRecord) error {
+	var event NotificationEvent
+	if err := json.Unmarshal(record.Payload, &event); err != nil {
+		return fmt.Errorf("payload armazenado inválido: %w", err)
+	}
+	return s.claimAndProcessWebhookEvent(ctx, record, event)
+}
+
+// claimAndProcessWebhookEvent locks a journaled event like the queue workers do before
+// running it, so the same event never runs twice at once.
+func (s *Service) claimAndProcessWebhookEvent(ctx context.Context, record WebhookEventRecord, event NotificationEvent) error {
+	claimed, ok, err := s.repo.ClaimWebhookEventByID(ctx, record.ID, time.Now().UTC().Add(-s.webhookQueue.LockTimeout))
+	if err != nil {
+		return fmt.Errorf("falha ao bloquear evento do webhook: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrWebhookEventBusy, record.EventID)
+	}
+	return s.processWebhookEvent(ctx, claimed, event)
+}
+
+// processWebhookEvent runs an event and records the outcome. Failures, panics included,
+// are scheduled for another attempt with backoff until the queue retry policy is exhausted.
+func (s *Service) processWebhookEvent(ctx context.Context, record WebhookEventRecord, event NotificationEvent) error {
+	err := handleRecovered(func() error { return s.HandleWebhookNotification(ctx, event) })
+	if errors.Is(err, ErrUnsupportedEvent) {
+		switch s.unknownEventPolicy() {
+		case UnknownEventAcknowledge:
+			logUnknownEvent(record.EventID, event)
+			err = nil
+		case UnknownEventStore:
+			return s.repo.UpdateWebhookEventStatus(ctx, record.ID, WebhookEventStatusUnhandled, err.Error(), time.Time{})
+		}
+	}
+	if err != nil {
+		policy := s.webhookQueue.Retry
+		attempts := record.Attempts + 1
+		status := WebhookEventStatusFailed
+		nextAttemptAt := time.Now().UTC().Add(policy.backoff(attempts))
+		if attempts >= policy.attempts() {
+			status = WebhookEventStatusDead
+			nextAttemptAt = time.Time{}
+		}
+		if updateErr := s.repo.UpdateWebhookEventStatus(context.WithoutCancel(ctx), record.ID, status, err.Error(), nextAttemptAt); updateErr != nil {
+			return errors.Join(err, updateErr)
+		}
+		return err
+	}
+
+	// Subscriber failures are kept in the journal but do not fail the event.
+	message := ""
+	if handlerErrs := s.notifySubscribers(ctx, record.EventID, event); len(handlerErrs) > 0 {
+		message = errors.Join(handlerErrs...).Error()
+	}
+	if err := s.repo.UpdateWebhookEventStatus(ctx, record.ID, WebhookEventStatusProcessed, message, time.Time{}); err != nil {
+		return fmt.Errorf("falha ao atualizar evento do webhook: %w", err)
+	}
+	return nil
+}
+
+// handleRecovered runs a webhook handler, turning a panic into an error so that one
+// event cannot stop the process.
+func handleRecovered(handle func() error) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("webhook handler panic: %v\n%s", rec, debug.Stack())
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+	return handle()
+}
+
+// webhookEventID returns the Asaas event ID, or a hash of the payload for deliveries without one.
+func webhookEventID(event NotificationEvent, payload []byte) string {
+	if event.ID != "" {
+		return event.ID
 	}
-	return s.HandleWebhookNotification(ctx, event)
+	sum := sha256.Sum256(payload)
+	return "sha256:" + hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,166 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// EventFamily groups webhook event types by the object they refer to.
+type EventFamily string
+
+const (
+	EventFamilyPayment      EventFamily = "PAYMENT"
+	EventFamilySubscription EventFamily = "SUBSCRIPTION"
+	EventFamilyInvoice      EventFamily = "INVOICE"
+)
+
+// FamilyOf returns the family of an event type, based on its prefix.
+func FamilyOf(eventType string) EventFamily {
+	family, _, _ := strings.Cut(eventType, "_")
+	return EventFamily(family)
+}
+
+// PaymentEvent is delivered to payment subscribers.
+type PaymentEvent struct {
+	ID      string
+	Type    string
+	Payment PaymentResponse
+}
+
+// SubscriptionEvent is delivered to subscription subscribers.
+type SubscriptionEvent struct {
+	ID           string
+	Type         string
+	Subscription SubscriptionResponse
+}
+
+// InvoiceEvent is delivered to invoice subscribers.
+type InvoiceEvent struct {
+	ID      string
+	Type    string
+	Invoice InvoiceResponse
+}
+
+// HandlerError reports a subscriber that failed while handling an event.
+type HandlerError struct {
+	Handler   string
+	EventID   string
+	EventType string
+	Err       error
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("handler %s falhou no evento %s (%s): %v", e.Handler, e.EventID, e.EventType, e.Err)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// eventSubscriber is a handler registered with one of the On*Event methods.
+type eventSubscriber struct {
+	name       string
+	family     EventFamily
+	eventTypes map[string]bool
+	run        func(ctx context.Context, eventID string, event NotificationEvent) error
+}
+
+func (sub eventSubscriber) matches(eventType string) bool {
+	if FamilyOf(eventType) != sub.family {
+		return false
+	}
+	return len(sub.eventTypes) == 0 || sub.eventTypes[eventType]
+}
+
+// OnPaymentEvent subscribes handler to the given payment event types, or to every
+// payment event when none is given. Subscribers run after the local records are updated.
+func (s *Service) OnPaymentEvent(name string, handler func(ctx context.Context, event PaymentEvent) error, eventTypes ...string) {
+	s.subscribe(name, EventFamilyPayment, eventTypes, func(ctx context.Context, eventID string, event NotificationEvent) error {
+		if event.Payment == nil {
+			return nil
+		}
+		return handler(ctx, PaymentEvent{ID: eventID, Type: event.Event, Payment: *event.Payment})
+	})
+}
+
+// OnSubscriptionEvent subscribes handler to the given subscription event types, or to
+// every subscription event when none is given.
+func (s *Service) OnSubscriptionEvent(name string, handler func(ctx context.Context, event SubscriptionEvent) error, eventTypes ...string) {
+	s.subscribe(name, EventFamilySubscription, eventTypes, func(ctx context.Context, eventID string, event NotificationEvent) error {
+		if event.Subscription == nil {
+			return nil
+		}
+		return handler(ctx, SubscriptionEvent{ID: eventID, Type: event.Event, Subscription: *event.Subscription})
+	})
+}
+
+// OnInvoiceEvent subscribes handler to the given invoice event types, or to every
+// invoice event when none is given.
+func (s *Service) OnInvoiceEvent(name string, handler func(ctx context.Context, event InvoiceEvent) error, eventTypes ...string) {
+	s.subscribe(name, EventFamilyInvoice, eventTypes, func(ctx context.Context, eventID string, event NotificationEvent) error {
+		if event.Invoice == nil {
+			return nil
+		}
+		return handler(ctx, InvoiceEvent{ID: eventID, Type: event.Event, Invoice: *event.Invoice})
+	})
+}
+
+// SetHandlerErrorReporter replaces the function notified when a subscriber fails.
+// By default failures are logged.
+func (s *Service) SetHandlerErrorReporter(report func(*HandlerError)) {
+	s.webhooks.mu.Lock()
+	defer s.webhooks.mu.Unlock()
+	s.webhooks.reportError = report
+}
+
+func (s *Service) subscribe(name string, family EventFamily, eventTypes []string, run func(context.Context, string, NotificationEvent) error) {
+	sub := eventSubscriber{name: name, family: family, run: run}
+	if len(eventTypes) > 0 {
+		sub.eventTypes = make(map[string]bool, len(eventTypes))
+		for _, eventType := range eventTypes {
+			sub.eventTypes[eventType] = true
+		}
+	}
+	s.webhooks.mu.Lock()
+	defer s.webhooks.mu.Unlock()
+	s.webhooks.subscribers = append(s.webhooks.subscribers, sub)
+}
+
+// notifySubscribers runs every subscriber of the event. A failing or panicking
+// subscriber is reported and does not stop the others.
+func (s *Service) notifySubscribers(ctx context.Context, eventID string, event NotificationEvent) []error {
+	s.webhooks.mu.RLock()
+	var subscribers []eventSubscriber
+	for _, sub := range s.webhooks.subscribers {
+		if sub.matches(event.Event) {
+			subscribers = append(subscribers, sub)
+		}
+	}
+	report := s.webhooks.reportError
+	s.webhooks.mu.RUnlock()
+
+	var errs []error
+	for _, sub := range subscribers {
+		if err := runSubscriber(ctx, sub, eventID, event); err != nil {
+			handlerErr := &HandlerError{Handler: sub.name, EventID: eventID, EventType: event.Event, Err: err}
+			if report != nil {
+				report(handlerErr)
+			} else {
+				log.Print(handlerErr)
+			}
+			errs = append(errs, handlerErr)
+		}
+	}
+	return errs
+}
+
+func runSubscriber(ctx context.Context, sub eventSubscriber, eventID string, event NotificationEvent) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+	return sub.run(ctx, eventID, event)
+}
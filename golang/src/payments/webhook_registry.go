@@ -0,0 +1,159 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// ErrUnsupportedEvent is returned for webhook event types with no built-in or registered handler.
+var ErrUnsupportedEvent = errors.New("tipo de evento n\u00e3o suportado")
+
+// UnknownEventPolicy decides what happens to webhook events nobody handles.
+type UnknownEventPolicy string
+
+const (
+	// UnknownEventReject answers the webhook with an error, as the service did originally.
+	UnknownEventReject UnknownEventPolicy = "reject"
+	// UnknownEventAcknowledge logs the event and acknowledges it without storing it.
+	UnknownEventAcknowledge UnknownEventPolicy = "acknowledge"
+	// UnknownEventStore acknowledges the event and keeps it in the journal as UNHANDLED,
+	// so it can be replayed once a handler is registered.
+	UnknownEventStore UnknownEventPolicy = "store"
+)
+
+// ParseUnknownEventPolicy validates a policy name.
+func ParseUnknownEventPolicy(value string) (UnknownEventPolicy, error) {
+	switch policy := UnknownEventPolicy(value); policy {
+	case UnknownEventReject, UnknownEventAcknowledge, UnknownEventStore:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("pol\u00edtica de eventos desconhecidos inv\u00e1lida: %s", value)
+	}
+}
+
+// WebhookEventHandler processes a webhook event type the service does not handle itself.
+type WebhookEventHandler func(ctx context.Context, event NotificationEvent) error
+
+// builtinWebhookEvents lists the event types handled by HandleWebhookNotification.
+// TestBuiltinWebhookEventsMatchSwitch fails when it differs from the switch statement there.
+var builtinWebhookEvents = map[string]bool{
+	"PAYMENT_CREATED":                              true,
+	"INVOICE_CREATED":                              true,
+	"SUBSCRIPTION_CREATED":                         true,
+	"PAYMENT_AUTHORIZED":                           true,
+	"PAYMENT_APPROVED_BY_RISK_ANALYSIS":            true,
+	"PAYMENT_CONFIRMED":                            true,
+	"PAYMENT_ANTICIPATED":                          true,
+	"PAYMENT_DELETED":                              true,
+	"PAYMENT_REFUNDED":                             true,
+	"PAYMENT_REFUND_DENIED":                        true,
+	"PAYMENT_CHARGEBACK_REQUESTED":                 true,
+	"PAYMENT_AWAITING_CHARGEBACK_REVERSAL":         true,
+	"PAYMENT_DUNNING_REQUESTED":                    true,
+	"PAYMENT_CHECKOUT_VIEWED":                      true,
+	"PAYMENT_PARTIALLY_REFUNDED":                   true,
+	"PAYMENT_SPLIT_DIVERGENCE_BLOCK":               true,
+	"PAYMENT_AWAITING_RISK_ANALYSIS":               true,
+	"PAYMENT_REPROVED_BY_RISK_ANALYSIS":            true,
+	"PAYMENT_UPDATED":                              true,
+	"PAYMENT_RECEIVED":                             true,
+	"PAYMENT_OVERDUE":                              true,
+	"PAYMENT_RESTORED":                             true,
+	"PAYMENT_REFUND_IN_PROGRESS":                   true,
+	"PAYMENT_RECEIVED_IN_CASH_UNDONE":              true,
+	"PAYMENT_CHARGEBACK_DISPUTE":                   true,
+	"PAYMENT_DUNNING_RECEIVED":                     true,
+	"PAYMENT_BANK_SLIP_VIEWED":                     true,
+	"PAYMENT_CREDIT_CARD_CAPTURE_REFUSED":          true,
+	"PAYMENT_SPLIT_CANCELLED":                      true,
+	"PAYMENT_SPLIT_DIVERGENCE_BLOCK_FINISHED":      true,
+	"SUBSCRIPTION_INACTIVATED":                     true,
+	"SUBSCRIPTION_SPLIT_DISABLED":                  true,
+	"SUBSCRIPTION_SPLIT_DIVERGENCE_BLOCK_FINISHED": true,
+	"SUBSCRIPTION_UPDATED":                         true,
+	"SUBSCRIPTION_DELETED":                         true,
+	"SUBSCRIPTION_SPLIT_DIVERGENCE_BLOCK":          true,
+	"INVOICE_SYNCHRONIZED":                         true,
+	"INVOICE_PROCESSING_CANCELLATION":              true,
+	"INVOICE_CANCELLATION_DENIED":                  true,
+	"INVOICE_UPDATED":                              true,
+	"INVOICE_AUTHORIZED":                           true,
+	"INVOICE_CANCELED":                             true,
+	"INVOICE_ERROR":                                true,
+}
+
+// webhookRegistry holds handlers registered by the application: handlers for extra
+// event types and subscribers notified after the built-in processing.
+type webhookRegistry struct {
+	mu            sync.RWMutex
+	handlers      map[string]WebhookEventHandler
+	unknownPolicy UnknownEventPolicy
+	subscribers   []eventSubscriber
+	reportError   func(*HandlerError)
+}
+
+func newWebhookRegistry() *webhookRegistry {
+	return &webhookRegistry{
+		handlers:      make(map[string]WebhookEventHandler),
+		unknownPolicy: UnknownEventStore,
+	}
+}
+
+// RegisterWebhookEventHandler handles eventType with handler. Built-in event types
+// cannot be overridden; registering the same type twice replaces the previous handler.
+func (s *Service) RegisterWebhookEventHandler(eventType string, handler WebhookEventHandler) error {
+	if eventType == "" || handler == nil {
+		return fmt.Errorf("tipo de evento e handler s\u00e3o obrigat\u00f3rios")
+	}
+	if builtinWebhookEvents[eventType] {
+		return fmt.Errorf("o evento %s j\u00e1 \u00e9 tratado pelo servi\u00e7o", eventType)
+	}
+	s.webhooks.mu.Lock()
+	defer s.webhooks.mu.Unlock()
+	s.webhooks.handlers[eventType] = handler
+	return nil
+}
+
+// SetUnknownEventPolicy changes how events without a handler are treated.
+func (s *Service) SetUnknownEventPolicy(policy UnknownEventPolicy) {
+	s.webhooks.mu.Lock()
+	defer s.webhooks.mu.Unlock()
+	s.webhooks.unknownPolicy = policy
+}
+
+// SupportsWebhookEvent reports whether eventType has a built-in or registered handler.
+func (s *Service) SupportsWebhookEvent(eventType string) bool {
+	if builtinWebhookEvents[eventType] {
+		return true
+	}
+	_, ok := s.registeredWebhookHandler(eventType)
+	return ok
+}
+
+func (s *Service) registeredWebhookHandler(eventType string) (WebhookEventHandler, bool) {
+	s.webhooks.mu.RLock()
+	defer s.webhooks.mu.RUnlock()
+	handler, ok := s.webhooks.handlers[eventType]
+	return handler, ok
+}
+
+func (s *Service) unknownEventPolicy() UnknownEventPolicy {
+	s.webhooks.mu.RLock()
+	defer s.webhooks.mu.RUnlock()
+	return s.webhooks.unknownPolicy
+}
+
+// handleUnregisteredEvent runs the registered handler for eventType or reports it as unsupported.
+func (s *Service) handleUnregisteredEvent(ctx context.Context, event NotificationEvent) error {
+	if handler, ok := s.registeredWebhookHandler(event.Event); ok {
+		return handler(ctx, event)
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedEvent, event.Event)
+}
+
+func logUnknownEvent(eventID string, event NotificationEvent) {
+	log.Printf("webhook event %s acknowledged without handler: unsupported type %s", eventID, event.Event)
+}
@@ -0,0 +1,319 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBuiltinWebhookEventsMatchSwitch keeps builtinWebhookEvents in sync with the
+// cases of HandleWebhookNotification.
+func TestBuiltinWebhookEventsMatchSwitch(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "service.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "HandleWebhookNotification" {
+			continue
+		}
+		for _, stmt := range fn.Body.List {
+			sw, ok := stmt.(*ast.SwitchStmt)
+			if !ok {
+				continue
+			}
+			for _, clause := range sw.Body.List {
+				for _, expr := range clause.(*ast.CaseClause).List {
+					lit, ok := expr.(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						t.Fatalf("unexpected case expression %T", expr)
+					}
+					value, _ := strconv.Unquote(lit.Value)
+					cases[value] = true
+				}
+			}
+		}
+	}
+	if len(cases) == 0 {
+		t.Fatal("no cases found in HandleWebhookNotification")
+	}
+	for event := range cases {
+		if !builtinWebhookEvents[event] {
+			t.Errorf("%s is handled by HandleWebhookNotification but missing from builtinWebhookEvents", event)
+		}
+	}
+	for event := range builtinWebhookEvents {
+		if !cases[event] {
+			t.Errorf("%s is in builtinWebhookEvents but not handled by HandleWebhookNotification", event)
+		}
+	}
+
+	service := NewService(NewMemoryRepository(), newFakeGateway())
+	for event := range builtinWebhookEvents {
+		err := handleRecovered(func() error {
+			return service.HandleWebhookNotification(context.Background(), NotificationEvent{Event: event})
+		})
+		if errors.Is(err, ErrUnsupportedEvent) {
+			t.Errorf("%s reported as unsupported", event)
+		}
+	}
+}
+
+func TestParseUnknownEventPolicy(t *testing.T) {
+	for _, value := range []string{"reject", "acknowledge", "store"} {
+		if policy, err := ParseUnknownEventPolicy(value); err != nil || string(policy) != value {
+			t.Fatalf("%s: got %q %v", value, policy, err)
+		}
+	}
+	if _, err := ParseUnknownEventPolicy("ignore"); err == nil {
+		t.Fatal("expected an error for an unknown policy")
+	}
+}
+
+func TestRegisterWebhookEventHandler(t *testing.T) {
+	service := NewService(NewMemoryRepository(), newFakeGateway())
+	handler := func(ctx context.Context, event NotificationEvent) error { return nil }
+
+	if err := service.RegisterWebhookEventHandler("PAYMENT_CREATED", handler); err == nil {
+		t.Fatal("built-in events must not be overridden")
+	}
+	if err := service.RegisterWebhookEventHandler("", handler); err == nil {
+		t.Fatal("expected an error for an empty event type")
+	}
+	if err := service.RegisterWebhookEventHandler("TRANSFER_DONE", nil); err == nil {
+		t.Fatal("expected an error for a nil handler")
+	}
+	if service.SupportsWebhookEvent("TRANSFER_DONE") {
+		t.Fatal("TRANSFER_DONE supported before registration")
+	}
+	if err := service.RegisterWebhookEventHandler("TRANSFER_DONE", handler); err != nil {
+		t.Fatal(err)
+	}
+	if !service.SupportsWebhookEvent("TRANSFER_DONE") || !service.SupportsWebhookEvent("PAYMENT_RECEIVED") {
+		t.Fatal("expected registered and built-in events to be supported")
+	}
+}
+
+func TestUnknownEventPolicies(t *testing.T) {
+	payload := []byte(`{"id":"evt_unknown","event":"TRANSFER_DONE"}`)
+	tests := []struct {
+		policy     UnknownEventPolicy
+		wantErr    error
+		wantStatus string
+	}{
+		{UnknownEventReject, ErrUnsupportedEvent, ""},
+		{UnknownEventAcknowledge, nil, ""},
+		{UnknownEventStore, nil, WebhookEventStatusUnhandled},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			ctx := context.Background()
+			repo := NewMemoryRepository()
+			service := NewService(repo, newFakeGateway())
+			service.SetUnknownEventPolicy(tt.policy)
+
+			err := service.HandleWebhookPayload(ctx, payload)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			record, err := repo.FindWebhookEventByEventID(ctx, "evt_unknown")
+			if tt.wantStatus == "" {
+				if err == nil {
+					t.Fatalf("event journaled as %s", record.Status)
+				}
+				return
+			}
+			if err != nil || record.Status != tt.wantStatus {
+				t.Fatalf("expected %s, got %+v %v", tt.wantStatus, record, err)
+			}
+		})
+	}
+}
+
+func TestUnhandledEventsRunAfterRegistration(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	service := NewService(repo, newFakeGateway())
+	if err := service.HandleWebhookPayload(ctx, []byte(`{"id":"evt_later","event":"TRANSFER_DONE"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var received string
+	err := service.RegisterWebhookEventHandler("TRANSFER_DONE", func(ctx context.Context, event NotificationEvent) error {
+		received = event.ID
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.ReplayWebhookEvent(ctx, "evt_later"); err != nil {
+		t.Fatal(err)
+	}
+	record, _ := repo.FindWebhookEventByEventID(ctx, "evt_later")
+	if received != "evt_later" || record.Status != WebhookEventStatusProcessed {
+		t.Fatalf("expected the stored event to be processed, got %q %+v", received, record)
+	}
+}
+
+// seedWebhookTargets stores a payment, a subscription and an invoice for webhook events to update.
+func seedWebhookTargets(t *testing.T, repo *MemoryRepository) {
+	t.Helper()
+	ctx := context.Background()
+	seedPayment(t, repo, "pay_1")
+	now := time.Now().UTC()
+	subscription := SubscriptionRecord{ID: "subs-1", AsaasID: "sub_1", CustomerID: "cust-1", BillingType: "PIX", Status: "ACTIVE", Value: NewMoney(49, 90), Cycle: "MONTHLY", CreatedAt: now, UpdatedAt: now}
+	if err := repo.SaveSubscription(ctx, subscription); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveInvoice(ctx, InvoiceRecord{ID: "inv-1", PaymentID: seededPaymentID, Status: "SCHEDULED"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func deliverWebhook(t *testing.T, service *Service, event NotificationEvent) error {
+	t.Helper()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service.HandleWebhookPayload(context.Background(), payload)
+}
+
+func TestWebhookSubscribersMatchFamilyAndType(t *testing.T) {
+	paymentEvent := func(eventType string) NotificationEvent {
+		return NotificationEvent{ID: "evt_1", Event: eventType, Payment: &PaymentResponse{ID: "pay_1", ExternalReference: seededPaymentID, Status: "RECEIVED"}}
+	}
+	tests := []struct {
+		name  string
+		event NotificationEvent
+		want  []string
+	}{
+		{"payment type subscribed twice", paymentEvent("PAYMENT_RECEIVED"), []string{"payments evt_1 PAYMENT_RECEIVED pay_1", "received evt_1 PAYMENT_RECEIVED pay_1"}},
+		{"payment type subscribed by family", paymentEvent("PAYMENT_OVERDUE"), []string{"payments evt_1 PAYMENT_OVERDUE pay_1"}},
+		{"subscription", NotificationEvent{ID: "evt_1", Event: "SUBSCRIPTION_UPDATED", Subscription: &SubscriptionResponse{ID: "sub_1", ExternalID: "subs-1", Status: "INACTIVE"}}, []string{"subscriptions evt_1 SUBSCRIPTION_UPDATED sub_1"}},
+		{"invoice type subscribed", NotificationEvent{ID: "evt_1", Event: "INVOICE_AUTHORIZED", Invoice: &InvoiceResponse{ID: "inv_1", ExternalID: "inv-1", Status: "AUTHORIZED"}}, []string{"invoices evt_1 INVOICE_AUTHORIZED inv_1"}},
+		{"invoice type not subscribed", NotificationEvent{ID: "evt_1", Event: "INVOICE_ERROR", Invoice: &InvoiceResponse{ID: "inv_1", ExternalID: "inv-1", Status: "ERROR"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := NewMemoryRepository()
+			seedWebhookTargets(t, repo)
+			service := NewService(repo, newFakeGateway())
+			var got []string
+			record := func(name, eventID, eventType, objectID string) {
+				got = append(got, fmt.Sprintf("%s %s %s %s", name, eventID, eventType, objectID))
+			}
+			service.OnPaymentEvent("payments", func(ctx context.Context, event PaymentEvent) error {
+				record("payments", event.ID, event.Type, event.Payment.ID)
+				return nil
+			})
+			service.OnPaymentEvent("received", func(ctx context.Context, event PaymentEvent) error {
+				record("received", event.ID, event.Type, event.Payment.ID)
+				return nil
+			}, "PAYMENT_RECEIVED", "PAYMENT_CONFIRMED")
+			service.OnSubscriptionEvent("subscriptions", func(ctx context.Context, event SubscriptionEvent) error {
+				record("subscriptions", event.ID, event.Type, event.Subscription.ID)
+				return nil
+			})
+			service.OnInvoiceEvent("invoices", func(ctx context.Context, event InvoiceEvent) error {
+				record("invoices", event.ID, event.Type, event.Invoice.ID)
+				return nil
+			}, "INVOICE_AUTHORIZED")
+
+			if err := deliverWebhook(t, service, tt.event); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWebhookSubscriberFailuresAreReported(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	seedWebhookTargets(t, repo)
+	service := NewService(repo, newFakeGateway())
+	var reports []*HandlerError
+	service.SetHandlerErrorReporter(func(err *HandlerError) {
+		reports = append(reports, err)
+	})
+	service.OnPaymentEvent("panics", func(ctx context.Context, event PaymentEvent) error {
+		panic("boom")
+	})
+	service.OnPaymentEvent("fails", func(ctx context.Context, event PaymentEvent) error {
+		return errHandled
+	})
+	ran := false
+	service.OnPaymentEvent("works", func(ctx context.Context, event PaymentEvent) error {
+		ran = true
+		return nil
+	})
+
+	event := NotificationEvent{ID: "evt_1", Event: "PAYMENT_RECEIVED", Payment: &PaymentResponse{ID: "pay_1", ExternalReference: seededPaymentID, Status: "RECEIVED"}}
+	if err := deliverWebhook(t, service, event); err != nil {
+		t.Fatalf("subscriber failures must not fail the event, got %v", err)
+	}
+	if !ran {
+		t.Fatal("expected the remaining subscriber to run")
+	}
+	if len(reports) != 2 {
+		t.Fatalf("expected two reports, got %v", reports)
+	}
+	if got := reports[0]; got.Handler != "panics" || got.EventID != "evt_1" || got.EventType != "PAYMENT_RECEIVED" || !strings.Contains(got.Error(), "panic: boom") {
+		t.Fatalf("unexpected panic report: %+v", got)
+	}
+	if got := reports[1]; got.Handler != "fails" || !errors.Is(got, errHandled) {
+		t.Fatalf("unexpected failure report: %+v", got)
+	}
+	record, err := repo.FindWebhookEventByEventID(ctx, "evt_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != WebhookEventStatusProcessed || !strings.Contains(record.Error, "handler panics") || !strings.Contains(record.Error, "handler fails") {
+		t.Fatalf("expected a processed event noting both failures, got %+v", record)
+	}
+}
+
+func TestWebhookSubscribersRunAfterLocalUpdate(t *testing.T) {
+	repo := NewMemoryRepository()
+	seedWebhookTargets(t, repo)
+	service := NewService(repo, newFakeGateway())
+	var seen []string
+	service.OnPaymentEvent("payments", func(ctx context.Context, event PaymentEvent) error {
+		payment, err := repo.FindPaymentByID(ctx, seededPaymentID)
+		seen = append(seen, payment.Status)
+		return err
+	})
+	service.OnSubscriptionEvent("subscriptions", func(ctx context.Context, event SubscriptionEvent) error {
+		subscription, err := repo.FindSubscriptionByID(ctx, event.Subscription.ExternalID)
+		seen = append(seen, subscription.Status)
+		return err
+	})
+
+	if err := deliverWebhook(t, service, NotificationEvent{ID: "evt_1", Event: "PAYMENT_RECEIVED", Payment: &PaymentResponse{ID: "pay_1", ExternalReference: seededPaymentID, Status: "RECEIVED"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := deliverWebhook(t, service, NotificationEvent{ID: "evt_2", Event: "SUBSCRIPTION_INACTIVATED", Subscription: &SubscriptionResponse{ID: "sub_1", ExternalID: "subs-1", Status: "INACTIVE"}}); err != nil {
+		t.Fatal(err)
+	}
+	// The local update fails for an unknown subscription, so the event is retried
+	// and subscribers are not told about it yet.
+	if err := deliverWebhook(t, service, NotificationEvent{ID: "evt_3", Event: "SUBSCRIPTION_DELETED", Subscription: &SubscriptionResponse{ID: "sub_2", ExternalID: "subs-2", Status: "INACTIVE"}}); err == nil {
+		t.Fatal("expected the unknown subscription to fail the event")
+	}
+	if strings.Join(seen, " ") != "RECEIVED INACTIVE" {
+		t.Fatalf("expected subscribers to see the updated records, got %q", seen)
+	}
+}
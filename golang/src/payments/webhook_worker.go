@@ -0,0 +1,100 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// WebhookQueueConfig controls background processing of journaled webhook events.
+type WebhookQueueConfig struct {
+	// Workers is the number of goroutines claiming events.
+	Workers int
+	// PollInterval is how long an idle worker waits before looking for new events.
+	PollInterval time.Duration
+	// LockTimeout is how long an event may stay in PROCESSING before another worker reclaims it.
+	LockTimeout time.Duration
+	// Retry schedules failed events; after Retry.MaxAttempts they are moved to DEAD.
+	Retry RetryPolicy
+}
+
+// DefaultWebhookQueueConfig returns the queue settings used when none is configured.
+func DefaultWebhookQueueConfig() WebhookQueueConfig {
+	return WebhookQueueConfig{
+		Workers:      4,
+		PollInterval: time.Second,
+		LockTimeout:  5 * time.Minute,
+		Retry: RetryPolicy{
+			MaxAttempts: 8,
+			BaseDelay:   30 * time.Second,
+			MaxDelay:    time.Hour,
+			Jitter:      0.2,
+		},
+	}
+}
+
+// SetWebhookQueueConfig replaces the webhook queue settings. Call it before starting workers.
+func (s *Service) SetWebhookQueueConfig(cfg WebhookQueueConfig) {
+	s.webhookQueue = cfg
+}
+
+// RunWebhookWorkers processes queued webhook events until ctx is done.
+func (s *Service) RunWebhookWorkers(ctx context.Context) {
+	cfg := s.webhookQueue
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runWebhookWorker(ctx, cfg)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Service) runWebhookWorker(ctx context.Context, cfg WebhookQueueConfig) {
+	for {
+		processed, err := s.processNextWebhookEvent(ctx, cfg)
+		if err != nil {
+			log.Printf("webhook worker: %v", err)
+		}
+		if processed && err == nil {
+			continue
+		}
+		if sleepContext(ctx, cfg.PollInterval) != nil {
+			return
+		}
+	}
+}
+
+// processNextWebhookEvent claims and runs one queued event. It returns false when the queue is empty.
+func (s *Service) processNextWebhookEvent(ctx context.Context, cfg WebhookQueueConfig) (bool, error) {
+	record, ok, err := s.repo.ClaimWebhookEvent(ctx, time.Now().UTC().Add(-cfg.LockTimeout))
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if record.Attempts >= cfg.Retry.attempts() {
+		// Every attempt left the event in PROCESSING, so it crashes or hangs its worker.
+		message := fmt.Sprintf("abandonado em processamento após %d tentativas", record.Attempts)
+		return true, s.repo.UpdateWebhookEventStatus(ctx, record.ID, WebhookEventStatusDead, message, time.Time{})
+	}
+
+	var event NotificationEvent
+	if err := json.Unmarshal(record.Payload, &event); err != nil {
+		// A payload that cannot be parsed will never succeed, so it goes straight to DEAD.
+		return true, s.repo.UpdateWebhookEventStatus(ctx, record.ID, WebhookEventStatusDead, "payload armazenado inválido: "+err.Error(), time.Time{})
+	}
+	if err := s.processWebhookEvent(ctx, record, event); err != nil {
+		log.Printf("webhook event %s (%s) failed on attempt %d: %v", record.EventID, record.EventType, record.Attempts+1, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,99 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newQueuedEvent journals a TRANSFER_DONE event handled by handler.
+func newQueuedEvent(t *testing.T, handler WebhookEventHandler) (*Service, *MemoryRepository) {
+	t.Helper()
+	repo := NewMemoryRepository()
+	service := NewService(repo, newFakeGateway())
+	if err := service.RegisterWebhookEventHandler("TRANSFER_DONE", handler); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.EnqueueWebhookPayload(context.Background(), []byte(`{"id":"evt_1","event":"TRANSFER_DONE"}`)); err != nil {
+		t.Fatal(err)
+	}
+	return service, repo
+}
+
+func TestWebhookWorkerRecoversFromPanics(t *testing.T) {
+	ctx := context.Background()
+	service, repo := newQueuedEvent(t, func(ctx context.Context, event NotificationEvent) error {
+		panic("boom")
+	})
+
+	processed, err := service.processNextWebhookEvent(ctx, service.webhookQueue)
+	if !processed || err != nil {
+		t.Fatalf("expected the event to be processed, got %v %v", processed, err)
+	}
+	record, err := repo.FindWebhookEventByEventID(ctx, "evt_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != WebhookEventStatusFailed || record.Attempts != 1 || record.Error != "panic: boom" {
+		t.Fatalf("expected a failed attempt, got %+v", record)
+	}
+}
+
+func TestStaleClaimsCountAsAttempts(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	service, repo := newQueuedEvent(t, func(ctx context.Context, event NotificationEvent) error {
+		calls++
+		return nil
+	})
+	cfg := service.webhookQueue
+	cfg.Retry.MaxAttempts = 2
+	// Every lock is already stale, as if each worker had crashed mid-event.
+	staleBefore := time.Now().UTC().Add(time.Hour)
+	cfg.LockTimeout = -time.Hour
+
+	for i := 0; i < 2; i++ {
+		if _, ok, err := repo.ClaimWebhookEvent(ctx, staleBefore); !ok || err != nil {
+			t.Fatalf("claim %d: %v %v", i+1, ok, err)
+		}
+	}
+	if _, err := service.processNextWebhookEvent(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	record, _ := repo.FindWebhookEventByEventID(ctx, "evt_1")
+	if record.Status != WebhookEventStatusDead || calls != 0 {
+		t.Fatalf("expected the event to be dead without running, got %+v after %d calls", record, calls)
+	}
+}
+
+func TestReplayDoesNotRunClaimedEvents(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	service, repo := newQueuedEvent(t, func(ctx context.Context, event NotificationEvent) error {
+		calls++
+		return nil
+	})
+
+	if _, ok, err := repo.ClaimWebhookEvent(ctx, time.Now().UTC().Add(-time.Hour)); !ok || err != nil {
+		t.Fatalf("claim: %v %v", ok, err)
+	}
+	if err := service.ReplayWebhookEvent(ctx, "evt_1"); !errors.Is(err, ErrWebhookEventBusy) {
+		t.Fatalf("expected ErrWebhookEventBusy, got %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("claimed event ran %d times", calls)
+	}
+
+	record, _ := repo.FindWebhookEventByEventID(ctx, "evt_1")
+	if err := repo.UpdateWebhookEventStatus(ctx, record.ID, WebhookEventStatusDead, "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.ReplayWebhookEvent(ctx, "evt_1"); err != nil {
+		t.Fatal(err)
+	}
+	record, _ = repo.FindWebhookEventByEventID(ctx, "evt_1")
+	if record.Status != WebhookEventStatusProcessed || calls != 1 {
+		t.Fatalf("expected the replay to process the event once, got %+v after %d calls", record, calls)
+	}
+}
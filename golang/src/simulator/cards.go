@@ -0,0 +1,76 @@
+package simulator
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"asaas/src/payments"
+)
+
+// card is a tokenized card. Only the masked data is kept.
+type card struct {
+	Customer string
+	Brand    string
+	Last4    string
+}
+
+func (s *Simulator) tokenizeCreditCard(w http.ResponseWriter, req *http.Request) {
+	var body payments.TokenizeCreditCardRequest
+	if !decodeBody(w, req, &body) {
+		return
+	}
+	s.mu.Lock()
+	c, ok := s.customers[body.Customer]
+	if !ok || c.Deleted {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "invalid_customer", "Cliente inexistente ou removido")
+		return
+	}
+	resp, err := s.chargeCard(body.Customer, body.CreditCard, body.CreditCardHolderInfo, "", body.RemoteIP)
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_creditCard", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// chargeCard validates the card of a request, tokenizing raw card data, and returns
+// the masked card. The caller must hold s.mu.
+func (s *Simulator) chargeCard(customerID string, raw *payments.CreditCard, holder *payments.CreditCardHolderInfo, token, remoteIP string) (*payments.CreditCardTokenResponse, error) {
+	if remoteIP == "" {
+		return nil, errors.New("O IP do comprador (remoteIp) é obrigatório")
+	}
+	if token != "" {
+		saved, ok := s.cards[token]
+		if !ok || saved.Customer != customerID {
+			return nil, errors.New("Token de cartão de crédito inválido")
+		}
+		return &payments.CreditCardTokenResponse{CreditCardNumber: saved.Last4, CreditCardBrand: saved.Brand, CreditCardToken: token}, nil
+	}
+	if raw == nil || holder == nil {
+		return nil, errors.New("Informe os dados do cartão e do titular")
+	}
+	number := strings.ReplaceAll(raw.Number, " ", "")
+	if len(number) < 13 || len(number) > 19 || strings.Trim(number, "0123456789") != "" || raw.CCV == "" {
+		return nil, errors.New("Cartão de crédito inválido")
+	}
+	saved := card{Customer: customerID, Brand: cardBrand(number), Last4: number[len(number)-4:]}
+	token = s.nextID("tok")
+	s.cards[token] = saved
+	return &payments.CreditCardTokenResponse{CreditCardNumber: saved.Last4, CreditCardBrand: saved.Brand, CreditCardToken: token}, nil
+}
+
+func cardBrand(number string) string {
+	switch number[0] {
+	case '4':
+		return "VISA"
+	case '5':
+		return "MASTERCARD"
+	case '3':
+		return "AMEX"
+	default:
+		return "ELO"
+	}
+}
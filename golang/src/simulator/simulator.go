@@ -0,0 +1,1255 @@
+// Package simulator is an in-memory imitation of the Asaas v3 API used for local
+// development and integration tests. It serves the endpoints called by
+// payments.AsaasClient under /v3 and can be mounted with httptest.NewServer.
+package simulator
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"asaas/src/payments"
+)
+
+// Options configures a Simulator.
+type Options struct {
+	// AccessToken, when set, must match the access_token header of every API request.
+	AccessToken string
+	// WebhookURL receives the webhook events triggered by state transitions.
+	WebhookURL string
+	// WebhookToken is sent in the asaas-access-token header of webhook deliveries.
+	WebhookToken string
+	// HTTPClient delivers webhooks. Defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+	// PublicURL is where the simulator is reachable, used in the bankSlipUrl of
+	// boleto payments. Defaults to the sandbox address, which does not serve them.
+	PublicURL string
+}
+
+// Simulator keeps customers, payments, subscriptions and invoices in memory and
+// serves them with the same JSON shapes as Asaas.
+type Simulator struct {
+	opts Options
+	mux  *http.ServeMux
+
+	mu            sync.Mutex
+	seq           int
+	customers     map[string]*customer
+	payments      map[string]*payment
+	installments  map[string]*installment
+	subscriptions map[string]*subscription
+	invoices      map[string]*invoice
+	cards         map[string]card
+	deliveries    []WebhookDelivery
+}
+
+// invoiceURLPrefix mimics the sandbox payment page links.
+const invoiceURLPrefix = "https://sandbox.asaas.com/i/"
+
+// defaultPublicURL is the host of bankSlipUrl links when Options.PublicURL is empty.
+const defaultPublicURL = "https://sandbox.asaas.com"
+
+type customer struct {
+	Object      string `json:"object"`
+	ID          string `json:"id"`
+	DateCreated string `json:"dateCreated"`
+	payments.CustomerRequest
+	Deleted bool `json:"deleted"`
+}
+
+type payment struct {
+	Object                string                            `json:"object"`
+	ID                    string                            `json:"id"`
+	DateCreated           string                            `json:"dateCreated"`
+	Customer              string                            `json:"customer"`
+	Subscription          string                            `json:"subscription,omitempty"`
+	Installment           string                            `json:"installment,omitempty"`
+	InstallmentNumber     int                               `json:"installmentNumber,omitempty"`
+	BillingType           string                            `json:"billingType"`
+	Value                 payments.Money                    `json:"value"`
+	Status                string                            `json:"status"`
+	Description           string                            `json:"description,omitempty"`
+	DueDate               string                            `json:"dueDate"`
+	ExternalReference     string                            `json:"externalReference"`
+	InstallmentCount      int                               `json:"installmentCount,omitempty"`
+	InvoiceURL            string                            `json:"invoiceUrl"`
+	BankSlipURL           string                            `json:"bankSlipUrl,omitempty"`
+	TransactionReceiptURL string                            `json:"transactionReceiptUrl,omitempty"`
+	Refunds               []payments.PaymentRefund          `json:"refunds,omitempty"`
+	CreditCard            *payments.CreditCardTokenResponse `json:"creditCard,omitempty"`
+	Discount              *payments.Discount                `json:"discount,omitempty"`
+	Interest              *payments.Interest                `json:"interest,omitempty"`
+	Fine                  *payments.Fine                    `json:"fine,omitempty"`
+	Split                 []payments.Split                  `json:"split,omitempty"`
+	Deleted               bool                              `json:"deleted"`
+}
+
+type installment struct {
+	Object           string         `json:"object"`
+	ID               string         `json:"id"`
+	DateCreated      string         `json:"dateCreated"`
+	Customer         string         `json:"customer"`
+	BillingType      string         `json:"billingType"`
+	Value            payments.Money `json:"value"`
+	PaymentValue     payments.Money `json:"paymentValue"`
+	InstallmentCount int            `json:"installmentCount"`
+	Description      string         `json:"description,omitempty"`
+	Deleted          bool           `json:"deleted"`
+}
+
+type subscription struct {
+	Object            string                            `json:"object"`
+	ID                string                            `json:"id"`
+	DateCreated       string                            `json:"dateCreated"`
+	Customer          string                            `json:"customer"`
+	BillingType       string                            `json:"billingType"`
+	Value             payments.Money                    `json:"value"`
+	NextDueDate       string                            `json:"nextDueDate"`
+	Cycle             string                            `json:"cycle"`
+	Description       string                            `json:"description,omitempty"`
+	EndDate           string                            `json:"endDate,omitempty"`
+	MaxPayments       int                               `json:"maxPayments,omitempty"`
+	ExternalReference string                            `json:"externalReference"`
+	Status            string                            `json:"status"`
+	CreditCard        *payments.CreditCardTokenResponse `json:"creditCard,omitempty"`
+	Discount          *payments.Discount                `json:"discount,omitempty"`
+	Interest          *payments.Interest                `json:"interest,omitempty"`
+	Fine              *payments.Fine                    `json:"fine,omitempty"`
+	Split             []payments.Split                  `json:"split,omitempty"`
+	Deleted           bool                              `json:"deleted"`
+}
+
+type invoice struct {
+	Object             string                `json:"object"`
+	ID                 string                `json:"id"`
+	Customer           string                `json:"customer"`
+	Payment            string                `json:"payment"`
+	Status             string                `json:"status"`
+	ServiceDescription string                `json:"serviceDescription"`
+	Observations       string                `json:"observations"`
+	Value              payments.Money        `json:"value"`
+	Deductions         payments.Money        `json:"deductions"`
+	EffectiveDate      string                `json:"effectiveDate"`
+	ExternalReference  string                `json:"externalReference"`
+	PaymentLink        string                `json:"paymentLink"`
+	Taxes              payments.InvoiceTaxes `json:"taxes"`
+}
+
+type listResponse struct {
+	Object     string `json:"object"`
+	HasMore    bool   `json:"hasMore"`
+	TotalCount int    `json:"totalCount"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	Data       any    `json:"data"`
+}
+
+type deletedResponse struct {
+	Deleted bool   `json:"deleted"`
+	ID      string `json:"id"`
+}
+
+type apiError struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// New builds a Simulator with empty state.
+func New(opts Options) *Simulator {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.PublicURL == "" {
+		opts.PublicURL = defaultPublicURL
+	}
+	s := &Simulator{
+		opts:          opts,
+		mux:           http.NewServeMux(),
+		customers:     make(map[string]*customer),
+		payments:      make(map[string]*payment),
+		installments:  make(map[string]*installment),
+		subscriptions: make(map[string]*subscription),
+		invoices:      make(map[string]*invoice),
+		cards:         make(map[string]card),
+	}
+	s.routes()
+	return s
+}
+
+// SetWebhookURL changes where webhook events are delivered.
+func (s *Simulator) SetWebhookURL(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts.WebhookURL = url
+}
+
+// SetPublicURL changes the host of the bankSlipUrl of payments created afterwards.
+func (s *Simulator) SetPublicURL(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts.PublicURL = strings.TrimSuffix(url, "/")
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.ServeHTTP(w, req)
+}
+
+func (s *Simulator) routes() {
+	api := func(pattern string, handler http.HandlerFunc) {
+		s.mux.Handle(pattern, s.authenticate(handler))
+	}
+	api("POST /v3/customers", s.createCustomer)
+	api("GET /v3/customers", s.listCustomers)
+	api("GET /v3/customers/{id}", s.getCustomer)
+	api("PUT /v3/customers/{id}", s.updateCustomer)
+	api("POST /v3/customers/{id}", s.updateCustomer)
+	api("DELETE /v3/customers/{id}", s.deleteCustomer)
+	api("POST /v3/customers/{id}/restore", s.restoreCustomer)
+
+	api("POST /v3/creditCard/tokenizeCreditCard", s.tokenizeCreditCard)
+
+	api("POST /v3/payments", s.createPayment)
+	api("GET /v3/payments", s.listPayments)
+	api("GET /v3/payments/{id}", s.getPayment)
+	api("POST /v3/payments/{id}", s.updatePayment)
+	api("DELETE /v3/payments/{id}", s.deletePayment)
+	api("POST /v3/payments/{id}/refund", s.refundPayment)
+	api("POST /v3/payments/{id}/captureAuthorizedPayment", s.capturePayment)
+	api("GET /v3/payments/{id}/pixQrCode", s.getPixQrCode)
+	api("GET /v3/payments/{id}/identificationField", s.getIdentificationField)
+	api("GET /v3/installments/{id}/payments", s.listInstallmentPayments)
+	api("POST /v3/installments/{id}/refund", s.refundInstallment)
+	// Like in Asaas, the boleto PDF is public.
+	s.mux.HandleFunc("GET /b/pdf/{id}", s.getBankSlip)
+
+	api("POST /v3/subscriptions", s.createSubscription)
+	api("GET /v3/subscriptions", s.listSubscriptions)
+	api("GET /v3/subscriptions/{id}", s.getSubscription)
+	api("PUT /v3/subscriptions/{id}", s.updateSubscription)
+	api("POST /v3/subscriptions/{id}", s.updateSubscription)
+	api("DELETE /v3/subscriptions/{id}", s.deleteSubscription)
+	api("GET /v3/subscriptions/{id}/payments", s.listSubscriptionPayments)
+
+	api("POST /v3/invoices", s.createInvoice)
+	api("GET /v3/invoices", s.listInvoices)
+	api("GET /v3/invoices/{id}", s.getInvoice)
+	api("POST /v3/invoices/{id}/cancel", s.cancelInvoice)
+
+	s.mux.HandleFunc("POST /simulator/payments/{id}/confirm", s.triggerHandler(s.ConfirmPayment))
+	s.mux.HandleFunc("POST /simulator/payments/{id}/overdue", s.triggerHandler(s.OverduePayment))
+	s.mux.HandleFunc("POST /simulator/payments/{id}/refund", s.triggerHandler(s.RefundPayment))
+	s.mux.HandleFunc("POST /simulator/payments/{id}/refuse-capture", s.triggerHandler(s.RefuseCapture))
+	s.mux.HandleFunc("POST /simulator/payments/{id}/split/cancel", s.triggerHandler(s.CancelSplit))
+	s.mux.HandleFunc("POST /simulator/payments/{id}/split/block", s.triggerHandler(s.BlockSplit))
+	s.mux.HandleFunc("POST /simulator/payments/{id}/split/unblock", s.triggerHandler(s.UnblockSplit))
+	s.mux.HandleFunc("POST /simulator/subscriptions/{id}/payments", s.generateSubscriptionPaymentHandler)
+	s.mux.HandleFunc("POST /simulator/subscriptions/{id}/split/disable", s.triggerHandler(s.DisableSubscriptionSplit))
+	s.mux.HandleFunc("GET /simulator/webhooks", s.listDeliveries)
+}
+
+func (s *Simulator) authenticate(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := req.Header.Get("access_token")
+		if token == "" || (s.opts.AccessToken != "" && token != s.opts.AccessToken) {
+			writeError(w, http.StatusUnauthorized, "invalid_access_token", "A chave de API fornecida é inválida")
+			return
+		}
+		next(w, req)
+	})
+}
+
+func (s *Simulator) nextID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s_%012d", prefix, s.seq)
+}
+
+func (s *Simulator) today() string {
+	return s.opts.Now().UTC().Format("2006-01-02")
+}
+
+func (s *Simulator) createCustomer(w http.ResponseWriter, req *http.Request) {
+	var body payments.CustomerRequest
+	if !decodeBody(w, req, &body) {
+		return
+	}
+	if body.Name == "" {
+		writeError(w, http.StatusBadRequest, "invalid_name", "O nome do cliente deve ser informado")
+		return
+	}
+	s.mu.Lock()
+	c := &customer{Object: "customer", ID: s.nextID("cus"), DateCreated: s.today(), CustomerRequest: body}
+	s.customers[c.ID] = c
+	resp := *c
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) listCustomers(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	s.mu.Lock()
+	var items []customer
+	for _, c := range s.customers {
+		if c.Deleted {
+			continue
+		}
+		if ref := query.Get("externalReference"); ref != "" && c.ExternalID != ref {
+			continue
+		}
+		if email := query.Get("email"); email != "" && c.Email != email {
+			continue
+		}
+		if cpfCnpj := query.Get("cpfCnpj"); cpfCnpj != "" && c.CpfCnpj != cpfCnpj {
+			continue
+		}
+		if name := query.Get("name"); name != "" && !strings.Contains(strings.ToLower(c.Name), strings.ToLower(name)) {
+			continue
+		}
+		items = append(items, *c)
+	}
+	s.mu.Unlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	writeList(w, req, items)
+}
+
+func (s *Simulator) getCustomer(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	c, ok := s.customers[req.PathValue("id")]
+	var resp customer
+	if ok {
+		resp = *c
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// updateCustomer changes only the fields present in the body, like Asaas does.
+func (s *Simulator) updateCustomer(w http.ResponseWriter, req *http.Request) {
+	var body payments.UpdateCustomerRequest
+	if !decodeBody(w, req, &body) {
+		return
+	}
+	s.mu.Lock()
+	c, ok := s.customers[req.PathValue("id")]
+	if !ok || c.Deleted {
+		s.mu.Unlock()
+		writeNotFound(w)
+		return
+	}
+	for _, field := range []struct {
+		dst *string
+		src *string
+	}{
+		{&c.Name, body.Name},
+		{&c.Email, body.Email},
+		{&c.CpfCnpj, body.CpfCnpj},
+		{&c.Phone, body.Phone},
+		{&c.MobilePhone, body.MobilePhone},
+		{&c.Address, body.Address},
+		{&c.AddressNumber, body.AddressNumber},
+		{&c.Complement, body.Complement},
+		{&c.Province, body.Province},
+		{&c.PostalCode, body.PostalCode},
+		{&c.AdditionalEmails, body.AdditionalEmails},
+	} {
+		if field.src != nil {
+			*field.dst = *field.src
+		}
+	}
+	if body.ExternalID != "" {
+		c.ExternalID = body.ExternalID
+	}
+	if body.NotificationDisabled != nil {
+		c.CustomerRequest.NotificationDisabled = *body.NotificationDisabled
+	}
+	resp := *c
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) deleteCustomer(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	s.mu.Lock()
+	c, ok := s.customers[id]
+	if ok {
+		c.Deleted = true
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, deletedResponse{Deleted: true, ID: id})
+}
+
+func (s *Simulator) restoreCustomer(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	c, ok := s.customers[req.PathValue("id")]
+	var resp customer
+	if ok {
+		c.Deleted = false
+		resp = *c
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) createPayment(w http.ResponseWriter, req *http.Request) {
+	var body payments.PaymentRequest
+	if !decodeBody(w, req, &body) {
+		return
+	}
+	plan := body.InstallmentCount > 1 && (body.InstallmentValue > 0 || body.TotalValue > 0)
+	if !plan && body.Value <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_value", "O valor da cobrança deve ser maior que zero")
+		return
+	}
+	if body.InstallmentValue < 0 || body.TotalValue < 0 || (body.InstallmentValue > 0 && body.TotalValue > 0) {
+		writeError(w, http.StatusBadRequest, "invalid_installment", "Informe apenas installmentValue ou totalValue")
+		return
+	}
+	if _, err := time.Parse("2006-01-02", body.DueDate); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_dueDate", "A data de vencimento é inválida")
+		return
+	}
+	if !validChargeTerms(w, body.Discount, body.Fine) || !validSplits(w, body.Split) {
+		return
+	}
+	s.mu.Lock()
+	c, ok := s.customers[body.Customer]
+	if !ok || c.Deleted {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "invalid_customer", "Cliente inexistente ou removido")
+		return
+	}
+	var charged *payments.CreditCardTokenResponse
+	if body.BillingType == "CREDIT_CARD" && (body.CreditCard != nil || body.CreditCardToken != "") {
+		var err error
+		charged, err = s.chargeCard(body.Customer, body.CreditCard, body.CreditCardHolderInfo, body.CreditCardToken, body.RemoteIP)
+		if err != nil {
+			s.mu.Unlock()
+			writeError(w, http.StatusBadRequest, "invalid_creditCard", err.Error())
+			return
+		}
+	}
+	var created []*payment
+	if plan {
+		created = s.newInstallment(body)
+	} else {
+		p := s.newPayment(body.Customer, body.BillingType, body.Value, body.DueDate)
+		p.Description = body.Description
+		p.ExternalReference = body.ExternalID
+		p.InstallmentCount = body.InstallmentCount
+		created = []*payment{p}
+	}
+	for _, p := range created {
+		p.Discount, p.Interest, p.Fine = body.Discount, body.Interest, body.Fine
+		p.Split = s.newSplits(body.Split, p.Value, len(created))
+	}
+	if charged != nil {
+		// Card charges are approved on creation, like in the sandbox.
+		for _, p := range created {
+			p.Status = "CONFIRMED"
+			if body.AuthorizeOnly {
+				p.Status = "AUTHORIZED"
+			}
+			p.CreditCard = charged
+		}
+	}
+	resp := *created[0]
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// newInstallment stores an installment plan and its monthly payments, returned in
+// order. The total is split evenly, with the leftover cents in the first
+// installment, and only the first payment keeps the externalReference. The caller
+// must hold s.mu.
+func (s *Simulator) newInstallment(body payments.PaymentRequest) []*payment {
+	count := body.InstallmentCount
+	values := make([]payments.Money, count)
+	if body.InstallmentValue > 0 {
+		for i := range values {
+			values[i] = body.InstallmentValue
+		}
+	} else {
+		share := body.TotalValue / payments.Money(count)
+		for i := range values {
+			values[i] = share
+		}
+		values[0] += body.TotalValue - share*payments.Money(count)
+	}
+
+	plan := &installment{
+		Object:           "installment",
+		ID:               s.nextID("ins"),
+		DateCreated:      s.today(),
+		Customer:         body.Customer,
+		BillingType:      body.BillingType,
+		PaymentValue:     values[count-1],
+		InstallmentCount: count,
+		Description:      body.Description,
+	}
+	s.installments[plan.ID] = plan
+
+	created := make([]*payment, 0, count)
+	dueDate := body.DueDate
+	for i, value := range values {
+		p := s.newPayment(body.Customer, body.BillingType, value, dueDate)
+		p.Description = body.Description
+		p.Installment = plan.ID
+		p.InstallmentNumber = i + 1
+		p.InstallmentCount = count
+		if i == 0 {
+			p.ExternalReference = body.ExternalID
+		}
+		plan.Value += value
+		created = append(created, p)
+		dueDate = advanceDueDate(dueDate, "MONTHLY")
+	}
+	return created
+}
+
+// validChargeTerms rejects discount and fine types Asaas does not know, answering
+// the request when they are invalid.
+func validChargeTerms(w http.ResponseWriter, discount *payments.Discount, fine *payments.Fine) bool {
+	if discount != nil && discount.Type != payments.ValueTypeFixed && discount.Type != payments.ValueTypePercentage {
+		writeError(w, http.StatusBadRequest, "invalid_discount", "O tipo do desconto deve ser FIXED ou PERCENTAGE")
+		return false
+	}
+	if fine != nil && fine.Type != "" && fine.Type != payments.ValueTypeFixed && fine.Type != payments.ValueTypePercentage {
+		writeError(w, http.StatusBadRequest, "invalid_fine", "O tipo da multa deve ser FIXED ou PERCENTAGE")
+		return false
+	}
+	return true
+}
+
+// validSplits rejects splits without a wallet or whose percentages add up to more
+// than 100%, answering the request when they are invalid.
+func validSplits(w http.ResponseWriter, splits []payments.Split) bool {
+	var percentual payments.Percentage
+	for _, split := range splits {
+		if split.WalletID == "" {
+			writeError(w, http.StatusBadRequest, "invalid_split", "Informe o walletId de cada split")
+			return false
+		}
+		percentual += split.PercentualValue
+	}
+	if percentual > payments.NewPercentage(100, 0) {
+		writeError(w, http.StatusBadRequest, "invalid_split", "Os percentuais do split somam mais de 100%")
+		return false
+	}
+	return true
+}
+
+// newSplits builds the pending splits of a payment of value. A totalFixedValue is
+// spread over the payments of its installment plan. The caller must hold s.mu.
+func (s *Simulator) newSplits(requested []payments.Split, value payments.Money, count int) []payments.Split {
+	var splits []payments.Split
+	for _, split := range requested {
+		total := split.FixedValue + split.PercentualValue.Of(value)
+		if split.TotalFixedValue > 0 {
+			total += split.TotalFixedValue / payments.Money(count)
+		}
+		splits = append(splits, payments.Split{
+			ID:              s.nextID("spl"),
+			WalletID:        split.WalletID,
+			FixedValue:      split.FixedValue,
+			PercentualValue: split.PercentualValue,
+			TotalFixedValue: split.TotalFixedValue,
+			TotalValue:      total,
+			Status:          "PENDING",
+		})
+	}
+	return splits
+}
+
+// newPayment stores a pending payment. The caller must hold s.mu.
+func (s *Simulator) newPayment(customerID, billingType string, value payments.Money, dueDate string) *payment {
+	id := s.nextID("pay")
+	p := &payment{
+		Object:      "payment",
+		ID:          id,
+		DateCreated: s.today(),
+		Customer:    customerID,
+		BillingType: billingType,
+		Value:       value,
+		Status:      "PENDING",
+		DueDate:     dueDate,
+		InvoiceURL:  invoiceURLPrefix + id,
+	}
+	s.setBankSlipURL(p)
+	s.payments[id] = p
+	return p
+}
+
+// setBankSlipURL links boleto payments to their PDF. The caller must hold s.mu.
+func (s *Simulator) setBankSlipURL(p *payment) {
+	p.BankSlipURL = ""
+	if hasBoleto(p) {
+		p.BankSlipURL = s.opts.PublicURL + "/b/pdf/" + p.ID
+	}
+}
+
+func hasBoleto(p *payment) bool {
+	return p.BillingType == "BOLETO" || p.BillingType == "UNDEFINED"
+}
+
+func (s *Simulator) listPayments(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	s.mu.Lock()
+	var items []payment
+	for _, p := range s.payments {
+		if p.Deleted {
+			continue
+		}
+		if ref := query.Get("externalReference"); ref != "" && p.ExternalReference != ref {
+			continue
+		}
+		if customerID := query.Get("customer"); customerID != "" && p.Customer != customerID {
+			continue
+		}
+		if subscriptionID := query.Get("subscription"); subscriptionID != "" && p.Subscription != subscriptionID {
+			continue
+		}
+		if installmentID := query.Get("installment"); installmentID != "" && p.Installment != installmentID {
+			continue
+		}
+		if status := query.Get("status"); status != "" && p.Status != status {
+			continue
+		}
+		if billingType := query.Get("billingType"); billingType != "" && p.BillingType != billingType {
+			continue
+		}
+		if !dateWithin(query, "dateCreated", p.DateCreated) || !dateWithin(query, "dueDate", p.DueDate) {
+			continue
+		}
+		items = append(items, *p)
+	}
+	s.mu.Unlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	writeList(w, req, items)
+}
+
+func (s *Simulator) getPayment(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	p, ok := s.payments[req.PathValue("id")]
+	var resp payment
+	if ok {
+		resp = *p
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) updatePayment(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		BillingType       *string         `json:"billingType"`
+		Value             *payments.Money `json:"value"`
+		DueDate           *string         `json:"dueDate"`
+		Description       *string         `json:"description"`
+		ExternalReference *string         `json:"externalReference"`
+	}
+	if !decodeBody(w, req, &body) {
+		return
+	}
+	s.mu.Lock()
+	p, ok := s.payments[req.PathValue("id")]
+	if !ok || p.Deleted {
+		s.mu.Unlock()
+		writeNotFound(w)
+		return
+	}
+	if body.BillingType != nil {
+		p.BillingType = *body.BillingType
+		s.setBankSlipURL(p)
+	}
+	if body.Value != nil {
+		p.Value = *body.Value
+	}
+	if body.DueDate != nil {
+		p.DueDate = *body.DueDate
+	}
+	if body.Description != nil {
+		p.Description = *body.Description
+	}
+	if body.ExternalReference != nil {
+		p.ExternalReference = *body.ExternalReference
+	}
+	resp := *p
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) deletePayment(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	s.mu.Lock()
+	p, ok := s.payments[id]
+	if ok {
+		p.Deleted = true
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, deletedResponse{Deleted: true, ID: id})
+}
+
+func (s *Simulator) refundPayment(w http.ResponseWriter, req *http.Request) {
+	var body payments.RefundRequest
+	if req.ContentLength != 0 && !decodeBody(w, req, &body) {
+		return
+	}
+	id := req.PathValue("id")
+	err := s.transitionPayment(req.Context(), id, func(p *payment) (string, error) {
+		return s.refund(p, body.Value, body.Description)
+	})
+	s.writeTransition(w, id, err)
+}
+
+func (s *Simulator) capturePayment(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	err := s.transitionPayment(req.Context(), id, func(p *payment) (string, error) {
+		if p.Status != "AUTHORIZED" {
+			return "", fmt.Errorf("%w: %s não pode ser capturada", ErrInvalidTransition, p.Status)
+		}
+		p.Status = "CONFIRMED"
+		p.TransactionReceiptURL = p.InvoiceURL + "/receipt"
+		return "PAYMENT_CONFIRMED", nil
+	})
+	s.writeTransition(w, id, err)
+}
+
+// writeTransition answers an API call that changed a payment with the payment.
+func (s *Simulator) writeTransition(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeNotFound(w)
+		return
+	case errors.Is(err, ErrInvalidTransition):
+		writeError(w, http.StatusBadRequest, "invalid_action", err.Error())
+		return
+	}
+	// A failed webhook delivery does not undo the change, as in Asaas.
+	s.mu.Lock()
+	resp := *s.payments[id]
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// getPixQrCode issues a new QR code on every call, valid until the end of the due date.
+func (s *Simulator) getPixQrCode(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	p, ok := s.payments[req.PathValue("id")]
+	if !ok || p.Deleted {
+		s.mu.Unlock()
+		writeNotFound(w)
+		return
+	}
+	if p.BillingType != "PIX" && p.BillingType != "UNDEFINED" {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "invalid_billingType", "QR Code PIX disponível apenas para cobranças PIX")
+		return
+	}
+	payload := fmt.Sprintf("00020101021226800014br.gov.bcb.pix2558sandbox.asaas.com/qr/%s/%s5204000053039865802BR6304", p.ID, s.nextID("qr"))
+	resp := payments.PixQrCodeResponse{
+		EncodedImage:   base64.StdEncoding.EncodeToString([]byte(payload)),
+		Payload:        payload,
+		ExpirationDate: p.DueDate + " 23:59:59",
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) getIdentificationField(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	p, ok := s.payments[req.PathValue("id")]
+	if !ok || p.Deleted {
+		s.mu.Unlock()
+		writeNotFound(w)
+		return
+	}
+	if !hasBoleto(p) {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "invalid_billingType", "Linha digitável disponível apenas para cobranças por boleto")
+		return
+	}
+	resp := boletoIdentification(p)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// getBankSlip serves a minimal PDF carrying the identification field of the boleto.
+func (s *Simulator) getBankSlip(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	p, ok := s.payments[req.PathValue("id")]
+	if !ok || p.Deleted || !hasBoleto(p) {
+		s.mu.Unlock()
+		writeNotFound(w)
+		return
+	}
+	id, field := p.ID, boletoIdentification(p)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/pdf")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%%PDF-1.4\n%% Boleto %s %s\n%%%%EOF\n", id, field.IdentificationField)
+}
+
+// boletoIdentification derives a fake but stable identification from the payment.
+// The caller must hold s.mu.
+func boletoIdentification(p *payment) payments.IdentificationFieldResponse {
+	_, seq, _ := strings.Cut(p.ID, "_")
+	nossoNumero, _ := strconv.ParseInt(seq, 10, 64)
+	barCode := fmt.Sprintf("00191%04d%010d%025d", 0, int64(p.Value), nossoNumero)
+	return payments.IdentificationFieldResponse{
+		IdentificationField: barCode[:4] + barCode[19:] + barCode[4:19] + "000",
+		NossoNumero:         strconv.FormatInt(nossoNumero, 10),
+		BarCode:             barCode,
+	}
+}
+
+func (s *Simulator) listInstallmentPayments(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	s.mu.Lock()
+	plan, ok := s.installments[id]
+	if !ok || plan.Deleted {
+		s.mu.Unlock()
+		writeNotFound(w)
+		return
+	}
+	items := s.installmentPayments(id)
+	s.mu.Unlock()
+	writeList(w, req, items)
+}
+
+// installmentPayments returns the payments of a plan that were not removed, in
+// installment order. The caller must hold s.mu.
+func (s *Simulator) installmentPayments(id string) []payment {
+	var items []payment
+	for _, p := range s.payments {
+		if p.Installment == id && !p.Deleted {
+			items = append(items, *p)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].InstallmentNumber < items[j].InstallmentNumber })
+	return items
+}
+
+// refundInstallment refunds every paid installment of a plan, sending one webhook
+// per payment. Installments that were not paid are left untouched.
+func (s *Simulator) refundInstallment(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	s.mu.Lock()
+	plan, ok := s.installments[id]
+	if !ok || plan.Deleted {
+		s.mu.Unlock()
+		writeNotFound(w)
+		return
+	}
+	items := s.installmentPayments(id)
+	s.mu.Unlock()
+
+	refunded := 0
+	for _, item := range items {
+		err := s.transitionPayment(req.Context(), item.ID, func(p *payment) (string, error) {
+			return s.refund(p, 0, "")
+		})
+		if errors.Is(err, ErrInvalidTransition) {
+			continue
+		}
+		// A failed webhook delivery does not undo the refund, as in Asaas.
+		refunded++
+	}
+	if refunded == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_action", "Nenhuma parcela paga para estornar")
+		return
+	}
+
+	s.mu.Lock()
+	resp := *plan
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) createSubscription(w http.ResponseWriter, req *http.Request) {
+	var body payments.SubscriptionRequest
+	if !decodeBody(w, req, &body) {
+		return
+	}
+	if body.Value <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_value", "O valor da assinatura deve ser maior que zero")
+		return
+	}
+	if _, err := time.Parse("2006-01-02", body.NextDueDate); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_nextDueDate", "A data do próximo vencimento é inválida")
+		return
+	}
+	if !validChargeTerms(w, body.Discount, body.Fine) || !validSplits(w, body.Split) {
+		return
+	}
+	s.mu.Lock()
+	c, ok := s.customers[body.Customer]
+	if !ok || c.Deleted {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "invalid_customer", "Cliente inexistente ou removido")
+		return
+	}
+	var charged *payments.CreditCardTokenResponse
+	if body.BillingType == "CREDIT_CARD" && (body.CreditCard != nil || body.CreditCardToken != "") {
+		var err error
+		charged, err = s.chargeCard(body.Customer, body.CreditCard, body.CreditCardHolderInfo, body.CreditCardToken, body.RemoteIP)
+		if err != nil {
+			s.mu.Unlock()
+			writeError(w, http.StatusBadRequest, "invalid_creditCard", err.Error())
+			return
+		}
+	}
+	sub := &subscription{
+		Object:            "subscription",
+		ID:                s.nextID("sub"),
+		DateCreated:       s.today(),
+		Customer:          body.Customer,
+		BillingType:       body.BillingType,
+		Value:             body.Value,
+		NextDueDate:       body.NextDueDate,
+		Cycle:             body.Cycle,
+		Description:       body.Description,
+		EndDate:           body.EndDate,
+		MaxPayments:       body.MaxPayments,
+		ExternalReference: body.ExternalID,
+		Status:            "ACTIVE",
+		CreditCard:        charged,
+		Discount:          body.Discount,
+		Interest:          body.Interest,
+		Fine:              body.Fine,
+	}
+	for _, split := range body.Split {
+		sub.Split = append(sub.Split, payments.Split{
+			ID:              s.nextID("spl"),
+			WalletID:        split.WalletID,
+			FixedValue:      split.FixedValue,
+			PercentualValue: split.PercentualValue,
+		})
+	}
+	s.subscriptions[sub.ID] = sub
+	resp := *sub
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) listSubscriptions(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	s.mu.Lock()
+	var items []subscription
+	for _, sub := range s.subscriptions {
+		if sub.Deleted {
+			continue
+		}
+		if ref := query.Get("externalReference"); ref != "" && sub.ExternalReference != ref {
+			continue
+		}
+		if customerID := query.Get("customer"); customerID != "" && sub.Customer != customerID {
+			continue
+		}
+		if status := query.Get("status"); status != "" && sub.Status != status {
+			continue
+		}
+		if billingType := query.Get("billingType"); billingType != "" && sub.BillingType != billingType {
+			continue
+		}
+		items = append(items, *sub)
+	}
+	s.mu.Unlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	writeList(w, req, items)
+}
+
+func (s *Simulator) getSubscription(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	sub, ok := s.subscriptions[req.PathValue("id")]
+	var resp subscription
+	if ok {
+		resp = *sub
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// updateSubscription changes the fields sent in the body. With updatePendingPayments
+// the pending and overdue payments of the subscription take the new value and billing type.
+func (s *Simulator) updateSubscription(w http.ResponseWriter, req *http.Request) {
+	var body payments.UpdateSubscriptionRequest
+	if !decodeBody(w, req, &body) {
+		return
+	}
+	if body.Value < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_value", "O valor da assinatura deve ser maior que zero")
+		return
+	}
+	if body.NextDueDate != "" {
+		if _, err := time.Parse("2006-01-02", body.NextDueDate); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_nextDueDate", "A data do próximo vencimento é inválida")
+			return
+		}
+	}
+	s.mu.Lock()
+	sub, ok := s.subscriptions[req.PathValue("id")]
+	if !ok || sub.Deleted {
+		s.mu.Unlock()
+		writeNotFound(w)
+		return
+	}
+	if body.BillingType != "" {
+		sub.BillingType = body.BillingType
+	}
+	if body.Value > 0 {
+		sub.Value = body.Value
+	}
+	if body.NextDueDate != "" {
+		sub.NextDueDate = body.NextDueDate
+	}
+	if body.Cycle != "" {
+		sub.Cycle = body.Cycle
+	}
+	if body.UpdatePendingPayments {
+		for _, p := range s.payments {
+			if p.Subscription == sub.ID && !p.Deleted && (p.Status == "PENDING" || p.Status == "OVERDUE") {
+				p.BillingType = sub.BillingType
+				p.Value = sub.Value
+				s.setBankSlipURL(p)
+			}
+		}
+	}
+	resp := *sub
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) deleteSubscription(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	s.mu.Lock()
+	sub, ok := s.subscriptions[id]
+	if ok {
+		sub.Deleted = true
+		sub.Status = "INACTIVE"
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, deletedResponse{Deleted: true, ID: id})
+}
+
+// listSubscriptionPayments lists the payments a subscription generated that were
+// not removed, by due date.
+func (s *Simulator) listSubscriptionPayments(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	s.mu.Lock()
+	if _, ok := s.subscriptions[id]; !ok {
+		s.mu.Unlock()
+		writeNotFound(w)
+		return
+	}
+	var items []payment
+	for _, p := range s.payments {
+		if p.Subscription == id && !p.Deleted {
+			items = append(items, *p)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].DueDate != items[j].DueDate {
+			return items[i].DueDate < items[j].DueDate
+		}
+		return items[i].ID < items[j].ID
+	})
+	writeList(w, req, items)
+}
+
+func (s *Simulator) createInvoice(w http.ResponseWriter, req *http.Request) {
+	var body payments.InvoiceRequest
+	if !decodeBody(w, req, &body) {
+		return
+	}
+	s.mu.Lock()
+	p, ok := s.payments[body.Payment]
+	if !ok || p.Deleted {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "invalid_payment", "Cobrança inexistente ou removida")
+		return
+	}
+	inv := &invoice{
+		Object:             "invoice",
+		ID:                 s.nextID("inv"),
+		Customer:           p.Customer,
+		Payment:            p.ID,
+		Status:             "SCHEDULED",
+		ServiceDescription: body.ServiceDescription,
+		Observations:       body.Observations,
+		Value:              body.Value,
+		Deductions:         body.Deductions,
+		EffectiveDate:      body.EffectiveDate,
+		ExternalReference:  body.ExternalID,
+		Taxes:              body.Taxes,
+	}
+	inv.PaymentLink = p.InvoiceURL
+	s.invoices[inv.ID] = inv
+	resp := *inv
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) listInvoices(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	s.mu.Lock()
+	var items []invoice
+	for _, inv := range s.invoices {
+		if ref := query.Get("externalReference"); ref != "" && inv.ExternalReference != ref {
+			continue
+		}
+		if paymentID := query.Get("payment"); paymentID != "" && inv.Payment != paymentID {
+			continue
+		}
+		if customerID := query.Get("customer"); customerID != "" && inv.Customer != customerID {
+			continue
+		}
+		if status := query.Get("status"); status != "" && inv.Status != status {
+			continue
+		}
+		if !dateWithin(query, "effectiveDate", inv.EffectiveDate) {
+			continue
+		}
+		items = append(items, *inv)
+	}
+	s.mu.Unlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	writeList(w, req, items)
+}
+
+func (s *Simulator) getInvoice(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	inv, ok := s.invoices[req.PathValue("id")]
+	var resp invoice
+	if ok {
+		resp = *inv
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Simulator) cancelInvoice(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	inv, ok := s.invoices[req.PathValue("id")]
+	var resp invoice
+	if ok {
+		inv.Status = "CANCELED"
+		resp = *inv
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func decodeBody(w http.ResponseWriter, req *http.Request, v any) bool {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_object", "JSON inválido: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// dateWithin applies the inclusive name[ge] and name[le] filters to a yyyy-mm-dd date.
+func dateWithin(query url.Values, name, date string) bool {
+	if from := query.Get(name + "[ge]"); from != "" && date < from {
+		return false
+	}
+	if to := query.Get(name + "[le]"); to != "" && date > to {
+		return false
+	}
+	return true
+}
+
+// writeList applies the offset and limit query parameters the way Asaas does.
+func writeList[T any](w http.ResponseWriter, req *http.Request, items []T) {
+	query := req.URL.Query()
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	total := len(items)
+	start := min(offset, total)
+	end := min(start+limit, total)
+	page := items[start:end]
+	if page == nil {
+		page = []T{}
+	}
+	writeJSON(w, http.StatusOK, listResponse{
+		Object:     "list",
+		HasMore:    end < total,
+		TotalCount: total,
+		Limit:      limit,
+		Offset:     offset,
+		Data:       page,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, struct {
+		Errors []apiError `json:"errors"`
+	}{Errors: []apiError{{Code: code, Description: description}}})
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, "not_found", "Recurso não encontrado")
+}
@@ -0,0 +1,1004 @@
+package simulator_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"asaas/src/payments"
+	"asaas/src/simulator"
+)
+
+const webhookToken = "whsec-test"
+
+// newEnvironment wires a Service backed by MemoryRepository to a simulator whose
+// webhooks are handled synchronously by the same Service.
+func newEnvironment(t *testing.T) (*payments.Service, *payments.MemoryRepository, *simulator.Simulator) {
+	t.Helper()
+	sim := simulator.New(simulator.Options{AccessToken: "test-token", WebhookToken: webhookToken})
+	api := httptest.NewServer(sim)
+	t.Cleanup(api.Close)
+	sim.SetPublicURL(api.URL)
+
+	repo := payments.NewMemoryRepository()
+	client := payments.NewAsaasClient(payments.Config{APIURL: api.URL + "/v3", APIToken: "test-token"})
+	service := payments.NewService(repo, client)
+
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("asaas-access-token") != webhookToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		payload, _ := io.ReadAll(req.Body)
+		if err := service.HandleWebhookPayload(req.Context(), payload); err != nil {
+			t.Errorf("webhook failed: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(app.Close)
+	sim.SetWebhookURL(app.URL)
+
+	return service, repo, sim
+}
+
+func TestPaymentLifecycle(t *testing.T) {
+	ctx := context.Background()
+	service, repo, sim := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Maria", Email: "maria@example.com"})
+	if err != nil {
+		t.Fatalf("register customer: %v", err)
+	}
+	payment, remote, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer:    customer.ID,
+		BillingType: "PIX",
+		Value:       payments.NewMoney(150, 0),
+		DueDate:     "2024-06-10",
+		Description: "Plano anual",
+	})
+	if err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+	if remote.ExternalReference != payment.ID || payment.AsaasID != remote.ID {
+		t.Fatalf("payment not linked: local %+v remote %+v", payment, remote)
+	}
+
+	if err := sim.ConfirmPayment(ctx, remote.ID); err != nil {
+		t.Fatalf("confirm payment: %v", err)
+	}
+	stored, err := repo.FindPaymentByID(ctx, payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != "RECEIVED" || stored.TransactionReceiptURL == "" {
+		t.Fatalf("payment not received locally: %+v", stored)
+	}
+	invoice, err := repo.FindInvoiceByPaymentID(ctx, payment.ID)
+	if err != nil {
+		t.Fatalf("invoice not issued: %v", err)
+	}
+	if invoice.AsaasID == "" || invoice.Value != payments.NewMoney(150, 0) {
+		t.Fatalf("unexpected invoice: %+v", invoice)
+	}
+
+	if err := sim.RefundPayment(ctx, remote.ID); err != nil {
+		t.Fatalf("refund payment: %v", err)
+	}
+	stored, err = repo.FindPaymentByID(ctx, payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != "REFUNDED" {
+		t.Fatalf("expected REFUNDED, got %s", stored.Status)
+	}
+
+	deliveries := sim.Deliveries()
+	if len(deliveries) != 2 || deliveries[0].Event != "PAYMENT_RECEIVED" || deliveries[1].Event != "PAYMENT_REFUNDED" {
+		t.Fatalf("unexpected deliveries: %+v", deliveries)
+	}
+}
+
+func TestOverdueRequiresPendingPayment(t *testing.T) {
+	ctx := context.Background()
+	service, repo, sim := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "João"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, remote, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "BOLETO", Value: payments.NewMoney(80, 0), DueDate: "2024-06-10",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sim.OverduePayment(ctx, remote.ID); err != nil {
+		t.Fatalf("overdue payment: %v", err)
+	}
+	stored, err := repo.FindPaymentByID(ctx, payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != "OVERDUE" {
+		t.Fatalf("expected OVERDUE, got %s", stored.Status)
+	}
+	if err := sim.OverduePayment(ctx, remote.ID); err == nil {
+		t.Fatal("expected overdue payment to reject a second transition")
+	}
+}
+
+func TestSubscriptionPaymentIsImported(t *testing.T) {
+	ctx := context.Background()
+	service, repo, sim := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Ana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, remote, err := service.CreateSubscription(ctx, payments.SubscriptionRequest{
+		Customer: customer.ID, BillingType: "PIX", Value: payments.NewMoney(39, 90), NextDueDate: "2024-06-01", Cycle: "MONTHLY",
+	})
+	if err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+
+	paymentID, err := sim.GenerateSubscriptionPayment(ctx, remote.ID)
+	if err != nil {
+		t.Fatalf("generate subscription payment: %v", err)
+	}
+
+	remotePayment, ok := sim.Payment(paymentID)
+	if !ok || remotePayment.ExternalReference == "" {
+		t.Fatalf("externalReference not updated in the simulator: %+v", remotePayment)
+	}
+	imported, err := repo.FindPaymentByID(ctx, remotePayment.ExternalReference)
+	if err != nil {
+		t.Fatalf("subscription payment not imported: %v", err)
+	}
+	if imported.SubscriptionID != subscription.ID || imported.AsaasID != paymentID || imported.DueDate.Format("2006-01-02") != "2024-06-01" {
+		t.Fatalf("unexpected imported payment: %+v", imported)
+	}
+}
+
+func TestCustomerLifecycle(t *testing.T) {
+	ctx := context.Background()
+	service, repo, _ := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Carla", Email: "carla@example.com", PostalCode: "01310-100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email, disabled := "carla@novo.com", true
+	updated, remote, err := service.UpdateCustomer(ctx, customer.ID, payments.UpdateCustomerRequest{Email: &email, NotificationDisabled: &disabled})
+	if err != nil {
+		t.Fatalf("update customer: %v", err)
+	}
+	if remote.Email != "carla@novo.com" || updated.Name != "Carla" || updated.PostalCode != "01310-100" || !updated.NotificationDisabled {
+		t.Fatalf("unexpected update: local %+v remote %+v", updated, remote)
+	}
+	// Explicit zero values are sent: notifications come back on and the postal code is cleared.
+	disabled, postalCode := false, ""
+	updated, _, err = service.UpdateCustomer(ctx, customer.ID, payments.UpdateCustomerRequest{NotificationDisabled: &disabled, PostalCode: &postalCode})
+	if err != nil {
+		t.Fatalf("update customer: %v", err)
+	}
+	if updated.NotificationDisabled || updated.PostalCode != "" || updated.Name != "Carla" || updated.Email != "carla@novo.com" {
+		t.Fatalf("unexpected update: %+v", updated)
+	}
+
+	if err := service.DeleteCustomer(ctx, customer.ID); err != nil {
+		t.Fatalf("delete customer: %v", err)
+	}
+	_, _, err = service.CreatePayment(ctx, payments.PaymentRequest{Customer: customer.ID, BillingType: "PIX", Value: payments.NewMoney(10, 0), DueDate: "2024-06-10"})
+	if !errors.Is(err, payments.ErrCustomerDeleted) {
+		t.Fatalf("expected ErrCustomerDeleted, got %v", err)
+	}
+
+	restored, remote, err := service.RestoreCustomer(ctx, customer.ID)
+	if err != nil {
+		t.Fatalf("restore customer: %v", err)
+	}
+	if remote.Deleted || !restored.DeletedAt.IsZero() {
+		t.Fatalf("customer still removed: local %+v remote %+v", restored, remote)
+	}
+	stored, err := repo.FindCustomerByID(ctx, customer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Email != "carla@novo.com" || !stored.DeletedAt.IsZero() {
+		t.Fatalf("unexpected stored customer: %+v", stored)
+	}
+}
+
+func TestListIteratorPages(t *testing.T) {
+	ctx := context.Background()
+	api := httptest.NewServer(simulator.New(simulator.Options{AccessToken: "test-token"}))
+	t.Cleanup(api.Close)
+	client := payments.NewAsaasClient(payments.Config{APIURL: api.URL + "/v3", APIToken: "test-token"})
+
+	customer, err := client.CreateCustomer(ctx, payments.CustomerRequest{Name: "Bruno"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		billingType := "PIX"
+		if i == 4 {
+			billingType = "BOLETO"
+		}
+		if _, err := client.CreatePayment(ctx, payments.PaymentRequest{Customer: customer.ID, BillingType: billingType, Value: payments.NewMoney(10, 0), DueDate: "2024-06-10"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it := client.ListPayments(ctx, payments.PaymentFilter{Customer: customer.ID, BillingType: "PIX", PageSize: 2})
+	listed, err := it.All()
+	if err != nil {
+		t.Fatalf("list payments: %v", err)
+	}
+	if len(listed) != 4 || it.TotalCount() != 4 {
+		t.Fatalf("expected 4 payments, got %d (total %d)", len(listed), it.TotalCount())
+	}
+	seen := make(map[string]bool)
+	for _, payment := range listed {
+		if seen[payment.ID] || payment.BillingType != "PIX" {
+			t.Fatalf("unexpected payment in listing: %+v", payment)
+		}
+		seen[payment.ID] = true
+	}
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	it = client.ListPayments(cancelCtx, payments.PaymentFilter{PageSize: 2})
+	if !it.Next() {
+		t.Fatalf("expected a first payment: %v", it.Err())
+	}
+	cancel()
+	if it.Next() || !errors.Is(it.Err(), context.Canceled) {
+		t.Fatalf("expected iteration to stop with context.Canceled, got %v", it.Err())
+	}
+}
+
+func TestPartialAndFullRefunds(t *testing.T) {
+	ctx := context.Background()
+	service, repo, sim := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Paula"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, remote, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "PIX", Value: payments.NewMoney(150, 0), DueDate: "2024-06-10",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.ConfirmPayment(ctx, remote.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	partial, _, err := service.RefundPayment(ctx, payment.ID, payments.RefundRequest{Value: payments.NewMoney(30, 0), Description: "Item devolvido"})
+	if err != nil {
+		t.Fatalf("partial refund: %v", err)
+	}
+	if partial.Status != payments.RefundStatusDone || partial.Value != payments.NewMoney(30, 0) {
+		t.Fatalf("unexpected partial refund: %+v", partial)
+	}
+
+	full, after, err := service.RefundPayment(ctx, payment.ID, payments.RefundRequest{})
+	if err != nil {
+		t.Fatalf("full refund: %v", err)
+	}
+	if full.Value != payments.NewMoney(120, 0) || after.Status != "REFUNDED" {
+		t.Fatalf("unexpected full refund: %+v (payment %s)", full, after.Status)
+	}
+
+	// The webhooks arrive before the API answers, so each refund must be stored once.
+	refunds, err := repo.ListRefundsByPaymentID(ctx, payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 2 || refunds[0].Description != "Item devolvido" || refunds[1].Status != payments.RefundStatusDone {
+		t.Fatalf("unexpected local refunds: %+v", refunds)
+	}
+	stored, err := repo.FindPaymentByID(ctx, payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != "REFUNDED" {
+		t.Fatalf("expected REFUNDED, got %s", stored.Status)
+	}
+
+	if _, _, err := service.RefundPayment(ctx, payment.ID, payments.RefundRequest{}); !errors.Is(err, payments.ErrInvalidRefund) {
+		t.Fatalf("expected ErrInvalidRefund, got %v", err)
+	}
+}
+
+func TestPixQrCodeIsCachedUntilExpired(t *testing.T) {
+	ctx := context.Background()
+	service, repo, _ := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Renata"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, _, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "PIX", Value: payments.NewMoney(80, 0), DueDate: "2999-01-10",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := service.PaymentPixQrCode(ctx, payment.ID)
+	if err != nil {
+		t.Fatalf("pix qr code: %v", err)
+	}
+	if first.Payload == "" || first.EncodedImage == "" || first.ExpirationDate.IsZero() {
+		t.Fatalf("unexpected qr code: %+v", first)
+	}
+	cached, err := service.PaymentPixQrCode(ctx, payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Payload != first.Payload {
+		t.Fatalf("expected the stored qr code, got a new one")
+	}
+
+	expired := first
+	expired.ExpirationDate = time.Now().Add(-time.Minute)
+	if err := repo.SavePixQrCode(ctx, payment.ID, expired); err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := service.PaymentPixQrCode(ctx, payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Payload == first.Payload {
+		t.Fatalf("expected a new qr code after expiration")
+	}
+
+	boleto, _, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "BOLETO", Value: payments.NewMoney(80, 0), DueDate: "2999-01-10",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.PaymentPixQrCode(ctx, boleto.ID); !errors.Is(err, payments.ErrBillingTypeMismatch) {
+		t.Fatalf("expected ErrBillingTypeMismatch, got %v", err)
+	}
+}
+
+func TestBoletoIsStoredLocally(t *testing.T) {
+	ctx := context.Background()
+	service, repo, _ := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Sérgio"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, _, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "BOLETO", Value: payments.NewMoney(99, 90), DueDate: "2999-01-10",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boleto, err := service.PaymentBoleto(ctx, payment.ID)
+	if err != nil {
+		t.Fatalf("boleto: %v", err)
+	}
+	if len(boleto.IdentificationField) != 47 || len(boleto.BarCode) != 44 || boleto.NossoNumero == "" || boleto.BankSlipURL == "" {
+		t.Fatalf("unexpected boleto: %+v", boleto)
+	}
+	if stored, err := repo.FindBoleto(ctx, payment.ID); err != nil || stored != boleto {
+		t.Fatalf("boleto not stored: %+v, %v", stored, err)
+	}
+
+	pdf, err := service.PaymentBankSlipPDF(ctx, payment.ID)
+	if err != nil {
+		t.Fatalf("bank slip: %v", err)
+	}
+	if !strings.HasPrefix(string(pdf), "%PDF") || !strings.Contains(string(pdf), boleto.IdentificationField) {
+		t.Fatalf("unexpected pdf: %q", pdf)
+	}
+	if stored, err := repo.FindBankSlipPDF(ctx, payment.ID); err != nil || string(stored) != string(pdf) {
+		t.Fatalf("pdf not stored: %v", err)
+	}
+
+	pix, _, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "PIX", Value: payments.NewMoney(10, 0), DueDate: "2999-01-10",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.PaymentBankSlipPDF(ctx, pix.ID); !errors.Is(err, payments.ErrBillingTypeMismatch) {
+		t.Fatalf("expected ErrBillingTypeMismatch, got %v", err)
+	}
+}
+
+func TestCreditCardTokenIsReused(t *testing.T) {
+	ctx := context.Background()
+	service, repo, _ := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Tânia"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	card := &payments.CreditCard{HolderName: "TANIA S", Number: "5162306219378829", ExpiryMonth: "05", ExpiryYear: "2031", CCV: "318"}
+	holder := &payments.CreditCardHolderInfo{Name: "Tânia", Email: "tania@example.com", CpfCnpj: "24971563792", PostalCode: "89223005", AddressNumber: "277", Phone: "4738010919"}
+
+	request := payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "CREDIT_CARD", Value: payments.NewMoney(120, 0), DueDate: "2999-01-10",
+		CreditCard: card, CreditCardHolderInfo: holder, RemoteIP: "203.0.113.7",
+	}
+	_, remote, err := service.CreatePayment(ctx, request)
+	if err != nil {
+		t.Fatalf("card payment: %v", err)
+	}
+	if remote.CreditCard == nil || remote.CreditCard.CreditCardToken != "" {
+		t.Fatalf("card token returned: %+v", remote.CreditCard)
+	}
+	// Cards are only saved on request.
+	if unsaved, _ := repo.FindCustomerByID(ctx, customer.ID); unsaved.CreditCardToken != "" {
+		t.Fatalf("card saved without saveCreditCard: %+v", unsaved)
+	}
+	if _, _, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "CREDIT_CARD", Value: payments.NewMoney(60, 0), DueDate: "2999-02-10", UseSavedCard: true,
+	}); !errors.Is(err, payments.ErrInvalidCreditCard) {
+		t.Fatalf("expected ErrInvalidCreditCard without a saved card, got %v", err)
+	}
+	request.SaveCreditCard = true
+	if _, _, err := service.CreatePayment(ctx, request); err != nil {
+		t.Fatalf("card payment: %v", err)
+	}
+	saved, err := repo.FindCustomerByID(ctx, customer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.CreditCardToken == "" || saved.CreditCardBrand != "MASTERCARD" || saved.CreditCardLast4 != "8829" {
+		t.Fatalf("card not saved: %+v", saved)
+	}
+	if record, _ := json.Marshal(saved); strings.Contains(string(record), card.Number) || strings.Contains(string(record), saved.CreditCardToken) {
+		t.Fatalf("customer record exposes card data: %s", record)
+	}
+	if printed := fmt.Sprintf("%v %+v %#v", *card, *card, *card); strings.Contains(printed, card.Number) || strings.Contains(printed, card.CCV) {
+		t.Fatalf("card data printed: %s", printed)
+	}
+
+	// Without card data the saved card is charged only when asked; otherwise the
+	// buyer pays on the invoice page.
+	if invoice, remote, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "CREDIT_CARD", Value: payments.NewMoney(60, 0), DueDate: "2999-02-10", RemoteIP: "203.0.113.7",
+	}); err != nil || invoice.Status != "PENDING" || remote.CreditCard != nil {
+		t.Fatalf("saved card charged without useSavedCard: %+v %v", remote, err)
+	}
+	payment, remote, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "CREDIT_CARD", Value: payments.NewMoney(60, 0), DueDate: "2999-02-10", RemoteIP: "203.0.113.7", UseSavedCard: true,
+	})
+	if err != nil {
+		t.Fatalf("token payment: %v", err)
+	}
+	if payment.Status != "CONFIRMED" || remote.CreditCard == nil || remote.CreditCard.CreditCardNumber != saved.CreditCardLast4 || remote.CreditCard.CreditCardToken != "" {
+		t.Fatalf("saved card not charged: %+v", remote)
+	}
+
+	other, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Ulisses"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenized, err := service.TokenizeCreditCard(ctx, other.ID, payments.TokenizeCreditCardRequest{
+		CreditCard:           &payments.CreditCard{HolderName: "ULISSES", Number: "4111111111111111", ExpiryMonth: "01", ExpiryYear: "2030", CCV: "123"},
+		CreditCardHolderInfo: holder, RemoteIP: "203.0.113.8",
+	})
+	if err != nil {
+		t.Fatalf("tokenize: %v", err)
+	}
+	if tokenized.CreditCardBrand != "VISA" || tokenized.CreditCardLast4 != "1111" || tokenized.CreditCardToken == saved.CreditCardToken {
+		t.Fatalf("unexpected tokenized card: %+v", tokenized)
+	}
+	if _, err := service.TokenizeCreditCard(ctx, other.ID, payments.TokenizeCreditCardRequest{}); !errors.Is(err, payments.ErrInvalidCreditCard) {
+		t.Fatalf("expected ErrInvalidCreditCard, got %v", err)
+	}
+}
+
+func TestPreAuthorizationLifecycle(t *testing.T) {
+	ctx := context.Background()
+	service, repo, sim := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Vera"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.TokenizeCreditCard(ctx, customer.ID, payments.TokenizeCreditCardRequest{
+		CreditCard:           &payments.CreditCard{HolderName: "VERA", Number: "5162306219378829", ExpiryMonth: "05", ExpiryYear: "2031", CCV: "318"},
+		CreditCardHolderInfo: &payments.CreditCardHolderInfo{Name: "Vera", CpfCnpj: "24971563792", PostalCode: "89223005", AddressNumber: "1"},
+		RemoteIP:             "203.0.113.9",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	authorize := func() payments.PaymentRecord {
+		t.Helper()
+		payment, _, err := service.CreatePayment(ctx, payments.PaymentRequest{
+			Customer: customer.ID, BillingType: "CREDIT_CARD", Value: payments.NewMoney(250, 0), DueDate: "2999-01-10",
+			RemoteIP: "203.0.113.9", AuthorizeOnly: true, UseSavedCard: true,
+		})
+		if err != nil {
+			t.Fatalf("authorize: %v", err)
+		}
+		if payment.Status != "AUTHORIZED" || payment.AuthorizationStatus != payments.AuthorizationStatusAuthorized {
+			t.Fatalf("unexpected authorization: %+v", payment)
+		}
+		return payment
+	}
+
+	captured := authorize()
+	payment, remote, err := service.CapturePayment(ctx, captured.ID)
+	if err != nil {
+		t.Fatalf("capture: %v", err)
+	}
+	if remote.Status != "CONFIRMED" || payment.AuthorizationStatus != payments.AuthorizationStatusCaptured {
+		t.Fatalf("unexpected capture: %+v", payment)
+	}
+	if _, _, err := service.CapturePayment(ctx, captured.ID); !errors.Is(err, payments.ErrInvalidAuthorization) {
+		t.Fatalf("expected ErrInvalidAuthorization, got %v", err)
+	}
+
+	refused := authorize()
+	if err := sim.RefuseCapture(ctx, refused.AsaasID); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := repo.FindPaymentByID(ctx, refused.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.AuthorizationStatus != payments.AuthorizationStatusRefused {
+		t.Fatalf("expected REFUSED, got %q", stored.AuthorizationStatus)
+	}
+
+	expired := authorize()
+	cancelled, err := service.CancelExpiredAuthorizations(ctx, 0)
+	if err != nil {
+		t.Fatalf("cancel expired: %v", err)
+	}
+	if cancelled != 1 {
+		t.Fatalf("expected one cancelled authorization, got %d", cancelled)
+	}
+	stored, err = repo.FindPaymentByID(ctx, expired.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.AuthorizationStatus != payments.AuthorizationStatusCancelled || stored.Status != "REFUNDED" {
+		t.Fatalf("unexpected cancelled authorization: %+v", stored)
+	}
+
+	if _, _, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "PIX", Value: payments.NewMoney(10, 0), DueDate: "2999-01-10", AuthorizeOnly: true,
+	}); !errors.Is(err, payments.ErrBillingTypeMismatch) {
+		t.Fatalf("expected ErrBillingTypeMismatch, got %v", err)
+	}
+}
+
+func TestInstallmentPlanLifecycle(t *testing.T) {
+	ctx := context.Background()
+	service, repo, sim := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Otávio"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, remote, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "BOLETO", DueDate: "2999-01-10",
+		InstallmentCount: 3, TotalValue: payments.NewMoney(100, 0),
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if remote.Installment == "" || first.InstallmentID == "" || first.InstallmentNumber != 1 {
+		t.Fatalf("unexpected first installment: %+v", first)
+	}
+
+	details, err := service.Installment(ctx, first.InstallmentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.TotalValue != payments.NewMoney(100, 0) || len(details.Payments) != 3 {
+		t.Fatalf("unexpected installment: %+v", details)
+	}
+	var total payments.Money
+	for i, payment := range details.Payments {
+		if payment.InstallmentNumber != i+1 {
+			t.Fatalf("unexpected order: %+v", details.Payments)
+		}
+		stored, _ := sim.Payment(payment.AsaasID)
+		if stored.ExternalReference != payment.ID {
+			t.Fatalf("externalReference of %s is %q, want %q", payment.AsaasID, stored.ExternalReference, payment.ID)
+		}
+		total += payment.Value
+	}
+	if total != payments.NewMoney(100, 0) {
+		t.Fatalf("installments add up to %s", total)
+	}
+
+	for _, payment := range details.Payments[:2] {
+		if err := sim.ConfirmPayment(ctx, payment.AsaasID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	details, err = service.CancelRemainingInstallments(ctx, first.InstallmentID)
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if details.Status != payments.InstallmentStatusCancelled || details.Payments[2].Status != payments.PaymentStatusDeleted {
+		t.Fatalf("unexpected cancelled installment: %+v", details)
+	}
+
+	details, err = service.RefundInstallment(ctx, first.InstallmentID)
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	for _, payment := range details.Payments[:2] {
+		if payment.Status != "REFUNDED" {
+			t.Fatalf("expected refunded installment, got %+v", payment)
+		}
+		refunds, err := repo.ListRefundsByPaymentID(ctx, payment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refunds) != 1 {
+			t.Fatalf("expected one refund for %s, got %+v", payment.ID, refunds)
+		}
+	}
+
+	if _, _, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "BOLETO", DueDate: "2999-01-10",
+		InstallmentCount: 2, InstallmentValue: payments.NewMoney(10, 0), TotalValue: payments.NewMoney(20, 0),
+	}); !errors.Is(err, payments.ErrInvalidInstallment) {
+		t.Fatalf("expected ErrInvalidInstallment, got %v", err)
+	}
+}
+
+func TestChargeTermsDefaultsAndValidation(t *testing.T) {
+	ctx := context.Background()
+	service, repo, sim := newEnvironment(t)
+	service.SetChargeDefaults(payments.ChargeDefaults{
+		Interest: &payments.Interest{Value: payments.NewPercentage(1, 0)},
+		Fine:     &payments.Fine{Value: payments.NewPercentage(2, 0)},
+	})
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Lúcia"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, remote, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "BOLETO", Value: payments.NewMoney(200, 0), DueDate: "2999-01-10",
+		Discount: &payments.Discount{Value: payments.NewPercentage(10, 0), DueDateLimitDays: 5, Type: payments.ValueTypePercentage},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	want := payments.ChargeTerms{
+		DiscountValue: payments.NewPercentage(10, 0), DiscountDueDateLimitDays: 5, DiscountType: payments.ValueTypePercentage,
+		InterestValue: payments.NewPercentage(1, 0), FineValue: payments.NewPercentage(2, 0), FineType: payments.ValueTypePercentage,
+	}
+	if payment.ChargeTerms != want {
+		t.Fatalf("unexpected terms: %+v", payment.ChargeTerms)
+	}
+	if remote.Interest == nil || remote.Fine == nil || remote.Fine.Type != payments.ValueTypePercentage {
+		t.Fatalf("defaults not sent to Asaas: %+v", remote)
+	}
+
+	withoutFine, remote, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "BOLETO", Value: payments.NewMoney(50, 0), DueDate: "2999-01-10",
+		Fine: &payments.Fine{},
+	})
+	if err != nil {
+		t.Fatalf("create without fine: %v", err)
+	}
+	if remote.Fine != nil || withoutFine.FineValue != 0 || withoutFine.InterestValue != payments.NewPercentage(1, 0) {
+		t.Fatalf("zero fine should disable the default: %+v", withoutFine.ChargeTerms)
+	}
+
+	for name, req := range map[string]payments.PaymentRequest{
+		"fixed discount above the value": {Discount: &payments.Discount{Value: payments.NewPercentage(50, 0), Type: payments.ValueTypeFixed}},
+		"percentage above 100":           {Fine: &payments.Fine{Value: payments.NewPercentage(150, 0), Type: payments.ValueTypePercentage}},
+		"unknown discount type":          {Discount: &payments.Discount{Value: payments.NewPercentage(1, 0), Type: "PERCENT"}},
+	} {
+		req.Customer, req.BillingType, req.Value, req.DueDate = customer.ID, "BOLETO", payments.NewMoney(50, 0), "2999-01-10"
+		if _, _, err := service.CreatePayment(ctx, req); !errors.Is(err, payments.ErrInvalidChargeTerms) {
+			t.Fatalf("%s: expected ErrInvalidChargeTerms, got %v", name, err)
+		}
+	}
+
+	_, subscription, err := service.CreateSubscription(ctx, payments.SubscriptionRequest{
+		Customer: customer.ID, BillingType: "BOLETO", Value: payments.NewMoney(80, 0), NextDueDate: "2999-02-01", Cycle: "MONTHLY",
+	})
+	if err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	paymentID, err := sim.GenerateSubscriptionPayment(ctx, subscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := repo.FindPaymentByAsaasID(ctx, paymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.InterestValue != payments.NewPercentage(1, 0) || imported.FineValue != payments.NewPercentage(2, 0) {
+		t.Fatalf("subscription terms not copied to its payment: %+v", imported.ChargeTerms)
+	}
+}
+
+func TestSplitsFollowWebhooks(t *testing.T) {
+	ctx := context.Background()
+	service, repo, sim := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Otávio"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	split := []payments.Split{
+		{WalletID: "wallet-a", PercentualValue: payments.NewPercentage(10, 0)},
+		{WalletID: "wallet-b", FixedValue: payments.NewMoney(5, 0)},
+	}
+	payment, remote, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "PIX", Value: payments.NewMoney(100, 0), DueDate: "2999-01-10", Split: split,
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	splits, err := service.PaymentSplits(ctx, payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(splits) != 2 {
+		t.Fatalf("expected 2 splits, got %+v", splits)
+	}
+	for _, s := range splits {
+		if s.AsaasID == "" || s.Status != payments.SplitStatusPending {
+			t.Fatalf("unexpected split: %+v", s)
+		}
+		if s.WalletID == "wallet-a" && s.TotalValue != payments.NewMoney(10, 0) {
+			t.Fatalf("unexpected percentual split value: %s", s.TotalValue)
+		}
+	}
+
+	if err := sim.BlockSplit(ctx, remote.ID); err != nil {
+		t.Fatal(err)
+	}
+	splits, _ = repo.ListSplitsByPaymentID(ctx, payment.ID)
+	for _, s := range splits {
+		if s.Status != payments.SplitStatusBlocked {
+			t.Fatalf("split not blocked: %+v", s)
+		}
+	}
+	stored, _ := repo.FindPaymentByID(ctx, payment.ID)
+	if stored.Status != "PENDING" {
+		t.Fatalf("split event changed the payment status to %s", stored.Status)
+	}
+	if err := sim.UnblockSplit(ctx, remote.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.CancelSplit(ctx, remote.ID); err != nil {
+		t.Fatal(err)
+	}
+	splits, _ = repo.ListSplitsByPaymentID(ctx, payment.ID)
+	for _, s := range splits {
+		if s.Status != payments.SplitStatusCancelled || s.CancellationReason == "" {
+			t.Fatalf("split not cancelled: %+v", s)
+		}
+	}
+
+	if _, _, err := service.CreatePayment(ctx, payments.PaymentRequest{
+		Customer: customer.ID, BillingType: "PIX", Value: payments.NewMoney(100, 0), DueDate: "2999-01-10",
+		Split: []payments.Split{{WalletID: "wallet-a", PercentualValue: payments.NewPercentage(60, 0)}, {WalletID: "wallet-b", PercentualValue: payments.NewPercentage(50, 0)}},
+	}); !errors.Is(err, payments.ErrInvalidSplit) {
+		t.Fatalf("expected ErrInvalidSplit, got %v", err)
+	}
+
+	subscription, remoteSubscription, err := service.CreateSubscription(ctx, payments.SubscriptionRequest{
+		Customer: customer.ID, BillingType: "PIX", Value: payments.NewMoney(40, 0), NextDueDate: "2999-02-01", Cycle: "MONTHLY", Split: split[:1],
+	})
+	if err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	paymentID, err := sim.GenerateSubscriptionPayment(ctx, remoteSubscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := repo.FindPaymentByAsaasID(ctx, paymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if importedSplits, _ := repo.ListSplitsByPaymentID(ctx, imported.ID); len(importedSplits) != 1 || importedSplits[0].TotalValue != payments.NewMoney(4, 0) {
+		t.Fatalf("subscription split not copied to its payment: %+v", importedSplits)
+	}
+
+	if err := sim.DisableSubscriptionSplit(ctx, remoteSubscription.ID); err != nil {
+		t.Fatal(err)
+	}
+	subscriptionSplits, err := service.SubscriptionSplits(ctx, subscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptionSplits) != 1 || subscriptionSplits[0].Status != payments.SplitStatusDisabled {
+		t.Fatalf("subscription split not disabled: %+v", subscriptionSplits)
+	}
+	storedSubscription, _ := repo.FindSubscriptionByID(ctx, subscription.ID)
+	if storedSubscription.Status != "ACTIVE" {
+		t.Fatalf("split event changed the subscription status to %s", storedSubscription.Status)
+	}
+}
+
+func TestSubscriptionUpdateKeepsHistory(t *testing.T) {
+	ctx := context.Background()
+	service, repo, sim := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Helena"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, remote, err := service.CreateSubscription(ctx, payments.SubscriptionRequest{
+		Customer: customer.ID, BillingType: "BOLETO", Value: payments.NewMoney(50, 0), NextDueDate: "2999-01-10", Cycle: "MONTHLY",
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	pendingID, err := sim.GenerateSubscriptionPayment(ctx, remote.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := repo.FindPaymentByAsaasID(ctx, pendingID)
+	if _, err := service.PaymentBoleto(ctx, pending.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.PaymentBankSlipPDF(ctx, pending.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, _, err := service.UpdateSubscription(ctx, subscription.ID, payments.UpdateSubscriptionRequest{
+		Value: payments.NewMoney(80, 0), Cycle: "YEARLY", BillingType: "PIX", UpdatePendingPayments: true,
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if updated.Value != payments.NewMoney(80, 0) || updated.Cycle != "YEARLY" || updated.BillingType != "PIX" {
+		t.Fatalf("unexpected subscription: %+v", updated)
+	}
+	stored, _ := repo.FindSubscriptionByID(ctx, subscription.ID)
+	if stored.Value != updated.Value || stored.Cycle != "YEARLY" {
+		t.Fatalf("local subscription not updated: %+v", stored)
+	}
+	pending, _ = repo.FindPaymentByAsaasID(ctx, pendingID)
+	if pending.Value != payments.NewMoney(80, 0) || pending.BillingType != "PIX" {
+		t.Fatalf("pending payment not updated: %+v", pending)
+	}
+	if _, err := repo.FindBoleto(ctx, pending.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("stale boleto kept: %v", err)
+	}
+	if _, err := repo.FindBankSlipPDF(ctx, pending.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("stale bank slip kept: %v", err)
+	}
+	if _, err := service.PaymentPixQrCode(ctx, pending.ID); err != nil {
+		t.Fatal(err)
+	}
+	if remotePending, _ := sim.Payment(pendingID); remotePending.Value != payments.NewMoney(80, 0) {
+		t.Fatalf("pending payment not updated in Asaas: %+v", remotePending)
+	}
+
+	changes, err := service.SubscriptionChanges(ctx, subscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, change := range changes {
+		got[change.Field] = change.PreviousValue + " -> " + change.NewValue
+	}
+	// Generating the payment moved the next due date in Asaas, and the update brings it over.
+	want := map[string]string{
+		"billingType": "BOLETO -> PIX", "value": "50.00 -> 80.00", "cycle": "MONTHLY -> YEARLY", "nextDueDate": "2999-01-10 -> 2999-02-10",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected history: %+v", changes)
+	}
+	for field, change := range want {
+		if got[field] != change {
+			t.Fatalf("%s: expected %q, got %q", field, change, got[field])
+		}
+	}
+
+	if _, _, err := service.UpdateSubscription(ctx, subscription.ID, payments.UpdateSubscriptionRequest{Value: payments.NewMoney(85, 0), UpdatePendingPayments: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.FindPixQrCode(ctx, pending.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("stale pix qr code kept: %v", err)
+	}
+
+	if _, _, err := service.UpdateSubscription(ctx, subscription.ID, payments.UpdateSubscriptionRequest{}); !errors.Is(err, payments.ErrInvalidSubscriptionUpdate) {
+		t.Fatalf("expected ErrInvalidSubscriptionUpdate, got %v", err)
+	}
+	if err := repo.UpdateSubscriptionStatus(ctx, subscription.ID, payments.SubscriptionStatusInactive); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := service.UpdateSubscription(ctx, subscription.ID, payments.UpdateSubscriptionRequest{Value: payments.NewMoney(90, 0)}); !errors.Is(err, payments.ErrSubscriptionInactive) {
+		t.Fatalf("expected ErrSubscriptionInactive, got %v", err)
+	}
+}
+
+func TestSyncSubscriptionPaymentsImportsMissedWebhooks(t *testing.T) {
+	ctx := context.Background()
+	service, repo, sim := newEnvironment(t)
+
+	customer, _, err := service.RegisterCustomer(ctx, payments.CustomerRequest{Name: "Igor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, remote, err := service.CreateSubscription(ctx, payments.SubscriptionRequest{
+		Customer: customer.ID, BillingType: "PIX", Value: payments.NewMoney(25, 0), NextDueDate: "2999-03-05", Cycle: "MONTHLY",
+	})
+	if err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	deliveredID, err := sim.GenerateSubscriptionPayment(ctx, remote.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The next PAYMENT_CREATED webhooks never reach the service.
+	sim.SetWebhookURL("")
+	var missedIDs []string
+	for range 2 {
+		id, err := sim.GenerateSubscriptionPayment(ctx, remote.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		missedIDs = append(missedIDs, id)
+	}
+
+	imported, err := service.SyncSubscriptionPayments(ctx, subscription.ID)
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if len(imported) != len(missedIDs) {
+		t.Fatalf("expected %d imported payments, got %+v", len(missedIDs), imported)
+	}
+	for _, id := range missedIDs {
+		local, err := repo.FindPaymentByAsaasID(ctx, id)
+		if err != nil {
+			t.Fatalf("payment %s not imported: %v", id, err)
+		}
+		if local.SubscriptionID != subscription.ID || local.CustomerID != customer.ID {
+			t.Fatalf("unexpected imported payment: %+v", local)
+		}
+		if remotePayment, _ := sim.Payment(id); remotePayment.ExternalReference != local.ID {
+			t.Fatalf("externalReference of %s: expected %q, got %q", id, local.ID, remotePayment.ExternalReference)
+		}
+	}
+
+	imported, err = service.SyncSubscriptionPayments(ctx, subscription.ID)
+	if err != nil || len(imported) != 0 {
+		t.Fatalf("second sync imported %+v: %v", imported, err)
+	}
+	page, err := service.SubscriptionPayments(ctx, subscription.ID, payments.ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 3 {
+		t.Fatalf("expected 3 local payments, got %+v", page.Items)
+	}
+	if delivered, _ := repo.FindPaymentByAsaasID(ctx, deliveredID); delivered.SubscriptionID != subscription.ID {
+		t.Fatalf("delivered payment changed: %+v", delivered)
+	}
+	if _, err := service.SubscriptionPayments(ctx, "missing", payments.ListFilter{}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"asaas/src/payments"
+)
+
+var (
+	// ErrNotFound is returned by triggers for unknown objects.
+	ErrNotFound = errors.New("objeto não encontrado no simulador")
+	// ErrInvalidTransition is returned when an object cannot move to the requested status.
+	ErrInvalidTransition = errors.New("transição de status inválida")
+)
+
+// WebhookDelivery records a webhook sent by the simulator.
+type WebhookDelivery struct {
+	EventID    string    `json:"eventId"`
+	Event      string    `json:"event"`
+	ObjectID   string    `json:"objectId"`
+	StatusCode int       `json:"statusCode"`
+	Error      string    `json:"error,omitempty"`
+	SentAt     time.Time `json:"sentAt"`
+}
+
+type webhookEvent struct {
+	ID           string        `json:"id"`
+	Event        string        `json:"event"`
+	DateCreated  string        `json:"dateCreated"`
+	Payment      *payment      `json:"payment,omitempty"`
+	Subscription *subscription `json:"subscription,omitempty"`
+	Invoice      *invoice      `json:"invoice,omitempty"`
+}
+
+// ConfirmPayment marks a pending or overdue payment as paid. Credit card payments
+// become CONFIRMED (PAYMENT_CONFIRMED); other billing types become RECEIVED (PAYMENT_RECEIVED).
+func (s *Simulator) ConfirmPayment(ctx context.Context, id string) error {
+	return s.transitionPayment(ctx, id, func(p *payment) (string, error) {
+		if p.Status != "PENDING" && p.Status != "OVERDUE" {
+			return "", fmt.Errorf("%w: %s não pode ser confirmada", ErrInvalidTransition, p.Status)
+		}
+		p.TransactionReceiptURL = p.InvoiceURL + "/receipt"
+		if p.BillingType == "CREDIT_CARD" {
+			p.Status = "CONFIRMED"
+			return "PAYMENT_CONFIRMED", nil
+		}
+		p.Status = "RECEIVED"
+		return "PAYMENT_RECEIVED", nil
+	})
+}
+
+// OverduePayment marks a pending payment as OVERDUE and sends PAYMENT_OVERDUE.
+func (s *Simulator) OverduePayment(ctx context.Context, id string) error {
+	return s.transitionPayment(ctx, id, func(p *payment) (string, error) {
+		if p.Status != "PENDING" {
+			return "", fmt.Errorf("%w: %s não pode vencer", ErrInvalidTransition, p.Status)
+		}
+		p.Status = "OVERDUE"
+		return "PAYMENT_OVERDUE", nil
+	})
+}
+
+// RefundPayment refunds what is left of a paid payment, marks it as REFUNDED and
+// sends PAYMENT_REFUNDED.
+func (s *Simulator) RefundPayment(ctx context.Context, id string) error {
+	return s.transitionPayment(ctx, id, func(p *payment) (string, error) {
+		return s.refund(p, 0, "")
+	})
+}
+
+// RefuseCapture refuses the capture of an authorized card payment, which goes back
+// to PENDING, and sends PAYMENT_CREDIT_CARD_CAPTURE_REFUSED.
+func (s *Simulator) RefuseCapture(ctx context.Context, id string) error {
+	return s.transitionPayment(ctx, id, func(p *payment) (string, error) {
+		if p.Status != "AUTHORIZED" {
+			return "", fmt.Errorf("%w: %s não está autorizada", ErrInvalidTransition, p.Status)
+		}
+		p.Status = "PENDING"
+		return "PAYMENT_CREDIT_CARD_CAPTURE_REFUSED", nil
+	})
+}
+
+// CancelSplit cancels the splits of a payment that were not credited yet and sends
+// PAYMENT_SPLIT_CANCELLED, listing the splits.
+func (s *Simulator) CancelSplit(ctx context.Context, id string) error {
+	return s.transitionPayment(ctx, id, func(p *payment) (string, error) {
+		if err := setSplitStatus(p, "CANCELLED", "PENDING", "BLOCKED_BY_VALUE_DIVERGENCE"); err != nil {
+			return "", err
+		}
+		for i := range p.Split {
+			if p.Split[i].Status == "CANCELLED" {
+				p.Split[i].CancellationReason = "PAYMENT_REFUNDED"
+			}
+		}
+		return "PAYMENT_SPLIT_CANCELLED", nil
+	})
+}
+
+// BlockSplit blocks the pending splits of a payment for a value divergence and
+// sends PAYMENT_SPLIT_DIVERGENCE_BLOCK.
+func (s *Simulator) BlockSplit(ctx context.Context, id string) error {
+	return s.transitionPayment(ctx, id, func(p *payment) (string, error) {
+		if err := setSplitStatus(p, "BLOCKED_BY_VALUE_DIVERGENCE", "PENDING"); err != nil {
+			return "", err
+		}
+		return "PAYMENT_SPLIT_DIVERGENCE_BLOCK", nil
+	})
+}
+
+// UnblockSplit releases the blocked splits of a payment and sends
+// PAYMENT_SPLIT_DIVERGENCE_BLOCK_FINISHED.
+func (s *Simulator) UnblockSplit(ctx context.Context, id string) error {
+	return s.transitionPayment(ctx, id, func(p *payment) (string, error) {
+		if err := setSplitStatus(p, "PENDING", "BLOCKED_BY_VALUE_DIVERGENCE"); err != nil {
+			return "", err
+		}
+		return "PAYMENT_SPLIT_DIVERGENCE_BLOCK_FINISHED", nil
+	})
+}
+
+// setSplitStatus moves the splits of p in one of the from statuses to status. The
+// caller must hold the simulator lock.
+func setSplitStatus(p *payment, status string, from ...string) error {
+	changed := false
+	for i := range p.Split {
+		for _, current := range from {
+			if p.Split[i].Status == current {
+				p.Split[i].Status = status
+				changed = true
+				break
+			}
+		}
+	}
+	if !changed {
+		return fmt.Errorf("%w: cobrança %s sem split que possa ir para %s", ErrInvalidTransition, p.ID, status)
+	}
+	return nil
+}
+
+// DisableSubscriptionSplit removes the splits of a subscription and sends
+// SUBSCRIPTION_SPLIT_DISABLED, whose payload no longer lists them.
+func (s *Simulator) DisableSubscriptionSplit(ctx context.Context, id string) error {
+	s.mu.Lock()
+	sub, ok := s.subscriptions[id]
+	if !ok || sub.Deleted {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: assinatura %s", ErrNotFound, id)
+	}
+	if len(sub.Split) == 0 {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: assinatura %s sem split", ErrInvalidTransition, id)
+	}
+	sub.Split = nil
+	snapshot := *sub
+	event := s.newEvent("SUBSCRIPTION_SPLIT_DISABLED")
+	event.Subscription = &snapshot
+	s.mu.Unlock()
+
+	return s.deliver(ctx, event, id)
+}
+
+// refund adds a completed refund of value, or of the remaining amount when value is
+// zero. Refunding the remaining amount marks the payment as REFUNDED; smaller
+// refunds send PAYMENT_PARTIALLY_REFUNDED. Refunding an authorized payment cancels
+// the authorization, so only the whole value is accepted. The caller must hold s.mu.
+func (s *Simulator) refund(p *payment, value payments.Money, description string) (string, error) {
+	if p.Status == "AUTHORIZED" {
+		if value != 0 && value != p.Value {
+			return "", fmt.Errorf("%w: autorização só pode ser cancelada pelo valor total", ErrInvalidTransition)
+		}
+		p.Status = "REFUNDED"
+		return "PAYMENT_REFUNDED", nil
+	}
+	if p.Status != "RECEIVED" && p.Status != "CONFIRMED" {
+		return "", fmt.Errorf("%w: %s não pode ser estornada", ErrInvalidTransition, p.Status)
+	}
+	remaining := p.Value
+	for _, refund := range p.Refunds {
+		remaining -= refund.Value
+	}
+	if value == 0 {
+		value = remaining
+	}
+	if value <= 0 || value > remaining {
+		return "", fmt.Errorf("%w: valor de estorno %s acima do saldo de %s", ErrInvalidTransition, value, remaining)
+	}
+	p.Refunds = append(p.Refunds, payments.PaymentRefund{
+		DateCreated:           s.opts.Now().UTC().Format("2006-01-02 15:04:05"),
+		Status:                "DONE",
+		Value:                 value,
+		Description:           description,
+		TransactionReceiptURL: fmt.Sprintf("%s/refunds/%d", p.InvoiceURL, len(p.Refunds)+1),
+	})
+	if value < remaining {
+		return "PAYMENT_PARTIALLY_REFUNDED", nil
+	}
+	p.Status = "REFUNDED"
+	return "PAYMENT_REFUNDED", nil
+}
+
+// GenerateSubscriptionPayment creates the next payment of a subscription, advances its
+// next due date and sends PAYMENT_CREATED, as Asaas does when a cycle starts.
+func (s *Simulator) GenerateSubscriptionPayment(ctx context.Context, subscriptionID string) (string, error) {
+	s.mu.Lock()
+	sub, ok := s.subscriptions[subscriptionID]
+	if !ok || sub.Deleted {
+		s.mu.Unlock()
+		return "", fmt.Errorf("%w: assinatura %s", ErrNotFound, subscriptionID)
+	}
+	p := s.newPayment(sub.Customer, sub.BillingType, sub.Value, sub.NextDueDate)
+	p.Subscription = sub.ID
+	p.Description = sub.Description
+	p.Discount, p.Interest, p.Fine = sub.Discount, sub.Interest, sub.Fine
+	p.Split = s.newSplits(sub.Split, p.Value, 1)
+	sub.NextDueDate = advanceDueDate(sub.NextDueDate, sub.Cycle)
+	snapshot := *p
+	event := s.newEvent("PAYMENT_CREATED")
+	event.Payment = &snapshot
+	s.mu.Unlock()
+
+	return p.ID, s.deliver(ctx, event, p.ID)
+}
+
+// Payment returns the current state of a payment as AsaasClient would decode it.
+func (s *Simulator) Payment(id string) (payments.PaymentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.payments[id]
+	if !ok {
+		return payments.PaymentResponse{}, false
+	}
+	return payments.PaymentResponse{
+		ID:                    p.ID,
+		Customer:              p.Customer,
+		BillingType:           p.BillingType,
+		Value:                 p.Value,
+		Status:                p.Status,
+		Description:           p.Description,
+		DueDate:               p.DueDate,
+		ExternalReference:     p.ExternalReference,
+		Subscription:          p.Subscription,
+		Installment:           p.Installment,
+		InstallmentNumber:     p.InstallmentNumber,
+		InvoiceURL:            p.InvoiceURL,
+		BankSlipURL:           p.BankSlipURL,
+		TransactionReceiptURL: p.TransactionReceiptURL,
+		Refunds:               append([]payments.PaymentRefund(nil), p.Refunds...),
+		CreditCard:            p.CreditCard,
+		Discount:              p.Discount,
+		Interest:              p.Interest,
+		Fine:                  p.Fine,
+		Split:                 append([]payments.Split(nil), p.Split...),
+	}, true
+}
+
+// Deliveries returns the webhooks sent so far, oldest first.
+func (s *Simulator) Deliveries() []WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]WebhookDelivery(nil), s.deliveries...)
+}
+
+func (s *Simulator) transitionPayment(ctx context.Context, id string, apply func(p *payment) (string, error)) error {
+	s.mu.Lock()
+	p, ok := s.payments[id]
+	if !ok || p.Deleted {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: cobrança %s", ErrNotFound, id)
+	}
+	eventType, err := apply(p)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	snapshot := *p
+	event := s.newEvent(eventType)
+	event.Payment = &snapshot
+	s.mu.Unlock()
+
+	return s.deliver(ctx, event, id)
+}
+
+// newEvent builds a webhook envelope. The caller must hold s.mu.
+func (s *Simulator) newEvent(eventType string) webhookEvent {
+	return webhookEvent{
+		ID:          s.nextID("evt"),
+		Event:       eventType,
+		DateCreated: s.opts.Now().UTC().Format("2006-01-02 15:04:05"),
+	}
+}
+
+// deliver POSTs the event to the configured webhook URL and records the outcome.
+// Without a webhook URL the event is only recorded.
+func (s *Simulator) deliver(ctx context.Context, event webhookEvent, objectID string) error {
+	s.mu.Lock()
+	url, token := s.opts.WebhookURL, s.opts.WebhookToken
+	s.mu.Unlock()
+
+	delivery := WebhookDelivery{EventID: event.ID, Event: event.Event, ObjectID: objectID, SentAt: s.opts.Now().UTC()}
+	err := s.post(ctx, url, token, event, &delivery)
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	s.mu.Lock()
+	s.deliveries = append(s.deliveries, delivery)
+	s.mu.Unlock()
+	return err
+}
+
+func (s *Simulator) post(ctx context.Context, url, token string, event webhookEvent, delivery *WebhookDelivery) error {
+	if url == "" {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("asaas-access-token", token)
+	}
+	resp, err := s.opts.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("falha ao entregar webhook %s: %w", event.Event, err)
+	}
+	resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s recusado com status %d", event.Event, resp.StatusCode)
+	}
+	return nil
+}
+
+func advanceDueDate(date, cycle string) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	switch cycle {
+	case "WEEKLY":
+		t = t.AddDate(0, 0, 7)
+	case "BIWEEKLY":
+		t = t.AddDate(0, 0, 14)
+	case "BIMONTHLY":
+		t = t.AddDate(0, 2, 0)
+	case "QUARTERLY":
+		t = t.AddDate(0, 3, 0)
+	case "SEMIANNUALLY":
+		t = t.AddDate(0, 6, 0)
+	case "YEARLY":
+		t = t.AddDate(1, 0, 0)
+	default:
+		t = t.AddDate(0, 1, 0)
+	}
+	return t.Format("2006-01-02")
+}
+
+func (s *Simulator) triggerHandler(trigger func(ctx context.Context, id string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := trigger(req.Context(), req.PathValue("id")); err != nil {
+			writeTriggerError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Simulator) generateSubscriptionPaymentHandler(w http.ResponseWriter, req *http.Request) {
+	id, err := s.GenerateSubscriptionPayment(req.Context(), req.PathValue("id"))
+	if err != nil {
+		writeTriggerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+func (s *Simulator) listDeliveries(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, s.Deliveries())
+}
+
+func writeTriggerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, ErrInvalidTransition):
+		writeError(w, http.StatusBadRequest, "invalid_action", err.Error())
+	default:
+		writeError(w, http.StatusBadGateway, "webhook_failed", err.Error())
+	}
+}